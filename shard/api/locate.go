@@ -0,0 +1,29 @@
+// KV-Raft: Key placement shared by shards and clients
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package api
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// KeyHash is the 64-bit FNV-1a hash of a key as stored: namespace + "/" + key,
+// or the bare key without a namespace
+func KeyHash(namespace, key string) uint64 {
+	h := fnv.New64a()
+	if namespace != "" {
+		h.Write([]byte(namespace + "/"))
+	}
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// OwningShard returns the shard owning a key: the shard IDs of the ring are
+// sorted ascending and the one at index KeyHash mod len(ring) owns it
+func OwningShard(namespace, key string, ring []int) int {
+	sorted := append([]int(nil), ring...)
+	sort.Ints(sorted)
+	return sorted[KeyHash(namespace, key)%uint64(len(sorted))]
+}
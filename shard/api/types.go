@@ -0,0 +1,211 @@
+// KV-Raft: Wire structures shared by the shard server and the Go client
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package api
+
+// Response structures for consistent JSON responses
+type APIResponse struct {
+	Success bool        `json:"success"`
+	Message string      `json:"message,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Code    string      `json:"code,omitempty"`
+}
+
+type GetRequest struct {
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+	Version   uint64 `json:"version,omitempty"`   // 0 reads the latest version
+	MinIndex  uint64 `json:"min_index,omitempty"` // raft index a cached read must reflect, from a write response
+}
+
+type GetResponse struct {
+	Success       bool   `json:"success"`
+	Key           string `json:"key"`
+	Value         string `json:"value"`
+	ValueB64      string `json:"val_b64,omitempty"` // set instead of Value for binary values
+	Version       uint64 `json:"version,omitempty"`
+	OldestVersion uint64 `json:"oldest_version,omitempty"`
+	LatestVersion uint64 `json:"latest_version,omitempty"`
+	Error         string `json:"error,omitempty"`
+	Code          string `json:"code,omitempty"`
+}
+
+type PutRequest struct {
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+	Value     string `json:"val"`
+	ValueB64  string `json:"val_b64,omitempty"` // base64 of a binary value, instead of val
+	Ack       string `json:"ack,omitempty"`     // AckCommitted (default) or AckNone
+}
+
+// Acknowledgement levels of a PUT
+const (
+	AckCommitted = "committed" // respond once the write is committed and applied
+	AckNone      = "none"      // respond 202 once the write is proposed to raft
+)
+
+// GetFieldRequest reads one field of a JSON value; Field is a dotted path such
+// as "a.b.0.c", where numeric segments index arrays
+type GetFieldRequest struct {
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+	Field     string `json:"field"`
+}
+
+// AppendRequest adds Delimiter and Value to the end of a key's value
+type AppendRequest struct {
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+	Value     string `json:"val"`
+	Delimiter string `json:"delim,omitempty"` // defaults to a newline
+}
+
+// StageRequest sets the value a key gets at the next commit of staged values
+type StageRequest struct {
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+	Value     string `json:"val"`
+}
+
+// CommitStagedRequest makes staged values live: those of Keys, or every key
+// staged in Namespace when Keys is empty
+type CommitStagedRequest struct {
+	Namespace string   `json:"namespace,omitempty"`
+	Keys      []string `json:"keys,omitempty"`
+}
+
+type LocateRequest struct {
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+}
+
+// Location is where a key lives, as computed by OwningShard
+type Location struct {
+	Key    string `json:"key"`
+	Hash   string `json:"hash"` // KeyHash as 16 hex digits; it does not fit a JSON number exactly
+	Ring   []int  `json:"ring"`
+	Shard  int    `json:"shard"`
+	Path   string `json:"path"`             // prefix of the owning shard's endpoints on every node
+	Leader string `json:"leader,omitempty"` // base URL of the owning shard's leader, empty if unknown
+}
+
+type DeleteRequest struct {
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+}
+
+// NextIDRequest allocates Count IDs from the sequence Name
+type NextIDRequest struct {
+	Name  string `json:"name"`
+	Count uint64 `json:"count,omitempty"` // defaults to 1
+}
+
+// DeleteIfRequest deletes a key only while it still holds Expected
+type DeleteIfRequest struct {
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+	Expected  string `json:"expected"`
+}
+
+type DeletePrefixRequest struct {
+	Prefix string `json:"prefix"`
+}
+
+// BatchOperation is one write of a BatchRequest; Op is "put" or "delete"
+type BatchOperation struct {
+	Op        string `json:"op"`
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+	Value     string `json:"val,omitempty"`
+}
+
+type BatchRequest struct {
+	Ops []BatchOperation `json:"ops"`
+}
+
+type LockRequest struct {
+	Key   string `json:"key"`
+	Owner string `json:"owner"`
+	TTL   string `json:"ttl,omitempty"` // duration such as "30s"
+}
+
+type MultiGetRequest struct {
+	Namespace string   `json:"namespace,omitempty"`
+	Keys      []string `json:"keys"`
+}
+
+// RebalanceRequest moves the keys a group does not own under Ring, a
+// comma-separated list of shard IDs, to their owners at Rate keys per second
+type RebalanceRequest struct {
+	Ring string `json:"ring,omitempty"` // defaults to the -shards list
+	Rate int    `json:"rate,omitempty"` // defaults to -rebalance_rate
+}
+
+// TxGetRequest reads Keys at one read-index barrier, without a log entry
+type TxGetRequest struct {
+	Namespace string   `json:"namespace,omitempty"`
+	Keys      []string `json:"keys"`
+}
+
+// ScanRequest lists up to Limit keys starting with Prefix and matching Glob,
+// in key order, beginning after the key After
+type ScanRequest struct {
+	Namespace string `json:"namespace,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	Glob      string `json:"glob,omitempty"`  // path.Match pattern the whole key must match
+	After     string `json:"after,omitempty"` // the last key of the previous page
+	Limit     int    `json:"limit,omitempty"` // defaults to 100
+	// Unsorted returns the first matching keys found, in no order, without
+	// collecting every matching key to sort; it cannot be combined with After
+	Unsorted bool `json:"unsorted,omitempty"`
+}
+
+// ScanItem is one key of a scan with its latest value
+type ScanItem struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	ValueB64 string `json:"val_b64,omitempty"` // set instead of Value for binary values
+}
+
+// ScanResult is the data of a /scan response; More is set when keys follow
+// the last item, which a further scan with After set to it returns
+type ScanResult struct {
+	Items []ScanItem `json:"items"`
+	More  bool       `json:"more"`
+}
+
+// Machine-readable error codes set in the Code field of error responses
+const (
+	CodeInvalidJSON     = "INVALID_JSON"
+	CodeInvalidRequest  = "INVALID_REQUEST"
+	CodeNotLeader       = "NOT_LEADER"
+	CodeNoLeader        = "NO_LEADER"
+	CodeConfigError     = "CONFIG_ERROR"
+	CodeNodeConflict    = "NODE_CONFLICT"
+	CodeNodeNotFound    = "NODE_NOT_FOUND"
+	CodeMembershipError = "MEMBERSHIP_ERROR"
+	CodeKeyNotFound     = "KEY_NOT_FOUND"
+	CodeVersionNotFound = "VERSION_NOT_FOUND"
+	CodeTypeMismatch    = "TYPE_MISMATCH"
+	CodeInvalidValue    = "INVALID_VALUE"
+	CodeCASMismatch     = "CAS_MISMATCH"
+	CodeLocked          = "LOCKED"
+	CodeLockNotHeld     = "LOCK_NOT_HELD"
+	CodeFieldNotFound   = "FIELD_NOT_FOUND"
+	CodeNotJSON         = "NOT_JSON"
+	CodeTooLarge        = "TOO_LARGE"
+	CodeNotReady        = "NOT_READY"
+	CodeRaftApplyFailed = "RAFT_APPLY_FAILED"
+	CodeLeadershipLost  = "LEADERSHIP_LOST"
+	CodeShuttingDown    = "SHUTTING_DOWN"
+	CodeApplyTimeout    = "APPLY_TIMEOUT"
+	CodeNoQuorum        = "NO_QUORUM"
+	CodeForbidden       = "FORBIDDEN"
+	CodeForwardFailed   = "FORWARD_FAILED"
+	CodeRebalancing     = "REBALANCE_RUNNING"
+	CodeMisdirectedKey  = "MISDIRECTED_KEY"
+	CodeScanTimeout     = "SCAN_TIMEOUT"
+	CodeInternalError   = "INTERNAL_ERROR"
+)
@@ -0,0 +1,141 @@
+// KV-Raft: Atomic append to a key's value
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+// defaultAppendDelimiter separates appended values when the request names none
+const defaultAppendDelimiter = "\n"
+
+// AppendHandler adds a delimiter and val to the end of a key's value in a
+// single log entry, so concurrent appends never lose each other's writes
+func (s *Server) AppendHandler(w http.ResponseWriter, r *http.Request) {
+	var req AppendRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if req.Key == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("key"))
+		return
+	}
+	if req.Value == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("val"))
+		return
+	}
+	if req.Delimiter == "" {
+		req.Delimiter = defaultAppendDelimiter
+	}
+
+	if !validNamespace(req.Namespace) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Namespace must not contain '/'")
+		return
+	}
+	if !validKey(req.Namespace, req.Key) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, bareKeyMessage)
+		return
+	}
+	if reservedKey(namespacedKey(req.Namespace, req.Key)) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, reservedKeyMessage)
+		return
+	}
+
+	// Concatenated JSON documents are not a JSON document
+	if s.config.ValueEncoding == EncodingJSON {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidValue, "Append is not supported with -value_encoding json")
+		return
+	}
+	if err := validateValueEncoding(s.config.ValueEncoding, req.Delimiter+req.Value); err != nil {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidValue, err.Error())
+		return
+	}
+	if s.config.MaxValueBytes > 0 && len(req.Value) > s.config.MaxValueBytes {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, api.CodeTooLarge, fmt.Sprintf("Value is %d bytes, the limit is %d", len(req.Value), s.config.MaxValueBytes))
+		return
+	}
+
+	idemKey, ok := idempotencyKey(w, r)
+	if !ok {
+		return
+	}
+
+	// Only the leader applies the append, so followers forward it
+	if s.raft.State() != raft.Leader {
+		query := url.Values{}
+		query.Set("key", req.Key)
+		query.Set("val", req.Value)
+		query.Set("delim", req.Delimiter)
+		if req.Namespace != "" {
+			query.Set("namespace", req.Namespace)
+		}
+		s.forwardToLeader(w, r, http.MethodPost, "/append?"+query.Encode(), nil)
+		return
+	}
+
+	// The size limit is checked while applying, against the value as of this entry
+	payload := fsm.Payload{
+		OP:             fsm.APPEND,
+		Key:            namespacedKey(req.Namespace, req.Key),
+		Value:          req.Value,
+		Delimiter:      req.Delimiter,
+		MaxBytes:       s.config.MaxValueBytes,
+		IdempotencyKey: idemKey,
+	}
+
+	data, err := s.marshalPayload(payload)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal payload")
+		return
+	}
+
+	if err := s.checkWriteQuorum(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+
+	applyFuture := s.timedApply(payload.OP, data)
+	if err := applyFuture.Error(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+	setSessionToken(w, applyFuture.Index())
+
+	applyResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Invalid raft response")
+		return
+	}
+	markReplayed(w, applyResponse)
+
+	if applyResponse.Error != nil {
+		status, code := applyErrorStatus(applyResponse.Error)
+		writeJSONError(w, status, code, "Failed to append value: "+applyResponse.Error.Error())
+		return
+	}
+
+	log.Printf("[HTTP-APPEND] key %s is now %v bytes", req.Key, applyResponse.Data)
+
+	response := APIResponse{
+		Success: true,
+		Message: "Value appended successfully",
+		Data: map[string]interface{}{
+			"key":    req.Key,
+			"length": applyResponse.Data,
+			"index":  applyFuture.Index(),
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,173 @@
+// KV-Raft: HTTP handler for batches of writes applied by one raft entry
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+// batchOps maps the operation names of a BatchRequest to FSM operations
+var batchOps = map[string]string{
+	"put":    fsm.PUT,
+	"delete": fsm.DEL,
+}
+
+// BatchHandler applies every operation of the request in a single raft log
+// entry. Each entry must be replicated before the next one commits, so the
+// size of a batch is capped by -max_batch_ops and -max_batch_bytes.
+func (s *Server) BatchHandler(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if len(req.Ops) == 0 {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("ops"))
+		return
+	}
+	if len(req.Ops) > s.config.MaxBatchOps {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, api.CodeTooLarge, fmt.Sprintf("Batch has %d operations, the limit is %d", len(req.Ops), s.config.MaxBatchOps))
+		return
+	}
+
+	ops := make([]fsm.BatchOp, len(req.Ops))
+	for i, op := range req.Ops {
+		fsmOp, ok := batchOps[op.Op]
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("Operation %d: op must be put or delete", i))
+			return
+		}
+		if op.Key == "" {
+			writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("Operation %d: %s", i, missingField("key")))
+			return
+		}
+		if !validNamespace(op.Namespace) {
+			writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("Operation %d: namespace must not contain '/'", i))
+			return
+		}
+		if !validKey(op.Namespace, op.Key) {
+			writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("Operation %d: %s", i, bareKeyMessage))
+			return
+		}
+		if reservedKey(namespacedKey(op.Namespace, op.Key)) {
+			writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("Operation %d: %s", i, reservedKeyMessage))
+			return
+		}
+		if fsmOp == fsm.PUT {
+			if op.Value == "" {
+				writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("Operation %d: %s", i, missingField("val")))
+				return
+			}
+			if err := validateValueEncoding(s.config.ValueEncoding, op.Value); err != nil {
+				writeJSONError(w, http.StatusBadRequest, api.CodeInvalidValue, fmt.Sprintf("Operation %d: %s", i, err.Error()))
+				return
+			}
+			if s.config.MaxValueBytes > 0 && len(op.Value) > s.config.MaxValueBytes {
+				writeJSONError(w, http.StatusRequestEntityTooLarge, api.CodeTooLarge, fmt.Sprintf("Operation %d: value is %d bytes, the limit is %d", i, len(op.Value), s.config.MaxValueBytes))
+				return
+			}
+		}
+		ops[i] = fsm.BatchOp{
+			OP:    fsmOp,
+			Key:   namespacedKey(op.Namespace, op.Key),
+			Value: op.Value,
+		}
+	}
+
+	idemKey, ok := idempotencyKey(w, r)
+	if !ok {
+		return
+	}
+
+	// Followers cannot apply through raft, so forward the batch to the leader
+	if s.raft.State() != raft.Leader {
+		body, err := json.Marshal(req)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal request")
+			return
+		}
+		s.forwardToLeader(w, r, http.MethodPost, "/batch", bytes.NewReader(body))
+		return
+	}
+
+	payload := fsm.Payload{
+		OP:             fsm.BATCH,
+		Ops:            ops,
+		IdempotencyKey: idemKey,
+	}
+
+	data, err := s.marshalPayload(payload)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal payload")
+		return
+	}
+	if len(data) > s.config.MaxBatchBytes {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, api.CodeTooLarge, fmt.Sprintf("Batch log entry is %d bytes, the limit is %d", len(data), s.config.MaxBatchBytes))
+		return
+	}
+
+	if err := s.checkWriteQuorum(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+
+	applyFuture := s.timedApply(payload.OP, data)
+	if err := applyFuture.Error(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+	setSessionToken(w, applyFuture.Index())
+
+	applyResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Invalid raft response")
+		return
+	}
+	markReplayed(w, applyResponse)
+
+	errs, ok := applyResponse.Data.([]error)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Invalid batch result")
+		return
+	}
+
+	failed := 0
+	results := make([]map[string]interface{}, len(req.Ops))
+	for i, op := range req.Ops {
+		result := map[string]interface{}{
+			"op":      op.Op,
+			"key":     op.Key,
+			"success": errs[i] == nil,
+		}
+		if errs[i] != nil {
+			_, code := applyErrorStatus(errs[i])
+			result["error"] = errs[i].Error()
+			result["code"] = code
+			failed++
+		}
+		results[i] = result
+	}
+
+	log.Printf("[HTTP-BATCH] applied %d operations, %d failed", len(ops), failed)
+
+	response := APIResponse{
+		Success: failed == 0,
+		Message: fmt.Sprintf("Batch applied: %d succeeded, %d failed", len(ops)-failed, failed),
+		Data: map[string]interface{}{
+			"results": results,
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
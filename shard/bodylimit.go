@@ -0,0 +1,56 @@
+// KV-Raft: Capping how much of a request body the handlers will read
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"kv-raft/api"
+)
+
+// streamedPaths are the endpoints, relative to a group's prefix, that read
+// their body a piece at a time rather than into memory: /import applies it in
+// batches and /raft/restore spools it to disk. -max_request_bytes does not
+// apply to them.
+var streamedPaths = map[string]bool{
+	"/import":       true,
+	"/raft/restore": true,
+}
+
+// maxBytesHandler stops every request outside streamedPaths from sending a
+// body of more than limit bytes. A body declared larger is rejected with 413
+// before it is read; one that turns out larger fails to decode, which
+// decodeRequest reports as 413 too.
+func maxBytesHandler(next http.Handler, limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if streamedPaths[groupRelativePath(r.URL.Path)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > limit {
+			writeBodyTooLarge(w, limit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodyTooLarge writes a 413 and returns true if err came from reading past
+// the -max_request_bytes limit
+func bodyTooLarge(w http.ResponseWriter, err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		return false
+	}
+	writeBodyTooLarge(w, maxBytesErr.Limit)
+	return true
+}
+
+func writeBodyTooLarge(w http.ResponseWriter, limit int64) {
+	writeJSONError(w, http.StatusRequestEntityTooLarge, api.CodeTooLarge, fmt.Sprintf("Request body is larger than the limit of %d bytes", limit))
+}
@@ -0,0 +1,192 @@
+// KV-Raft: Multi-node cluster bootstrap once the expected peers are up
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+const bootstrapPollInterval = 2 * time.Second
+
+// bootstrapWhenExpected polls the /raft/status of the addresses peers returns,
+// asking it again on every poll, until expect distinct nodes (including this
+// one) are reachable, then bootstraps the cluster with all of them as voters. Every node builds the same sorted server list, so
+// they all bootstrap with an identical configuration. Callers must only start
+// it when raft.HasExistingState reports no state on disk.
+func bootstrapWhenExpected(r *raft.Raft, self raft.Server, expect int, peers func() []string) {
+	for {
+		servers := map[raft.ServerID]raft.Server{self.ID: self}
+		for _, peer := range peers() {
+			server, err := fetchPeerIdentity(peer)
+			if err != nil {
+				continue
+			}
+			servers[server.ID] = server
+		}
+
+		if len(servers) >= expect {
+			configuration := raft.Configuration{}
+			for _, server := range servers {
+				configuration.Servers = append(configuration.Servers, server)
+			}
+			sort.Slice(configuration.Servers, func(i, j int) bool {
+				return configuration.Servers[i].ID < configuration.Servers[j].ID
+			})
+
+			log.Printf("Found %d of %d expected peers, bootstrapping Raft cluster", len(servers), expect)
+			if err := r.BootstrapCluster(configuration).Error(); err != nil {
+				log.Printf("Failed to bootstrap cluster: %v", err)
+			}
+			return
+		}
+
+		log.Printf("Found %d of %d expected peers, waiting", len(servers), expect)
+		time.Sleep(bootstrapPollInterval)
+	}
+}
+
+// parseBootstrapNodes parses the -nodes list of `kv-raft bootstrap`, given as
+// comma-separated id=raft_addr pairs, keeping its order
+func parseBootstrapNodes(list string) ([]raft.Server, error) {
+	var nodes []raft.Server
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		id, addr, ok := strings.Cut(entry, "=")
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("node %q must be given as id=raft_addr", entry)
+		}
+		if seen[id] || seen[addr] {
+			return nil, fmt.Errorf("node %q repeats an ID or address", entry)
+		}
+		seen[id], seen[addr] = true, true
+		nodes = append(nodes, raft.Server{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(id),
+			Address:  raft.ServerAddress(addr),
+		})
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("-nodes lists no nodes")
+	}
+	return nodes, nil
+}
+
+// applyBootstrapSelf takes this node's raft address from its -nodes entry, and
+// the HTTP port from that address, unless -raft_addr or -port were given
+func applyBootstrapSelf(nodes []raft.Server) {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	for _, node := range nodes {
+		if node.ID != raft.ServerID(*nodeID) {
+			continue
+		}
+		if set["raft_addr"] && *raftaddr != string(node.Address) {
+			log.Fatalf("-raft_addr %s does not match %s listed for node %s in -nodes", *raftaddr, node.Address, node.ID)
+		}
+		*raftaddr = string(node.Address)
+		if !set["port"] {
+			if _, httpPort, err := net.SplitHostPort(convertRaftToHTTPAddress(*raftaddr)); err == nil {
+				*port, _ = strconv.Atoi(httpPort)
+			}
+		}
+		return
+	}
+	log.Fatalf("-node_id %s is not listed in -nodes", *nodeID)
+}
+
+// joinBootstrapNodes runs on the first node of `kv-raft bootstrap -nodes`.
+// Once the cluster has a leader, it waits for each other listed node to come
+// up and asks the leader to add it as a voter. /raft/join accepts a node that
+// is already a member, so re-running the bootstrap only adds missing nodes.
+func joinBootstrapNodes(r *raft.Raft, nodes []raft.Server) {
+	for {
+		if leaderAddr, _ := r.LeaderWithID(); leaderAddr != "" {
+			break
+		}
+		time.Sleep(bootstrapPollInterval)
+	}
+
+	client := http.Client{Timeout: tcpTimeout}
+	for _, node := range nodes[1:] {
+		for {
+			server, err := fetchPeerIdentity(convertRaftToHTTPAddress(string(node.Address)))
+			if err != nil || server.ID != node.ID {
+				log.Printf("[BOOTSTRAP] waiting for node %s at %s to come up", node.ID, node.Address)
+				time.Sleep(bootstrapPollInterval)
+				continue
+			}
+
+			// Leadership may move while nodes join, so ask whoever leads now
+			leaderAddr, _ := r.LeaderWithID()
+			form := url.Values{"nodeid": {string(node.ID)}, "addr": {string(node.Address)}}
+			resp, err := client.PostForm(fmt.Sprintf("http://%s/raft/join", convertRaftToHTTPAddress(string(leaderAddr))), form)
+			if err != nil {
+				log.Printf("[BOOTSTRAP] failed to reach leader %s to add node %s: %v", leaderAddr, node.ID, err)
+				time.Sleep(bootstrapPollInterval)
+				continue
+			}
+			var result APIResponse
+			json.NewDecoder(resp.Body).Decode(&result)
+			resp.Body.Close()
+
+			if resp.StatusCode == http.StatusOK {
+				log.Printf("[BOOTSTRAP] node %s: %s", node.ID, result.Message)
+				break
+			}
+			// A conflicting member will not resolve itself by retrying
+			if resp.StatusCode == http.StatusConflict {
+				log.Printf("[BOOTSTRAP] not adding node %s: %s", node.ID, result.Error)
+				break
+			}
+			log.Printf("[BOOTSTRAP] adding node %s failed, retrying: %s", node.ID, result.Error)
+			time.Sleep(bootstrapPollInterval)
+		}
+	}
+	log.Printf("[BOOTSTRAP] all %d nodes are members of the cluster", len(nodes))
+}
+
+// fetchPeerIdentity asks a peer's /raft/status for its node ID and raft address
+func fetchPeerIdentity(peer string) (raft.Server, error) {
+	client := http.Client{Timeout: tcpTimeout}
+	resp, err := client.Get(fmt.Sprintf("http://%s/raft/status", peer))
+	if err != nil {
+		return raft.Server{}, err
+	}
+	defer resp.Body.Close()
+
+	var status struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return raft.Server{}, err
+	}
+
+	nodeID, raftAddr := status.Data["node_id"], status.Data["raft_addr"]
+	if nodeID == "" || raftAddr == "" {
+		return raft.Server{}, fmt.Errorf("peer %s did not report its node ID and raft address", peer)
+	}
+
+	return raft.Server{
+		Suffrage: raft.Voter,
+		ID:       raft.ServerID(nodeID),
+		Address:  raft.ServerAddress(raftAddr),
+	}, nil
+}
@@ -0,0 +1,181 @@
+// KV-Raft: Bounded delivery of shard information to peer shards
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	broadcastTimeout     = 2 * time.Second
+	maxBroadcastInFlight = 4
+
+	// Peer addresses come from /syncshards and /addshard, so the workers are
+	// bounded: one exits once its peer had nothing to send for
+	// broadcastIdleTimeout, and at most maxBroadcastPeers run at a time
+	broadcastIdleTimeout = time.Minute
+	maxBroadcastPeers    = 256
+)
+
+// peerQueue holds the shard map not yet delivered to one peer. A newer map
+// replaces the pending one, so a slow peer only ever has one map queued.
+type peerQueue struct {
+	mu      sync.Mutex
+	pending *ShardMap
+	wake    chan struct{}
+}
+
+// broadcaster delivers /syncshards maps with one worker goroutine per peer,
+// so sends to a peer are serialized, and a shared semaphore caps how many
+// requests are in flight across all peers.
+type broadcaster struct {
+	mu       sync.Mutex
+	queues   map[string]*peerQueue
+	stopped  bool
+	workers  sync.WaitGroup
+	inFlight chan struct{}
+	client   *http.Client
+
+	idleTimeout time.Duration
+	maxPeers    int
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{
+		queues:      make(map[string]*peerQueue),
+		inFlight:    make(chan struct{}, maxBroadcastInFlight),
+		client:      &http.Client{Timeout: broadcastTimeout},
+		idleTimeout: broadcastIdleTimeout,
+		maxPeers:    maxBroadcastPeers,
+	}
+}
+
+// send queues the shard map for peerAddr without blocking the caller. The
+// queue is found and filled under b.mu, so an idle worker cannot exit between
+// the two and strand the map.
+func (b *broadcaster) send(peerAddr string, m ShardMap) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.stopped {
+		return
+	}
+
+	q, ok := b.queues[peerAddr]
+	if !ok {
+		if len(b.queues) >= b.maxPeers {
+			opCounters.broadcastFailures.Add(1)
+			log.Printf("Not broadcasting to %s: already sending to %d peers", peerAddr, len(b.queues))
+			return
+		}
+		q = &peerQueue{
+			wake: make(chan struct{}, 1),
+		}
+		b.queues[peerAddr] = q
+		b.workers.Add(1)
+		go b.run(peerAddr, q)
+	}
+
+	q.mu.Lock()
+	q.pending = &m
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default: // worker is already due to run
+	}
+}
+
+// run delivers the maps queued for peerAddr until stop closes q.wake, or
+// until nothing was queued for idleTimeout
+func (b *broadcaster) run(peerAddr string, q *peerQueue) {
+	defer b.workers.Done()
+
+	idle := time.NewTimer(b.idleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case _, ok := <-q.wake:
+			if !ok {
+				return
+			}
+			q.mu.Lock()
+			pending := q.pending
+			q.pending = nil
+			q.mu.Unlock()
+
+			if pending != nil {
+				b.post(peerAddr, *pending)
+			}
+		case <-idle.C:
+			if b.evict(peerAddr, q) {
+				return
+			}
+		}
+
+		if !idle.Stop() {
+			select {
+			case <-idle.C:
+			default:
+			}
+		}
+		idle.Reset(b.idleTimeout)
+	}
+}
+
+// evict removes q from the queues unless a map was queued for it since the
+// worker last looked, reporting whether it did
+func (b *broadcaster) evict(peerAddr string, q *peerQueue) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending != nil || b.stopped {
+		return false
+	}
+	delete(b.queues, peerAddr)
+	return true
+}
+
+// stop ends every worker, waiting for one in the middle of a post, and
+// drops maps not yet delivered. Sends after stop are ignored.
+func (b *broadcaster) stop() {
+	b.mu.Lock()
+	b.stopped = true
+	for peerAddr, q := range b.queues {
+		close(q.wake)
+		delete(b.queues, peerAddr)
+	}
+	b.mu.Unlock()
+
+	b.workers.Wait()
+}
+
+func (b *broadcaster) post(peerAddr string, m ShardMap) {
+	b.inFlight <- struct{}{}
+	defer func() { <-b.inFlight }()
+
+	body, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("Failed to encode shard map for %s: %v", peerAddr, err)
+		return
+	}
+
+	resp, err := b.client.Post(fmt.Sprintf("http://%s/syncshards", peerAddr), "application/json", bytes.NewReader(body))
+	if err != nil {
+		opCounters.broadcastFailures.Add(1)
+		log.Printf("Failed to broadcast to %s: %v", peerAddr, err)
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowPeer starts a /syncshards peer that holds every request until the test
+// ends, returning its port and how many requests it received
+func slowPeer(t *testing.T) (string, *atomic.Int64) {
+	t.Helper()
+	release := make(chan struct{})
+	var received atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(release)
+		server.Close()
+	})
+	return server.URL[strings.LastIndex(server.URL, ":")+1:], &received
+}
+
+func queueCount(b *broadcaster) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.queues)
+}
+
+// waitFor polls cond until it holds or a second passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestSlowPeerDoesNotGrowGoroutines(t *testing.T) {
+	port, received := slowPeer(t)
+	b := newBroadcaster()
+	b.client.Timeout = 100 * time.Millisecond
+	defer b.stop()
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 1000; i++ {
+		b.send("127.0.0.1:"+port, ShardMap{Epoch: uint64(i)})
+	}
+	waitFor(t, "the first post", func() bool { return received.Load() == 1 })
+
+	// One worker blocked in a post, plus the HTTP client's connection goroutines
+	if grown := runtime.NumGoroutine() - before; grown > 5 {
+		t.Errorf("1000 sends to one slow peer started %d goroutines", grown)
+	}
+	if n := queueCount(b); n != 1 {
+		t.Errorf("%d queues for one peer, want 1", n)
+	}
+}
+
+func TestBroadcastPeersAreCapped(t *testing.T) {
+	port, _ := slowPeer(t)
+	b := newBroadcaster()
+	b.client.Timeout = 100 * time.Millisecond
+	b.maxPeers = 4
+	defer b.stop()
+
+	// Only 127.0.0.1 reaches the slow peer; a queue stays until it is idle
+	// whether or not its peer answers
+	for i := 1; i <= 10; i++ {
+		b.send(fmt.Sprintf("127.0.0.%d:%s", i, port), ShardMap{})
+	}
+	if n := queueCount(b); n != 4 {
+		t.Errorf("%d queues after sends to 10 peers, want the cap of 4", n)
+	}
+}
+
+func TestIdleBroadcastQueuesAreEvicted(t *testing.T) {
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer peer.Close()
+
+	b := newBroadcaster()
+	b.idleTimeout = 20 * time.Millisecond
+	defer b.stop()
+
+	addr := strings.TrimPrefix(peer.URL, "http://")
+	b.send(addr, ShardMap{})
+	waitFor(t, "the idle queue to be evicted", func() bool { return queueCount(b) == 0 })
+
+	// A later send starts a new worker
+	b.send(addr, ShardMap{})
+	if n := queueCount(b); n != 1 {
+		t.Errorf("%d queues after sending again, want 1", n)
+	}
+}
+
+func TestStopEndsWorkers(t *testing.T) {
+	port, received := slowPeer(t)
+	b := newBroadcaster()
+	b.client.Timeout = 100 * time.Millisecond
+
+	b.send("127.0.0.1:"+port, ShardMap{})
+	waitFor(t, "the first post", func() bool { return received.Load() == 1 })
+
+	// stop returns once the worker blocked in its post has exited
+	stopped := make(chan struct{})
+	go func() {
+		b.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("stop did not return")
+	}
+
+	b.send("127.0.0.1:"+port, ShardMap{})
+	if n := queueCount(b); n != 0 {
+		t.Errorf("%d queues after stop, want 0", n)
+	}
+}
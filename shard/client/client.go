@@ -0,0 +1,389 @@
+// KV-Raft: Go client for the shard HTTP API
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"kv-raft/api"
+)
+
+// ErrNotFound is returned by Get and Delete when the key does not exist
+var ErrNotFound = errors.New("key not found")
+
+// ErrCASMismatch is returned by CompareAndDelete when the key no longer holds the expected value
+var ErrCASMismatch = errors.New("key does not hold the expected value")
+
+// ErrNoLeader is returned when none of the configured shards reports itself as leader
+var ErrNoLeader = errors.New("no raft leader found")
+
+// idempotencyHeader makes a shard apply a retried write only once
+const idempotencyHeader = "Idempotency-Key"
+
+// Options configures a Client. Zero values select the defaults.
+type Options struct {
+	// HTTPClient is used for all requests; by default a pooled client with a 10s timeout
+	HTTPClient *http.Client
+	// MaxRetries is how many times a failed request is retried (default 3).
+	// Writes are retried with the same Idempotency-Key, so they are applied
+	// once as long as the shards remember keys (--idempotency_keys).
+	MaxRetries int
+	// Backoff is the delay before the first retry, doubled on each further retry (default 100ms)
+	Backoff time.Duration
+}
+
+// Client talks to a KV-Raft cluster, sending requests to the current leader
+type Client struct {
+	addrs      []string
+	httpClient *http.Client
+	maxRetries int
+	backoff    time.Duration
+
+	mu     sync.Mutex
+	leader string
+}
+
+// StatusError is a non-2xx response from a shard other than those mapped to
+// ErrNotFound and ErrCASMismatch
+type StatusError struct {
+	Status  int
+	Code    string // one of the api.Code constants
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("kv-raft: status %d (%s): %s", e.Status, e.Code, e.Message)
+}
+
+// New returns a client for the shards at addrs (host:port of each HTTP API)
+func New(addrs []string, opts Options) *Client {
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				MaxIdleConns:        100,
+				MaxIdleConnsPerHost: 10,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		}
+	}
+
+	maxRetries := opts.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = 3
+	}
+
+	backoff := opts.Backoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+
+	return &Client{
+		addrs:      addrs,
+		httpClient: httpClient,
+		maxRetries: maxRetries,
+		backoff:    backoff,
+	}
+}
+
+// Put stores value under key
+func (c *Client) Put(ctx context.Context, key, value string) error {
+	body, err := json.Marshal(api.PutRequest{Key: key, Value: value})
+	if err != nil {
+		return err
+	}
+
+	var resp api.APIResponse
+	return c.write(ctx, http.MethodPost, "/put", body, &resp)
+}
+
+// Get returns the latest value of key, or ErrNotFound
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	var resp api.GetResponse
+	if err := c.do(ctx, http.MethodGet, "/get?key="+url.QueryEscape(key), nil, "", &resp); err != nil {
+		return "", err
+	}
+	if resp.ValueB64 != "" {
+		value, err := base64.StdEncoding.DecodeString(resp.ValueB64)
+		if err != nil {
+			return "", err
+		}
+		return string(value), nil
+	}
+	return resp.Value, nil
+}
+
+// Delete removes key, or returns ErrNotFound if it does not exist
+func (c *Client) Delete(ctx context.Context, key string) error {
+	body, err := json.Marshal(api.DeleteRequest{Key: key})
+	if err != nil {
+		return err
+	}
+
+	var resp api.APIResponse
+	return c.write(ctx, http.MethodDelete, "/delete", body, &resp)
+}
+
+// CompareAndDelete removes key only while its latest value is expected, in
+// one log entry. It returns ErrCASMismatch if the key holds another value and
+// ErrNotFound if it does not exist.
+func (c *Client) CompareAndDelete(ctx context.Context, key, expected string) error {
+	body, err := json.Marshal(api.DeleteIfRequest{Key: key, Expected: expected})
+	if err != nil {
+		return err
+	}
+
+	var resp api.APIResponse
+	return c.write(ctx, http.MethodDelete, "/deleteif", body, &resp)
+}
+
+// Batch applies ops in one log entry and returns the error of each, nil for
+// those that succeeded. Like a single Delete, a delete of a missing key fails
+// with ErrNotFound. The returned error is set only if the batch was not applied.
+func (c *Client) Batch(ctx context.Context, ops []api.BatchOperation) ([]error, error) {
+	body, err := json.Marshal(api.BatchRequest{Ops: ops})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Data struct {
+			Results []struct {
+				Success bool   `json:"success"`
+				Error   string `json:"error"`
+				Code    string `json:"code"`
+			} `json:"results"`
+		} `json:"data"`
+	}
+	if err := c.write(ctx, http.MethodPost, "/batch", body, &resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Data.Results) != len(ops) {
+		return nil, fmt.Errorf("kv-raft: batch of %d operations returned %d results", len(ops), len(resp.Data.Results))
+	}
+	errs := make([]error, len(ops))
+	for i, result := range resp.Data.Results {
+		if !result.Success {
+			errs[i] = codeError(http.StatusOK, result.Code, result.Error)
+		}
+	}
+	return errs, nil
+}
+
+// Scan returns up to limit keys starting with prefix, in key order, with their
+// latest values; limit 0 selects the shard's default. Pass the last key
+// returned as after to read the next page while More is set. Values sent in
+// val_b64 are decoded into Value.
+func (c *Client) Scan(ctx context.Context, prefix, after string, limit int) (api.ScanResult, error) {
+	body, err := json.Marshal(api.ScanRequest{Prefix: prefix, After: after, Limit: limit})
+	if err != nil {
+		return api.ScanResult{}, err
+	}
+
+	var resp struct {
+		Data api.ScanResult `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/scan", body, "", &resp); err != nil {
+		return api.ScanResult{}, err
+	}
+	for i, item := range resp.Data.Items {
+		if item.ValueB64 == "" {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(item.ValueB64)
+		if err != nil {
+			return api.ScanResult{}, err
+		}
+		resp.Data.Items[i].Value, resp.Data.Items[i].ValueB64 = string(value), ""
+	}
+	return resp.Data, nil
+}
+
+// write sends a write with an Idempotency-Key of its own, kept across retries,
+// so a write that was applied but whose response was lost is not applied again
+func (c *Client) write(ctx context.Context, method, pathAndQuery string, body []byte, out interface{}) error {
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, method, pathAndQuery, body, key, out)
+}
+
+// newIdempotencyKey returns a random key for one write call
+func newIdempotencyKey() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// do sends a request to the leader, rediscovering it and backing off between
+// retries. A shard answering NOT_LEADER is asked where the leader is, and the
+// request is sent there right away.
+func (c *Client) do(ctx context.Context, method, pathAndQuery string, body []byte, idemKey string, out interface{}) error {
+	var lastErr error
+	redirected := false
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if attempt > 0 && !redirected {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.backoff << (attempt - 1)):
+			}
+		}
+		redirected = false
+
+		leader, err := c.leaderAddr(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		err = c.send(ctx, leader, method, pathAndQuery, body, idemKey, out)
+		if err == nil || !retryable(err) {
+			return err
+		}
+
+		lastErr = err
+		c.forgetLeader()
+
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.Code == api.CodeNotLeader {
+			if next := c.leaderOf(ctx, leader); next != "" && next != leader {
+				c.setLeader(next)
+				redirected = true
+			}
+		}
+	}
+	return lastErr
+}
+
+// send makes one request to addr. Redirects are followed by the HTTP client,
+// which sends the body and headers, Idempotency-Key included, again.
+func (c *Client) send(ctx context.Context, addr, method, pathAndQuery string, body []byte, idemKey string, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+addr+pathAndQuery, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idemKey != "" {
+		req.Header.Set(idempotencyHeader, idemKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var errResp api.APIResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return codeError(resp.StatusCode, errResp.Code, errResp.Error)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// codeError maps an error code from a shard to ErrNotFound, ErrCASMismatch
+// or a *StatusError
+func codeError(status int, code, message string) error {
+	switch code {
+	case api.CodeKeyNotFound:
+		return ErrNotFound
+	case api.CodeCASMismatch:
+		return ErrCASMismatch
+	}
+	return &StatusError{Status: status, Code: code, Message: message}
+}
+
+// retryable reports whether a request may succeed when sent again, possibly to a new leader
+func retryable(err error) bool {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrCASMismatch) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status >= 500 || statusErr.Code == api.CodeNotLeader
+	}
+	return true
+}
+
+// leaderAddr returns the cached leader, or asks every shard's /raft/status for it
+func (c *Client) leaderAddr(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	leader := c.leader
+	c.mu.Unlock()
+	if leader != "" {
+		return leader, nil
+	}
+
+	for _, addr := range c.addrs {
+		var status struct {
+			Data map[string]string `json:"data"`
+		}
+		if err := c.send(ctx, addr, http.MethodGet, "/raft/status", nil, "", &status); err != nil {
+			continue
+		}
+		if status.Data["state"] == "Leader" {
+			c.setLeader(addr)
+			return addr, nil
+		}
+	}
+	return "", ErrNoLeader
+}
+
+// leaderOf asks the shard at addr which node it knows as leader, returning
+// its host:port, or "" if addr does not know one
+func (c *Client) leaderOf(ctx context.Context, addr string) string {
+	var leader struct {
+		Data struct {
+			URL string `json:"url"`
+		} `json:"data"`
+	}
+	if err := c.send(ctx, addr, http.MethodGet, "/raft/leader", nil, "", &leader); err != nil {
+		return ""
+	}
+	parsed, err := url.Parse(leader.Data.URL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Host
+}
+
+func (c *Client) setLeader(addr string) {
+	c.mu.Lock()
+	c.leader = addr
+	c.mu.Unlock()
+}
+
+func (c *Client) forgetLeader() {
+	c.mu.Lock()
+	c.leader = ""
+	c.mu.Unlock()
+}
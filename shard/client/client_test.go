@@ -0,0 +1,281 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"kv-raft/api"
+)
+
+// newShard starts a fake shard reporting itself as leader or follower on
+// /raft/status and serving every other path with handler. It returns the
+// shard's host:port.
+func newShard(t *testing.T, leader bool, handler http.HandlerFunc) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/raft/status" {
+			state := "Follower"
+			if leader {
+				state = "Leader"
+			}
+			writeJSON(w, http.StatusOK, api.APIResponse{Success: true, Data: map[string]string{"state": state}})
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, api.APIResponse{Success: false, Error: code, Code: code})
+}
+
+func ok(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, api.APIResponse{Success: true})
+}
+
+// recorder counts the requests a handler receives and keeps their Idempotency-Keys
+type recorder struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (rec *recorder) wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec.mu.Lock()
+		rec.keys = append(rec.keys, r.Header.Get(idempotencyHeader))
+		attempt := len(rec.keys)
+		rec.mu.Unlock()
+		r.Header.Set("X-Attempt", strconv.Itoa(attempt))
+		next(w, r)
+	}
+}
+
+func (rec *recorder) count() int {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return len(rec.keys)
+}
+
+func testOptions() Options {
+	return Options{MaxRetries: 3, Backoff: time.Millisecond}
+}
+
+func TestLeaderDiscovery(t *testing.T) {
+	var followerHits, leaderHits recorder
+	follower := newShard(t, false, followerHits.wrap(ok))
+	leader := newShard(t, true, leaderHits.wrap(ok))
+
+	c := New([]string{follower, leader}, testOptions())
+	for i := 0; i < 3; i++ {
+		if err := c.Put(context.Background(), "k", "v"); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if followerHits.count() != 0 || leaderHits.count() != 3 {
+		t.Errorf("follower got %d puts and leader %d, want 0 and 3", followerHits.count(), leaderHits.count())
+	}
+}
+
+func TestNoLeader(t *testing.T) {
+	follower := newShard(t, false, ok)
+	c := New([]string{follower}, testOptions())
+	if err := c.Put(context.Background(), "k", "v"); !errors.Is(err, ErrNoLeader) {
+		t.Errorf("Put with no leader = %v, want ErrNoLeader", err)
+	}
+}
+
+func TestWriteRetriesKeepIdempotencyKey(t *testing.T) {
+	var hits recorder
+	leader := newShard(t, true, hits.wrap(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Attempt") == "1" {
+			writeError(w, http.StatusServiceUnavailable, api.CodeLeadershipLost)
+			return
+		}
+		ok(w, r)
+	}))
+
+	c := New([]string{leader}, testOptions())
+	if err := c.Put(context.Background(), "k", "v"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := c.Put(context.Background(), "k", "v"); err != nil {
+		t.Fatalf("second Put: %v", err)
+	}
+
+	if len(hits.keys) != 3 {
+		t.Fatalf("leader got %d requests, want 3", len(hits.keys))
+	}
+	if hits.keys[0] == "" || hits.keys[0] != hits.keys[1] {
+		t.Errorf("retry sent Idempotency-Key %q after %q, want the same non-empty key", hits.keys[1], hits.keys[0])
+	}
+	if hits.keys[2] == hits.keys[0] {
+		t.Errorf("a new Put reused the Idempotency-Key %q", hits.keys[2])
+	}
+}
+
+func TestReadsHaveNoIdempotencyKey(t *testing.T) {
+	var hits recorder
+	leader := newShard(t, true, hits.wrap(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, api.GetResponse{Success: true, Key: "k", Value: "v"})
+	}))
+
+	c := New([]string{leader}, testOptions())
+	value, err := c.Get(context.Background(), "k")
+	if err != nil || value != "v" {
+		t.Fatalf("Get = %q, %v; want v", value, err)
+	}
+	if hits.keys[0] != "" {
+		t.Errorf("Get sent Idempotency-Key %q", hits.keys[0])
+	}
+}
+
+func TestNotLeaderFollowsToLeader(t *testing.T) {
+	var leaderHits recorder
+	leader := newShard(t, false, leaderHits.wrap(ok))
+	// A stale node still reporting itself as leader points at the new one
+	stale := newShard(t, true, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/raft/leader" {
+			writeJSON(w, http.StatusOK, api.APIResponse{Success: true, Data: map[string]interface{}{"url": "http://" + leader}})
+			return
+		}
+		writeError(w, http.StatusMisdirectedRequest, api.CodeNotLeader)
+	})
+
+	// A backoff longer than the test shows the request went on without one
+	c := New([]string{stale}, Options{MaxRetries: 1, Backoff: time.Hour})
+	if err := c.Put(context.Background(), "k", "v"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if leaderHits.count() != 1 {
+		t.Errorf("leader got %d puts, want 1", leaderHits.count())
+	}
+}
+
+func TestHTTPRedirectFollowed(t *testing.T) {
+	var targetHits recorder
+	var body string
+	target := newShard(t, false, targetHits.wrap(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		ok(w, r)
+	}))
+	redirecting := newShard(t, true, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://"+target+r.URL.Path, http.StatusTemporaryRedirect)
+	})
+
+	c := New([]string{redirecting}, testOptions())
+	if err := c.Put(context.Background(), "k", "v"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if targetHits.count() != 1 || targetHits.keys[0] == "" {
+		t.Fatalf("redirect target got %d puts with keys %q, want 1 with a key", targetHits.count(), targetHits.keys)
+	}
+	if !strings.Contains(body, `"key":"k"`) {
+		t.Errorf("redirect target got body %q, want the put", body)
+	}
+}
+
+func TestErrorMapping(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		code     string
+		want     error
+		attempts int
+	}{
+		{"not found", http.StatusNotFound, api.CodeKeyNotFound, ErrNotFound, 1},
+		{"cas mismatch", http.StatusConflict, api.CodeCASMismatch, ErrCASMismatch, 1},
+		{"invalid request", http.StatusBadRequest, api.CodeInvalidRequest, nil, 1},
+		{"server error", http.StatusInternalServerError, api.CodeInternalError, nil, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits recorder
+			leader := newShard(t, true, hits.wrap(func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, tt.status, tt.code)
+			}))
+
+			c := New([]string{leader}, testOptions())
+			err := c.CompareAndDelete(context.Background(), "k", "v")
+			if tt.want != nil {
+				if !errors.Is(err, tt.want) {
+					t.Errorf("CompareAndDelete = %v, want %v", err, tt.want)
+				}
+			} else {
+				var statusErr *StatusError
+				if !errors.As(err, &statusErr) || statusErr.Status != tt.status || statusErr.Code != tt.code {
+					t.Errorf("CompareAndDelete = %v, want a StatusError %d %s", err, tt.status, tt.code)
+				}
+			}
+			if hits.count() != tt.attempts {
+				t.Errorf("sent %d attempts, want %d", hits.count(), tt.attempts)
+			}
+		})
+	}
+}
+
+func TestBatch(t *testing.T) {
+	leader := newShard(t, true, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, api.APIResponse{Success: false, Data: map[string]interface{}{
+			"results": []map[string]interface{}{
+				{"op": "put", "key": "a", "success": true},
+				{"op": "delete", "key": "b", "success": false, "error": "key not found", "code": api.CodeKeyNotFound},
+			},
+		}})
+	})
+
+	c := New([]string{leader}, testOptions())
+	errs, err := c.Batch(context.Background(), []api.BatchOperation{
+		{Op: "put", Key: "a", Value: "1"},
+		{Op: "delete", Key: "b"},
+	})
+	if err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	if len(errs) != 2 || errs[0] != nil || !errors.Is(errs[1], ErrNotFound) {
+		t.Errorf("Batch errors = %v, want [nil ErrNotFound]", errs)
+	}
+}
+
+func TestScan(t *testing.T) {
+	var got api.ScanRequest
+	leader := newShard(t, true, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		writeJSON(w, http.StatusOK, api.APIResponse{Success: true, Data: api.ScanResult{
+			Items: []api.ScanItem{{Key: "user/2", Value: "b"}, {Key: "user/3", ValueB64: "/w=="}},
+			More:  true,
+		}})
+	})
+
+	c := New([]string{leader}, testOptions())
+	page, err := c.Scan(context.Background(), "user/", "user/1", 1)
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if got.Prefix != "user/" || got.After != "user/1" || got.Limit != 1 {
+		t.Errorf("Scan sent %+v", got)
+	}
+	if len(page.Items) != 2 || page.Items[0] != (api.ScanItem{Key: "user/2", Value: "b"}) || !page.More {
+		t.Errorf("Scan = %+v, want user/2 with more", page)
+	}
+	if got := page.Items[1]; got != (api.ScanItem{Key: "user/3", Value: "\xff"}) {
+		t.Errorf("binary item = %+v, want its val_b64 decoded", got)
+	}
+}
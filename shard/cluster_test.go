@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+// testNode is one member of a testCluster. Its log, stable and snapshot
+// stores outlive the raft instance, so a stopped node restarts from them.
+type testNode struct {
+	Server    *Server
+	raft      *raft.Raft
+	transport *raft.InmemTransport
+	logs      *raft.InmemStore
+	snapshots *raft.InmemSnapshotStore
+	address   raft.ServerAddress
+	id        raft.ServerID
+}
+
+// testCluster is a raft cluster of in-process nodes over the in-memory
+// transport, each serving the handlers through its Server
+type testCluster struct {
+	t     *testing.T
+	Nodes []*testNode
+}
+
+// newTestCluster starts n voters bootstrapped by the first one, and waits
+// for it to lead. The nodes are shut down when the test ends.
+func newTestCluster(t *testing.T, n int) *testCluster {
+	t.Helper()
+	c := &testCluster{t: t}
+	var servers []raft.Server
+	for i := 0; i < n; i++ {
+		node := &testNode{
+			logs:      raft.NewInmemStore(),
+			snapshots: raft.NewInmemSnapshotStore(),
+			address:   raft.ServerAddress(fmt.Sprintf("node%d", i+1)),
+			id:        raft.ServerID(fmt.Sprintf("n%d", i+1)),
+		}
+		c.Nodes = append(c.Nodes, node)
+		servers = append(servers, raft.Server{ID: node.id, Address: node.address, Suffrage: raft.Voter})
+	}
+	for i := range c.Nodes {
+		c.Start(i)
+	}
+	if err := c.Nodes[0].raft.BootstrapCluster(raft.Configuration{Servers: servers}).Error(); err != nil {
+		t.Fatalf("BootstrapCluster: %v", err)
+	}
+	if leader := c.Leader(); leader != 0 {
+		t.Fatalf("node %d leads after bootstrap, want node 0", leader)
+	}
+	t.Cleanup(func() {
+		for i, node := range c.Nodes {
+			if node.raft != nil {
+				c.Stop(i)
+			}
+		}
+	})
+	return c
+}
+
+// Start starts node i from its stores with a fresh FSM, and connects it to
+// the other running nodes
+func (c *testCluster) Start(i int) {
+	c.t.Helper()
+	node := c.Nodes[i]
+	_, node.transport = raft.NewInmemTransport(node.address)
+	for _, peer := range c.Nodes {
+		if peer == node || peer.transport == nil {
+			continue
+		}
+		node.transport.Connect(peer.address, peer.transport)
+		peer.transport.Connect(node.address, node.transport)
+	}
+
+	config := raft.DefaultConfig()
+	config.LocalID = node.id
+	config.HeartbeatTimeout = 50 * time.Millisecond
+	config.ElectionTimeout = 50 * time.Millisecond
+	config.LeaderLeaseTimeout = 50 * time.Millisecond
+	config.CommitTimeout = 5 * time.Millisecond
+	config.LogOutput = io.Discard
+
+	store := fsm.NewFSM(fsm.Options{HistoryDepth: 1}).(*fsm.FSM)
+	r, err := raft.NewRaft(config, store, node.logs, node.logs, node.snapshots, node.transport)
+	if err != nil {
+		c.t.Fatalf("NewRaft for node %d: %v", i, err)
+	}
+	node.raft = r
+	// Local reads show what each node has applied rather than what the leader has
+	node.Server = New(r, store, raft.Server{ID: node.id, Address: node.address}, Config{
+		ApplyTimeout: time.Second,
+		ReadMode:     ReadModeLocal,
+	})
+}
+
+// Stop shuts node i down and disconnects it from the others
+func (c *testCluster) Stop(i int) {
+	c.t.Helper()
+	node := c.Nodes[i]
+	if err := node.raft.Shutdown().Error(); err != nil {
+		c.t.Fatalf("Shutdown of node %d: %v", i, err)
+	}
+	for _, peer := range c.Nodes {
+		if peer != node && peer.transport != nil {
+			peer.transport.Disconnect(node.address)
+		}
+	}
+	node.raft, node.transport = nil, nil
+}
+
+// Leader waits for one of the running nodes to lead and returns it
+func (c *testCluster) Leader() int {
+	c.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for i, node := range c.Nodes {
+			if node.raft != nil && node.raft.State() == raft.Leader {
+				return i
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.t.Fatal("no node was elected leader")
+	return -1
+}
+
+// Put writes key through node i's /put and returns the write's raft index
+func (c *testCluster) Put(i int, key, value string) uint64 {
+	c.t.Helper()
+	rec := httptest.NewRecorder()
+	query := url.Values{"key": {key}, "val": {value}}
+	c.Nodes[i].Server.PutHandler(rec, httptest.NewRequest(http.MethodPost, "/put?"+query.Encode(), nil))
+	var resp struct {
+		Data struct {
+			Index uint64 `json:"index"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		c.t.Fatalf("PUT %s on node %d = %d %s", key, i, rec.Code, rec.Body.String())
+	}
+	return resp.Data.Index
+}
+
+// Get reads node i's own copy of key through its /get, once it has applied
+// minIndex
+func (c *testCluster) Get(i int, key string, minIndex uint64) string {
+	c.t.Helper()
+	rec := httptest.NewRecorder()
+	query := url.Values{"key": {key}, "min_index": {fmt.Sprint(minIndex)}}
+	c.Nodes[i].Server.GetHandler(rec, httptest.NewRequest(http.MethodGet, "/get?"+query.Encode(), nil))
+	var resp api.GetResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		c.t.Fatalf("GET %s on node %d: response %q is not JSON", key, i, rec.Body.String())
+	}
+	return resp.Value
+}
+
+// expect checks that each of nodes holds every key of want once it has
+// applied minIndex
+func (c *testCluster) expect(nodes []int, want map[string]string, minIndex uint64) {
+	c.t.Helper()
+	for _, i := range nodes {
+		for key, value := range want {
+			if got := c.Get(i, key, minIndex); got != value {
+				c.t.Errorf("node %d has %s=%q, want %q", i, key, got, value)
+			}
+		}
+	}
+}
+
+func TestClusterReplicatesAndFailsOver(t *testing.T) {
+	c := newTestCluster(t, 3)
+	want := map[string]string{"a": "one"}
+
+	// Replication
+	index := c.Put(0, "a", "one")
+	c.expect([]int{0, 1, 2}, want, index)
+
+	// Leader failover: the others elect a leader that keeps the data and
+	// accepts writes
+	c.Stop(0)
+	leader := c.Leader()
+	if leader == 0 {
+		t.Fatal("the stopped node still leads")
+	}
+	index = c.Put(leader, "b", "two")
+	want["b"] = "two"
+	c.expect([]int{1, 2}, want, index)
+
+	// The old leader rejoins as a follower and catches up from its log
+	c.Start(0)
+	c.expect([]int{0}, want, index)
+
+	// Snapshot restore: a follower that lost its log rebuilds its state from
+	// its snapshot and whatever the leader sends after it
+	follower := 1
+	if leader == 1 {
+		follower = 2
+	}
+	if err := c.Nodes[follower].raft.Snapshot().Error(); err != nil {
+		t.Fatalf("Snapshot on node %d: %v", follower, err)
+	}
+	c.Stop(follower)
+	c.Nodes[follower].logs = raft.NewInmemStore()
+	index = c.Put(leader, "c", "three")
+	want["c"] = "three"
+	c.Start(follower)
+	c.expect([]int{follower}, want, index)
+}
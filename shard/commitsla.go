@@ -0,0 +1,86 @@
+// KV-Raft: Rolling commit latency and the -commit_sla alarm
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// commitLatencyWindow is how many of the latest successful applies the
+// moving average covers
+const commitLatencyWindow = 100
+
+// commitSLARepeat is how often a breach still in progress is logged again
+const commitSLARepeat = time.Minute
+
+// commitLatency keeps the durations of the latest commitLatencyWindow applies
+// that succeeded, from raft.Apply until the future's Error returned, in a
+// ring with their running sum
+type commitLatency struct {
+	mu      sync.Mutex
+	samples [commitLatencyWindow]time.Duration
+	next    int
+	count   int
+	sum     time.Duration
+
+	breached   bool
+	lastWarned time.Time
+}
+
+// average returns the mean of the samples in the window, 0 before the first
+func (c *commitLatency) average() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.averageLocked()
+}
+
+func (c *commitLatency) averageLocked() time.Duration {
+	if c.count == 0 {
+		return 0
+	}
+	return c.sum / time.Duration(c.count)
+}
+
+// isBreached reports whether the average was above -commit_sla at the last sample
+func (c *commitLatency) isBreached() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.breached
+}
+
+// recordCommit adds one apply's latency to the window and, with -commit_sla
+// set, logs a warning when the average goes above it, again every
+// commitSLARepeat while it stays above, and once more when it recovers
+func (s *Server) recordCommit(latency time.Duration) {
+	c := s.commitLatency
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.count == commitLatencyWindow {
+		c.sum -= c.samples[c.next]
+	} else {
+		c.count++
+	}
+	c.samples[c.next] = latency
+	c.sum += latency
+	c.next = (c.next + 1) % commitLatencyWindow
+
+	sla := s.config.CommitSLA
+	if sla <= 0 {
+		return
+	}
+	average := c.averageLocked()
+	switch {
+	case average > sla && (!c.breached || time.Since(c.lastWarned) >= commitSLARepeat):
+		log.Printf("[COMMIT-SLA] WARNING: average commit latency of the last %d applies is %s, above the %s SLA; check disk and network latency", c.count, average, sla)
+		c.breached = true
+		c.lastWarned = time.Now()
+	case average <= sla && c.breached:
+		log.Printf("[COMMIT-SLA] average commit latency is back to %s, within the %s SLA", average, sla)
+		c.breached = false
+	}
+}
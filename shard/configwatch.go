@@ -0,0 +1,54 @@
+// KV-Raft: Long-polling for changes to the cluster configuration
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxConfigWait caps how long one /config request may block
+	maxConfigWait = 5 * time.Minute
+	// configPollInterval is how often a waiting request re-reads the epoch
+	configPollInterval = 200 * time.Millisecond
+)
+
+// ConfigRequest holds the optional long-poll parameters of /config
+type ConfigRequest struct {
+	Wait  string `json:"wait"`  // duration such as "30s"; empty returns immediately
+	Epoch uint64 `json:"epoch"` // block until the epoch is past this one
+}
+
+// waitForConfigEpoch blocks until the configuration epoch is past epoch, the
+// wait elapses or the client goes away. The epoch is the log index of the
+// latest committed raft configuration, so it advances whenever a shard joins
+// or leaves.
+func (us *UnifiedServer) waitForConfigEpoch(ctx context.Context, w http.ResponseWriter, epoch uint64, wait time.Duration) {
+	if wait > maxConfigWait {
+		wait = maxConfigWait
+	}
+
+	// The server's write timeout would otherwise cut off a long wait
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 10*time.Second))
+
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if us.server.configurationIndex() > epoch {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// KV-Raft: CORS headers for browser clients of the read and status endpoints
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// corsPaths are the endpoints, relative to a group's prefix, that browsers
+// may call from another origin: reads and cluster status, never writes or
+// raft management
+var corsPaths = map[string]bool{
+	"/get":          true,
+	"/getfield":     true,
+	"/mget":         true,
+	"/txget":        true,
+	"/scan":         true,
+	"/readyz":       true,
+	"/locate":       true,
+	"/version":      true,
+	"/openapi.json": true,
+	"/config":       true,
+	"/hotkeys":      true,
+	"/raft/status":  true,
+	"/raft/peers":   true,
+	"/raft/leader":  true,
+	"/raft/history": true,
+}
+
+const (
+	corsAllowMethods = "GET, POST, OPTIONS" // /mget takes its keys in a POST body
+	corsAllowHeaders = "Content-Type, Cache-Control, " + sessionHeader
+	corsMaxAge       = "600"
+)
+
+// parseCORSOrigins splits -cors_origins into the allowed origins. Each is *
+// or a scheme://host[:port] origin exactly as a browser sends it.
+func parseCORSOrigins(list string) ([]string, error) {
+	var origins []string
+	for _, origin := range strings.Split(list, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		if origin != "*" {
+			parsed, err := url.Parse(origin)
+			if err != nil || parsed.Scheme == "" || parsed.Host == "" || parsed.Path != "" || parsed.RawQuery != "" {
+				return nil, fmt.Errorf("%q is not * or an origin such as https://dashboard.example.com", origin)
+			}
+		}
+		origins = append(origins, origin)
+	}
+	return origins, nil
+}
+
+// corsHandler adds CORS headers to responses from corsPaths for requests
+// whose Origin is in origins, or for any origin with *, and answers their
+// preflight OPTIONS requests itself. Other requests pass through untouched,
+// so a browser on another origin cannot read their responses.
+func corsHandler(next http.Handler, origins []string) http.Handler {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !corsPaths[groupRelativePath(r.URL.Path)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		switch {
+		case allowed["*"]:
+			header.Set("Access-Control-Allow-Origin", "*")
+		case allowed[origin]:
+			header.Set("Access-Control-Allow-Origin", origin)
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			header.Set("Access-Control-Allow-Methods", corsAllowMethods)
+			header.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			header.Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// groupRelativePath strips a /shard/<group> prefix from path, so a group
+// other than 0 matches the same corsPaths
+func groupRelativePath(path string) string {
+	rest, ok := strings.CutPrefix(path, "/shard/")
+	if !ok {
+		return path
+	}
+	if slash := strings.Index(rest, "/"); slash >= 0 {
+		return rest[slash:]
+	}
+	return path
+}
@@ -0,0 +1,84 @@
+// KV-Raft: Internal operation counters for debugging without Prometheus
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"kv-raft/api"
+)
+
+// opCounters count, for the whole process since startup or the last reset,
+// what /debug/counters reports
+var opCounters struct {
+	puts              atomic.Uint64
+	gets              atomic.Uint64
+	deletes           atomic.Uint64
+	applyFailures     atomic.Uint64
+	forwards          atomic.Uint64
+	broadcastFailures atomic.Uint64
+}
+
+// requestCounters maps the instrument op names of the handlers counted as
+// puts, gets and deletes to their counter
+var requestCounters = map[string]*atomic.Uint64{
+	"put":       &opCounters.puts,
+	"get":       &opCounters.gets,
+	"get_field": &opCounters.gets,
+	"mget":      &opCounters.gets,
+	"txget":     &opCounters.gets,
+	"scan":      &opCounters.gets,
+	"delete":    &opCounters.deletes,
+	"delete_if": &opCounters.deletes,
+}
+
+// countRequest counts a request to the handler instrumented as op, if it is
+// one of requestCounters
+func countRequest(op string) {
+	if counter, ok := requestCounters[op]; ok {
+		counter.Add(1)
+	}
+}
+
+// CountersHandler returns the op counters as JSON. With ?reset=true each one
+// is read and zeroed at once, so an increment between the two is never lost.
+func CountersHandler(w http.ResponseWriter, r *http.Request) {
+	reset := false
+	if value := r.URL.Query().Get("reset"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "reset must be true or false")
+			return
+		}
+		reset = parsed
+	}
+
+	read := func(counter *atomic.Uint64) uint64 {
+		if reset {
+			return counter.Swap(0)
+		}
+		return counter.Load()
+	}
+
+	message := "Counters since startup or the last reset"
+	if reset {
+		message = "Counters reset; these are the values before the reset"
+	}
+	response := APIResponse{
+		Success: true,
+		Message: message,
+		Data: map[string]interface{}{
+			"puts":               read(&opCounters.puts),
+			"gets":               read(&opCounters.gets),
+			"deletes":            read(&opCounters.deletes),
+			"apply_failures":     read(&opCounters.applyFailures),
+			"forwards":           read(&opCounters.forwards),
+			"broadcast_failures": read(&opCounters.broadcastFailures),
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,109 @@
+// KV-Raft: Conditional delete of a key that still holds an expected value
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+// DeleteIfHandler deletes a key only while its latest value is the expected
+// one. The comparison and the delete are one log entry, so a value another
+// client wrote in between is never deleted: the request fails with 409.
+func (s *Server) DeleteIfHandler(w http.ResponseWriter, r *http.Request) {
+	var req DeleteIfRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if req.Key == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("key"))
+		return
+	}
+	if req.Expected == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("expected"))
+		return
+	}
+
+	if !validNamespace(req.Namespace) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Namespace must not contain '/'")
+		return
+	}
+	if !validKey(req.Namespace, req.Key) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, bareKeyMessage)
+		return
+	}
+	if reservedKey(namespacedKey(req.Namespace, req.Key)) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, reservedKeyMessage)
+		return
+	}
+
+	idemKey, ok := idempotencyKey(w, r)
+	if !ok {
+		return
+	}
+
+	payload := fsm.Payload{
+		OP:             fsm.DELIF,
+		Key:            namespacedKey(req.Namespace, req.Key),
+		Expected:       req.Expected,
+		IdempotencyKey: idemKey,
+	}
+
+	data, err := s.marshalPayload(payload)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal payload")
+		return
+	}
+
+	if err := s.checkWriteQuorum(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+
+	applyFuture := s.timedApply(payload.OP, data)
+	if err := applyFuture.Error(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+	setSessionToken(w, applyFuture.Index())
+
+	applyResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Invalid raft response")
+		return
+	}
+	markReplayed(w, applyResponse)
+
+	if applyResponse.Error != nil {
+		switch {
+		case errors.Is(applyResponse.Error, fsm.ErrKeyNotFound):
+			writeJSONError(w, http.StatusNotFound, api.CodeKeyNotFound, "Key not found")
+		case errors.Is(applyResponse.Error, fsm.ErrCASMismatch):
+			writeJSONError(w, http.StatusConflict, api.CodeCASMismatch, "Key no longer holds the expected value; it was not deleted")
+		default:
+			status, code := applyErrorStatus(applyResponse.Error)
+			writeJSONError(w, status, code, "Failed to delete key: "+applyResponse.Error.Error())
+		}
+		return
+	}
+
+	log.Printf("[HTTP-DELETE-IF] key %s was deleted", req.Key)
+
+	response := APIResponse{
+		Success: true,
+		Message: "Key deleted successfully",
+		Data: map[string]interface{}{
+			"key":   req.Key,
+			"index": applyFuture.Index(),
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,91 @@
+// KV-Raft: Atomic deletion of every key under a prefix
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+func (s *Server) DeletePrefixHandler(w http.ResponseWriter, r *http.Request) {
+	var req DeletePrefixRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	// An empty prefix would match, and delete, every key in the store
+	if req.Prefix == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("prefix"))
+		return
+	}
+	if reservedPrefix(req.Prefix) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, reservedKeyMessage)
+		return
+	}
+
+	idemKey, ok := idempotencyKey(w, r)
+	if !ok {
+		return
+	}
+
+	// Only the leader applies the deletion, so followers forward it
+	if s.raft.State() != raft.Leader {
+		query := url.Values{}
+		query.Set("prefix", req.Prefix)
+		s.forwardToLeader(w, r, http.MethodPost, "/deleteprefix?"+query.Encode(), nil)
+		return
+	}
+
+	// The whole subtree is removed by a single log entry
+	payload := fsm.Payload{
+		OP:             fsm.DELPREFIX,
+		Key:            req.Prefix,
+		IdempotencyKey: idemKey,
+	}
+
+	data, err := s.marshalPayload(payload)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal payload")
+		return
+	}
+
+	if err := s.checkWriteQuorum(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+
+	applyFuture := s.timedApply(payload.OP, data)
+	if err := applyFuture.Error(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+	setSessionToken(w, applyFuture.Index())
+
+	applyResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Invalid raft response")
+		return
+	}
+	markReplayed(w, applyResponse)
+
+	log.Printf("[HTTP-DELETE-PREFIX] %v keys under %s were deleted", applyResponse.Data, req.Prefix)
+
+	response := APIResponse{
+		Success: true,
+		Message: "Keys deleted successfully",
+		Data: map[string]interface{}{
+			"prefix":  req.Prefix,
+			"deleted": applyResponse.Data,
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
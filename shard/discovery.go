@@ -0,0 +1,200 @@
+// KV-Raft: Peer discovery through DNS SRV records
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lookupSRVPeers resolves the SRV record name, such as the one a Kubernetes
+// headless service publishes, and returns the HTTP addresses of its targets,
+// sorted. Each target's port is a raft port, mapped as raft port - 10000.
+func lookupSRVPeers(name string) ([]string, error) {
+	_, records, err := net.LookupSRV("", "", name)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make([]string, 0, len(records))
+	for _, record := range records {
+		raftAddr := net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+		peers = append(peers, convertRaftToHTTPAddress(raftAddr))
+	}
+	sort.Strings(peers)
+	return peers, nil
+}
+
+// staticPeers returns the -peer_shards addresses as a peer source
+func staticPeers(list string) func() []string {
+	var peers []string
+	for _, peer := range strings.Split(list, ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			peers = append(peers, peer)
+		}
+	}
+	return func() []string { return peers }
+}
+
+// srvPeers returns the targets of the SRV record name as a peer source,
+// resolved again on every call so that it follows records coming and going
+func srvPeers(name string) func() []string {
+	return func() []string {
+		peers, err := lookupSRVPeers(name)
+		if err != nil {
+			log.Printf("[DISCOVERY] failed to resolve %s: %v", name, err)
+		}
+		return peers
+	}
+}
+
+// StartDiscovery resolves the SRV record name now and every interval until
+// Stop is called. Peers found are added to the shard map, and while this node
+// knows no leader it asks the discovered peers' leader to add it as a voter.
+//
+// A peer whose record disappears is only logged: a DNS blip must not shrink
+// the quorum, so members still leave through /raft/leave or -leave_on_shutdown.
+func (us *UnifiedServer) StartDiscovery(name string, interval time.Duration) {
+	us.wg.Add(1)
+	go func() {
+		defer us.wg.Done()
+
+		known := map[string]bool{}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			us.discover(name, known)
+			select {
+			case <-us.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// discover runs one round of StartDiscovery; known holds the peers found by
+// the previous round and is updated in place
+func (us *UnifiedServer) discover(name string, known map[string]bool) {
+	peers, err := lookupSRVPeers(name)
+	if err != nil {
+		log.Printf("[DISCOVERY] failed to resolve %s: %v", name, err)
+		return
+	}
+
+	current := map[string]bool{}
+	for _, peer := range peers {
+		current[peer] = true
+		if !known[peer] {
+			log.Printf("[DISCOVERY] found peer %s", peer)
+		}
+	}
+	for peer := range known {
+		if !current[peer] {
+			log.Printf("[DISCOVERY] peer %s is no longer in %s; it stays a raft member until it leaves", peer, name)
+			delete(known, peer)
+		}
+	}
+
+	var others []string
+	for _, peer := range peers {
+		known[peer] = true
+		server, err := fetchPeerIdentity(peer)
+		if err != nil || server.ID == us.server.self.ID {
+			continue
+		}
+		others = append(others, peer)
+		if id := extractShardIDFromAddress(peer); id > 0 && id != us.shardID {
+			us.setShard(id, peer)
+		}
+	}
+
+	// A recovered node rejoins as a nonvoter first, through StartRejoin
+	if leaderAddr, _ := us.raft.LeaderWithID(); leaderAddr == "" && len(others) > 0 && !us.rejoining.Load() {
+		us.joinThroughPeers(others)
+	}
+}
+
+// leaderURLThroughPeers returns the base URL of the leader as reported by the
+// first peer that knows one, or "" if none does
+func leaderURLThroughPeers(client *http.Client, peers []string) string {
+	for _, peer := range peers {
+		resp, err := client.Get(fmt.Sprintf("http://%s/raft/leader", peer))
+		if err != nil {
+			continue
+		}
+		var leader struct {
+			Data struct {
+				URL string `json:"url"`
+			} `json:"data"`
+		}
+		json.NewDecoder(resp.Body).Decode(&leader)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && leader.Data.URL != "" {
+			return leader.Data.URL
+		}
+	}
+	return ""
+}
+
+// postToLeader posts form to path on the leader found through the peers and
+// returns the status it answered with
+func postToLeader(peers []string, path string, form url.Values) (int, error) {
+	client := &http.Client{Timeout: tcpTimeout}
+	leaderURL := leaderURLThroughPeers(client, peers)
+	if leaderURL == "" {
+		return 0, errors.New("no peer knows a leader")
+	}
+	resp, err := client.PostForm(leaderURL+path, form)
+	if err != nil {
+		return 0, err
+	}
+	var result APIResponse
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("leader %s answered %s", leaderURL, result.Error)
+	}
+	return resp.StatusCode, nil
+}
+
+// joinThroughPeers asks the leader, as reported by the first peer that knows
+// one, to add this node as a voter
+func (us *UnifiedServer) joinThroughPeers(peers []string) {
+	client := &http.Client{Timeout: tcpTimeout}
+	// Join with -raft_addr as given, as operators do, rather than the
+	// transport's resolved address, so a member rejoining matches its entry
+	nodeID := string(us.server.self.ID)
+
+	leaderURL := leaderURLThroughPeers(client, peers)
+	if leaderURL == "" {
+		return
+	}
+
+	form := url.Values{"nodeid": {nodeID}, "addr": {*raftaddr}}
+	resp, err := client.PostForm(leaderURL+"/raft/join", form)
+	if err != nil {
+		log.Printf("[JOIN] failed to reach leader %s: %v", leaderURL, err)
+		return
+	}
+	var result APIResponse
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		log.Printf("[JOIN] joined through leader %s: %s", leaderURL, result.Message)
+	} else {
+		log.Printf("[JOIN] leader %s did not add this node: %s", leaderURL, result.Error)
+	}
+}
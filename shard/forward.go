@@ -0,0 +1,94 @@
+// KV-Raft: Forwarding of requests from followers to the raft leader
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"kv-raft/api"
+)
+
+// forwardedHeader marks a request already forwarded once, so two nodes that
+// disagree about who the leader is cannot bounce a request between them
+const forwardedHeader = "X-KV-Raft-Forwarded"
+
+// forwardClient pools connections to the leader; main gives it an HTTP/2
+// transport with -h2c
+var forwardClient = &http.Client{Timeout: 5 * time.Second, Transport: newPeerTransport(false)}
+
+// forwardToLeader proxies a request to the current raft leader's HTTP API and
+// copies the leader's response back to the client. Successful GETs are served
+// from the forward cache, when enabled, unless the client sends
+// Cache-Control: no-cache.
+func (s *Server) forwardToLeader(w http.ResponseWriter, r *http.Request, method, pathAndQuery string, body io.Reader) {
+	if r.Header.Get(forwardedHeader) != "" {
+		writeJSONError(w, http.StatusServiceUnavailable, api.CodeNotLeader, "This node is not the leader and the request was already forwarded")
+		return
+	}
+
+	cacheable := method == http.MethodGet && s.forwardCache != nil && !bypassForwardCache(r)
+	if cacheable && s.forwardCache.serve(w, pathAndQuery) {
+		return
+	}
+
+	leaderAddr, leaderID := s.raft.LeaderWithID()
+	if leaderAddr == "" {
+		writeJSONError(w, http.StatusServiceUnavailable, api.CodeNoLeader, "No raft leader available")
+		return
+	}
+
+	url := s.peerURL(leaderAddr) + pathAndQuery
+	req, err := http.NewRequestWithContext(r.Context(), method, url, body)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to build forwarded request")
+		return
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if key := r.Header.Get(idempotencyHeader); key != "" {
+		req.Header.Set(idempotencyHeader, key)
+	}
+	if token := r.Header.Get(sessionHeader); token != "" {
+		req.Header.Set(sessionHeader, token)
+	}
+	req.Header.Set(forwardedHeader, "true")
+
+	log.Printf("[FORWARD] %s %s to leader %s", method, pathAndQuery, leaderID)
+	opCounters.forwards.Add(1)
+
+	resp, err := forwardClient.Do(req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, api.CodeForwardFailed, "Failed to reach leader: "+err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	if cacheable && resp.StatusCode == http.StatusOK {
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			writeJSONError(w, http.StatusBadGateway, api.CodeForwardFailed, "Failed to read leader response: "+err.Error())
+			return
+		}
+		s.forwardCache.set(pathAndQuery, resp.StatusCode, resp.Header, respBody)
+
+		for name, values := range resp.Header {
+			w.Header()[name] = values
+		}
+		w.Header().Set(forwardCacheHeader, "MISS")
+		w.WriteHeader(resp.StatusCode)
+		w.Write(respBody)
+		return
+	}
+
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
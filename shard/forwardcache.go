@@ -0,0 +1,108 @@
+// KV-Raft: Short-lived cache of reads forwarded to the leader
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// forwardCacheMaxEntries bounds the memory held by the forward cache;
+	// responses are not cached while it is full of unexpired entries
+	forwardCacheMaxEntries = 10000
+
+	forwardCacheHeader    = "X-Forward-Cache"
+	forwardCacheAgeHeader = "X-Forward-Cache-Age"
+)
+
+type forwardedResponse struct {
+	status   int
+	header   http.Header
+	body     []byte
+	cachedAt time.Time
+}
+
+// forwardCache holds successful GET responses a follower got from the leader
+// for up to ttl, keyed by path and query. Unlike the read cache it is not kept
+// in step with the log: an entry is never invalidated, it only expires.
+type forwardCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]forwardedResponse
+}
+
+// newForwardCache returns a cache serving responses up to ttl old, or nil when
+// ttl is not positive. A nil *forwardCache is valid and never hits.
+func newForwardCache(ttl time.Duration) *forwardCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &forwardCache{
+		ttl:     ttl,
+		entries: make(map[string]forwardedResponse),
+	}
+}
+
+// bypassForwardCache reports whether the client asked for a fresh response,
+// or sent a session token that a cached response may predate
+func bypassForwardCache(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Cache-Control"), "no-cache") || r.Header.Get(sessionHeader) != ""
+}
+
+// serve writes the cached response for pathAndQuery, if still within the ttl
+func (c *forwardCache) serve(w http.ResponseWriter, pathAndQuery string) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[pathAndQuery]
+	c.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	age := time.Since(entry.cachedAt)
+	if age > c.ttl {
+		c.mu.Lock()
+		delete(c.entries, pathAndQuery)
+		c.mu.Unlock()
+		return false
+	}
+
+	for name, values := range entry.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(forwardCacheHeader, "HIT")
+	w.Header().Set(forwardCacheAgeHeader, strconv.FormatInt(age.Milliseconds(), 10))
+	w.WriteHeader(entry.status)
+	w.Write(entry.body)
+	return true
+}
+
+func (c *forwardCache) set(pathAndQuery string, status int, header http.Header, body []byte) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= forwardCacheMaxEntries {
+		for key, entry := range c.entries {
+			if time.Since(entry.cachedAt) > c.ttl {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= forwardCacheMaxEntries {
+			return
+		}
+	}
+	c.entries[pathAndQuery] = forwardedResponse{status: status, header: header.Clone(), body: body, cachedAt: time.Now()}
+}
@@ -0,0 +1,27 @@
+// KV-Raft: Atomic append to a string value
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package fsm
+
+import "fmt"
+
+// Append adds delimiter and value to the end of key's latest value, or stores
+// value alone when key is absent, and returns the new length in bytes. When
+// maxBytes is positive, an append that would make the value longer fails
+// with ErrValueTooLarge and leaves it unchanged.
+func (fsm FSM) Append(key, delimiter, value string, maxBytes int) (int, error) {
+	appended := value
+	if existing, ok := fsm.kv_store.Load(key); ok {
+		appended = fsm.compression.decode(existing.latest().value) + delimiter + value
+	}
+
+	if maxBytes > 0 && len(appended) > maxBytes {
+		return 0, fmt.Errorf("%w: appending would make the value %d bytes, the limit is %d", ErrValueTooLarge, len(appended), maxBytes)
+	}
+
+	if err := fsm.Put(key, appended); err != nil {
+		return 0, err
+	}
+	return len(appended), nil
+}
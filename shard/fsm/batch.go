@@ -0,0 +1,32 @@
+// KV-Raft: Several writes applied by a single raft log entry
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package fsm
+
+import "fmt"
+
+// BatchOp is one write of a BATCH payload; OP is PUT or DEL
+type BatchOp struct {
+	OP    string
+	Key   string
+	Value string `json:",omitempty"`
+}
+
+// ApplyBatch applies ops in order and returns the error of each, nil for
+// those that succeeded. No other entry can interleave with the batch, but an
+// op that fails does not undo the ops before it.
+func (fsm FSM) ApplyBatch(ops []BatchOp) []error {
+	errs := make([]error, len(ops))
+	for i, op := range ops {
+		switch op.OP {
+		case PUT:
+			errs[i] = fsm.Put(op.Key, op.Value)
+		case DEL:
+			errs[i] = fsm.Delete(op.Key)
+		default:
+			errs[i] = fmt.Errorf("%w: %q in a batch", ErrUnsupportedOp, op.OP)
+		}
+	}
+	return errs
+}
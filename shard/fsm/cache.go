@@ -0,0 +1,87 @@
+// KV-Raft: Read cache with a bounded staleness window
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package fsm
+
+import (
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	value    interface{}
+	cachedAt time.Time
+}
+
+// ReadCache holds values read through the raft log for up to ttl. It is filled
+// and invalidated from FSM.Apply, so every node keeps it in step with the log.
+type ReadCache struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	entries map[string]cacheEntry
+}
+
+// NewReadCache returns a cache serving values up to ttl old, or nil when ttl
+// is not positive. A nil *ReadCache is valid and never hits.
+func NewReadCache(ttl time.Duration) *ReadCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &ReadCache{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// Get returns the cached value for key and its age, if still within the ttl
+func (c *ReadCache) Get(key string) (interface{}, time.Duration, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, 0, false
+	}
+
+	age := time.Since(entry.cachedAt)
+	if age > c.ttl {
+		c.invalidate(key)
+		return nil, 0, false
+	}
+	return entry.value, age, true
+}
+
+func (c *ReadCache) set(key string, value interface{}) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.entries[key] = cacheEntry{value: value, cachedAt: time.Now()}
+	c.mu.Unlock()
+}
+
+// clear drops every entry, as after restoring a snapshot
+func (c *ReadCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.entries = make(map[string]cacheEntry)
+	c.mu.Unlock()
+}
+
+func (c *ReadCache) invalidate(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+}
@@ -0,0 +1,94 @@
+// KV-Raft: Snappy compression of large values at rest
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package fsm
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// The one-byte prefix of every value stored with compression enabled
+const (
+	valueRaw    byte = 0
+	valueSnappy byte = 1
+)
+
+// valueCompression stores values of at least minBytes snappy-compressed when
+// that makes them smaller. With compression every stored value carries a
+// valueRaw or valueSnappy prefix; without it (a nil *valueCompression) values
+// are stored as they are. One process never holds both forms: the store is
+// rebuilt from the snapshot and the log at every start, and restore and Put
+// store values in this process's form.
+type valueCompression struct {
+	minBytes int
+}
+
+// newValueCompression returns the compression for Options.CompressMinBytes,
+// nil when it is not positive
+func newValueCompression(minBytes int) *valueCompression {
+	if minBytes <= 0 {
+		return nil
+	}
+	return &valueCompression{minBytes: minBytes}
+}
+
+// encode returns value in its stored form
+func (c *valueCompression) encode(value string) string {
+	if c == nil {
+		return value
+	}
+	if len(value) >= c.minBytes {
+		buf := make([]byte, 1+snappy.MaxEncodedLen(len(value)))
+		buf[0] = valueSnappy
+		compressed := snappy.Encode(buf[1:], []byte(value))
+		if len(compressed) < len(value) {
+			return string(buf[:1+len(compressed)])
+		}
+	}
+	return string(valueRaw) + value
+}
+
+// decode returns the value stored as stored. Compressed values were either
+// compressed by this process or checked by restore, so they always decode.
+func (c *valueCompression) decode(stored string) string {
+	if c == nil {
+		return stored
+	}
+	if stored[0] == valueSnappy {
+		value, err := snappy.Decode(nil, []byte(stored[1:]))
+		if err != nil {
+			panic(fmt.Sprintf("stored value does not decompress: %v", err))
+		}
+		return string(value)
+	}
+	return stored[1:]
+}
+
+// snapshotVersion returns the snapshot form of a stored version: a
+// compressed value is written as its snappy bytes, any other as the value
+func (c *valueCompression) snapshotVersion(v versionedValue) snapshotVersion {
+	if c != nil && v.value[0] == valueSnappy {
+		return snapshotVersion{Version: v.version, Snappy: []byte(v.value[1:])}
+	}
+	return snapshotVersion{Version: v.version, Value: c.decode(v.value)}
+}
+
+// restoreVersion returns a snapshot version in this process's stored form.
+// Compressed values stay compressed with compression enabled and are
+// decompressed without it.
+func (c *valueCompression) restoreVersion(v snapshotVersion) (versionedValue, error) {
+	if len(v.Snappy) == 0 {
+		return versionedValue{version: v.Version, value: c.encode(v.Value)}, nil
+	}
+	value, err := snappy.Decode(nil, v.Snappy)
+	if err != nil {
+		return versionedValue{}, fmt.Errorf("version %d does not decompress: %w", v.Version, err)
+	}
+	if c == nil {
+		return versionedValue{version: v.Version, value: string(value)}, nil
+	}
+	return versionedValue{version: v.Version, value: string(valueSnappy) + string(v.Snappy)}, nil
+}
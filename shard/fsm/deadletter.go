@@ -0,0 +1,76 @@
+// KV-Raft: Dead-letter log for raft commands the FSM could not apply
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package fsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// deadLetter is a single skipped log entry as written to the dead-letter file
+type deadLetter struct {
+	Index  uint64    `json:"index"`
+	Term   uint64    `json:"term"`
+	Reason string    `json:"reason"`
+	Data   []byte    `json:"data"`
+	Time   time.Time `json:"time"`
+}
+
+// deadLetterLog appends entries that could not be applied to an on-disk file
+// so that divergence between the raft log and the FSM does not go unnoticed.
+type deadLetterLog struct {
+	mu       sync.Mutex
+	path     string
+	count    atomic.Uint64
+	onRecord func() // nil when nobody is notified
+}
+
+func newDeadLetterLog(path string, onRecord func()) *deadLetterLog {
+	return &deadLetterLog{path: path, onRecord: onRecord}
+}
+
+func (d *deadLetterLog) record(log *raft.Log, reason string) {
+	d.count.Add(1)
+	if d.onRecord != nil {
+		d.onRecord()
+	}
+	fmt.Fprintf(os.Stderr, "dead letter at index %d term %d: %s\n", log.Index, log.Term, reason)
+
+	if d.path == "" {
+		return
+	}
+
+	line, err := json.Marshal(deadLetter{
+		Index:  log.Index,
+		Term:   log.Term,
+		Reason: reason,
+		Data:   log.Data,
+		Time:   time.Now(),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error marshalling dead letter %s\n", err.Error())
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f, err := os.OpenFile(d.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error opening dead letter file %s\n", err.Error())
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing dead letter %s\n", err.Error())
+	}
+}
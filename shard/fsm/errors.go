@@ -0,0 +1,77 @@
+// KV-Raft: Typed errors returned through ApplyResponse
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/raft"
+)
+
+// Sentinel errors set in ApplyResponse.Error. Handlers should compare them
+// with errors.Is, as some are wrapped with extra context.
+var (
+	ErrKeyNotFound     = errors.New("key not found")
+	ErrVersionNotFound = errors.New("version not found")
+	ErrTypeMismatch    = errors.New("value has unexpected type")
+	ErrCASMismatch     = errors.New("compare-and-swap expected value does not match")
+	ErrLockHeld        = errors.New("lock is held by another owner")
+	ErrLockNotHeld     = errors.New("lock is not held")
+	ErrValueTooLarge   = errors.New("value is too large")
+	ErrUnsupportedOp   = errors.New("unsupported operation")
+)
+
+// ApplyError describes a log entry, or one op of a BATCH entry, whose apply
+// returned an error
+type ApplyError struct {
+	Index uint64
+	OP    string
+	Key   string
+	Err   error
+}
+
+// reportErrors passes every error in an apply result to onApplyError
+func (fsm FSM) reportErrors(log *raft.Log, payload Payload, result interface{}) {
+	response, ok := result.(*ApplyResponse)
+	if fsm.onApplyError == nil || !ok || response.Replayed {
+		return
+	}
+
+	if response.Error != nil {
+		fsm.onApplyError(ApplyError{Index: log.Index, OP: payload.OP, Key: payload.Key, Err: response.Error})
+	}
+	if errs, ok := response.Data.([]error); ok {
+		for i, err := range errs {
+			if err != nil {
+				fsm.onApplyError(ApplyError{Index: log.Index, OP: payload.OP, Key: payload.Ops[i].Key, Err: err})
+			}
+		}
+	}
+}
+
+// sentinels are the errors restoreError recognises by message
+var sentinels = []error{
+	ErrKeyNotFound, ErrVersionNotFound, ErrTypeMismatch, ErrCASMismatch, ErrLockHeld, ErrLockNotHeld,
+	ErrValueTooLarge, ErrUnsupportedOp,
+}
+
+// restoreError rebuilds an error recorded as its message, wrapping the
+// sentinel it started with so errors.Is still matches
+func restoreError(msg string) error {
+	if msg == "" {
+		return nil
+	}
+	for _, sentinel := range sentinels {
+		if msg == sentinel.Error() {
+			return sentinel
+		}
+		if detail, ok := strings.CutPrefix(msg, sentinel.Error()+": "); ok {
+			return fmt.Errorf("%w: %s", sentinel, detail)
+		}
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,198 @@
+// KV-Raft: Deterministic least-recently-used eviction of keys
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package fsm
+
+import (
+	"container/list"
+	"encoding"
+	"hash"
+	"hash/fnv"
+	"sync"
+)
+
+// keyLRU orders the stored keys by their last write or raft-applied read. It
+// is only updated from FSM.Apply, so its order is the order of the raft log:
+// the log index is the clock, never wall time, and every node fed the same log
+// evicts the same keys in the same order. Reads served from the read cache do
+// not go through the log and so do not count as accesses.
+//
+// The cap itself is replicated too: it starts at 0 (no cap) on every node and
+// only changes through the MaxKeys a log entry carries, see FSM.setMaxKeys.
+type keyLRU struct {
+	mu       sync.Mutex
+	size     int        // 0 when there is no cap
+	order    *list.List // most recently used first
+	elements map[string]*list.Element
+
+	evicted uint64
+	digest  hash.Hash64 // FNV-1a over every evicted key in eviction order
+}
+
+// EvictionStats describes the keys evicted under the replicated -max_keys cap
+type EvictionStats struct {
+	MaxKeys int
+	Evicted uint64
+	// Digest covers the evicted keys in order; nodes that applied the same
+	// log report the same digest
+	Digest uint64
+}
+
+// newKeyLRU returns an LRU with no cap, which keeps no order and never evicts
+func newKeyLRU() *keyLRU {
+	return &keyLRU{
+		order:    list.New(),
+		elements: make(map[string]*list.Element),
+		digest:   fnv.New64a(),
+	}
+}
+
+// touch marks key as the most recently used, inserting it if new, and returns
+// the least recently used keys that no longer fit
+func (c *keyLRU) touch(key string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size == 0 {
+		return nil
+	}
+	if elem, ok := c.elements[key]; ok {
+		c.order.MoveToFront(elem)
+		return nil
+	}
+
+	c.elements[key] = c.order.PushFront(key)
+	return c.evictOverflow()
+}
+
+// resize changes the cap to size, 0 removing it, and returns the least
+// recently used keys that no longer fit. Putting a cap on a store that had
+// none orders the keys listed by keys, sorted by name, as if written in that
+// order, leaving out the sequences that NextID keeps from being evicted.
+func (c *keyLRU) resize(size int, keys func() []string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case size == c.size:
+		return nil
+	case size == 0:
+		// Without a cap nothing is snapshotted, so the counters restart
+		// here too, on every node alike
+		c.reset()
+		return nil
+	case c.size == 0:
+		for _, key := range keys() {
+			if !isSequenceKey(key) {
+				c.elements[key] = c.order.PushFront(key)
+			}
+		}
+	}
+	c.size = size
+	return c.evictOverflow()
+}
+
+// evictOverflow removes the least recently used keys beyond the cap
+func (c *keyLRU) evictOverflow() []string {
+	var evicted []string
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		key := oldest.Value.(string)
+		c.order.Remove(oldest)
+		delete(c.elements, key)
+
+		c.evicted++
+		c.digest.Write([]byte(key))
+		c.digest.Write([]byte{0})
+		evicted = append(evicted, key)
+	}
+	return evicted
+}
+
+func (c *keyLRU) reset() {
+	c.size = 0
+	c.order.Init()
+	c.elements = make(map[string]*list.Element)
+	c.evicted = 0
+	c.digest = fnv.New64a()
+}
+
+// access marks an existing key as the most recently used
+func (c *keyLRU) access(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.elements[key]; ok {
+		c.order.MoveToFront(elem)
+	}
+}
+
+func (c *keyLRU) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.elements[key]; ok {
+		c.order.Remove(elem)
+		delete(c.elements, key)
+	}
+}
+
+// snapshot returns the cap, LRU order and eviction counters, or nil when there is no cap
+func (c *keyLRU) snapshot() (*snapshotEviction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size == 0 {
+		return nil, nil
+	}
+
+	digest, err := c.digest.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	state := &snapshotEviction{
+		MaxKeys: c.size,
+		Order:   make([]string, 0, c.order.Len()),
+		Evicted: c.evicted,
+		Digest:  digest,
+	}
+	for elem := c.order.Back(); elem != nil; elem = elem.Prev() {
+		state.Order = append(state.Order, elem.Value.(string))
+	}
+	return state, nil
+}
+
+// restore replaces the LRU with a snapshot's. A snapshot taken with no cap,
+// or before the cap was replicated, restores with none; the leader's next
+// entry puts it back.
+func (c *keyLRU) restore(state *snapshotEviction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reset()
+	if state == nil || state.MaxKeys <= 0 {
+		return nil
+	}
+
+	c.size = state.MaxKeys
+	for _, key := range state.Order {
+		c.elements[key] = c.order.PushFront(key)
+	}
+	c.evicted = state.Evicted
+	if len(state.Digest) > 0 {
+		return c.digest.(encoding.BinaryUnmarshaler).UnmarshalBinary(state.Digest)
+	}
+	return nil
+}
+
+func (c *keyLRU) stats() EvictionStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return EvictionStats{
+		MaxKeys: c.size,
+		Evicted: c.evicted,
+		Digest:  c.digest.Sum64(),
+	}
+}
@@ -0,0 +1,101 @@
+package fsm
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// fuzzOps are the operations FuzzApply builds payloads for
+var fuzzOps = []string{
+	PUT, GET, DEL, DELIF, NEXTID, DELPREFIX, MGET, BATCH, APPEND, STAGE, COMMIT_STAGED,
+	LOCK_ACQUIRE, LOCK_RENEW, LOCK_RELEASE,
+}
+
+// newFuzzFSM returns an FSM with every optional feature that Apply touches enabled
+func newFuzzFSM() *FSM {
+	return NewFSM(Options{
+		ReadCache:        NewReadCache(time.Minute),
+		HistoryDepth:     3,
+		IdempotencyKeys:  8,
+		HotKeySampleRate: 1,
+		CompressMinBytes: 8,
+	}).(*FSM)
+}
+
+// FuzzApply feeds Apply both raw log data and payloads built from the
+// fuzzer's values. Apply must never panic, and must return a non-nil
+// *ApplyResponse for every known operation stamped with a version it accepts.
+func FuzzApply(f *testing.F) {
+	seeds := []string{
+		`{"Version":1,"OP":"PUT","Key":"k","Value":null}`,
+		`{"Version":1,"OP":"PUT","Key":"k","Value":{"a":[1,2]}}`,
+		`{"Version":7,"OP":"BATCH","Ops":[{"OP":"PUT"},{"Key":"k"},{}]}`,
+		`{"Version":7,"OP":"BATCH","Ops":null}`,
+		`{"Version":3,"OP":"MGET","Keys":null}`,
+		`{"Version":3,"OP":"MGET","Keys":["a",null,"b"]}`,
+		`{"Version":8,"OP":"LOCK_ACQUIRE","Key":"k","Owner":"o","TTL":-5,"Time":1}`,
+		`{"Version":2,"OP":"GET","Key":"k","KeyVersion":18446744073709551615}`,
+		`{"Version":6,"OP":"PUT","Key":"k","BinaryValue":"/w=="}`,
+		`{"Version":5,"OP":"DEL","Key":"k","IdempotencyKey":"same"}`,
+		`{"Version":12,"OP":"NEXTID","Key":"n","Count":18446744073709551615}`,
+		`{"Version":9,"OP":"APPEND","Key":"k","Value":7,"MaxBytes":-1}`,
+		`{"Version":13,"OP":"PUT","Key":"k","Value":"v","MaxKeys":1}`,
+		`{"Version":13,"OP":"DELPREFIX","Key":"","MaxKeys":-1}`,
+		`{"Version":255,"OP":"PUT","Key":"k","Value":"v"}`,
+		`{"OP":"NOPE"}`,
+		`not json`,
+		``,
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed), uint8(0), "k", "v", uint64(0))
+	}
+	f.Add([]byte(nil), uint8(1), "k", "", uint64(18446744073709551615))
+	f.Add([]byte(nil), uint8(7), "", "v", uint64(3))
+	f.Add([]byte(nil), uint8(12), "lock", "owner", uint64(1))
+
+	f.Fuzz(func(t *testing.T, data []byte, op uint8, key, value string, n uint64) {
+		store := newFuzzFSM()
+
+		// Raw bytes: anything may happen but a panic
+		store.Apply(&raft.Log{Type: raft.LogCommand, Index: 1, Data: data})
+
+		payload := Payload{
+			OP:              fuzzOps[int(op)%len(fuzzOps)],
+			Key:             key,
+			Value:           value,
+			KeyVersion:      n,
+			Keys:            []string{key, value},
+			Owner:           value,
+			TTL:             time.Duration(n),
+			Ops:             []BatchOp{{OP: PUT, Key: key, Value: value}, {OP: DEL, Key: value}, {OP: value}},
+			Time:            int64(n),
+			Delimiter:       value,
+			MaxBytes:        int(n),
+			Expected:        value,
+			Count:           n,
+			MaxKeys:         int(int8(n)),
+			IdempotencyKey:  value,
+			IdempotencyKeys: int(int8(n)),
+		}
+		if op&0x80 != 0 {
+			payload.Value = map[string]interface{}{"nested": value}
+			payload.BinaryValue = []byte(value)
+		}
+		payload.Version = MinPayloadVersion(payload)
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+
+		// Apply twice so the second sees the first's state and idempotency key
+		for index := uint64(2); index <= 3; index++ {
+			result := store.Apply(&raft.Log{Type: raft.LogCommand, Index: index, Data: encoded, AppendedAt: time.Unix(0, 0)})
+			if response, ok := result.(*ApplyResponse); !ok || response == nil {
+				t.Fatalf("Apply(%s) = %#v, want a non-nil *ApplyResponse", encoded, result)
+			}
+		}
+	})
+}
@@ -0,0 +1,81 @@
+// KV-Raft: Bounded per-key version history
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package fsm
+
+import (
+	"fmt"
+)
+
+type versionedValue struct {
+	version uint64
+	value   string
+}
+
+// valueRecord is what the FSM stores for each key. Records are never mutated
+// in place; a write replaces the record so readers always see a consistent
+// history.
+type valueRecord struct {
+	versions []versionedValue // oldest first, at most historyDepth entries
+}
+
+// GetResult is the Data of a successful GET apply
+type GetResult struct {
+	Value         string
+	Version       uint64
+	OldestVersion uint64
+	LatestVersion uint64
+}
+
+func (r *valueRecord) latest() versionedValue {
+	return r.versions[len(r.versions)-1]
+}
+
+// with returns a new record holding value as the next version, keeping at most
+// depth versions.
+func (r *valueRecord) with(value string, depth int) *valueRecord {
+	if depth < 1 {
+		depth = 1
+	}
+
+	next := versionedValue{version: 1, value: value}
+	var versions []versionedValue
+	if r != nil {
+		next.version = r.latest().version + 1
+		versions = r.versions
+	}
+
+	if len(versions) >= depth {
+		versions = versions[len(versions)-depth+1:]
+	}
+
+	updated := make([]versionedValue, 0, len(versions)+1)
+	updated = append(updated, versions...)
+	updated = append(updated, next)
+	return &valueRecord{versions: updated}
+}
+
+// result returns the given version, or the latest one when version is 0
+func (r *valueRecord) result(version uint64) (GetResult, error) {
+	found := r.latest()
+	if version != 0 {
+		ok := false
+		for _, v := range r.versions {
+			if v.version == version {
+				found, ok = v, true
+				break
+			}
+		}
+		if !ok {
+			return GetResult{}, fmt.Errorf("%w: version %d", ErrVersionNotFound, version)
+		}
+	}
+
+	return GetResult{
+		Value:         found.value,
+		Version:       found.version,
+		OldestVersion: r.versions[0].version,
+		LatestVersion: r.latest().version,
+	}, nil
+}
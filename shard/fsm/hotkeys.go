@@ -0,0 +1,107 @@
+// KV-Raft: Sampled per-key access counts for finding hot keys
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package fsm
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxHotKeys bounds how many keys the access counter tracks at once
+const maxHotKeys = 10000
+
+// hotKeyCounter counts key accesses on this node, one in every sampleRate,
+// for diagnostics. The counts are local and approximate: they are not part of
+// the replicated state, differ between nodes and are lost on restart.
+type hotKeyCounter struct {
+	sampleRate uint64
+	accesses   atomic.Uint64
+
+	mu     sync.Mutex
+	counts map[string]uint64
+	since  time.Time
+}
+
+// HotKey is a key with its estimated number of accesses
+type HotKey struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// HotKeys is a snapshot of the access counter
+type HotKeys struct {
+	Keys       []HotKey  `json:"keys"`
+	SampleRate int       `json:"sample_rate"`
+	Since      time.Time `json:"since"`
+}
+
+// newHotKeyCounter returns a counter sampling one in sampleRate accesses, or
+// nil when sampleRate is not positive. A nil *hotKeyCounter counts nothing.
+func newHotKeyCounter(sampleRate int) *hotKeyCounter {
+	if sampleRate <= 0 {
+		return nil
+	}
+	return &hotKeyCounter{
+		sampleRate: uint64(sampleRate),
+		counts:     make(map[string]uint64),
+		since:      time.Now(),
+	}
+}
+
+// record counts an access to key if it is sampled
+func (c *hotKeyCounter) record(key string) {
+	if c == nil || key == "" || c.accesses.Add(1)%c.sampleRate != 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// When full, halve every count so long-cold keys drop out and make room
+	if _, ok := c.counts[key]; !ok && len(c.counts) >= maxHotKeys {
+		for k, n := range c.counts {
+			if n/2 == 0 {
+				delete(c.counts, k)
+			} else {
+				c.counts[k] = n / 2
+			}
+		}
+	}
+	c.counts[key]++
+}
+
+// top returns the n most accessed keys, with counts scaled back up by the
+// sample rate, and clears the counts if reset is set
+func (c *hotKeyCounter) top(n int, reset bool) HotKeys {
+	if c == nil {
+		return HotKeys{Keys: []HotKey{}}
+	}
+
+	c.mu.Lock()
+	keys := make([]HotKey, 0, len(c.counts))
+	for key, count := range c.counts {
+		keys = append(keys, HotKey{Key: key, Count: count * c.sampleRate})
+	}
+	result := HotKeys{SampleRate: int(c.sampleRate), Since: c.since}
+	if reset {
+		c.counts = make(map[string]uint64)
+		c.since = time.Now()
+	}
+	c.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if n > 0 && len(keys) > n {
+		keys = keys[:n]
+	}
+	result.Keys = keys
+	return result
+}
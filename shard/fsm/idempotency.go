@@ -0,0 +1,178 @@
+// KV-Raft: Replicated record of recently applied idempotency keys
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package fsm
+
+import (
+	"container/list"
+	"sync"
+)
+
+type idempotencyEntry struct {
+	key      string
+	response *ApplyResponse
+}
+
+// idempotencyLRU remembers the responses of the most recently applied writes
+// that carried an idempotency key. It is only updated from FSM.Apply, so every
+// node evicts the same keys in the same order. Its size is replicated too:
+// each entry with an idempotency key carries the leader's, see
+// Payload.IdempotencyKeys, and snapshots carry the size they were taken with.
+type idempotencyLRU struct {
+	mu      sync.Mutex
+	size    int        // 0 remembers nothing
+	order   *list.List // most recently used first
+	entries map[string]*list.Element
+}
+
+// newIdempotencyLRU returns an LRU holding up to size keys until an entry or
+// snapshot replicates another size
+func newIdempotencyLRU(size int) *idempotencyLRU {
+	return &idempotencyLRU{
+		size:    max(size, 0),
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// recordedResponse is an ApplyResponse as stored in a snapshot. Only write
+// responses are recorded, so Data is one of the types writes return.
+type recordedResponse struct {
+	Error string     `json:",omitempty"`
+	Value *string    `json:",omitempty"` // PUT
+	Count *int       `json:",omitempty"` // DELPREFIX
+	Lock  *LockState `json:",omitempty"` // lock operations
+	Batch []string   `json:",omitempty"` // BATCH, one error message per op, empty on success
+	Keys  []string   `json:",omitempty"` // COMMIT_STAGED, the keys committed
+}
+
+func recordResponse(response *ApplyResponse) recordedResponse {
+	var recorded recordedResponse
+	if response.Error != nil {
+		recorded.Error = response.Error.Error()
+	}
+	switch data := response.Data.(type) {
+	case string:
+		recorded.Value = &data
+	case int:
+		recorded.Count = &data
+	case LockState:
+		recorded.Lock = &data
+	case []error:
+		recorded.Batch = make([]string, len(data))
+		for i, err := range data {
+			if err != nil {
+				recorded.Batch[i] = err.Error()
+			}
+		}
+	case []string:
+		recorded.Keys = data
+	}
+	return recorded
+}
+
+func (r recordedResponse) response() *ApplyResponse {
+	response := &ApplyResponse{Error: restoreError(r.Error)}
+	switch {
+	case r.Value != nil:
+		response.Data = *r.Value
+	case r.Count != nil:
+		response.Data = *r.Count
+	case r.Lock != nil:
+		response.Data = *r.Lock
+	case r.Batch != nil:
+		errs := make([]error, len(r.Batch))
+		for i, msg := range r.Batch {
+			errs[i] = restoreError(msg)
+		}
+		response.Data = errs
+	case r.Keys != nil:
+		response.Data = r.Keys
+	}
+	return response
+}
+
+// snapshot returns the size and the remembered keys, least recently used first
+func (c *idempotencyLRU) snapshot() (int, []snapshotIdempotent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size == 0 {
+		return 0, nil
+	}
+	entries := make([]snapshotIdempotent, 0, c.order.Len())
+	for elem := c.order.Back(); elem != nil; elem = elem.Prev() {
+		entry := elem.Value.(*idempotencyEntry)
+		entries = append(entries, snapshotIdempotent{Key: entry.key, Response: recordResponse(entry.response)})
+	}
+	return c.size, entries
+}
+
+// restore replaces the remembered keys with those of a snapshot. A snapshot
+// from before the size was replicated, or taken while nothing was remembered,
+// keeps this node's size; the next entry with an idempotency key sets it.
+func (c *idempotencyLRU) restore(size int, entries []snapshotIdempotent) {
+	c.mu.Lock()
+	if size > 0 {
+		c.size = size
+	}
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.mu.Unlock()
+
+	for _, entry := range entries {
+		c.add(entry.Key, entry.Response.response())
+	}
+}
+
+// resize applies the size an entry carries: positive remembers up to that
+// many keys, negative remembers none, and 0, from entries written before the
+// size was replicated, keeps the current one
+func (c *idempotencyLRU) resize(size int) {
+	if size == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.size = max(size, 0)
+	c.evictOverflow()
+}
+
+func (c *idempotencyLRU) evictOverflow() {
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*idempotencyEntry).key)
+	}
+}
+
+func (c *idempotencyLRU) get(key string) (*ApplyResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*idempotencyEntry).response, true
+}
+
+func (c *idempotencyLRU) add(key string, response *ApplyResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size == 0 {
+		return
+	}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*idempotencyEntry).response = response
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&idempotencyEntry{key: key, response: response})
+	c.evictOverflow()
+}
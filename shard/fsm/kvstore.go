@@ -0,0 +1,184 @@
+// KV-Raft: Pluggable maps holding the FSM's records
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package fsm
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Store implementations for Options.StoreImpl
+const (
+	StoreSyncMap = "syncmap"
+	StoreSharded = "sharded"
+)
+
+// shardedStoreStripes is how many independently locked maps the sharded
+// store splits the keys over
+const shardedStoreStripes = 64
+
+// kvStore maps keys to their records. Implementations are safe for concurrent
+// use. Range may be called with a function that stores or deletes keys.
+type kvStore interface {
+	Load(key string) (*valueRecord, bool)
+	Store(key string, record *valueRecord)
+	Delete(key string)
+	LoadAndDelete(key string) (*valueRecord, bool)
+	Range(f func(key string, record *valueRecord) bool)
+	Clear()
+}
+
+// ValidateStoreImpl reports an error unless impl names a store
+// implementation; empty selects the default, StoreSyncMap
+func ValidateStoreImpl(impl string) error {
+	switch impl {
+	case "", StoreSyncMap, StoreSharded:
+		return nil
+	}
+	return fmt.Errorf("must be %s or %s", StoreSyncMap, StoreSharded)
+}
+
+// newKVStore returns the store named impl, which ValidateStoreImpl accepted
+func newKVStore(impl string) kvStore {
+	if impl == StoreSharded {
+		return newShardedStore(shardedStoreStripes)
+	}
+	return &syncMapStore{}
+}
+
+// syncMapStore is a single sync.Map. It suits the FSM's usual load: one
+// writer, raft's apply loop, and reads of keys that are rarely rewritten.
+type syncMapStore struct {
+	m sync.Map
+}
+
+func (s *syncMapStore) Load(key string) (*valueRecord, bool) {
+	record, ok := s.m.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return record.(*valueRecord), true
+}
+
+func (s *syncMapStore) Store(key string, record *valueRecord) {
+	s.m.Store(key, record)
+}
+
+func (s *syncMapStore) Delete(key string) {
+	s.m.Delete(key)
+}
+
+func (s *syncMapStore) LoadAndDelete(key string) (*valueRecord, bool) {
+	record, ok := s.m.LoadAndDelete(key)
+	if !ok {
+		return nil, false
+	}
+	return record.(*valueRecord), true
+}
+
+func (s *syncMapStore) Range(f func(key string, record *valueRecord) bool) {
+	s.m.Range(func(k, v interface{}) bool {
+		return f(k.(string), v.(*valueRecord))
+	})
+}
+
+func (s *syncMapStore) Clear() {
+	s.m.Clear()
+}
+
+// shardedStore splits the keys over stripes, each a plain map behind its own
+// RWMutex, chosen by the FNV-1a hash of the key. Writes to different stripes
+// never contend, where every sync.Map write to a new key takes its one lock.
+type shardedStore struct {
+	stripes []storeStripe
+}
+
+type storeStripe struct {
+	mu      sync.RWMutex
+	records map[string]*valueRecord
+}
+
+func newShardedStore(stripes int) *shardedStore {
+	s := &shardedStore{stripes: make([]storeStripe, stripes)}
+	for i := range s.stripes {
+		s.stripes[i].records = make(map[string]*valueRecord)
+	}
+	return s
+}
+
+// stripe returns the stripe holding key
+func (s *shardedStore) stripe(key string) *storeStripe {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return &s.stripes[hash%uint32(len(s.stripes))]
+}
+
+func (s *shardedStore) Load(key string) (*valueRecord, bool) {
+	stripe := s.stripe(key)
+	stripe.mu.RLock()
+	defer stripe.mu.RUnlock()
+	record, ok := stripe.records[key]
+	return record, ok
+}
+
+func (s *shardedStore) Store(key string, record *valueRecord) {
+	stripe := s.stripe(key)
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+	stripe.records[key] = record
+}
+
+func (s *shardedStore) Delete(key string) {
+	stripe := s.stripe(key)
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+	delete(stripe.records, key)
+}
+
+func (s *shardedStore) LoadAndDelete(key string) (*valueRecord, bool) {
+	stripe := s.stripe(key)
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+	record, ok := stripe.records[key]
+	delete(stripe.records, key)
+	return record, ok
+}
+
+// Range calls f for the keys of one stripe at a time, copied out under its
+// read lock, so f may store and delete without deadlocking
+func (s *shardedStore) Range(f func(key string, record *valueRecord) bool) {
+	type entry struct {
+		key    string
+		record *valueRecord
+	}
+	var entries []entry
+	for i := range s.stripes {
+		stripe := &s.stripes[i]
+		entries = entries[:0]
+		stripe.mu.RLock()
+		for key, record := range stripe.records {
+			entries = append(entries, entry{key, record})
+		}
+		stripe.mu.RUnlock()
+
+		for _, e := range entries {
+			if !f(e.key, e.record) {
+				return
+			}
+		}
+	}
+}
+
+func (s *shardedStore) Clear() {
+	for i := range s.stripes {
+		stripe := &s.stripes[i]
+		stripe.mu.Lock()
+		stripe.records = make(map[string]*valueRecord)
+		stripe.mu.Unlock()
+	}
+}
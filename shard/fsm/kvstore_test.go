@@ -0,0 +1,50 @@
+package fsm
+
+import (
+	"math/rand"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// BenchmarkStore runs a write-heavy workload over many keys against an FSM
+// with each store implementation, calling it directly to measure only the
+// map and its locking. Vary the concurrency with -cpu:
+//
+//	go test ./fsm -run '^$' -bench BenchmarkStore -cpu 1,4,16
+func BenchmarkStore(b *testing.B) {
+	const (
+		keys         = 100000
+		writePercent = 80
+	)
+	keyNames := make([]string, keys)
+	for i := range keyNames {
+		keyNames[i] = "bench-" + strconv.Itoa(i)
+	}
+	value := string(make([]byte, 64))
+
+	for _, impl := range []string{StoreSyncMap, StoreSharded} {
+		b.Run(impl, func(b *testing.B) {
+			store := NewFSM(Options{HistoryDepth: 1, StoreImpl: impl}).(*FSM)
+			// Half the keys exist up front, so writes both replace and insert
+			for _, key := range keyNames[:keys/2] {
+				store.Put(key, value)
+			}
+
+			var seed atomic.Int64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				rng := rand.New(rand.NewSource(seed.Add(1)))
+				for pb.Next() {
+					key := keyNames[rng.Intn(keys)]
+					if rng.Intn(100) < writePercent {
+						store.Put(key, value)
+					} else {
+						store.Read(key, 0)
+					}
+				}
+			})
+		})
+	}
+}
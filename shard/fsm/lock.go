@@ -0,0 +1,74 @@
+// KV-Raft: Distributed locks applied through the raft log
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package fsm
+
+import (
+	"time"
+)
+
+// LockState is the holder of a lock and when it expires. It is the Data of
+// successful lock applies.
+type LockState struct {
+	Owner     string
+	ExpiresAt time.Time
+}
+
+// lockAt returns the lock on key that is still held at now. Expiry is judged
+// against the entry's replicated time rather than the local clock, so every
+// node reaches the same decision; expired locks are dropped lazily.
+func (fsm *FSM) lockAt(key string, now time.Time) (LockState, bool) {
+	value, ok := fsm.locks.Load(key)
+	if !ok {
+		return LockState{}, false
+	}
+
+	lock := value.(LockState)
+	if !now.Before(lock.ExpiresAt) {
+		fsm.locks.Delete(key)
+		return LockState{}, false
+	}
+	return lock, true
+}
+
+// AcquireLock takes the lock on key for owner until now+ttl. An owner that
+// already holds the lock extends it.
+func (fsm *FSM) AcquireLock(key, owner string, ttl time.Duration, now time.Time) (LockState, error) {
+	if lock, held := fsm.lockAt(key, now); held && lock.Owner != owner {
+		return lock, ErrLockHeld
+	}
+
+	lock := LockState{Owner: owner, ExpiresAt: now.Add(ttl)}
+	fsm.locks.Store(key, lock)
+	return lock, nil
+}
+
+// RenewLock extends a lock held by owner until now+ttl
+func (fsm *FSM) RenewLock(key, owner string, ttl time.Duration, now time.Time) (LockState, error) {
+	lock, held := fsm.lockAt(key, now)
+	if !held {
+		return LockState{}, ErrLockNotHeld
+	}
+	if lock.Owner != owner {
+		return lock, ErrLockHeld
+	}
+
+	lock.ExpiresAt = now.Add(ttl)
+	fsm.locks.Store(key, lock)
+	return lock, nil
+}
+
+// ReleaseLock frees a lock held by owner
+func (fsm *FSM) ReleaseLock(key, owner string, now time.Time) (LockState, error) {
+	lock, held := fsm.lockAt(key, now)
+	if !held {
+		return LockState{}, ErrLockNotHeld
+	}
+	if lock.Owner != owner {
+		return lock, ErrLockHeld
+	}
+
+	fsm.locks.Delete(key)
+	return lock, nil
+}
@@ -0,0 +1,121 @@
+// KV-Raft: Listing keys in order by prefix
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package fsm
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+	"strings"
+)
+
+// KeyValue is one key of a Scan with its latest value
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// ScanOptions selects the keys of a Scan
+type ScanOptions struct {
+	Prefix string // only keys starting with Prefix
+	After  string // only keys sorting after After
+	Limit  int    // most keys returned
+	// Match, when set, keeps only the keys it returns true for
+	Match func(key string) bool
+	// Unsorted returns the first keys the walk finds, in no particular
+	// order, and stops the walk as soon as the page is full
+	Unsorted bool
+}
+
+// scanCheckEvery is how many keys Scan walks between checks of its context
+const scanCheckEvery = 1024
+
+// scanMatch is a key a Scan selected with the record it held when walked.
+// Records are replaced rather than changed on write, so reading one after
+// the walk still reads the value as of the walk.
+type scanMatch struct {
+	key    string
+	record *valueRecord
+}
+
+// scanHeap is a max-heap of matches by key: its root is the match a smaller
+// key pushes out of a full page
+type scanHeap []scanMatch
+
+func (h scanHeap) Len() int            { return len(h) }
+func (h scanHeap) Less(i, j int) bool  { return h[i].key > h[j].key }
+func (h scanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *scanHeap) Push(x interface{}) { *h = append(*h, x.(scanMatch)) }
+func (h *scanHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// Scan returns, in key order, up to opts.Limit of the keys opts selects with
+// their latest values, and whether more keys follow. Like TxGet, it sees no
+// entry half applied and does not count as an access for max_keys eviction.
+// It stops with ctx's error once ctx is done, which bounds the walk over a
+// large store.
+//
+// The store has no order of its own, so a sorted page walks every key, but
+// keeps only the opts.Limit+1 smallest selected ones; see ScanOptions.Unsorted
+// for a walk that stops early. Writes wait only for the walk: the page is
+// sorted and its values decoded after the lock is released.
+func (fsm *FSM) Scan(ctx context.Context, opts ScanOptions) ([]KeyValue, bool, error) {
+	// One key past the page is enough to know that more follow
+	keep := opts.Limit + 1
+	matched, err := fsm.scanWalk(ctx, opts, keep)
+	if err != nil {
+		return nil, false, err
+	}
+	if !opts.Unsorted {
+		sort.Slice(matched, func(i, j int) bool { return matched[i].key < matched[j].key })
+	}
+
+	more := len(matched) > opts.Limit
+	if more {
+		matched = matched[:opts.Limit]
+	}
+	page := make([]KeyValue, len(matched))
+	for i, m := range matched {
+		page[i] = KeyValue{Key: m.key, Value: fsm.compression.decode(m.record.latest().value)}
+	}
+	return page, more, nil
+}
+
+// scanWalk collects up to keep of the keys opts selects under the apply lock:
+// the first ones found for an unsorted scan, the smallest ones otherwise
+func (fsm *FSM) scanWalk(ctx context.Context, opts ScanOptions, keep int) ([]scanMatch, error) {
+	fsm.applyMu.RLock()
+	defer fsm.applyMu.RUnlock()
+
+	matched := make(scanHeap, 0, keep)
+	var err error
+	walked := 0
+	fsm.kv_store.Range(func(key string, record *valueRecord) bool {
+		if walked++; walked%scanCheckEvery == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		if !strings.HasPrefix(key, opts.Prefix) || key <= opts.After || (opts.Match != nil && !opts.Match(key)) {
+			return true
+		}
+		switch {
+		case opts.Unsorted:
+			matched = append(matched, scanMatch{key, record})
+			return len(matched) < keep
+		case len(matched) < keep:
+			heap.Push(&matched, scanMatch{key, record})
+		case key < matched[0].key:
+			matched[0] = scanMatch{key, record}
+			heap.Fix(&matched, 0)
+		}
+		return true
+	})
+	return matched, err
+}
@@ -0,0 +1,70 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestScanStopsWhenContextDone(t *testing.T) {
+	f := newTestFSM()
+	for i := 0; i < 3*scanCheckEvery; i++ {
+		f.Put(fmt.Sprintf("k%05d", i), "v")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := f.Scan(ctx, ScanOptions{Limit: 10}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Scan with a cancelled context returned %v", err)
+	}
+	if page, more, err := f.Scan(context.Background(), ScanOptions{Limit: 10}); err != nil || len(page) != 10 || !more {
+		t.Errorf("Scan = %d keys, more %v, %v", len(page), more, err)
+	}
+}
+
+func TestScanReturnsKeysInOrder(t *testing.T) {
+	// Written in an order unrelated to the key order, so the store's own
+	// iteration order cannot pass for sorted by chance
+	f := newTestFSM()
+	var want []string
+	for i := 0; i < 500; i++ {
+		want = append(want, fmt.Sprintf("k%03d", i))
+		f.Put(fmt.Sprintf("k%03d", (i*367)%500), "v")
+	}
+
+	var got []string
+	after := ""
+	for {
+		page, more, err := f.Scan(context.Background(), ScanOptions{After: after, Limit: 64})
+		if err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		for _, kv := range page {
+			got = append(got, kv.Key)
+		}
+		if !more {
+			break
+		}
+		after = page[len(page)-1].Key
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("pages listed %d keys out of order: %v", len(got), got)
+	}
+
+	// An unsorted page is the same size and says whether more follow, in any order
+	page, more, err := f.Scan(context.Background(), ScanOptions{Prefix: "k1", Limit: 64, Unsorted: true})
+	if err != nil || len(page) != 64 || !more {
+		t.Fatalf("unsorted Scan = %d keys, more %v, %v", len(page), more, err)
+	}
+	seen := map[string]bool{}
+	for _, kv := range page {
+		if kv.Key[:2] != "k1" || seen[kv.Key] {
+			t.Errorf("unsorted Scan returned %s", kv.Key)
+		}
+		seen[kv.Key] = true
+	}
+	if page, more, _ := f.Scan(context.Background(), ScanOptions{Prefix: "k49", Limit: 64, Unsorted: true}); len(page) != 10 || more {
+		t.Errorf("unsorted Scan of the last 10 keys = %d keys, more %v", len(page), more)
+	}
+}
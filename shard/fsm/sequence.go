@@ -0,0 +1,62 @@
+// KV-Raft: Replicated sequences handing out unique IDs
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package fsm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// SequenceNamespace is the namespace NEXTID keeps its sequences in; the value
+// of _seq/<name> is the last ID handed out from <name>. Clients may read it
+// but not write it.
+const SequenceNamespace = "_seq"
+
+// isSequenceKey reports whether key is a sequence in SequenceNamespace
+func isSequenceKey(key string) bool {
+	return strings.HasPrefix(key, SequenceNamespace+"/")
+}
+
+// IDRange is the block of IDs a NEXTID allocated, First through Last inclusive
+type IDRange struct {
+	First uint64
+	Last  uint64
+}
+
+// NextID allocates count IDs from the sequence stored at key, whose value is
+// the last ID handed out in decimal, or absent before the first allocation.
+// Every node applies the same entries in the same order, so no two
+// allocations overlap, across leader changes too.
+//
+// Unlike Put, NextID keeps the key out of the -max_keys LRU, since an evicted
+// sequence would start over at 1 and hand out the same IDs again. It also
+// takes out a key that got in before, such as one written by a PUT from
+// before the namespace was reserved.
+func (fsm FSM) NextID(key string, count uint64) (IDRange, error) {
+	var last uint64
+	record, ok := fsm.kv_store.Load(key)
+	if ok {
+		value := fsm.compression.decode(record.latest().value)
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return IDRange{}, fmt.Errorf("%w: sequence value %q is not a non-negative integer", ErrTypeMismatch, value)
+		}
+		last = parsed
+	} else {
+		fsm.keyCount.Add(1)
+	}
+
+	if count > math.MaxUint64-last {
+		return IDRange{}, fmt.Errorf("%w: allocating %d IDs after %d would overflow", ErrValueTooLarge, count, last)
+	}
+
+	allocated := IDRange{First: last + 1, Last: last + count}
+	fsm.kv_store.Store(key, record.with(fsm.compression.encode(strconv.FormatUint(allocated.Last, 10)), fsm.historyDepth))
+	fsm.lru.remove(key)
+	fsm.cache.invalidate(key)
+	return allocated, nil
+}
@@ -5,17 +5,243 @@
 package fsm
 
 import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
 	"github.com/hashicorp/raft"
 )
 
-type snapshot struct{}
+// snapshotFormat is the newest snapshot layout this node can restore:
+//
+//	1: values as strings
+//	2: adds Snappy, the compressed bytes of values stored with -value_compression
+//
+// Snapshots taken without compression are written as format 1, so nodes from
+// before format 2 can still restore them.
+const (
+	snapshotFormat      uint8 = 2
+	snapshotFormatPlain uint8 = 1
+)
 
-func (s snapshot) Persist(_ raft.SnapshotSink) error {
-	return nil
+// snapshotState is the whole replicated state of the FSM as of the last entry
+// applied before Snapshot was called. Deleted keys are simply absent from
+// Keys: the state after a delete is what the snapshot records, so log entries
+// compacted into it need no tombstones.
+type snapshotState struct {
+	Format      uint8
+	Keys        []snapshotKey        // sorted by key
+	Locks       map[string]LockState `json:",omitempty"`
+	Staged      map[string]string    `json:",omitempty"` // values set by STAGE, not yet committed
+	Idempotency []snapshotIdempotent `json:",omitempty"` // least recently used first
+	// IdempotencyKeys is the size of the idempotency LRU, absent in
+	// snapshots from before it was replicated
+	IdempotencyKeys int               `json:",omitempty"`
+	Eviction        *snapshotEviction `json:",omitempty"`
+	ConfigIndex     uint64            `json:",omitempty"` // see FSM.ConfigurationIndex
+}
+
+type snapshotKey struct {
+	Key      string
+	Versions []snapshotVersion // oldest first
+}
+
+type snapshotVersion struct {
+	Version uint64
+	Value   string
+	Snappy  []byte `json:",omitempty"` // set in place of Value for a compressed value
+}
+
+type snapshotIdempotent struct {
+	Key      string
+	Response recordedResponse
+}
+
+// snapshotEviction carries the -max_keys cap and LRU, so a node restored from
+// a snapshot keeps evicting in the same order as the rest of the cluster
+type snapshotEviction struct {
+	MaxKeys int      `json:",omitempty"` // absent in snapshots from before the cap was replicated
+	Order   []string // least recently used first
+	Evicted uint64
+	Digest  []byte // marshalled FNV-1a state
 }
 
-func (s snapshot) Release() {}
+// snapshot is a point-in-time view of the FSM. Keys are held as references
+// to their immutable records rather than copies, and Persist streams them one
+// at a time; the rest of the state is small and is copied.
+type snapshot struct {
+	header      snapshotHeader
+	keys        []snapshotRef // sorted by key
+	compression *valueCompression
+}
+
+// snapshotHeader is snapshotState without Keys
+type snapshotHeader struct {
+	Format          uint8
+	Locks           map[string]LockState `json:",omitempty"`
+	Staged          map[string]string    `json:",omitempty"`
+	Idempotency     []snapshotIdempotent `json:",omitempty"`
+	IdempotencyKeys int                  `json:",omitempty"`
+	Eviction        *snapshotEviction    `json:",omitempty"`
+	ConfigIndex     uint64               `json:",omitempty"`
+}
+
+type snapshotRef struct {
+	key    string
+	record *valueRecord
+}
+
+// snapshotBufferSize is the write buffer between Persist and the sink
+const snapshotBufferSize = 64 * 1024
+
+// Persist writes the snapshot as the JSON of a snapshotState, with Keys last.
+// Each key's versions are encoded only when it is reached, so beyond the
+// references taken by Snapshot, memory use is one key at a time plus the
+// write buffer. The same state always produces the same bytes.
+func (s *snapshot) Persist(sink raft.SnapshotSink) error {
+	if err := s.write(sink); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *snapshot) write(w io.Writer) error {
+	header, err := json.Marshal(s.header)
+	if err != nil {
+		return err
+	}
+
+	// The header always has Format, so it ends in "}" after at least one
+	// field; Keys is spliced in before that brace
+	buf := bufio.NewWriterSize(w, snapshotBufferSize)
+	buf.Write(header[:len(header)-1])
+	buf.WriteString(`,"Keys":[`)
 
-func newSnapshot() (raft.FSMSnapshot, error) {
-	return &snapshot{}, nil
+	// One versions slice is reused for every key
+	encoder := json.NewEncoder(buf)
+	var versions []snapshotVersion
+	for i, ref := range s.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		versions = versions[:0]
+		for _, version := range ref.record.versions {
+			versions = append(versions, s.compression.snapshotVersion(version))
+		}
+		if err := encoder.Encode(snapshotKey{Key: ref.key, Versions: versions}); err != nil {
+			return err
+		}
+	}
+
+	buf.WriteString("]}\n")
+	return buf.Flush()
+}
+
+func (s *snapshot) Release() {}
+
+// newSnapshot captures the FSM state. Raft calls Snapshot between applies and
+// then persists concurrently with later ones; records are never mutated in
+// place, so the references taken here are a consistent point-in-time view
+// without copying any value. The view costs one key and record pointer per
+// key, about 24 bytes each, where a full copy would double the store.
+func newSnapshot(fsm FSM) (raft.FSMSnapshot, error) {
+	s := &snapshot{header: snapshotHeader{Format: snapshotFormatPlain}, compression: fsm.compression}
+	if fsm.compression != nil {
+		s.header.Format = snapshotFormat
+	}
+
+	fsm.kv_store.Range(func(key string, record *valueRecord) bool {
+		s.keys = append(s.keys, snapshotRef{key: key, record: record})
+		return true
+	})
+	sort.Slice(s.keys, func(i, j int) bool { return s.keys[i].key < s.keys[j].key })
+
+	fsm.locks.Range(func(k, v interface{}) bool {
+		if s.header.Locks == nil {
+			s.header.Locks = make(map[string]LockState)
+		}
+		s.header.Locks[k.(string)] = v.(LockState)
+		return true
+	})
+
+	fsm.staged.Range(func(k, v interface{}) bool {
+		if s.header.Staged == nil {
+			s.header.Staged = make(map[string]string)
+		}
+		s.header.Staged[k.(string)] = v.(string)
+		return true
+	})
+
+	s.header.IdempotencyKeys, s.header.Idempotency = fsm.idempotency.snapshot()
+
+	eviction, err := fsm.lru.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	s.header.Eviction = eviction
+
+	// Raft only calls StoreConfiguration for configuration entries still in
+	// the log, so the index of one compacted into this snapshot is kept here
+	s.header.ConfigIndex = fsm.configIndex.Load()
+
+	return s, nil
+}
+
+// restore replaces the FSM state with a decoded snapshot
+func (fsm FSM) restore(state snapshotState) error {
+	if state.Format > snapshotFormat {
+		return fmt.Errorf("unsupported snapshot format %d", state.Format)
+	}
+
+	// Every value is decoded before the store is touched, so a snapshot
+	// with a corrupt value leaves it as it was
+	records := make([]*valueRecord, len(state.Keys))
+	for i, key := range state.Keys {
+		if len(key.Versions) == 0 {
+			continue
+		}
+		record := &valueRecord{}
+		for _, version := range key.Versions {
+			restored, err := fsm.compression.restoreVersion(version)
+			if err != nil {
+				return fmt.Errorf("key %q: %w", key.Key, err)
+			}
+			record.versions = append(record.versions, restored)
+		}
+		records[i] = record
+	}
+
+	fsm.applyMu.Lock()
+	defer fsm.applyMu.Unlock()
+
+	fsm.kv_store.Clear()
+	fsm.keyCount.Store(0)
+	for i, key := range state.Keys {
+		if records[i] == nil {
+			continue
+		}
+		fsm.kv_store.Store(key.Key, records[i])
+		fsm.keyCount.Add(1)
+	}
+
+	clearMap(fsm.locks)
+	for key, lock := range state.Locks {
+		fsm.locks.Store(key, lock)
+	}
+
+	clearMap(fsm.staged)
+	for key, value := range state.Staged {
+		fsm.staged.Store(key, value)
+	}
+
+	fsm.idempotency.restore(state.IdempotencyKeys, state.Idempotency)
+	fsm.configIndex.Store(state.ConfigIndex)
+	if err := fsm.lru.restore(state.Eviction); err != nil {
+		return err
+	}
+	fsm.cache.clear()
+	return nil
 }
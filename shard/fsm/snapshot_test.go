@@ -0,0 +1,101 @@
+package fsm
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+// memorySink is a raft.SnapshotSink writing to memory
+type memorySink struct {
+	bytes.Buffer
+}
+
+func (s *memorySink) ID() string    { return "memory" }
+func (s *memorySink) Cancel() error { return nil }
+func (s *memorySink) Close() error  { return nil }
+
+func newTestFSM() *FSM {
+	return NewFSM(Options{HistoryDepth: 1}).(*FSM)
+}
+
+// persist snapshots f and returns the bytes Persist wrote
+func persist(t *testing.T, f *FSM) []byte {
+	t.Helper()
+	snap, err := f.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	sink := &memorySink{}
+	if err := snap.Persist(sink); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	return sink.Bytes()
+}
+
+func TestSnapshotKeepsConfigurationIndex(t *testing.T) {
+	source := newTestFSM()
+	source.Put("k", "v")
+	source.StoreConfiguration(42, raft.Configuration{})
+
+	restored := newTestFSM()
+	if err := restored.Restore(io.NopCloser(bytes.NewReader(persist(t, source)))); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	if got := restored.ConfigurationIndex(); got != 42 {
+		t.Errorf("ConfigurationIndex after restore = %d, want 42", got)
+	}
+	if value, err := restored.Get("k"); err != nil || value != "v" {
+		t.Errorf("Get(k) after restore = %v, %v; want v", value, err)
+	}
+}
+
+func TestSnapshotWithoutConfigurationIndex(t *testing.T) {
+	// Snapshots taken before the index was recorded restore it as 0
+	restored := newTestFSM()
+	restored.StoreConfiguration(7, raft.Configuration{})
+	old := `{"Format":1,"Keys":[{"Key":"k","Versions":[{"Version":1,"Value":"v"}]}]}`
+	if err := restored.Restore(io.NopCloser(bytes.NewReader([]byte(old)))); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if got := restored.ConfigurationIndex(); got != 0 {
+		t.Errorf("ConfigurationIndex after restoring an old snapshot = %d, want 0", got)
+	}
+}
+
+func TestPrepareRestoreReplacesConfigurationIndex(t *testing.T) {
+	source := newTestFSM()
+	source.Put("k", "v")
+	source.StoreConfiguration(42, raft.Configuration{})
+
+	var prepared bytes.Buffer
+	if err := PrepareRestore(bytes.NewReader(persist(t, source)), &prepared, 7); err != nil {
+		t.Fatalf("PrepareRestore: %v", err)
+	}
+
+	restored := newTestFSM()
+	if err := restored.Restore(io.NopCloser(&prepared)); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if got := restored.ConfigurationIndex(); got != 7 {
+		t.Errorf("ConfigurationIndex after restore = %d, want 7", got)
+	}
+	if value, err := restored.Get("k"); err != nil || value != "v" {
+		t.Errorf("Get(k) after restore = %v, %v; want v", value, err)
+	}
+}
+
+func TestPrepareRestoreRejectsInvalidSnapshots(t *testing.T) {
+	for _, snapshot := range []string{
+		`not a snapshot`,
+		`{"Format":3,"Keys":[]}`,
+		`{"Format":2,"Keys":[{"Key":"k","Versions":[{"Version":1,"Snappy":"AAAA"}]}]}`,
+	} {
+		if err := PrepareRestore(bytes.NewReader([]byte(snapshot)), io.Discard, 1); err == nil {
+			t.Errorf("PrepareRestore(%s) succeeded", snapshot)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// KV-Raft: Staged values committed to live keys in one log entry
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package fsm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Stage sets the value key will get when it is next committed with
+// CommitStaged. Staged values live apart from the store, so reads of key keep
+// returning its live value until then.
+func (fsm FSM) Stage(key string, value interface{}) error {
+	strValue, ok := value.(string)
+	if !ok {
+		return valueTypeError(value)
+	}
+	fsm.staged.Store(key, strValue)
+	return nil
+}
+
+// CommitStaged makes staged values live, as puts applied in key order, and
+// returns the keys it committed. With keys it commits exactly those, failing
+// with ErrKeyNotFound and committing nothing if any is not staged; otherwise
+// it commits every staged key starting with prefix. Every node applies the
+// whole commit within one log entry, so readers going through the log see
+// either none of the keys changed or all of them.
+func (fsm FSM) CommitStaged(prefix string, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		fsm.staged.Range(func(k, _ interface{}) bool {
+			if key := k.(string); strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+			return true
+		})
+	} else {
+		for _, key := range keys {
+			if _, ok := fsm.staged.Load(key); !ok {
+				return nil, fmt.Errorf("%w: %s is not staged", ErrKeyNotFound, key)
+			}
+		}
+	}
+
+	// The -max_keys LRU makes the order of puts part of the replicated state
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+
+	committed := []string{}
+	for _, key := range keys {
+		value, ok := fsm.staged.LoadAndDelete(key)
+		if !ok {
+			continue // listed twice
+		}
+		if err := fsm.Put(key, value); err != nil {
+			return committed, err
+		}
+		committed = append(committed, key)
+	}
+	return committed, nil
+}
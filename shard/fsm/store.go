@@ -9,7 +9,11 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/hashicorp/raft"
 )
@@ -18,102 +22,688 @@ const (
 	PUT = "PUT"
 	GET = "GET"
 	DEL = "DEL"
+	// DELIF deletes Key only while its latest value equals Expected
+	DELIF = "DELIF"
+
+	// NEXTID allocates Count IDs from the sequence stored at Key
+	NEXTID = "NEXTID"
+
+	// DELPREFIX deletes every key starting with Key in a single log entry
+	DELPREFIX = "DELPREFIX"
+	// MGET reads every key in Keys at the same point in the log
+	MGET = "MGET"
+	// BATCH applies every write in Ops in a single log entry
+	BATCH = "BATCH"
+	// APPEND adds Delimiter and Value to the end of Key's value, creating it if absent
+	APPEND = "APPEND"
+	// STAGE sets the value Key gets at the next COMMIT_STAGED, leaving its live value alone
+	STAGE = "STAGE"
+	// COMMIT_STAGED makes the staged values of Keys, or of every key starting
+	// with Key when Keys is empty, live in a single log entry
+	COMMIT_STAGED = "COMMIT_STAGED"
+
+	// Lock operations on Key for Owner; acquire and renew hold it for TTL
+	LOCK_ACQUIRE = "LOCK_ACQUIRE"
+	LOCK_RENEW   = "LOCK_RENEW"
+	LOCK_RELEASE = "LOCK_RELEASE"
 )
 
+// PayloadVersion is the newest Payload format this node can apply. Entries
+// written before versioning was introduced carry no version and decode as 0,
+// which is applied as 1.
+//
+//	1: OP, Key, Value
+//	2: adds KeyVersion to GET, and the DELPREFIX operation
+//	3: adds Keys for MGET
+//	4: adds Owner and TTL for lock operations
+//	5: adds IdempotencyKey for writes
+//	6: adds BinaryValue for PUT
+//	7: adds Ops for BATCH
+//	8: adds Time, the leader's clock when proposing the entry
+//	9: adds Delimiter and MaxBytes for APPEND
+//	10: adds the STAGE and COMMIT_STAGED operations
+//	11: adds Expected for DELIF
+//	12: adds Count for NEXTID
+//	13: adds MaxKeys to every operation
+//	14: adds IdempotencyKeys to writes with an IdempotencyKey
+const PayloadVersion uint8 = 14
+
+// opVersions is the payload version that introduced each operation
+var opVersions = map[string]uint8{
+	PUT:           1,
+	GET:           1,
+	DEL:           1,
+	DELPREFIX:     2,
+	MGET:          3,
+	LOCK_ACQUIRE:  4,
+	LOCK_RENEW:    4,
+	LOCK_RELEASE:  4,
+	BATCH:         7,
+	APPEND:        9,
+	STAGE:         10,
+	COMMIT_STAGED: 10,
+	DELIF:         11,
+	NEXTID:        12,
+}
+
+// MinPayloadVersion returns the lowest payload version that can carry
+// payload: the version of its operation, raised to that of any newer field it
+// sets. Stamping it rather than PayloadVersion lets nodes that are not yet
+// upgraded apply the entries they understand during a rolling upgrade. An
+// unknown operation returns 0.
+func MinPayloadVersion(payload Payload) uint8 {
+	version := opVersions[payload.OP]
+	if version == 0 {
+		return 0
+	}
+	raise := func(to uint8, used bool) {
+		if used && to > version {
+			version = to
+		}
+	}
+	raise(2, payload.KeyVersion != 0)
+	raise(5, payload.IdempotencyKey != "")
+	raise(6, payload.BinaryValue != nil)
+	raise(13, payload.MaxKeys != 0)
+	raise(14, payload.IdempotencyKeys != 0)
+	// Time is stamped on every entry, but only lock operations read it;
+	// older nodes apply the rest the same without it
+	raise(8, payload.Time != 0 && isLockOp(payload.OP))
+	return version
+}
+
+func isLockOp(op string) bool {
+	return op == LOCK_ACQUIRE || op == LOCK_RENEW || op == LOCK_RELEASE
+}
+
+// Options configures a new FSM
+type Options struct {
+	// DeadLetterPath is where unappliable log entries are recorded; empty disables the file
+	DeadLetterPath string
+	// ReadCache is kept in step with applied writes; nil disables read caching
+	ReadCache *ReadCache
+	// HistoryDepth is how many versions of each key are kept; 1 keeps only the latest
+	HistoryDepth int
+	// IdempotencyKeys is how many idempotency keys are remembered until an
+	// entry replicates the leader's size; 0 disables deduplication
+	IdempotencyKeys int
+	// OnApplyError is called from Apply for every error an entry's apply
+	// returns; it must not block. Nil disables the callback.
+	OnApplyError func(ApplyError)
+	// OnDeadLetter is called each time an entry is skipped and counted by
+	// DeadLetterCount; it must not block. Nil disables the callback.
+	OnDeadLetter func()
+	// HotKeySampleRate counts one in this many key accesses for HotKeys; 0 disables counting
+	HotKeySampleRate int
+	// CompressMinBytes stores values of at least this many bytes
+	// snappy-compressed; 0 disables compression
+	CompressMinBytes int
+	// StoreImpl selects the map holding the keys, StoreSyncMap when empty
+	StoreImpl string
+}
+
 type FSM struct {
-	kv_store *sync.Map
+	kv_store     kvStore
+	locks        *sync.Map
+	staged       *sync.Map // key -> value set by STAGE, not yet committed
+	deadLetters  *deadLetterLog
+	cache        *ReadCache
+	historyDepth int
+	idempotency  *idempotencyLRU
+	configIndex  *atomic.Uint64
+	keyCount     *atomic.Int64 // keys in kv_store, kept so metrics need not walk it
+	hotKeys      *hotKeyCounter
+	lru          *keyLRU
+	compression  *valueCompression
+	onApplyError func(ApplyError)
+
+	// applyMu is held by Apply and Restore while they change the store, and
+	// by TxGet while it reads, so that a TxGet sees no entry half applied
+	applyMu *sync.RWMutex
 }
 
 func (fsm FSM) Put(key string, value interface{}) error {
 	strValue, ok := value.(string)
 	if !ok {
-		return fmt.Errorf("value is not a string")
+		return valueTypeError(value)
 	}
 
-	fsm.kv_store.Store(key, strValue)
+	record, ok := fsm.kv_store.Load(key)
+	if !ok {
+		fsm.keyCount.Add(1)
+	}
+
+	fsm.kv_store.Store(key, record.with(fsm.compression.encode(strValue), fsm.historyDepth))
+	fsm.cache.invalidate(key)
+	fsm.evict(fsm.lru.touch(key))
 	return nil
 }
 
+// evict removes keys the LRU no longer has room for
+func (fsm FSM) evict(keys []string) {
+	for _, evicted := range keys {
+		if _, ok := fsm.kv_store.LoadAndDelete(evicted); ok {
+			fsm.keyCount.Add(-1)
+		}
+		fsm.cache.invalidate(evicted)
+	}
+}
+
+// setMaxKeys applies the cap an entry carries before the entry itself:
+// positive caps the store, negative lifts the cap, and 0 leaves it as it is
+func (fsm FSM) setMaxKeys(maxKeys int) {
+	if maxKeys == 0 {
+		return
+	}
+	if maxKeys < 0 {
+		maxKeys = 0
+	}
+	fsm.evict(fsm.lru.resize(maxKeys, fsm.sortedKeys))
+}
+
+func (fsm FSM) sortedKeys() []string {
+	var keys []string
+	fsm.kv_store.Range(func(key string, _ *valueRecord) bool {
+		keys = append(keys, key)
+		return true
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// valueTypeError describes a PUT value that is not a string, naming its JSON type
+func valueTypeError(value interface{}) error {
+	kind := fmt.Sprintf("%T", value)
+	switch value.(type) {
+	case nil:
+		kind = "null"
+	case float64, json.Number:
+		kind = "a number"
+	case bool:
+		kind = "a boolean"
+	case map[string]interface{}:
+		kind = "an object"
+	case []interface{}:
+		kind = "an array"
+	}
+	return fmt.Errorf("%w: value must be a string, not %s", ErrTypeMismatch, kind)
+}
+
 func (fsm *FSM) Get(key string) (interface{}, error) {
-	value, ok := fsm.kv_store.Load(key)
+	result, err := fsm.GetVersion(key, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Value, nil
+}
+
+// GetVersion returns the given version of key, or the latest when version is 0
+func (fsm *FSM) GetVersion(key string, version uint64) (GetResult, error) {
+	record, ok := fsm.kv_store.Load(key)
 	if !ok {
-		return nil, fmt.Errorf("key not found")
+		return GetResult{}, ErrKeyNotFound
 	}
 
-	return value, nil
+	result, err := record.result(version)
+	if err != nil {
+		return GetResult{}, err
+	}
+	result.Value = fsm.compression.decode(result.Value)
+	return result, nil
+}
+
+// Read returns what a GET applied through the log would, without the log,
+// filling the read cache the same way. Unlike such a GET it does not count as
+// an access for max_keys eviction, whose order must follow the log.
+func (fsm *FSM) Read(key string, version uint64) (GetResult, error) {
+	fsm.hotKeys.record(key)
+	result, err := fsm.GetVersion(key, version)
+	if err != nil || version != 0 {
+		return result, err
+	}
+
+	// A write applied since the load may have invalidated the key already;
+	// drop the entry again rather than leave the older value cached
+	fsm.cache.set(key, result)
+	if latest, err := fsm.GetVersion(key, 0); err != nil || latest.Version != result.Version {
+		fsm.cache.invalidate(key)
+	}
+	return result, nil
 }
 
 func (fsm *FSM) Delete(key string) error {
 	_, ok := fsm.kv_store.Load(key)
 	if !ok {
-		return fmt.Errorf("key not found")
+		return ErrKeyNotFound
 	}
 
 	fsm.kv_store.Delete(key)
+	fsm.keyCount.Add(-1)
+	fsm.cache.invalidate(key)
+	fsm.lru.remove(key)
 	return nil
 }
 
+// DeleteIf deletes key only if its latest value is expected, so a value
+// written since the caller read it is not lost
+func (fsm *FSM) DeleteIf(key, expected string) error {
+	record, ok := fsm.kv_store.Load(key)
+	if !ok {
+		return ErrKeyNotFound
+	}
+	if fsm.compression.decode(record.latest().value) != expected {
+		return ErrCASMismatch
+	}
+	return fsm.Delete(key)
+}
+
+// DeletePrefix removes every key starting with prefix and returns how many were
+// removed. The matching keys are collected before any is deleted, so the whole
+// subtree as of this log entry is removed by it.
+func (fsm *FSM) DeletePrefix(prefix string) int {
+	var matched []string
+	fsm.kv_store.Range(func(key string, _ *valueRecord) bool {
+		if strings.HasPrefix(key, prefix) {
+			matched = append(matched, key)
+		}
+		return true
+	})
+
+	for _, key := range matched {
+		fsm.kv_store.Delete(key)
+		fsm.cache.invalidate(key)
+		fsm.lru.remove(key)
+	}
+	fsm.keyCount.Add(-int64(len(matched)))
+	return len(matched)
+}
+
+// MultiGetResult is the Data of an MGET apply
+type MultiGetResult struct {
+	Values  map[string]string
+	Missing []string
+}
+
+// MultiGet reads the latest value of each key
+func (fsm *FSM) MultiGet(keys []string) MultiGetResult {
+	result := MultiGetResult{
+		Values:  make(map[string]string),
+		Missing: []string{},
+	}
+	for _, key := range keys {
+		record, ok := fsm.kv_store.Load(key)
+		if !ok {
+			result.Missing = append(result.Missing, key)
+			continue
+		}
+		result.Values[key] = fsm.compression.decode(record.latest().value)
+		fsm.lru.access(key)
+	}
+	return result
+}
+
+// Payload is one command in the raft log.
+//
+// FSM.Apply must reach the same result on every node, so it never reads the
+// local clock: the leader stamps Time before proposing the entry, and every
+// time-based decision (lock expiry, and any future TTL or eviction by age) is
+// made against that replicated time, see entryTime.
 type Payload struct {
-	OP    string
-	Key   string
-	Value interface{}
+	Version    uint8
+	OP         string
+	Key        string
+	Value      interface{}
+	KeyVersion uint64        `json:",omitempty"`
+	Keys       []string      `json:",omitempty"`
+	Owner      string        `json:",omitempty"`
+	TTL        time.Duration `json:",omitempty"`
+	// IdempotencyKey makes a retried write return the first result instead of applying again
+	IdempotencyKey string `json:",omitempty"`
+	// IdempotencyKeys is the leader's -idempotency_keys, sent with an
+	// IdempotencyKey so that every node remembers the same keys; negative
+	// remembers none and 0 keeps the node's own size
+	IdempotencyKeys int `json:",omitempty"`
+	// BinaryValue replaces Value for PUTs of bytes that are not valid UTF-8,
+	// which would not survive the JSON encoding of a string
+	BinaryValue []byte    `json:",omitempty"`
+	Ops         []BatchOp `json:",omitempty"`
+	// Time is the leader's clock in Unix nanoseconds when it proposed the entry
+	Time int64 `json:",omitempty"`
+	// Delimiter separates an APPEND's Value from the existing value
+	Delimiter string `json:",omitempty"`
+	// MaxBytes is the leader's value size limit for an APPEND; 0 disables it.
+	// It travels in the entry so that every node enforces the same limit.
+	MaxBytes int `json:",omitempty"`
+	// Expected is the value a DELIF requires the key to still hold
+	Expected string `json:",omitempty"`
+	// Count is how many IDs a NEXTID allocates
+	Count uint64 `json:",omitempty"`
+	// MaxKeys is the leader's -max_keys cap, set before the entry applies;
+	// negative lifts the cap and 0 leaves it unchanged. Like MaxBytes it
+	// travels in the entry, so every node evicts under the same cap.
+	MaxKeys int `json:",omitempty"`
+}
+
+// entryTime returns the replicated time of a log entry: the leader's stamp,
+// or for entries written before version 8 the time the leader appended it
+func entryTime(log *raft.Log, payload Payload) time.Time {
+	if payload.Time != 0 {
+		return time.Unix(0, payload.Time)
+	}
+	return log.AppendedAt
 }
 
 type ApplyResponse struct {
 	Error error
 	Data  interface{}
+	// Replayed is set when the response was recorded for an earlier entry with the same idempotency key
+	Replayed bool
 }
 
 func (fsm FSM) Apply(log *raft.Log) interface{} {
 	switch log.Type {
 	case raft.LogCommand:
+		fsm.applyMu.Lock()
+		defer fsm.applyMu.Unlock()
+
 		var payload = Payload{}
 		if err := json.Unmarshal(log.Data, &payload); err != nil {
-			fmt.Fprintf(os.Stderr, "error marshalling payload %s\n", err.Error())
+			fsm.deadLetters.record(log, "error unmarshalling payload: "+err.Error())
 			return nil
 		}
 
-		switch payload.OP {
-		case PUT:
-			fsm.Put(payload.Key, payload.Value)
+		version := payload.Version
+		if version == 0 {
+			version = 1
+		}
+		switch required := MinPayloadVersion(payload); {
+		case version > PayloadVersion:
+			fsm.deadLetters.record(log, fmt.Sprintf("unsupported payload version %d", payload.Version))
+			return nil
+		case version < required:
+			// A field or operation the stamped version does not have; a node
+			// at that version would apply it differently, so none applies it
+			fsm.deadLetters.record(log, fmt.Sprintf("%s payload needs version %d, has %d", payload.OP, required, payload.Version))
+			return nil
+		default:
+			fsm.setMaxKeys(payload.MaxKeys)
+			fsm.recordAccesses(payload)
+			result := fsm.applyIdempotent(log, payload)
+			fsm.reportErrors(log, payload, result)
+			return result
+		}
+	}
+	fmt.Fprintf(os.Stderr, "raft log command type:%s\n", raft.LogCommand)
+	return nil
+}
+
+// applyIdempotent applies a payload once per idempotency key, returning the
+// recorded response for an entry whose key was already applied
+func (fsm FSM) applyIdempotent(log *raft.Log, payload Payload) interface{} {
+	if payload.IdempotencyKey == "" {
+		return fsm.applyCommand(log, payload)
+	}
+	fsm.idempotency.resize(payload.IdempotencyKeys)
+
+	if recorded, ok := fsm.idempotency.get(payload.IdempotencyKey); ok {
+		replay := *recorded
+		replay.Replayed = true
+		return &replay
+	}
+
+	result := fsm.applyCommand(log, payload)
+	if response, ok := result.(*ApplyResponse); ok {
+		fsm.idempotency.add(payload.IdempotencyKey, response)
+	}
+	return result
+}
+
+// applyCommand applies a payload of any supported version. Later versions only
+// add fields, which older payloads leave at their zero values.
+func (fsm FSM) applyCommand(log *raft.Log, payload Payload) interface{} {
+	switch payload.OP {
+	case PUT:
+		value := payload.Value
+		if payload.BinaryValue != nil {
+			value = string(payload.BinaryValue)
+		}
+		if err := fsm.Put(payload.Key, value); err != nil {
 			return &ApplyResponse{
-				Error: nil,
-				Data:  payload.Value,
+				Error: err,
+				Data:  nil,
 			}
-		case GET:
-			value, err := fsm.Get(payload.Key)
-			if err != nil {
-				return &ApplyResponse{
-					Error: err,
-					Data:  nil,
-				}
+		}
+		return &ApplyResponse{
+			Error: nil,
+			Data:  value,
+		}
+	case GET:
+		result, err := fsm.GetVersion(payload.Key, payload.KeyVersion)
+		if err != nil {
+			return &ApplyResponse{
+				Error: err,
+				Data:  nil,
 			}
+		}
+		if payload.KeyVersion == 0 {
+			fsm.cache.set(payload.Key, result)
+		}
+		fsm.lru.access(payload.Key)
+		return &ApplyResponse{
+			Error: nil,
+			Data:  result,
+		}
+	case DEL:
+		if err := fsm.Delete(payload.Key); err != nil {
 			return &ApplyResponse{
-				Error: nil,
-				Data:  value,
+				Error: err,
+				Data:  nil,
 			}
-		case DEL:
-			fsm.Delete(payload.Key)
+		}
+		return &ApplyResponse{
+			Error: nil,
+			Data:  nil,
+		}
+	case DELIF:
+		return &ApplyResponse{
+			Error: fsm.DeleteIf(payload.Key, payload.Expected),
+			Data:  nil,
+		}
+	case MGET:
+		return &ApplyResponse{
+			Error: nil,
+			Data:  fsm.MultiGet(payload.Keys),
+		}
+	case BATCH:
+		return &ApplyResponse{
+			Error: nil,
+			Data:  fsm.ApplyBatch(payload.Ops),
+		}
+	case LOCK_ACQUIRE:
+		lock, err := fsm.AcquireLock(payload.Key, payload.Owner, payload.TTL, entryTime(log, payload))
+		return &ApplyResponse{
+			Error: err,
+			Data:  lock,
+		}
+	case LOCK_RENEW:
+		lock, err := fsm.RenewLock(payload.Key, payload.Owner, payload.TTL, entryTime(log, payload))
+		return &ApplyResponse{
+			Error: err,
+			Data:  lock,
+		}
+	case LOCK_RELEASE:
+		lock, err := fsm.ReleaseLock(payload.Key, payload.Owner, entryTime(log, payload))
+		return &ApplyResponse{
+			Error: err,
+			Data:  lock,
+		}
+	case APPEND:
+		value, ok := payload.Value.(string)
+		if !ok {
 			return &ApplyResponse{
-				Error: nil,
+				Error: valueTypeError(payload.Value),
 				Data:  nil,
 			}
 		}
+		length, err := fsm.Append(payload.Key, payload.Delimiter, value, payload.MaxBytes)
+		return &ApplyResponse{
+			Error: err,
+			Data:  length,
+		}
+	case STAGE:
+		return &ApplyResponse{
+			Error: fsm.Stage(payload.Key, payload.Value),
+			Data:  nil,
+		}
+	case COMMIT_STAGED:
+		committed, err := fsm.CommitStaged(payload.Key, payload.Keys)
+		return &ApplyResponse{
+			Error: err,
+			Data:  committed,
+		}
+	case NEXTID:
+		allocated, err := fsm.NextID(payload.Key, payload.Count)
+		return &ApplyResponse{
+			Error: err,
+			Data:  allocated,
+		}
+	case DELPREFIX:
+		return &ApplyResponse{
+			Error: nil,
+			Data:  fsm.DeletePrefix(payload.Key),
+		}
+	default:
+		fsm.deadLetters.record(log, "unknown operation: "+payload.OP)
+		return nil
 	}
-	fmt.Fprintf(os.Stderr, "raft log command type:%s\n", raft.LogCommand)
-	return nil
 }
 
 func (fsm FSM) Snapshot() (raft.FSMSnapshot, error) {
-	return newSnapshot()
+	return newSnapshot(fsm)
 }
 
 func (fsm FSM) Restore(rc io.ReadCloser) error {
-	return nil
+	defer rc.Close()
+
+	var state snapshotState
+	if err := json.NewDecoder(rc).Decode(&state); err != nil {
+		return fmt.Errorf("error decoding snapshot: %w", err)
+	}
+	return fsm.restore(state)
+}
+
+// PrepareRestore decodes a snapshot from outside the cluster as Restore would,
+// without touching any FSM, so that raft never adopts one it cannot restore.
+// It writes the snapshot to w with configIndex, the latest configuration
+// index of the cluster it is restored into, in place of its own: the uploaded
+// one belongs to the configuration the snapshot was taken under, and raft
+// would not correct it, as it calls StoreConfiguration only for new
+// configuration entries.
+func PrepareRestore(r io.Reader, w io.Writer, configIndex uint64) error {
+	var state snapshotState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return fmt.Errorf("error decoding snapshot: %w", err)
+	}
+	if state.Format > snapshotFormat {
+		return fmt.Errorf("unsupported snapshot format %d", state.Format)
+	}
+	for _, key := range state.Keys {
+		for _, version := range key.Versions {
+			if _, err := (*valueCompression)(nil).restoreVersion(version); err != nil {
+				return fmt.Errorf("key %q: %w", key.Key, err)
+			}
+		}
+	}
+
+	state.ConfigIndex = configIndex
+	return json.NewEncoder(w).Encode(state)
+}
+
+// clearMap deletes every entry of m
+func clearMap(m *sync.Map) {
+	m.Range(func(k, _ interface{}) bool {
+		m.Delete(k)
+		return true
+	})
+}
+
+// StoreConfiguration implements raft.ConfigurationStore. Raft calls it with
+// every committed configuration, and ConfigurationFuture.Index does not report
+// the index, so this is where membership changes learn it.
+func (fsm FSM) StoreConfiguration(index uint64, configuration raft.Configuration) {
+	fsm.configIndex.Store(index)
+}
+
+// recordAccesses counts the keys an entry touches for HotKeys
+func (fsm FSM) recordAccesses(payload Payload) {
+	fsm.hotKeys.record(payload.Key)
+	for _, key := range payload.Keys {
+		fsm.hotKeys.record(key)
+	}
+	for _, op := range payload.Ops {
+		fsm.hotKeys.record(op.Key)
+	}
+}
+
+// HotKeys returns the n most accessed keys on this node (all when n is 0),
+// counting log entries applied here and reads served from the state machine.
+// reset starts the counts over.
+func (fsm *FSM) HotKeys(n int, reset bool) HotKeys {
+	return fsm.hotKeys.top(n, reset)
+}
+
+// Keys returns every stored key in ascending order
+func (fsm *FSM) Keys() []string {
+	keys := make([]string, 0, fsm.keyCount.Load())
+	fsm.kv_store.Range(func(key string, _ *valueRecord) bool {
+		keys = append(keys, key)
+		return true
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// KeyCount returns how many keys are stored, without walking the store
+func (fsm *FSM) KeyCount() int64 {
+	return fsm.keyCount.Load()
+}
+
+// ConfigurationIndex returns the log index of the latest committed configuration
+func (fsm *FSM) ConfigurationIndex() uint64 {
+	return fsm.configIndex.Load()
+}
+
+// DeadLetterCount returns how many log entries were skipped since startup
+func (fsm *FSM) DeadLetterCount() uint64 {
+	return fsm.deadLetters.count.Load()
+}
+
+// EvictionStats reports the replicated -max_keys cap and the keys evicted under it
+func (fsm *FSM) EvictionStats() EvictionStats {
+	return fsm.lru.stats()
+}
+
+// ReadCache returns the cache kept in step with this FSM, or nil if disabled
+func (fsm *FSM) ReadCache() *ReadCache {
+	return fsm.cache
 }
 
-func NewFSM() raft.FSM {
+func NewFSM(opts Options) raft.FSM {
 	return &FSM{
-		kv_store: &sync.Map{},
+		kv_store:     newKVStore(opts.StoreImpl),
+		locks:        &sync.Map{},
+		staged:       &sync.Map{},
+		deadLetters:  newDeadLetterLog(opts.DeadLetterPath, opts.OnDeadLetter),
+		cache:        opts.ReadCache,
+		historyDepth: opts.HistoryDepth,
+		idempotency:  newIdempotencyLRU(opts.IdempotencyKeys),
+		configIndex:  &atomic.Uint64{},
+		keyCount:     &atomic.Int64{},
+		lru:          newKeyLRU(),
+		hotKeys:      newHotKeyCounter(opts.HotKeySampleRate),
+		compression:  newValueCompression(opts.CompressMinBytes),
+		onApplyError: opts.OnApplyError,
+		applyMu:      &sync.RWMutex{},
 	}
 }
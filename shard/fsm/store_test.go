@@ -0,0 +1,222 @@
+package fsm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestMinPayloadVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload Payload
+		want    uint8
+	}{
+		{"put", Payload{OP: PUT, Key: "k", Value: "v", Time: 1}, 1},
+		{"get of an old version", Payload{OP: GET, Key: "k", KeyVersion: 3}, 2},
+		{"delete with idempotency key", Payload{OP: DEL, Key: "k", IdempotencyKey: "id"}, 5},
+		{"binary put", Payload{OP: PUT, Key: "k", BinaryValue: []byte{0xff}}, 6},
+		{"lock without time", Payload{OP: LOCK_ACQUIRE, Key: "k", Owner: "o"}, 4},
+		{"lock with time", Payload{OP: LOCK_ACQUIRE, Key: "k", Owner: "o", Time: 1}, 8},
+		{"delif", Payload{OP: DELIF, Key: "k", Expected: "v"}, 11},
+		{"nextid", Payload{OP: NEXTID, Key: "k", Count: 1}, 12},
+		{"put with a key cap", Payload{OP: PUT, Key: "k", Value: "v", MaxKeys: 2}, 13},
+		{"delete with idempotency size", Payload{OP: DEL, Key: "k", IdempotencyKey: "id", IdempotencyKeys: 2}, 14},
+		{"unknown op", Payload{OP: "NOPE", Key: "k"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MinPayloadVersion(tt.payload); got != tt.want {
+				t.Errorf("MinPayloadVersion = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func applyPayload(t *testing.T, f *FSM, payload Payload) interface{} {
+	t.Helper()
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return f.Apply(&raft.Log{Type: raft.LogCommand, Data: data})
+}
+
+func TestApplyChecksPayloadVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload Payload
+		applied bool
+	}{
+		{"unversioned put", Payload{OP: PUT, Key: "k", Value: "v"}, true},
+		{"put at its version", Payload{Version: 1, OP: PUT, Key: "k", Value: "v"}, true},
+		{"put at the newest version", Payload{Version: PayloadVersion, OP: PUT, Key: "k", Value: "v"}, true},
+		{"newer than this node", Payload{Version: PayloadVersion + 1, OP: PUT, Key: "k", Value: "v"}, false},
+		{"op newer than its stamp", Payload{Version: 11, OP: NEXTID, Key: "n", Count: 1}, false},
+		{"field newer than its stamp", Payload{Version: 4, OP: PUT, Key: "k", IdempotencyKey: "id", Value: "v"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := applyPayload(t, newTestFSM(), tt.payload)
+			if _, ok := result.(*ApplyResponse); ok != tt.applied {
+				t.Errorf("Apply returned %#v, applied = %v, want %v", result, ok, tt.applied)
+			}
+		})
+	}
+}
+
+func TestSequencesAreNeverEvicted(t *testing.T) {
+	nextID := func(f *FSM) uint64 {
+		t.Helper()
+		allocated, err := f.NextID("_seq/s", 1)
+		if err != nil {
+			t.Fatalf("NextID: %v", err)
+		}
+		return allocated.Last
+	}
+	fill := func(f *FSM) {
+		for _, key := range []string{"a", "b", "c"} {
+			f.Put(key, "v")
+		}
+	}
+
+	// A sequence that entered the LRU through a put leaves it on its next allocation
+	written := newTestFSM()
+	written.setMaxKeys(2)
+	written.Put("_seq/s", "5")
+	if got := nextID(written); got != 6 {
+		t.Fatalf("NextID after a put of 5 = %d, want 6", got)
+	}
+	fill(written)
+	if got := nextID(written); got != 7 {
+		t.Errorf("NextID after evictions = %d, want 7", got)
+	}
+
+	// Capping a store that had no cap orders its keys without the sequences
+	plain := newTestFSM()
+	nextID(plain)
+	restored := newTestFSM()
+	if err := restored.Restore(io.NopCloser(bytes.NewReader(persist(t, plain)))); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	restored.setMaxKeys(2)
+	fill(restored)
+	if got := nextID(restored); got != 2 {
+		t.Errorf("NextID after restoring and evicting = %d, want 2", got)
+	}
+}
+
+func TestMaxKeysFollowsTheLog(t *testing.T) {
+	put := func(key string, maxKeys int) Payload {
+		return Payload{Version: PayloadVersion, OP: PUT, Key: key, Value: "v", MaxKeys: maxKeys}
+	}
+	// The cap is set by the entries, whichever node proposed them: a leader
+	// without -max_keys stamps no cap, one with it stamps its own
+	entries := []Payload{
+		put("a", 0), put("b", 0), put("c", 0), put("d", 0),
+		put("e", 3), // caps a store of a..e ordered by name, evicting a and b
+		{Version: PayloadVersion, OP: GET, Key: "c", MaxKeys: 3},
+		put("f", 3),  // d is now the least recently used
+		put("g", 2),  // shrinking evicts e, then g evicts c
+		put("h", -1), // lifts the cap
+		put("i", 0),
+		put("j", 2), // caps f..i again by name, keeping h and i, then j evicts h
+	}
+
+	// One node applies the whole log; the other restores a snapshot taken
+	// halfway, as a follower that fell behind would, and applies the rest
+	whole, caughtUp := newTestFSM(), newTestFSM()
+	for i, payload := range entries {
+		applyPayload(t, whole, payload)
+		if i == len(entries)/2 {
+			if err := caughtUp.Restore(io.NopCloser(bytes.NewReader(persist(t, whole)))); err != nil {
+				t.Fatalf("Restore: %v", err)
+			}
+		}
+		if i > len(entries)/2 {
+			applyPayload(t, caughtUp, payload)
+		}
+	}
+
+	keys := func(f *FSM) []string {
+		var names []string
+		values, _, _ := f.Scan(context.Background(), ScanOptions{Limit: 100})
+		for _, kv := range values {
+			names = append(names, kv.Key)
+		}
+		return names
+	}
+	if got, want := fmt.Sprint(keys(whole)), "[i j]"; got != want {
+		t.Errorf("keys = %s, want %s", got, want)
+	}
+	if got, want := fmt.Sprint(keys(caughtUp)), fmt.Sprint(keys(whole)); got != want {
+		t.Errorf("the caught up node holds %s, the other %s", got, want)
+	}
+	if a, b := whole.EvictionStats(), caughtUp.EvictionStats(); a != b || a.MaxKeys != 2 {
+		t.Errorf("EvictionStats = %+v and %+v, want equal with MaxKeys 2", a, b)
+	}
+}
+
+func TestIdempotencyKeysFollowTheLog(t *testing.T) {
+	appendOnce := func(id string) Payload {
+		return Payload{Version: PayloadVersion, OP: APPEND, Key: "k", Value: id, IdempotencyKey: id, IdempotencyKeys: 2}
+	}
+	// k ends up as "abc" only if the retry of a, two keys back, is replayed
+	// rather than applied again
+	entries := []Payload{appendOnce("a"), appendOnce("b"), appendOnce("a"), appendOnce("c")}
+
+	// Nodes started with different -idempotency_keys remember what the
+	// leader's size says, so a retry is a replay on both or on neither
+	small := NewFSM(Options{HistoryDepth: 1, IdempotencyKeys: 1}).(*FSM)
+	large := NewFSM(Options{HistoryDepth: 1, IdempotencyKeys: 100}).(*FSM)
+	for _, f := range []*FSM{small, large} {
+		for _, payload := range entries {
+			applyPayload(t, f, payload)
+		}
+	}
+	for name, f := range map[string]*FSM{"small": small, "large": large} {
+		if value, err := f.Get("k"); err != nil || value != "abc" {
+			t.Errorf("%s: Get(k) = %v, %v; want abc", name, value, err)
+		}
+	}
+
+	// A node restored from a snapshot takes the size it was taken with
+	restored := NewFSM(Options{HistoryDepth: 1, IdempotencyKeys: 1}).(*FSM)
+	if err := restored.Restore(io.NopCloser(bytes.NewReader(persist(t, large)))); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if !bytes.Equal(persist(t, restored), persist(t, large)) {
+		t.Error("the restored node's snapshot differs from the one it restored")
+	}
+}
+
+func TestDeadLettersAreReported(t *testing.T) {
+	reported := 0
+	f := NewFSM(Options{HistoryDepth: 1, OnDeadLetter: func() { reported++ }}).(*FSM)
+	applyPayload(t, f, Payload{OP: "NOPE", Key: "k"})
+	applyPayload(t, f, Payload{Version: PayloadVersion + 1, OP: PUT, Key: "k", Value: "v"})
+	f.Apply(&raft.Log{Type: raft.LogCommand, Data: []byte("not json")})
+	applyPayload(t, f, Payload{OP: PUT, Key: "k", Value: "v"})
+
+	if reported != 3 || f.DeadLetterCount() != 3 {
+		t.Errorf("reported %d dead letters, DeadLetterCount %d; want 3 and 3", reported, f.DeadLetterCount())
+	}
+}
+
+func TestBatchRejectsUnsupportedOps(t *testing.T) {
+	f := newTestFSM()
+	errs := f.ApplyBatch([]BatchOp{{OP: PUT, Key: "a", Value: "1"}, {OP: APPEND, Key: "a", Value: "2"}})
+	if errs[0] != nil || !errors.Is(errs[1], ErrUnsupportedOp) {
+		t.Fatalf("ApplyBatch errors = %v, want [nil %v]", errs, ErrUnsupportedOp)
+	}
+	// A replayed response is rebuilt from the message and still matches
+	if err := restoreError(errs[1].Error()); !errors.Is(err, ErrUnsupportedOp) || errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("restoreError(%q) = %v", errs[1].Error(), err)
+	}
+}
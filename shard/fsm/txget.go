@@ -0,0 +1,32 @@
+// KV-Raft: Reading several keys at one point in the applied log
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package fsm
+
+// TxGet reads the latest value of each key with no entry applied in between,
+// so keys written together by one BATCH are seen either all before or all
+// after it. Like Read, it goes around the log and does not count as an access
+// for max_keys eviction.
+func (fsm *FSM) TxGet(keys []string) MultiGetResult {
+	for _, key := range keys {
+		fsm.hotKeys.record(key)
+	}
+
+	fsm.applyMu.RLock()
+	defer fsm.applyMu.RUnlock()
+
+	result := MultiGetResult{
+		Values:  make(map[string]string),
+		Missing: []string{},
+	}
+	for _, key := range keys {
+		record, ok := fsm.kv_store.Load(key)
+		if !ok {
+			result.Missing = append(result.Missing, key)
+			continue
+		}
+		result.Values[key] = fsm.compression.decode(record.latest().value)
+	}
+	return result
+}
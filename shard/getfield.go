@@ -0,0 +1,178 @@
+// KV-Raft: Field-level reads of JSON document values
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+var (
+	errFieldNotFound = errors.New("field not found")
+	errNotJSON       = errors.New("value is not JSON")
+)
+
+// GetFieldHandler returns one field of a stored JSON document, so clients of
+// large documents do not transfer the whole value
+func (s *Server) GetFieldHandler(w http.ResponseWriter, r *http.Request) {
+	var req GetFieldRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if req.Key == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("key"))
+		return
+	}
+	if req.Field == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("field"))
+		return
+	}
+
+	if !validNamespace(req.Namespace) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Namespace must not contain '/'")
+		return
+	}
+	if !validKey(req.Namespace, req.Key) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, bareKeyMessage)
+		return
+	}
+
+	// The read is linearizable like /get, so only the leader can serve it
+	if s.raft.State() != raft.Leader {
+		query := url.Values{}
+		query.Set("key", req.Key)
+		query.Set("field", req.Field)
+		if req.Namespace != "" {
+			query.Set("namespace", req.Namespace)
+		}
+		s.forwardToLeader(w, r, http.MethodGet, "/getfield?"+query.Encode(), nil)
+		return
+	}
+
+	if !s.awaitSession(w, r) {
+		return
+	}
+
+	result, ok := s.readField(w, r, namespacedKey(req.Namespace, req.Key))
+	if !ok {
+		return
+	}
+
+	value, err := extractField([]byte(result.Value), req.Field)
+	switch {
+	case errors.Is(err, errNotJSON):
+		writeJSONError(w, http.StatusUnprocessableEntity, api.CodeNotJSON, "Stored value is not JSON")
+		return
+	case errors.Is(err, errFieldNotFound):
+		writeJSONError(w, http.StatusNotFound, api.CodeFieldNotFound, err.Error())
+		return
+	}
+
+	log.Printf("[HTTP-GET-FIELD] field %s of key %s was found on this node", req.Field, req.Key)
+
+	response := APIResponse{
+		Success: true,
+		Message: "Field retrieved successfully",
+		Data: map[string]interface{}{
+			"key":     req.Key,
+			"field":   req.Field,
+			"value":   value,
+			"version": result.Version,
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
+
+// readField reads the latest value of storeKey as /get does in the
+// configured -read_mode, answering the request itself if that fails
+func (s *Server) readField(w http.ResponseWriter, r *http.Request, storeKey string) (fsm.GetResult, bool) {
+	var result fsm.GetResult
+	var err error
+
+	if store, ok := s.fsm.(*fsm.FSM); ok && s.config.ReadMode == ReadModeLinearizable {
+		if err := s.readIndex(r.Context()); err != nil {
+			s.writeApplyError(w, err)
+			return result, false
+		}
+		result, err = store.Read(storeKey, 0)
+	} else {
+		payload := fsm.Payload{
+			OP:  fsm.GET,
+			Key: storeKey,
+		}
+
+		data, marshalErr := s.marshalPayload(payload)
+		if marshalErr != nil {
+			writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal payload")
+			return result, false
+		}
+
+		applyFuture := s.applyRead(payload.OP, data)
+		if err := applyFuture.Error(); err != nil {
+			s.writeApplyError(w, err)
+			return result, false
+		}
+
+		applyResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
+		if !ok {
+			writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Invalid raft response")
+			return result, false
+		}
+		if applyResponse.Error == nil {
+			if result, ok = applyResponse.Data.(fsm.GetResult); !ok {
+				writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to convert value")
+				return result, false
+			}
+		}
+		err = applyResponse.Error
+	}
+
+	if err != nil {
+		status, code := applyErrorStatus(err)
+		writeJSONError(w, status, code, "Failed to read value: "+err.Error())
+		return result, false
+	}
+	return result, true
+}
+
+// extractField follows the dotted path through a JSON document and returns the
+// sub-value exactly as stored. Numeric segments index arrays.
+func extractField(document []byte, path string) (json.RawMessage, error) {
+	if !json.Valid(document) {
+		return nil, errNotJSON
+	}
+
+	current := json.RawMessage(document)
+	for i, segment := range strings.Split(path, ".") {
+		var object map[string]json.RawMessage
+		var array []json.RawMessage
+
+		next, found := json.RawMessage(nil), false
+		if json.Unmarshal(current, &object) == nil {
+			next, found = object[segment]
+		} else if json.Unmarshal(current, &array) == nil {
+			if index, err := strconv.Atoi(segment); err == nil && index >= 0 && index < len(array) {
+				next, found = array[index], true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s", errFieldNotFound, strings.Join(strings.Split(path, ".")[:i+1], "."))
+		}
+		current = next
+	}
+	return current, nil
+}
@@ -0,0 +1,273 @@
+// KV-Raft: Hosting several shards' raft groups in one process
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+
+	"kv-raft/fsm"
+)
+
+// groupRaftPortStep separates the raft ports of the groups hosted by one
+// process: group N listens on the -raft_addr port plus N*groupRaftPortStep.
+// Every process uses the same layout, so a peer's HTTP address is still
+// derived from its raft address, as in single-group mode.
+const groupRaftPortStep = 100
+
+// shardGroup is one raft group hosted by this process. id is 0 when the
+// process runs a single group served at the root of its HTTP port.
+type shardGroup struct {
+	id       int
+	dir      string
+	server   *UnifiedServer
+	raft     *raft.Raft
+	store    *raftboltdb.BoltStore
+	self     raft.Server
+	hasState bool
+
+	// recovered is set when a corrupt raft.db was moved aside, at this
+	// startup or an earlier one, and rejoin is not yet done; the group then
+	// replaces its old ID through StartRejoin instead of bootstrapping
+	recovered bool
+	rejoin    recoveryState
+}
+
+// parseShardGroups parses the -shards list of group IDs
+func parseShardGroups(list string) ([]int, error) {
+	seen := make(map[int]bool)
+	var groups []int
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		id, err := strconv.Atoi(entry)
+		if err != nil || id < 1 || id*groupRaftPortStep >= 65536 {
+			return nil, fmt.Errorf("%q is not a shard ID between 1 and %d", entry, 65535/groupRaftPortStep)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("shard %d is listed twice", id)
+		}
+		seen[id] = true
+		groups = append(groups, id)
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("no shard IDs given")
+	}
+	sort.Ints(groups)
+	return groups, nil
+}
+
+// groupRaftAddr returns the raft address group listens on for the base -raft_addr
+func groupRaftAddr(raftAddr string, group int) (string, error) {
+	host, port, err := net.SplitHostPort(raftAddr)
+	if err != nil {
+		return "", err
+	}
+	basePort, err := strconv.Atoi(port)
+	if err != nil {
+		return "", err
+	}
+	groupPort := basePort + group*groupRaftPortStep
+	if groupPort > 65535 {
+		return "", fmt.Errorf("raft port %d for shard %d is out of range", groupPort, group)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(groupPort)), nil
+}
+
+// groupHTTPAddr returns the HTTP address of the process hosting group at raftAddr
+func groupHTTPAddr(raftAddr string, group int) string {
+	host, port, err := net.SplitHostPort(raftAddr)
+	if err != nil {
+		return raftAddr
+	}
+	groupPort, err := strconv.Atoi(port)
+	if err != nil {
+		return raftAddr
+	}
+	return convertRaftToHTTPAddress(net.JoinHostPort(host, strconv.Itoa(groupPort-group*groupRaftPortStep)))
+}
+
+// groupPrefix is the path under which a group's endpoints are served
+func groupPrefix(group int) string {
+	return fmt.Sprintf("/shard/%d", group)
+}
+
+// peerURL returns the base URL of this server's group on the node at raftAddr
+func (s *Server) peerURL(raftAddr raft.ServerAddress) string {
+	if s.config.Group == 0 {
+		return "http://" + convertRaftToHTTPAddress(string(raftAddr))
+	}
+	return "http://" + groupHTTPAddr(string(raftAddr), s.config.Group) + groupPrefix(s.config.Group)
+}
+
+// openShardGroup creates the FSM, stores, transport and raft instance of one
+// group, keeping its state in dir. Bootstrapping is left to the caller.
+func openShardGroup(id, shardID int, dir, raftAddr string, config Config) (*shardGroup, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	raftConfig := raft.DefaultConfig()
+	raftConfig.SnapshotInterval = snapInterval
+	raftConfig.SnapshotThreshold = snapThreshold
+
+	readCache := fsm.NewReadCache(time.Duration(*readCacheTTL) * time.Millisecond)
+	compressMinBytes := 0
+	if *valueCompression {
+		compressMinBytes = *valueCompressionMinBytes
+	}
+	// Created up front so the counter is exported at 0 before the first skip
+	deadLetters := deadLetterEntries.WithLabelValues(strconv.Itoa(shardID), strconv.Itoa(id))
+	fsmStore := fsm.NewFSM(fsm.Options{
+		DeadLetterPath:   filepath.Join(dir, "dead_letter.log"),
+		ReadCache:        readCache,
+		HistoryDepth:     *historyDepth,
+		IdempotencyKeys:  *idempotencyKeys,
+		OnApplyError:     recordApplyError,
+		OnDeadLetter:     deadLetters.Inc,
+		HotKeySampleRate: *hotKeySample,
+		CompressMinBytes: compressMinBytes,
+		StoreImpl:        *storeImpl,
+	})
+
+	store, err := openLogStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	localID, err := localServerID(dir)
+	if err != nil {
+		return nil, err
+	}
+	raftConfig.LocalID = localID
+	rejoin, recovered, err := pendingRejoin(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheStore, err := raft.NewLogCache(256, store)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotStore, err := raft.NewFileSnapshotStore(dir, *snapshotRetain, os.Stdout)
+	if err != nil {
+		return nil, err
+	}
+
+	tcpAddr, err := net.ResolveTCPAddr("tcp", raftAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := raft.NewTCPTransport(raftAddr, tcpAddr, 3, tcpTimeout, os.Stdout)
+	if err != nil {
+		return nil, err
+	}
+
+	// Raft state on disk means this node already belongs to a cluster and must not bootstrap again
+	hasState, err := raft.HasExistingState(store, store, snapshotStore)
+	if err != nil {
+		return nil, err
+	}
+
+	raftServer, err := raft.NewRaft(raftConfig, fsmStore, cacheStore, store, snapshotStore, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	self := raft.Server{
+		ID:      localID,
+		Address: transport.LocalAddr(),
+	}
+	config.Group = id
+
+	server := NewUnifiedServer(raftServer, fsmStore, shardID, self, config)
+	server.rejoining.Store(recovered)
+
+	return &shardGroup{
+		id:       id,
+		dir:      dir,
+		server:   server,
+		raft:     raftServer,
+		store:    store,
+		self:     self,
+		hasState: hasState,
+
+		recovered: recovered,
+		rejoin:    rejoin,
+	}, nil
+}
+
+// registerReadHandlers registers the read-only endpoints of one group on mux.
+// They are all that the -read_port serves.
+func registerReadHandlers(mux *http.ServeMux, us *UnifiedServer) {
+	mux.HandleFunc("/get", instrument("get", us.GetHandler))
+	mux.HandleFunc("/getfield", instrument("get_field", us.GetFieldHandler))
+	mux.HandleFunc("/mget", instrument("mget", us.MultiGetHandler))
+	mux.HandleFunc("/txget", instrument("txget", us.TxGetHandler))
+	mux.HandleFunc("/scan", instrument("scan", us.ScanHandler))
+
+	// Readiness: 200 once this node has caught up with the leader
+	mux.HandleFunc("/readyz", us.ReadyHandler)
+}
+
+// registerHandlers registers the endpoints of one group on mux
+func registerHandlers(mux *http.ServeMux, us *UnifiedServer) {
+	registerReadHandlers(mux, us)
+
+	// Data operation endpoints
+	mux.HandleFunc("/put", instrument("put", us.PutHandler))
+	mux.HandleFunc("/delete", instrument("delete", us.DeleteHandler))
+	mux.HandleFunc("/deleteif", instrument("delete_if", us.DeleteIfHandler))
+	mux.HandleFunc("/append", instrument("append", us.AppendHandler))
+	mux.HandleFunc("/nextid", instrument("next_id", us.NextIDHandler))
+	mux.HandleFunc("/stage", instrument("stage", us.StageHandler))
+	mux.HandleFunc("/commit-staged", instrument("commit_staged", us.CommitStagedHandler))
+	mux.HandleFunc("/batch", instrument("batch", us.BatchHandler))
+	mux.HandleFunc("/import", instrument("import", us.ImportHandler))
+	mux.HandleFunc("/deleteprefix", instrument("delete_prefix", us.DeletePrefixHandler))
+	mux.HandleFunc("/namespace/delete", instrument("namespace_delete", us.NamespaceDeleteHandler))
+
+	// Lock endpoints
+	mux.HandleFunc("/lock/acquire", instrument("lock_acquire", us.LockAcquireHandler))
+	mux.HandleFunc("/lock/renew", instrument("lock_renew", us.LockRenewHandler))
+	mux.HandleFunc("/lock/release", instrument("lock_release", us.LockReleaseHandler))
+
+	// Config operation endpoints (merged from config server)
+	mux.HandleFunc("/config", us.ConfigHandler)
+	mux.HandleFunc("/addshard", us.AddShardHandler)
+	mux.HandleFunc("/newleader", us.NewLeaderHandler)
+	mux.HandleFunc("/syncshards", us.SyncShardsHandler)
+
+	// Raft management endpoints
+	mux.HandleFunc("/raft/join", us.RaftJoin)
+	mux.HandleFunc("/raft/status", us.RaftStatus)
+	mux.HandleFunc("/raft/leave", us.RaftLeave)
+	mux.HandleFunc("/raft/peers", us.RaftPeers)
+	mux.HandleFunc("/raft/leader", us.RaftLeader)
+	mux.HandleFunc("/raft/history", us.RaftHistory)
+	mux.HandleFunc("/raft/verify", us.RaftVerify)
+	mux.HandleFunc("/raft/snapshot", us.RaftSnapshot)
+	mux.HandleFunc("/raft/restore", us.RaftRestore)
+
+	// Moving keys to their owners after the ring changes
+	mux.HandleFunc("/rebalance", us.RebalanceHandler)
+	mux.HandleFunc("/rebalance/status", us.RebalanceStatusHandler)
+	mux.HandleFunc("/rebalance/stop", us.RebalanceStopHandler)
+
+	// Diagnostics
+	mux.HandleFunc("/hotkeys", us.HotKeysHandler)
+}
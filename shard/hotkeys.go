@@ -0,0 +1,63 @@
+// KV-Raft: Most accessed keys of a node, for finding hot keys
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+// defaultHotKeys is how many keys /hotkeys returns when n is not given
+const defaultHotKeys = 10
+
+// HotKeysRequest holds the parameters of /hotkeys
+type HotKeysRequest struct {
+	N     int  `json:"n"`     // how many keys to return; 0 returns defaultHotKeys
+	Reset bool `json:"reset"` // start the counts over after reading them
+}
+
+// HotKeysHandler returns this node's most accessed keys since the last reset.
+// Counts are sampled and local to the node, so ask the leader for the keys
+// behind its load; nothing is forwarded.
+func (s *Server) HotKeysHandler(w http.ResponseWriter, r *http.Request) {
+	var req HotKeysRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.N < 0 {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "n must not be negative")
+		return
+	}
+	if req.N == 0 {
+		req.N = defaultHotKeys
+	}
+
+	store, ok := s.fsm.(*fsm.FSM)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Hot keys need the key-value state machine")
+		return
+	}
+
+	hot := store.HotKeys(req.N, req.Reset)
+	if hot.SampleRate == 0 {
+		writeJSONError(w, http.StatusNotFound, api.CodeInvalidRequest, "Hot key counting is disabled; start the shard with -hotkey_sample above 0")
+		return
+	}
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Top %d keys on this node", len(hot.Keys)),
+		Data: map[string]interface{}{
+			"node_id":     s.self.ID,
+			"keys":        hot.Keys,
+			"sample_rate": hot.SampleRate,
+			"since":       hot.Since,
+			"reset":       req.Reset,
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
@@ -5,37 +5,49 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
+	"unicode/utf8"
 
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
 	"kv-raft/fsm"
 )
 
-// Response structures for consistent JSON responses
-type APIResponse struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message,omitempty"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
-}
-
-type GetResponse struct {
-	Success bool   `json:"success"`
-	Key     string `json:"key"`
-	Value   string `json:"value"`
-	Error   string `json:"error,omitempty"`
-}
-
-type PutRequest struct {
-	Key   string `json:"key"`
-	Value string `json:"val"`
-}
-
-type DeleteRequest struct {
-	Key string `json:"key"`
-}
+// Wire structures live in kv-raft/api so the Go client can share them
+type (
+	APIResponse   = api.APIResponse
+	GetResponse   = api.GetResponse
+	PutRequest    = api.PutRequest
+	DeleteRequest = api.DeleteRequest
+
+	DeleteIfRequest = api.DeleteIfRequest
+	NextIDRequest   = api.NextIDRequest
+
+	GetRequest       = api.GetRequest
+	GetFieldRequest  = api.GetFieldRequest
+	LockRequest      = api.LockRequest
+	MultiGetRequest  = api.MultiGetRequest
+	TxGetRequest     = api.TxGetRequest
+	ScanRequest      = api.ScanRequest
+	RebalanceRequest = api.RebalanceRequest
+	LocateRequest    = api.LocateRequest
+	AppendRequest    = api.AppendRequest
+	StageRequest     = api.StageRequest
+
+	CommitStagedRequest = api.CommitStagedRequest
+	DeletePrefixRequest = api.DeletePrefixRequest
+	BatchRequest        = api.BatchRequest
+)
 
 func WriteJSONResponse(w http.ResponseWriter, statusCode int, response interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -43,10 +55,11 @@ func WriteJSONResponse(w http.ResponseWriter, statusCode int, response interface
 	json.NewEncoder(w).Encode(response)
 }
 
-func WriteJSONError(w http.ResponseWriter, statusCode int, message string) {
+func WriteJSONError(w http.ResponseWriter, statusCode int, code string, message string) {
 	response := APIResponse{
 		Success: false,
 		Error:   message,
+		Code:    code,
 	}
 	WriteJSONResponse(w, statusCode, response)
 }
@@ -56,186 +69,628 @@ func writeJSONResponse(w http.ResponseWriter, statusCode int, response interface
 	WriteJSONResponse(w, statusCode, response)
 }
 
-func writeJSONError(w http.ResponseWriter, statusCode int, message string) {
-	WriteJSONError(w, statusCode, message)
+func writeJSONError(w http.ResponseWriter, statusCode int, code string, message string) {
+	WriteJSONError(w, statusCode, code, message)
+}
+
+// applyErrorStatus maps an error from ApplyResponse to the HTTP status and error code to respond with
+func applyErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, fsm.ErrKeyNotFound):
+		return http.StatusNotFound, api.CodeKeyNotFound
+	case errors.Is(err, fsm.ErrVersionNotFound):
+		return http.StatusNotFound, api.CodeVersionNotFound
+	case errors.Is(err, fsm.ErrCASMismatch):
+		return http.StatusConflict, api.CodeCASMismatch
+	case errors.Is(err, fsm.ErrLockHeld):
+		return http.StatusLocked, api.CodeLocked
+	case errors.Is(err, fsm.ErrLockNotHeld):
+		return http.StatusNotFound, api.CodeLockNotHeld
+	case errors.Is(err, fsm.ErrTypeMismatch):
+		return http.StatusBadRequest, api.CodeTypeMismatch
+	case errors.Is(err, fsm.ErrValueTooLarge):
+		return http.StatusRequestEntityTooLarge, api.CodeTooLarge
+	case errors.Is(err, fsm.ErrUnsupportedOp):
+		return http.StatusBadRequest, api.CodeInvalidRequest
+	default:
+		return http.StatusInternalServerError, api.CodeInternalError
+	}
+}
+
+// raftErrorStatus maps an error from applying an entry through raft, as
+// opposed to one the FSM returned, to an HTTP status and error code
+func raftErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, raft.ErrNotLeader):
+		return http.StatusMisdirectedRequest, api.CodeNotLeader
+	case errors.Is(err, raft.ErrLeadershipLost):
+		return http.StatusServiceUnavailable, api.CodeLeadershipLost
+	case errors.Is(err, raft.ErrRaftShutdown):
+		return http.StatusServiceUnavailable, api.CodeShuttingDown
+	case errors.Is(err, raft.ErrEnqueueTimeout):
+		return http.StatusGatewayTimeout, api.CodeApplyTimeout
+	case errors.Is(err, errReadIndexTimeout):
+		return http.StatusServiceUnavailable, api.CodeNotReady
+	case errors.Is(err, errNoQuorum):
+		return http.StatusServiceUnavailable, api.CodeNoQuorum
+	default:
+		return http.StatusInternalServerError, api.CodeRaftApplyFailed
+	}
+}
+
+// writeApplyError answers a request whose raft apply failed, telling the
+// client whether to go to the leader, retry, or check whether the write landed
+func (s *Server) writeApplyError(w http.ResponseWriter, err error) {
+	status, code := raftErrorStatus(err)
+	msg := "Raft apply failed: " + err.Error()
+	switch code {
+	case api.CodeNotLeader:
+		msg = "This node is not the leader"
+		if leaderAddr, _ := s.raft.LeaderWithID(); leaderAddr != "" {
+			msg += "; the leader is " + s.peerURL(leaderAddr)
+		}
+	case api.CodeLeadershipLost:
+		msg = "Leadership was lost before the entry committed; it may or may not have been applied"
+	case api.CodeShuttingDown:
+		msg = "Raft is shutting down"
+	case api.CodeApplyTimeout:
+		msg = fmt.Sprintf("Raft did not accept the entry within %s", s.config.ApplyTimeout)
+	case api.CodeNotReady:
+		msg = fmt.Sprintf("The leader did not apply its commit index within %s", maxIndexWait)
+	case api.CodeNoQuorum:
+		msg = "Write rejected: " + err.Error()
+	}
+	writeJSONError(w, status, code, msg)
+}
+
+// validateValueEncoding checks value against the configured -value_encoding
+func validateValueEncoding(encoding string, value string) error {
+	switch encoding {
+	case EncodingUTF8:
+		if !utf8.ValidString(value) {
+			return errors.New("Value must be valid UTF-8")
+		}
+	case EncodingJSON:
+		if !json.Valid([]byte(value)) {
+			return errors.New("Value must be valid JSON")
+		}
+	}
+	return nil
+}
+
+// readCache returns the FSM's read cache, or nil if caching is disabled
+func (s *Server) readCache() *fsm.ReadCache {
+	if store, ok := s.fsm.(*fsm.FSM); ok {
+		return store.ReadCache()
+	}
+	return nil
+}
+
+// marshalPayload stamps payload with this node's clock, key cap and
+// idempotency LRU size and the lowest payload version that can carry it, and
+// encodes it for the raft log. Only the leader proposes entries, so the stamps
+// are the leader's, and the FSM uses them instead of each node's own clock,
+// -max_keys and -idempotency_keys.
+func (s *Server) marshalPayload(payload fsm.Payload) ([]byte, error) {
+	payload.Time = time.Now().UnixNano()
+	payload.MaxKeys = s.maxKeysStamp()
+	if payload.IdempotencyKey != "" {
+		payload.IdempotencyKeys = s.config.IdempotencyKeys
+		if payload.IdempotencyKeys <= 0 {
+			payload.IdempotencyKeys = -1
+		}
+	}
+	payload.Version = fsm.MinPayloadVersion(payload)
+	return json.Marshal(payload)
+}
+
+// maxKeysStamp returns the key cap to stamp on an entry: -max_keys, or -1 to
+// lift the cap an earlier leader replicated, or 0 when there is none to change
+func (s *Server) maxKeysStamp() int {
+	if s.config.MaxKeys > 0 {
+		return s.config.MaxKeys
+	}
+	if store, ok := s.fsm.(*fsm.FSM); ok && store.EvictionStats().MaxKeys > 0 {
+		return -1
+	}
+	return 0
+}
+
+// readRetryBackoff is the first delay before retrying a read that failed
+// because leadership changed; it doubles on each retry
+const readRetryBackoff = 25 * time.Millisecond
+
+// applyRead applies a read through raft, retrying with backoff while the error
+// shows leadership changing, for at most config.ApplyTimeout in total
+func (s *Server) applyRead(op string, data []byte) raft.ApplyFuture {
+	deadline := time.Now().Add(s.config.ApplyTimeout)
+	backoff := readRetryBackoff
+	for {
+		applyFuture := s.timedApply(op, data)
+		err := applyFuture.Error()
+		if !errors.Is(err, raft.ErrLeadershipLost) && !errors.Is(err, raft.ErrNotLeader) {
+			return applyFuture
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return applyFuture
+		}
+
+		log.Printf("[HTTP-READ] %s failed during a leadership change, retrying in %s: %v", op, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// maxIndexWait caps how long a read waits for this node to apply min_index
+const maxIndexWait = 2 * time.Second
+
+// waitForIndex blocks until this node has applied index, reporting false if
+// it has not within maxIndexWait
+func (s *Server) waitForIndex(ctx context.Context, index uint64) bool {
+	ctx, cancel := context.WithTimeout(ctx, maxIndexWait)
+	defer cancel()
+
+	for s.raft.AppliedIndex() < index {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return true
 }
 
 func (s *Server) PutHandler(w http.ResponseWriter, r *http.Request) {
 	var req PutRequest
 
-	// Only accept JSON body format
-	if r.Header.Get("Content-Type") != "application/json" {
-		writeJSONError(w, http.StatusBadRequest, "Content-Type must be application/json")
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if req.Key == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("key"))
+		return
+	}
+	if req.Value != "" && req.ValueB64 != "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Send either val or val_b64, not both")
+		return
+	}
+	switch req.Ack {
+	case "", api.AckCommitted, api.AckNone:
+	default:
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "ack must be committed or none")
 		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSONError(w, http.StatusBadRequest, "Invalid JSON format")
+	// Binary values arrive base64-encoded and are stored as their raw bytes
+	value := req.Value
+	var binaryValue []byte
+	if req.ValueB64 != "" {
+		decoded, err := base64.StdEncoding.DecodeString(req.ValueB64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, api.CodeInvalidValue, "val_b64 must be valid base64")
+			return
+		}
+		value, binaryValue = string(decoded), decoded
+	}
+	// A JSON null val decodes to "" and is rejected as missing
+	if value == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("val"))
 		return
 	}
 
-	if req.Key == "" || req.Value == "" {
-		writeJSONError(w, http.StatusBadRequest, "Key and value are required in JSON body")
+	if !validNamespace(req.Namespace) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Namespace must not contain '/'")
+		return
+	}
+	if !validKey(req.Namespace, req.Key) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, bareKeyMessage)
+		return
+	}
+	if reservedKey(namespacedKey(req.Namespace, req.Key)) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, reservedKeyMessage)
+		return
+	}
+	if s.misdirected(w, req.Namespace, req.Key) {
+		return
+	}
+
+	if err := validateValueEncoding(s.config.ValueEncoding, value); err != nil {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidValue, err.Error())
+		return
+	}
+	if s.config.MaxValueBytes > 0 && len(value) > s.config.MaxValueBytes {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, api.CodeTooLarge, fmt.Sprintf("Value is %d bytes, the limit is %d", len(value), s.config.MaxValueBytes))
+		return
+	}
+
+	idemKey, ok := idempotencyKey(w, r)
+	if !ok {
 		return
 	}
 
 	log.Printf("[HTTP-PUT] key %s was put into this node", req.Key)
 
 	payload := fsm.Payload{
-		OP:    fsm.PUT,
-		Key:   req.Key,
-		Value: req.Value,
+		OP:             fsm.PUT,
+		Key:            namespacedKey(req.Namespace, req.Key),
+		Value:          value,
+		IdempotencyKey: idemKey,
+	}
+	if binaryValue != nil {
+		payload.Value, payload.BinaryValue = nil, binaryValue
 	}
 
-	data, err := json.Marshal(payload)
+	data, err := s.marshalPayload(payload)
 	if err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "Failed to marshal payload")
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal payload")
 		return
 	}
 
-	applyFuture := s.raft.Apply(data, 500*time.Millisecond)
+	if err := s.checkWriteQuorum(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+
+	// ack=none answers once the entry is proposed; the write may still be lost
+	// if this node loses leadership before it commits
+	if req.Ack == api.AckNone {
+		if s.raft.State() != raft.Leader {
+			s.writeApplyError(w, raft.ErrNotLeader)
+			return
+		}
+		s.proposeApply(payload.OP, data)
+
+		accepted := map[string]interface{}{"key": req.Key, "value": req.Value}
+		if binaryValue != nil {
+			accepted = map[string]interface{}{"key": req.Key, "val_b64": req.ValueB64}
+		}
+		writeJSONResponse(w, http.StatusAccepted, APIResponse{
+			Success: true,
+			Message: "Key-value pair accepted; it is not yet committed",
+			Data:    accepted,
+		})
+		return
+	}
+
+	applyFuture := s.timedApply(payload.OP, data)
 	if err := applyFuture.Error(); err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "Raft apply failed: "+err.Error())
+		s.writeApplyError(w, err)
 		return
 	}
+	setSessionToken(w, applyFuture.Index())
 
-	_, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	applyResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
 	if !ok {
-		writeJSONError(w, http.StatusInternalServerError, "Invalid raft response")
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Invalid raft response")
+		return
+	}
+	markReplayed(w, applyResponse)
+
+	if applyResponse.Error != nil {
+		status, code := applyErrorStatus(applyResponse.Error)
+		writeJSONError(w, status, code, "Failed to store value: "+applyResponse.Error.Error())
 		return
 	}
 
 	response := APIResponse{
 		Success: true,
 		Message: "Key-value pair stored successfully",
-		Data: map[string]string{
+		Data: map[string]interface{}{
 			"key":   req.Key,
 			"value": req.Value,
+			"index": applyFuture.Index(),
 		},
 	}
+	if binaryValue != nil {
+		response.Data = map[string]interface{}{
+			"key":     req.Key,
+			"val_b64": req.ValueB64,
+			"index":   applyFuture.Index(),
+		}
+	}
 	writeJSONResponse(w, http.StatusOK, response)
 }
 
 func (s *Server) GetHandler(w http.ResponseWriter, r *http.Request) {
-	var key string
+	var req GetRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
 
-	// Try to get key from query parameter first, then from JSON body
-	key = r.URL.Query().Get("key")
+	key, namespace, version := req.Key, req.Namespace, req.Version
 	if key == "" {
-		r.ParseForm()
-		key = r.Form.Get("key")
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("key"))
+		return
 	}
 
-	if key == "" {
-		writeJSONError(w, http.StatusBadRequest, "Key parameter is required")
+	if !validNamespace(namespace) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Namespace must not contain '/'")
+		return
+	}
+	if !validKey(namespace, key) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, bareKeyMessage)
+		return
+	}
+	if s.misdirected(w, namespace, key) {
+		return
+	}
+	storeKey := namespacedKey(namespace, key)
+
+	// A session token from an earlier write raises min_index to that write
+	minIndex, err := sessionMinIndex(r, req.MinIndex)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, err.Error())
+		return
+	}
+
+	// -read_mode local answers from this node's state machine, without raft
+	if s.config.ReadMode == ReadModeLocal {
+		s.localGet(w, r, key, storeKey, version, minIndex)
 		return
 	}
 
-	// Use Raft consensus for GET operations to ensure consistency
+	// Serve latest-version reads from the read cache when within the staleness
+	// window, unless this node is still catching up with the leader or has not
+	// yet applied the client's own write at min_index
+	cache := s.readCache()
+	if cache != nil && version == 0 && s.isReady() && s.waitForIndex(r.Context(), minIndex) {
+		if value, age, ok := cache.Get(storeKey); ok {
+			if result, ok := value.(fsm.GetResult); ok {
+				w.Header().Set("X-Cache", "HIT")
+				w.Header().Set("X-Cache-Age", strconv.FormatInt(age.Milliseconds(), 10))
+				s.writeGetResult(w, key, result)
+				return
+			}
+		}
+		w.Header().Set("X-Cache", "MISS")
+	}
+
+	query := url.Values{}
+	query.Set("key", key)
+	if namespace != "" {
+		query.Set("namespace", namespace)
+	}
+	if version != 0 {
+		query.Set("version", strconv.FormatUint(version, 10))
+	}
+
+	// Followers cannot confirm the read with a quorum, so forward it to the leader
+	if s.raft.State() != raft.Leader {
+		if s.serveStale(w, r, key, storeKey, version, minIndex) {
+			return
+		}
+		s.forwardToLeader(w, r, http.MethodGet, "/get?"+query.Encode(), nil)
+		return
+	}
+
+	if store, ok := s.fsm.(*fsm.FSM); ok && s.config.ReadMode == ReadModeLinearizable {
+		if err := s.readIndex(r.Context()); err != nil {
+			s.writeGetReadError(w, r, err, key, storeKey, version, minIndex, query)
+			return
+		}
+
+		result, err := store.Read(storeKey, version)
+		if err != nil {
+			writeGetError(w, key, version, err)
+			return
+		}
+		log.Printf("[HTTP-GET] key %s was found on this node", key)
+		s.writeGetResult(w, key, result)
+		return
+	}
+
+	// -read_mode log applies the read through the raft log
 	payload := fsm.Payload{
-		OP:  fsm.GET,
-		Key: key,
+		OP:         fsm.GET,
+		Key:        storeKey,
+		KeyVersion: version,
 	}
 
-	data, err := json.Marshal(payload)
+	data, err := s.marshalPayload(payload)
 	if err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "Failed to marshal payload")
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal payload")
 		return
 	}
 
-	applyFuture := s.raft.Apply(data, 500*time.Millisecond)
+	applyFuture := s.applyRead(payload.OP, data)
 	if err := applyFuture.Error(); err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "Raft apply failed: "+err.Error())
+		s.writeGetReadError(w, r, err, key, storeKey, version, minIndex, query)
 		return
 	}
 
 	applyResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
 	if !ok {
-		writeJSONError(w, http.StatusInternalServerError, "Invalid raft response")
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Invalid raft response")
 		return
 	}
 
 	if applyResponse.Error != nil {
-		response := GetResponse{
-			Success: false,
-			Key:     key,
-			Error:   "Key not found",
-		}
-		writeJSONResponse(w, http.StatusNotFound, response)
+		writeGetError(w, key, version, applyResponse.Error)
 		return
 	}
 
-	value := applyResponse.Data
+	result, ok := applyResponse.Data.(fsm.GetResult)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to convert value")
+		return
+	}
 
 	log.Printf("[HTTP-GET] key %s was found on this node", key)
 
-	var valueStr string
-	if str, ok := value.(string); ok {
-		valueStr = str
-	} else if bytes, ok := value.([]byte); ok {
-		valueStr = string(bytes)
-	} else {
-		writeJSONError(w, http.StatusInternalServerError, "Failed to convert value")
+	s.writeGetResult(w, key, result)
+}
+
+// writeGetReadError answers a GET whose read on the leader failed. If
+// leadership moved elsewhere the new leader answers; if it was lost with no
+// successor, -allow_stale_on_no_leader may still answer from this node.
+func (s *Server) writeGetReadError(w http.ResponseWriter, r *http.Request, err error, key, storeKey string, version, minIndex uint64, query url.Values) {
+	if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+		if leaderAddr, _ := s.raft.LeaderWithID(); leaderAddr != "" && r.Header.Get(forwardedHeader) == "" && s.raft.State() != raft.Leader {
+			s.forwardToLeader(w, r, http.MethodGet, "/get?"+query.Encode(), nil)
+			return
+		}
+		if s.serveStale(w, r, key, storeKey, version, minIndex) {
+			return
+		}
+	}
+	s.writeApplyError(w, err)
+}
+
+// localGet answers a GET from this node's state machine as applied so far.
+// On a follower that may be behind the leader; a min_index from the client's
+// write response makes it wait until that write has been applied here.
+func (s *Server) localGet(w http.ResponseWriter, r *http.Request, key, storeKey string, version, minIndex uint64) {
+	store, ok := s.fsm.(*fsm.FSM)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Local reads need the key-value state machine")
+		return
+	}
+	if !s.waitForIndex(r.Context(), minIndex) {
+		writeJSONError(w, http.StatusServiceUnavailable, api.CodeNotReady, fmt.Sprintf("Index %d not yet applied on this node", minIndex))
 		return
 	}
 
+	result, err := store.Read(storeKey, version)
+	if err != nil {
+		writeGetError(w, key, version, err)
+		return
+	}
+	s.writeGetResult(w, key, result)
+}
+
+// writeGetError answers a GET whose read failed
+func writeGetError(w http.ResponseWriter, key string, version uint64, err error) {
+	errMsg := err.Error()
+	switch {
+	case errors.Is(err, fsm.ErrKeyNotFound):
+		errMsg = "Key not found"
+	case errors.Is(err, fsm.ErrVersionNotFound):
+		errMsg = fmt.Sprintf("Version %d of key not found", version)
+	}
+	status, code := applyErrorStatus(err)
 	response := GetResponse{
-		Success: true,
+		Success: false,
 		Key:     key,
-		Value:   valueStr,
+		Error:   errMsg,
+		Code:    code,
 	}
-	writeJSONResponse(w, http.StatusOK, response)
+	writeJSONResponse(w, status, response)
+}
+
+// writeGetResult answers a successful GET, with the version read as its ETag.
+// HEAD requests share the GET path; net/http drops the body for them.
+func (s *Server) writeGetResult(w http.ResponseWriter, key string, result fsm.GetResult) {
+	if result.Version != 0 {
+		w.Header().Set("ETag", fmt.Sprintf("%q", strconv.FormatUint(result.Version, 10)))
+	}
+	writeJSONResponse(w, http.StatusOK, s.newGetResponse(key, result))
+}
+
+// newGetResponse builds a successful GET response including the version range.
+// Values that are not valid UTF-8 would be mangled by JSON, so they are
+// returned base64-encoded in val_b64 instead.
+func (s *Server) newGetResponse(key string, result fsm.GetResult) GetResponse {
+	response := GetResponse{
+		Success:       true,
+		Key:           key,
+		Value:         result.Value,
+		Version:       result.Version,
+		OldestVersion: result.OldestVersion,
+		LatestVersion: result.LatestVersion,
+	}
+	if s.needsBase64(result.Value) {
+		response.Value = ""
+		response.ValueB64 = base64.StdEncoding.EncodeToString([]byte(result.Value))
+	}
+	return response
+}
+
+// needsBase64 reports whether a value read back is sent in val_b64 rather
+// than as is
+func (s *Server) needsBase64(value string) bool {
+	return s.config.AlwaysBase64 || !utf8.ValidString(value)
 }
 
 func (s *Server) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	var req DeleteRequest
 
-	// Only accept JSON body format
-	if r.Header.Get("Content-Type") != "application/json" {
-		writeJSONError(w, http.StatusBadRequest, "Content-Type must be application/json")
+	if !decodeRequest(w, r, &req) {
 		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSONError(w, http.StatusBadRequest, "Invalid JSON format")
+	if req.Key == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("key"))
 		return
 	}
 
-	if req.Key == "" {
-		writeJSONError(w, http.StatusBadRequest, "Key parameter is required in JSON body")
+	if !validNamespace(req.Namespace) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Namespace must not contain '/'")
+		return
+	}
+	if !validKey(req.Namespace, req.Key) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, bareKeyMessage)
+		return
+	}
+	if reservedKey(namespacedKey(req.Namespace, req.Key)) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, reservedKeyMessage)
+		return
+	}
+
+	idemKey, ok := idempotencyKey(w, r)
+	if !ok {
 		return
 	}
 
 	log.Printf("[HTTP-DELETE] key %s was deleted from this node", req.Key)
 
 	payload := fsm.Payload{
-		OP:  fsm.DEL,
-		Key: req.Key,
+		OP:             fsm.DEL,
+		Key:            namespacedKey(req.Namespace, req.Key),
+		IdempotencyKey: idemKey,
 	}
 
-	data, err := json.Marshal(payload)
+	data, err := s.marshalPayload(payload)
 	if err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "Failed to marshal payload")
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal payload")
 		return
 	}
 
-	applyFuture := s.raft.Apply(data, 500*time.Millisecond)
+	if err := s.checkWriteQuorum(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+
+	applyFuture := s.timedApply(payload.OP, data)
 	if err := applyFuture.Error(); err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "Raft apply failed: "+err.Error())
+		s.writeApplyError(w, err)
 		return
 	}
+	setSessionToken(w, applyFuture.Index())
 
-	_, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	applyResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
 	if !ok {
-		writeJSONError(w, http.StatusInternalServerError, "Invalid raft response")
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Invalid raft response")
+		return
+	}
+	markReplayed(w, applyResponse)
+
+	if applyResponse.Error != nil {
+		if errors.Is(applyResponse.Error, fsm.ErrKeyNotFound) {
+			writeJSONError(w, http.StatusNotFound, api.CodeKeyNotFound, "Key not found")
+			return
+		}
+		status, code := applyErrorStatus(applyResponse.Error)
+		writeJSONError(w, status, code, "Failed to delete key: "+applyResponse.Error.Error())
 		return
 	}
 
 	response := APIResponse{
 		Success: true,
 		Message: "Key deleted successfully",
-		Data: map[string]string{
-			"key": req.Key,
+		Data: map[string]interface{}{
+			"key":   req.Key,
+			"index": applyFuture.Index(),
 		},
 	}
 	writeJSONResponse(w, http.StatusOK, response)
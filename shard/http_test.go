@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+// fakeFuture is a finished raft future of every kind the handlers wait on
+type fakeFuture struct {
+	err           error
+	index         uint64
+	response      interface{}
+	configuration raft.Configuration
+}
+
+func (f fakeFuture) Error() error                                     { return f.err }
+func (f fakeFuture) Index() uint64                                    { return f.index }
+func (f fakeFuture) Response() interface{}                            { return f.response }
+func (f fakeFuture) Configuration() raft.Configuration                { return f.configuration }
+func (f fakeFuture) Open() (*raft.SnapshotMeta, io.ReadCloser, error) { return nil, nil, f.err }
+
+// fakeRaft is a single-node raftNode that applies entries to its FSM at once
+// while it is leader, and fails them with raft.ErrNotLeader otherwise. A
+// restore is handed straight to the FSM.
+type fakeRaft struct {
+	mu            sync.Mutex
+	fsm           raft.FSM
+	state         raft.RaftState
+	index         uint64
+	configuration raft.Configuration
+	membershipErr error // returned by every membership change
+}
+
+func (f *fakeRaft) Apply(cmd []byte, timeout time.Duration) raft.ApplyFuture {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.state != raft.Leader {
+		return fakeFuture{err: raft.ErrNotLeader}
+	}
+	f.index++
+	response := f.fsm.Apply(&raft.Log{Type: raft.LogCommand, Index: f.index, Data: cmd})
+	return fakeFuture{index: f.index, response: response}
+}
+
+func (f *fakeRaft) State() raft.RaftState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.state
+}
+
+// LeaderWithID knows no leader but itself, so a follower has none to forward to
+func (f *fakeRaft) LeaderWithID() (raft.ServerAddress, raft.ServerID) {
+	if f.State() == raft.Leader {
+		return "127.0.0.1:1", "n1"
+	}
+	return "", ""
+}
+
+func (f *fakeRaft) AppliedIndex() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.index
+}
+
+func (f *fakeRaft) CommitIndex() uint64 { return f.AppliedIndex() }
+func (f *fakeRaft) CurrentTerm() uint64 { return 1 }
+func (f *fakeRaft) LastIndex() uint64   { return f.AppliedIndex() }
+
+func (f *fakeRaft) GetConfiguration() raft.ConfigurationFuture {
+	return fakeFuture{configuration: f.configuration}
+}
+func (f *fakeRaft) AddVoter(id raft.ServerID, address raft.ServerAddress, prevIndex uint64, timeout time.Duration) raft.IndexFuture {
+	return fakeFuture{err: f.membershipErr}
+}
+func (f *fakeRaft) AddNonvoter(id raft.ServerID, address raft.ServerAddress, prevIndex uint64, timeout time.Duration) raft.IndexFuture {
+	return fakeFuture{err: f.membershipErr}
+}
+func (f *fakeRaft) RemoveServer(id raft.ServerID, prevIndex uint64, timeout time.Duration) raft.IndexFuture {
+	return fakeFuture{err: f.membershipErr}
+}
+func (f *fakeRaft) Stats() map[string]string             { return map[string]string{} }
+func (f *fakeRaft) VerifyLeader() raft.Future            { return fakeFuture{} }
+func (f *fakeRaft) Snapshot() raft.SnapshotFuture        { return fakeFuture{} }
+func (f *fakeRaft) LeadershipTransfer() raft.Future      { return fakeFuture{} }
+func (f *fakeRaft) RegisterObserver(or *raft.Observer)   {}
+func (f *fakeRaft) DeregisterObserver(or *raft.Observer) {}
+func (f *fakeRaft) Restore(meta *raft.SnapshotMeta, reader io.Reader, timeout time.Duration) error {
+	return f.fsm.Restore(io.NopCloser(reader))
+}
+
+// fakeFSM keeps the latest value of each key for PUT, GET and DEL payloads
+type fakeFSM struct {
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func (f *fakeFSM) Apply(log *raft.Log) interface{} {
+	var payload fsm.Payload
+	if err := json.Unmarshal(log.Data, &payload); err != nil {
+		return &fsm.ApplyResponse{Error: err}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch payload.OP {
+	case fsm.PUT:
+		f.data[payload.Key] = fmt.Sprint(payload.Value)
+		return &fsm.ApplyResponse{}
+	case fsm.GET:
+		value, ok := f.data[payload.Key]
+		if !ok {
+			return &fsm.ApplyResponse{Error: fsm.ErrKeyNotFound}
+		}
+		return &fsm.ApplyResponse{Data: fsm.GetResult{Value: value, Version: 1, OldestVersion: 1, LatestVersion: 1}}
+	case fsm.DEL:
+		if _, ok := f.data[payload.Key]; !ok {
+			return &fsm.ApplyResponse{Error: fsm.ErrKeyNotFound}
+		}
+		delete(f.data, payload.Key)
+		return &fsm.ApplyResponse{}
+	}
+	return &fsm.ApplyResponse{Error: fmt.Errorf("unknown operation %s", payload.OP)}
+}
+
+func (f *fakeFSM) Snapshot() (raft.FSMSnapshot, error) {
+	return nil, errors.New("snapshots are not supported by the fake")
+}
+
+func (f *fakeFSM) Restore(snapshot io.ReadCloser) error {
+	return errors.New("restore is not supported by the fake")
+}
+
+// newTestServer returns a Server over a fake raft node, the leader or a
+// follower with no leader, whose FSM holds the key "stored"
+func newTestServer(leader bool) *Server {
+	store := &fakeFSM{data: map[string]string{"stored": "v"}}
+	node := &fakeRaft{fsm: store, state: raft.Follower}
+	if leader {
+		node.state = raft.Leader
+	}
+	return New(node, store, raft.Server{ID: "n1", Address: "127.0.0.1:1"}, Config{
+		ApplyTimeout: 50 * time.Millisecond,
+		ReadMode:     ReadModeLog,
+	})
+}
+
+// handlerTest is one request to a handler and the response it should get
+type handlerTest struct {
+	name        string
+	leader      bool
+	method      string
+	target      string
+	contentType string
+	body        string
+	wantStatus  int
+	wantCode    string // empty for a successful response
+}
+
+func runHandlerTests(t *testing.T, handler func(*Server) http.HandlerFunc, tests []handlerTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			handler(newTestServer(tt.leader))(rec, req)
+
+			var resp api.APIResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("response %q is not JSON: %v", rec.Body.String(), err)
+			}
+			if rec.Code != tt.wantStatus || resp.Code != tt.wantCode {
+				t.Errorf("got %d %q, want %d %q; body %s", rec.Code, resp.Code, tt.wantStatus, tt.wantCode, rec.Body.String())
+			}
+			if resp.Success != (tt.wantCode == "") {
+				t.Errorf("success = %v with code %q", resp.Success, resp.Code)
+			}
+		})
+	}
+}
+
+func TestPutHandler(t *testing.T) {
+	runHandlerTests(t, func(s *Server) http.HandlerFunc { return s.PutHandler }, []handlerTest{
+		{"json", true, http.MethodPost, "/put", "application/json", `{"key":"k","val":"v"}`, http.StatusOK, ""},
+		{"form", true, http.MethodPost, "/put", "application/x-www-form-urlencoded", "key=k&val=v", http.StatusOK, ""},
+		{"missing key", true, http.MethodPost, "/put", "application/json", `{"val":"v"}`, http.StatusBadRequest, api.CodeInvalidRequest},
+		{"wrong content type", true, http.MethodPost, "/put", "text/plain", `{"key":"k","val":"v"}`, http.StatusBadRequest, api.CodeInvalidRequest},
+		{"invalid json", true, http.MethodPost, "/put", "application/json", `{"key":`, http.StatusBadRequest, api.CodeInvalidJSON},
+		{"not leader", false, http.MethodPost, "/put", "application/json", `{"key":"k","val":"v"}`, http.StatusMisdirectedRequest, api.CodeNotLeader},
+		{"sequence namespace", true, http.MethodPost, "/put", "application/json", `{"namespace":"_seq","key":"k","val":"1"}`, http.StatusBadRequest, api.CodeInvalidRequest},
+		{"slash in a bare key", true, http.MethodPost, "/put", "application/json", `{"key":"team/x","val":"v"}`, http.StatusBadRequest, api.CodeInvalidRequest},
+		{"slash in a namespaced key", true, http.MethodPost, "/put", "application/json", `{"namespace":"team","key":"x/y","val":"v"}`, http.StatusOK, ""},
+	})
+}
+
+func TestGetHandler(t *testing.T) {
+	runHandlerTests(t, func(s *Server) http.HandlerFunc { return s.GetHandler }, []handlerTest{
+		{"query", true, http.MethodGet, "/get?key=stored", "", "", http.StatusOK, ""},
+		{"json", true, http.MethodPost, "/get", "application/json", `{"key":"stored"}`, http.StatusOK, ""},
+		{"key not found", true, http.MethodGet, "/get?key=absent", "", "", http.StatusNotFound, api.CodeKeyNotFound},
+		{"missing key", true, http.MethodGet, "/get", "", "", http.StatusBadRequest, api.CodeInvalidRequest},
+		{"wrong content type", true, http.MethodPost, "/get", "text/plain", `{"key":"stored"}`, http.StatusBadRequest, api.CodeInvalidRequest},
+		{"invalid json", true, http.MethodPost, "/get", "application/json", `{"key":`, http.StatusBadRequest, api.CodeInvalidJSON},
+		{"slash in a bare key", true, http.MethodGet, "/get?key=team/x", "", "", http.StatusBadRequest, api.CodeInvalidRequest},
+		// A follower forwards reads to the leader, and has none to forward to
+		{"not leader", false, http.MethodGet, "/get?key=stored", "", "", http.StatusServiceUnavailable, api.CodeNoLeader},
+	})
+}
+
+func TestDeleteHandler(t *testing.T) {
+	runHandlerTests(t, func(s *Server) http.HandlerFunc { return s.DeleteHandler }, []handlerTest{
+		{"json", true, http.MethodDelete, "/delete", "application/json", `{"key":"stored"}`, http.StatusOK, ""},
+		{"query", true, http.MethodDelete, "/delete?key=stored", "", "", http.StatusOK, ""},
+		{"key not found", true, http.MethodDelete, "/delete", "application/json", `{"key":"absent"}`, http.StatusNotFound, api.CodeKeyNotFound},
+		{"missing key", true, http.MethodDelete, "/delete", "application/json", `{}`, http.StatusBadRequest, api.CodeInvalidRequest},
+		{"wrong content type", true, http.MethodDelete, "/delete", "text/plain", `{"key":"stored"}`, http.StatusBadRequest, api.CodeInvalidRequest},
+		{"invalid json", true, http.MethodDelete, "/delete", "application/json", `{"key":`, http.StatusBadRequest, api.CodeInvalidJSON},
+		{"not leader", false, http.MethodDelete, "/delete", "application/json", `{"key":"stored"}`, http.StatusMisdirectedRequest, api.CodeNotLeader},
+		{"sequence namespace", true, http.MethodDelete, "/delete?namespace=_seq&key=k", "", "", http.StatusBadRequest, api.CodeInvalidRequest},
+	})
+}
+
+func TestRaftMembershipHandlers(t *testing.T) {
+	runHandlerTests(t, func(s *Server) http.HandlerFunc { return s.RaftJoin }, []handlerTest{
+		{"join", true, http.MethodPost, "/raft/join", "application/x-www-form-urlencoded", "nodeid=n2&addr=127.0.0.1:2", http.StatusOK, ""},
+		{"join on a follower", false, http.MethodPost, "/raft/join", "application/x-www-form-urlencoded", "nodeid=n2&addr=127.0.0.1:2", http.StatusMisdirectedRequest, api.CodeNotLeader},
+	})
+	runHandlerTests(t, func(s *Server) http.HandlerFunc { return s.RaftLeave }, []handlerTest{
+		{"leave on a follower", false, http.MethodPost, "/raft/leave", "application/x-www-form-urlencoded", "nodeid=n2", http.StatusMisdirectedRequest, api.CodeNotLeader},
+	})
+
+	// A failed change is reported like a failed apply
+	for _, tt := range []struct {
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{raft.ErrNotLeader, http.StatusMisdirectedRequest, api.CodeNotLeader},
+		{raft.ErrLeadershipLost, http.StatusServiceUnavailable, api.CodeLeadershipLost},
+		{errors.New("disk full"), http.StatusInternalServerError, api.CodeMembershipError},
+	} {
+		s := newTestServer(true)
+		s.raft.(*fakeRaft).membershipErr = tt.err
+		rec := httptest.NewRecorder()
+		s.RaftJoin(rec, httptest.NewRequest(http.MethodPost, "/raft/join?nodeid=n2&addr=127.0.0.1:2", nil))
+		var resp api.APIResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != tt.wantStatus || resp.Code != tt.wantCode {
+			t.Errorf("join failing with %v answered %d %q, want %d %q", tt.err, rec.Code, resp.Code, tt.wantStatus, tt.wantCode)
+		}
+	}
+}
+
+func TestStrictRouting(t *testing.T) {
+	servers := map[int]*Server{}
+	for _, group := range []int{1, 2} {
+		store := &fakeFSM{data: map[string]string{}}
+		servers[group] = New(&fakeRaft{fsm: store, state: raft.Leader}, store, raft.Server{ID: "n1", Address: "127.0.0.1:18011"}, Config{
+			ApplyTimeout:  50 * time.Millisecond,
+			ReadMode:      ReadModeLog,
+			Group:         group,
+			StrictRouting: true,
+		})
+	}
+	groupLocator := &locator{ring: []int{1, 2}, servers: servers}
+	for _, s := range servers {
+		s.locator = groupLocator
+	}
+
+	// Find a key each shard owns
+	owned := map[int]string{}
+	for i := 0; len(owned) < 2; i++ {
+		key := fmt.Sprintf("k%d", i)
+		owned[api.OwningShard("", key, groupLocator.ring)] = key
+	}
+
+	for _, tt := range []struct {
+		name    string
+		handler func(*Server) http.HandlerFunc
+		target  string
+	}{
+		{"put", func(s *Server) http.HandlerFunc { return s.PutHandler }, "/put?val=v&key="},
+		{"get", func(s *Server) http.HandlerFunc { return s.GetHandler }, "/get?key="},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler(servers[1])(rec, httptest.NewRequest(http.MethodPost, tt.target+owned[2], nil))
+			var resp struct {
+				Code string       `json:"code"`
+				Data api.Location `json:"data"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("response %q is not JSON: %v", rec.Body.String(), err)
+			}
+			if rec.Code != http.StatusMisdirectedRequest || resp.Code != api.CodeMisdirectedKey || resp.Data.Shard != 2 || resp.Data.Leader == "" {
+				t.Errorf("a key of shard 2 sent to shard 1 got %d %s", rec.Code, rec.Body.String())
+			}
+
+			// The owner serves it; without strict routing any shard does
+			servers[1].config.StrictRouting = false
+			defer func() { servers[1].config.StrictRouting = true }()
+			for _, s := range []*Server{servers[2], servers[1]} {
+				s.PutHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/put?val=v&key="+owned[2], nil))
+				rec := httptest.NewRecorder()
+				tt.handler(s)(rec, httptest.NewRequest(http.MethodPost, tt.target+owned[2], nil))
+				if rec.Code != http.StatusOK {
+					t.Errorf("shard %d answered %d: %s", s.config.Group, rec.Code, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestValueLimitCoversEveryPut(t *testing.T) {
+	// Batches need the real state machine to report a result per operation
+	s := newScanServer()
+	s.config.MaxValueBytes = 4
+	s.config.MaxBatchOps = 10
+	s.config.MaxBatchBytes = 1 << 20
+	s.config.ImportBatchSize = 10
+
+	for _, tt := range []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		body    string
+		want    int
+	}{
+		{"batch at the limit", s.BatchHandler, "/batch", `{"ops":[{"op":"put","key":"a","val":"1234"}]}`, http.StatusOK},
+		{"batch over the limit", s.BatchHandler, "/batch", `{"ops":[{"op":"delete","key":"a"},{"op":"put","key":"b","val":"12345"}]}`, http.StatusRequestEntityTooLarge},
+		{"import at the limit", s.ImportHandler, "/import", `{"key":"a","val":"1234"}`, http.StatusOK},
+		{"import over the limit", s.ImportHandler, "/import", "{\"key\":\"a\",\"val\":\"1\"}\n{\"key\":\"b\",\"val\":\"12345\"}", http.StatusRequestEntityTooLarge},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			tt.handler(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("got %d, want %d; body %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.want != http.StatusOK && !strings.Contains(rec.Body.String(), api.CodeTooLarge) {
+				t.Errorf("body %s has no %s", rec.Body.String(), api.CodeTooLarge)
+			}
+		})
+	}
+}
+
+func TestWriteQuorumForgetsOldFailures(t *testing.T) {
+	s := newTestServer(true)
+	s.config.CheckWriteQuorum = true
+	s.raft.(*fakeRaft).configuration = raft.Configuration{Servers: []raft.Server{
+		{ID: "n1", Suffrage: raft.Voter}, {ID: "n2", Suffrage: raft.Voter}, {ID: "n3", Suffrage: raft.Voter},
+	}}
+
+	now := time.Now()
+	for _, tt := range []struct {
+		name     string
+		failedAt map[raft.ServerID]time.Time
+		refused  bool
+	}{
+		{"all reachable", nil, false},
+		{"one failing", map[raft.ServerID]time.Time{"n2": now}, false},
+		{"two failing", map[raft.ServerID]time.Time{"n2": now, "n3": now}, true},
+		// A failure no longer reported is one whose resumed observation was lost
+		{"one failure expired", map[raft.ServerID]time.Time{"n2": now, "n3": now.Add(-voterContactWindow)}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s.voterContact.unreachable = map[raft.ServerID]time.Time{}
+			for id, at := range tt.failedAt {
+				s.voterContact.unreachable[id] = at
+			}
+			if err := s.checkWriteQuorum(); errors.Is(err, errNoQuorum) != tt.refused {
+				t.Errorf("checkWriteQuorum = %v, refused want %v", err, tt.refused)
+			}
+		})
+	}
+}
+
+func TestReadsHonourSessionToken(t *testing.T) {
+	s := newScanServer()
+	s.fsm.(*fsm.FSM).Put("doc", `{"a":1}`)
+	applied := encodeSessionToken(s.raft.AppliedIndex())
+	ahead := encodeSessionToken(s.raft.AppliedIndex() + 1000)
+
+	for _, tt := range []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+	}{
+		{"mget", s.MultiGetHandler, "/mget?keys=doc"},
+		{"getfield", s.GetFieldHandler, "/getfield?key=doc&field=a"},
+		{"txget", s.TxGetHandler, "/txget?keys=doc"},
+		{"scan", s.ScanHandler, "/scan"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, c := range []struct {
+				token string
+				want  int
+			}{
+				{applied, http.StatusOK},
+				{ahead, http.StatusServiceUnavailable},
+				{"not a token", http.StatusBadRequest},
+			} {
+				// A short deadline stands in for maxIndexWait
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				req := httptest.NewRequestWithContext(ctx, http.MethodGet, tt.target, nil)
+				req.Header.Set(sessionHeader, c.token)
+				rec := httptest.NewRecorder()
+				tt.handler(rec, req)
+				cancel()
+				if rec.Code != c.want {
+					t.Errorf("token %q: got %d, want %d; body %s", c.token, rec.Code, c.want, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestBatchRejectsUnknownOpsBeforeProposing(t *testing.T) {
+	s := newScanServer()
+	s.config.MaxBatchOps = 10
+	s.config.MaxBatchBytes = 1 << 20
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(`{"ops":[{"op":"put","key":"a","val":"1"},{"op":"append","key":"a","val":"2"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	s.BatchHandler(rec, req)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), api.CodeInvalidRequest) {
+		t.Errorf("batch with an unknown op answered %d: %s", rec.Code, rec.Body.String())
+	}
+	if index := s.raft.AppliedIndex(); index != 0 {
+		t.Errorf("the batch was proposed, applied index %d", index)
+	}
+}
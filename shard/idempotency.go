@@ -0,0 +1,39 @@
+// KV-Raft: Idempotency keys for safely retried writes
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"net/http"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+const (
+	// idempotencyHeader carries a client-chosen key identifying one logical write
+	idempotencyHeader = "Idempotency-Key"
+	// replayedHeader is set on responses recorded for an earlier request with the same key
+	replayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 256
+)
+
+// idempotencyKey returns the request's Idempotency-Key header, writing a 400
+// and returning false when it is too long
+func idempotencyKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	key := r.Header.Get(idempotencyHeader)
+	if len(key) > maxIdempotencyKeyLength {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Idempotency-Key must be at most 256 characters")
+		return "", false
+	}
+	return key, true
+}
+
+// markReplayed flags the response as a replay when the FSM did not apply the write again
+func markReplayed(w http.ResponseWriter, applyResponse *fsm.ApplyResponse) {
+	if applyResponse.Replayed {
+		w.Header().Set(replayedHeader, "true")
+	}
+}
@@ -0,0 +1,223 @@
+// KV-Raft: Streaming bulk import of newline-delimited JSON
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+const (
+	// importProgressInterval is how often progress is reported while importing
+	importProgressInterval = 2 * time.Second
+	// importIdleTimeout bounds the wait for the next batch of lines; the
+	// server's read and write timeouts are extended by it after every batch
+	importIdleTimeout = time.Minute
+)
+
+// importProgress is written to the client as a line of its own while the import runs
+type importProgress struct {
+	Imported int `json:"imported"`
+	Failed   int `json:"failed"`
+}
+
+// ImportHandler reads newline-delimited JSON objects shaped like a PUT
+// request ({"key": ..., "val": ..., "namespace": ...}) from the request body
+// and applies them in batches of -import_batch_size. Only one batch is read
+// ahead: each is a single raft entry whose commit is awaited before more of
+// the body is read, so the import never outruns replication and memory stays
+// bounded whatever the size of the body.
+//
+// The response is newline-delimited JSON too: a progress line every few
+// seconds and a final APIResponse. Errors found before any progress was
+// written get a regular error status; later ones end the stream with an
+// unsuccessful APIResponse.
+func (s *Server) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	// The body cannot be buffered for forwarding, so the client must send it to the leader
+	if s.raft.State() != raft.Leader {
+		leaderAddr, _ := s.raft.LeaderWithID()
+		writeJSONError(w, http.StatusMisdirectedRequest, api.CodeNotLeader, fmt.Sprintf("Imports must be sent to the leader at %s", s.peerURL(leaderAddr)))
+		return
+	}
+
+	controller := http.NewResponseController(w)
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), s.config.MaxBatchBytes)
+
+	var (
+		batch      []fsm.BatchOp
+		batchBytes int
+		progress   importProgress
+		streaming  bool
+		lastReport = time.Now()
+		line       int
+	)
+
+	fail := func(status int, code, msg string) {
+		log.Printf("[HTTP-IMPORT] stopped after %d keys: %s", progress.Imported, msg)
+		if !streaming {
+			writeJSONError(w, status, code, msg)
+			return
+		}
+		json.NewEncoder(w).Encode(APIResponse{Success: false, Error: msg, Code: code, Data: progress})
+	}
+
+	flush := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		payload := fsm.Payload{
+			OP:  fsm.BATCH,
+			Ops: batch,
+		}
+		data, err := s.marshalPayload(payload)
+		if err != nil {
+			fail(http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal payload")
+			return false
+		}
+
+		if err := s.checkWriteQuorum(); err != nil {
+			status, code := raftErrorStatus(err)
+			fail(status, code, fmt.Sprintf("Raft apply failed at line %d: %s", line, err.Error()))
+			return false
+		}
+
+		applyFuture := s.timedApply(payload.OP, data)
+		if err := applyFuture.Error(); err != nil {
+			status, code := raftErrorStatus(err)
+			fail(status, code, fmt.Sprintf("Raft apply failed at line %d: %s", line, err.Error()))
+			return false
+		}
+		applyResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
+		if !ok {
+			fail(http.StatusInternalServerError, api.CodeInternalError, "Invalid raft response")
+			return false
+		}
+		failed := 0
+		errs, _ := applyResponse.Data.([]error)
+		for _, err := range errs {
+			if err != nil {
+				failed++
+			}
+		}
+		progress.Imported += len(batch) - failed
+		progress.Failed += failed
+		batch, batchBytes = batch[:0], 0
+
+		// Keep the connection open for as long as batches keep arriving
+		deadline := time.Now().Add(importIdleTimeout)
+		controller.SetReadDeadline(deadline)
+		controller.SetWriteDeadline(deadline)
+
+		if time.Since(lastReport) >= importProgressInterval {
+			if !streaming {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				w.WriteHeader(http.StatusOK)
+				streaming = true
+			}
+			json.NewEncoder(w).Encode(progress)
+			controller.Flush()
+			lastReport = time.Now()
+			log.Printf("[HTTP-IMPORT] %d keys imported, %d failed", progress.Imported, progress.Failed)
+		}
+		return true
+	}
+
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		var req PutRequest
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		if *strictJSON {
+			decoder.DisallowUnknownFields()
+		}
+		err := decoder.Decode(&req)
+		if msg, ok := strictJSONError(decoder, err); !ok {
+			fail(http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("Line %d: %s", line, msg))
+			return
+		}
+		if err != nil {
+			fail(http.StatusBadRequest, api.CodeInvalidJSON, fmt.Sprintf("Line %d is not a JSON object with string fields", line))
+			return
+		}
+		if req.Key == "" || req.Value == "" {
+			fail(http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("Line %d: key and val are required", line))
+			return
+		}
+		if !validNamespace(req.Namespace) {
+			fail(http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("Line %d: namespace must not contain '/'", line))
+			return
+		}
+		if !validKey(req.Namespace, req.Key) {
+			fail(http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("Line %d: %s", line, bareKeyMessage))
+			return
+		}
+		if reservedKey(namespacedKey(req.Namespace, req.Key)) {
+			fail(http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("Line %d: %s", line, reservedKeyMessage))
+			return
+		}
+		if err := validateValueEncoding(s.config.ValueEncoding, req.Value); err != nil {
+			fail(http.StatusBadRequest, api.CodeInvalidValue, fmt.Sprintf("Line %d: %s", line, err.Error()))
+			return
+		}
+		if s.config.MaxValueBytes > 0 && len(req.Value) > s.config.MaxValueBytes {
+			fail(http.StatusRequestEntityTooLarge, api.CodeTooLarge, fmt.Sprintf("Line %d: value is %d bytes, the limit is %d", line, len(req.Value), s.config.MaxValueBytes))
+			return
+		}
+
+		// Flush first if this line would push the entry past -max_batch_bytes
+		if batchBytes+len(raw) > s.config.MaxBatchBytes && !flush() {
+			return
+		}
+		batch = append(batch, fsm.BatchOp{
+			OP:    fsm.PUT,
+			Key:   namespacedKey(req.Namespace, req.Key),
+			Value: req.Value,
+		})
+		batchBytes += len(raw)
+		if len(batch) >= s.config.ImportBatchSize && !flush() {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			fail(http.StatusRequestEntityTooLarge, api.CodeTooLarge, fmt.Sprintf("Line %d is longer than -max_batch_bytes", line+1))
+			return
+		}
+		fail(http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("Failed to read the body after line %d: %s", line, err.Error()))
+		return
+	}
+	if !flush() {
+		return
+	}
+
+	log.Printf("[HTTP-IMPORT] finished: %d keys imported, %d failed", progress.Imported, progress.Failed)
+
+	response := APIResponse{
+		Success: progress.Failed == 0,
+		Message: fmt.Sprintf("Import finished: %d keys imported, %d failed", progress.Imported, progress.Failed),
+		Data:    progress,
+	}
+	if streaming {
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
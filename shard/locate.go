@@ -0,0 +1,102 @@
+// KV-Raft: Locating the shard that owns a key
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"kv-raft/api"
+)
+
+// locator answers /locate for the groups hosted by this process. Every
+// process started with the same -shards list has the same ring, so any node
+// gives the same answer. Without -shards the ring is the single group 0,
+// served at the root.
+type locator struct {
+	ring    []int
+	servers map[int]*Server
+}
+
+func newLocator(groups []*shardGroup) *locator {
+	l := &locator{servers: make(map[int]*Server)}
+	for _, group := range groups {
+		l.ring = append(l.ring, group.id)
+		l.servers[group.id] = group.server.server
+	}
+	return l
+}
+
+func (l *locator) LocateHandler(w http.ResponseWriter, r *http.Request) {
+	var req LocateRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if req.Key == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("key"))
+		return
+	}
+	if !validNamespace(req.Namespace) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Namespace must not contain '/'")
+		return
+	}
+	if !validKey(req.Namespace, req.Key) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, bareKeyMessage)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Key located",
+		Data:    l.locate(req.Namespace, req.Key),
+	})
+}
+
+// locate returns the shard owning key in namespace and its current leader
+func (l *locator) locate(namespace, key string) api.Location {
+	shard := api.OwningShard(namespace, key, l.ring)
+	location := api.Location{
+		Key:   key,
+		Hash:  fmt.Sprintf("%016x", api.KeyHash(namespace, key)),
+		Ring:  l.ring,
+		Shard: shard,
+	}
+	if shard != 0 {
+		location.Path = groupPrefix(shard)
+	}
+
+	s := l.servers[shard]
+	if leaderAddr, _ := s.raft.LeaderWithID(); leaderAddr != "" {
+		location.Leader = s.peerURL(leaderAddr)
+	}
+	return location
+}
+
+// misdirected answers 421 MISDIRECTED_KEY with the owner's location when
+// -strict_routing is set and another shard owns the key, so that a client on
+// a stale ring does not leave the key on two shards. It reports whether it
+// answered.
+func (s *Server) misdirected(w http.ResponseWriter, namespace, key string) bool {
+	if !s.config.StrictRouting || s.locator == nil {
+		return false
+	}
+	location := s.locator.locate(namespace, key)
+	if location.Shard == s.config.Group {
+		return false
+	}
+
+	message := fmt.Sprintf("Key is owned by shard %d", location.Shard)
+	if location.Leader != "" {
+		message += ", whose leader is at " + location.Leader
+	}
+	writeJSONResponse(w, http.StatusMisdirectedRequest, APIResponse{
+		Success: false,
+		Error:   message,
+		Code:    api.CodeMisdirectedKey,
+		Data:    location,
+	})
+	return true
+}
@@ -0,0 +1,150 @@
+// KV-Raft: HTTP handlers for distributed locks
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+const defaultLockTTL = 30 * time.Second
+
+func (s *Server) LockAcquireHandler(w http.ResponseWriter, r *http.Request) {
+	s.lockHandler(w, r, fsm.LOCK_ACQUIRE)
+}
+
+func (s *Server) LockRenewHandler(w http.ResponseWriter, r *http.Request) {
+	s.lockHandler(w, r, fsm.LOCK_RENEW)
+}
+
+func (s *Server) LockReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	s.lockHandler(w, r, fsm.LOCK_RELEASE)
+}
+
+// lockHandler applies a lock operation for the key, owner and ttl of the request
+func (s *Server) lockHandler(w http.ResponseWriter, r *http.Request, op string) {
+	var req LockRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	key, owner := req.Key, req.Owner
+	if key == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("key"))
+		return
+	}
+	if owner == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("owner"))
+		return
+	}
+
+	ttl := defaultLockTTL
+	if req.TTL != "" {
+		parsed, err := time.ParseDuration(req.TTL)
+		if err != nil || parsed <= 0 {
+			writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "TTL must be a positive duration such as 30s")
+			return
+		}
+		ttl = parsed
+	}
+
+	idemKey, ok := idempotencyKey(w, r)
+	if !ok {
+		return
+	}
+
+	// Followers cannot apply through raft, so forward the operation to the leader
+	if s.raft.State() != raft.Leader {
+		query := url.Values{}
+		query.Set("key", key)
+		query.Set("owner", owner)
+		query.Set("ttl", ttl.String())
+		s.forwardToLeader(w, r, http.MethodPost, r.URL.Path+"?"+query.Encode(), nil)
+		return
+	}
+
+	payload := fsm.Payload{
+		OP:             op,
+		Key:            key,
+		Owner:          owner,
+		TTL:            ttl,
+		IdempotencyKey: idemKey,
+	}
+
+	data, err := s.marshalPayload(payload)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal payload")
+		return
+	}
+
+	if err := s.checkWriteQuorum(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+
+	applyFuture := s.timedApply(payload.OP, data)
+	if err := applyFuture.Error(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+	setSessionToken(w, applyFuture.Index())
+
+	applyResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Invalid raft response")
+		return
+	}
+	markReplayed(w, applyResponse)
+
+	lock, _ := applyResponse.Data.(fsm.LockState)
+	if applyResponse.Error != nil {
+		if errors.Is(applyResponse.Error, fsm.ErrLockHeld) {
+			response := APIResponse{
+				Success: false,
+				Error:   "Lock is held by " + lock.Owner,
+				Code:    api.CodeLocked,
+				Data: map[string]interface{}{
+					"key":        key,
+					"owner":      lock.Owner,
+					"expires_at": lock.ExpiresAt,
+				},
+			}
+			writeJSONResponse(w, http.StatusLocked, response)
+			return
+		}
+		status, code := applyErrorStatus(applyResponse.Error)
+		writeJSONError(w, status, code, "Lock operation failed: "+applyResponse.Error.Error())
+		return
+	}
+
+	log.Printf("[HTTP-LOCK] %s of key %s by %s", op, key, owner)
+
+	message := "Lock acquired successfully"
+	switch op {
+	case fsm.LOCK_RENEW:
+		message = "Lock renewed successfully"
+	case fsm.LOCK_RELEASE:
+		message = "Lock released successfully"
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: message,
+		Data: map[string]interface{}{
+			"key":        key,
+			"owner":      lock.Owner,
+			"expires_at": lock.ExpiresAt,
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
@@ -5,31 +5,47 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/raft"
-	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"kv-raft/api"
 	"kv-raft/fsm"
 )
 
 // UnifiedServer combines data server and config server functionality
 type UnifiedServer struct {
-	raft     *raft.Raft
+	raft     raftNode
 	server   *Server
 	fsm      raft.FSM
 	shardID  int
+	shardsMu    sync.Mutex     // guards knownShards and shardEpochs
 	knownShards map[int]string // shardID -> leader address mapping
+	shardEpochs map[int]uint64 // shardID -> epoch its address was set at, see ShardMap
+	broadcaster *broadcaster
+	mux         *http.ServeMux // this server's endpoints, relative to its group's path prefix
+	readMux     *http.ServeMux // the read-only subset of mux, for -read_port
+	locator     *locator       // the groups hosted by this process, for /rebalance
+	rebalancer  *rebalancer
+	rejoining   atomic.Bool    // set while a recovered node replaces its old ID, see StartRejoin
+
+	// ctx is cancelled by Stop to end the background goroutines tracked by wg
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
 const (
@@ -45,17 +61,128 @@ var (
 	shardID  = flag.Int("shard_id", 1, "shard id")
 	storedir = flag.String("store_dir", "", "db dir")
 	peerShards = flag.String("peer_shards", "", "comma-separated list of peer shard addresses for broadcasting (e.g., localhost:8011,localhost:8021)")
+	historyDepth = flag.Int("history_depth", 1, "number of versions kept per key for GET ?version=N (1 keeps only the latest)")
+	bootstrapExpect = flag.Int("bootstrap_expect", 0, "bootstrap the cluster once this many nodes (including this one) from peer_shards are up; 0 bootstraps shard 1 alone")
+	discoverySRV = flag.String("discovery_srv", "", "DNS SRV record (e.g. a Kubernetes headless service) whose targets are the peers' raft addresses; resolved at startup and every discovery_interval to join the cluster and learn shards, in place of peer_shards")
+	discoveryInterval = flag.Duration("discovery_interval", 30*time.Second, "how often -discovery_srv is resolved again")
+	recoverCorrupt = flag.Bool("recover_corrupt", false, "if raft.db is corrupt, move it and the snapshots aside and rejoin through peer_shards or discovery_srv under a new node ID, replacing the old one, instead of exiting")
+	leaveOnShutdown = flag.Bool("leave_on_shutdown", false, "on SIGINT/SIGTERM, remove this node from the raft configuration before exiting")
+	valueEncoding = flag.String("value_encoding", EncodingRaw, "validation applied to PUT values: raw (none), utf8 or json")
+	strictJSON = flag.Bool("strict_json", true, "reject JSON request bodies with unknown fields or trailing content after the first value")
+	readPort = flag.Int("read_port", 0, "optional second HTTP port serving only the read-only endpoints (/get, /getfield, /mget, /txget, /scan, /readyz, /locate, /version); 0 disables it")
+	httpGzip = flag.Bool("http_gzip", false, "gzip responses of at least http_gzip_min_bytes for clients that send Accept-Encoding: gzip")
+	httpGzipMinBytes = flag.Int("http_gzip_min_bytes", 1024, "smallest response body -http_gzip compresses, in bytes")
+	corsOrigins = flag.String("cors_origins", "", "comma-separated origins (e.g. https://dashboard.example.com, or *) whose browsers may call the read and status endpoints; empty disables CORS")
+	hotKeySample = flag.Int("hotkey_sample", 16, "count one in this many key accesses for /hotkeys (1 counts every access, 0 disables counting)")
+	pprofAddr = flag.String("pprof", "", "admin address serving /debug/pprof heap, goroutine and mutex profiles (e.g. localhost:6060); empty disables profiling")
+	readCacheTTL = flag.Int("read_cache_ttl", 0, "serve cached GETs up to this many milliseconds old (0 disables the read cache)")
+	idempotencyKeys = flag.Int("idempotency_keys", 10000, "number of recent Idempotency-Key headers remembered to deduplicate retried writes (0 disables); the leader's value is replicated to every node")
+	alwaysBase64 = flag.Bool("always_b64", false, "return every GET value base64-encoded as val_b64 instead of only values that are not valid UTF-8")
+	readHeaderTimeout = flag.Duration("read_header_timeout", 5*time.Second, "maximum time to read a request's headers")
+	readTimeout = flag.Duration("read_timeout", 15*time.Second, "maximum time to read an entire request, including the body")
+	writeTimeout = flag.Duration("write_timeout", 30*time.Second, "maximum time from the end of the request headers to the end of the response")
+	h2c = flag.Bool("h2c", false, "also accept HTTP/2 without TLS (prior knowledge), and forward to the leader over it; every node must set it")
+	idleTimeout = flag.Duration("idle_timeout", 120*time.Second, "maximum time an idle keep-alive connection is kept open")
+	allowEphemeral = flag.Bool("allow_ephemeral", false, "allow starting without store_dir, keeping all data in a temp dir that is deleted on exit")
+	maxBatchOps = flag.Int("max_batch_ops", 1000, "most operations accepted in one /batch request; larger batches get 413")
+	maxBatchBytes = flag.Int("max_batch_bytes", 1<<20, "largest raft log entry a /batch request may produce, in bytes; larger batches get 413")
+	readyMaxLag = flag.Uint64("ready_max_lag", 100, "most log entries this node may trail the leader's commit index and still report ready on /readyz and serve cached reads")
+	importBatchSize = flag.Int("import_batch_size", 500, "most keys /import applies in one raft log entry; the next batch is read only once the previous one commits")
+	maxValueBytes = flag.Int("max_value_bytes", 0, "largest value a /put may store or an /append may leave, in bytes; larger ones get 413 (0 disables the limit)")
+	readMode = flag.String("read_mode", ReadModeLinearizable, "how GET reads: linearizable (read index on the leader), log (through the raft log on the leader) or local (this node's state, possibly stale on followers)")
+	allowStaleOnNoLeader = flag.Bool("allow_stale_on_no_leader", false, "when no leader is known within apply_timeout, answer GETs from this node's state with an X-KV-Raft-Stale header instead of failing")
+	checkWriteQuorum = flag.Bool("check_write_quorum", false, "on the leader, reject writes with 503 NO_QUORUM while fewer than write_quorum voters answer heartbeats, instead of waiting for the apply to time out")
+	writeQuorum = flag.Int("write_quorum", 0, "fewest reachable voters, the leader included, -check_write_quorum requires for a write; values below a majority of the voters (including the default 0) mean a majority")
+	restoreToken = flag.String("restore_token", "", "enables POST /raft/restore, which replaces the whole cluster state with an uploaded snapshot, for requests carrying this token in X-KV-Raft-Restore-Token; empty disables it")
+	minVoters = flag.Int("min_voters", 1, "fewest voters /raft/leave may leave in the cluster; removals below it are rejected with 409")
+	forwardCacheTTL = flag.Duration("forward_cache_ttl", 0, "how long a follower reuses a GET response it forwarded to the leader; responses may be this stale (0 disables the cache)")
+	rebalanceRate = flag.Int("rebalance_rate", 100, "keys per second a /rebalance moves to their new owners unless it asks for another rate")
+	strictRouting = flag.Bool("strict_routing", false, "with -shards, answer /put and /get for a key another shard owns with 421 MISDIRECTED_KEY and the owner's address instead of serving it")
+	stateHistorySize = flag.Int("state_history", 256, "raft state and leader changes kept for /raft/history (0 disables it)")
+	commitSLA = flag.Duration("commit_sla", 0, "log a warning and set kvraft_commit_sla_breached when the mean commit latency of the last 100 applies goes above this (0 disables the alarm)")
+	applyTimeout = flag.Duration("apply_timeout", 500*time.Millisecond, "how long a raft apply may wait to be enqueued; reads retry through a leader election for at most this long")
+	storeImpl = flag.String("store_impl", fsm.StoreSyncMap, "map holding the keys in memory: syncmap (one sync.Map) or sharded (64 maps with their own locks, for many concurrent reads and writes)")
+	valueCompression = flag.Bool("value_compression", false, "store values of at least value_compression_min_bytes snappy-compressed in memory and in snapshots, decompressing them on read")
+	maxRequestBytes = flag.Int64("max_request_bytes", 8<<20, "largest request body read by any endpoint but the streamed /import and /raft/restore, in bytes; larger ones get 413 (0 disables the limit)")
+	valueCompressionMinBytes = flag.Int("value_compression_min_bytes", 1024, "smallest value -value_compression compresses, in bytes")
+	maxKeys = flag.Int("max_keys", 0, "most keys stored; writing a new key past the cap evicts the least recently used one, in raft log order. The leader's cap is replicated to every node (0 proposes none, lifting a cap set by an earlier leader)")
+	snapshotRetain = flag.Int("snapshot_retain", 2, "number of snapshots kept in store_dir, so an older one is left if the newest is corrupt")
+	nodes = flag.String("nodes", "", "with `kv-raft bootstrap`: comma-separated id=raft_addr of every voter, this node included; the first one bootstraps and adds the rest")
+	shards = flag.String("shards", "", "comma-separated shard IDs whose raft groups this process hosts, each served under /shard/{id} with raft on the -raft_addr port + 100*id and state in store_dir/shard-{id}; empty hosts one group at the root")
+	validate = flag.Bool("validate", false, "check the flags, ports and peer connectivity without starting raft, then exit 0 if valid or 1 if not")
 )
 
-func NewUnifiedServer(raft *raft.Raft, fsm raft.FSM, shardID int) *UnifiedServer {
-	server := New(raft, fsm)
-	return &UnifiedServer{
+func NewUnifiedServer(raft raftNode, fsm raft.FSM, shardID int, self raft.Server, config Config) *UnifiedServer {
+	server := New(raft, fsm, self, config)
+	ctx, cancel := context.WithCancel(context.Background())
+	us := &UnifiedServer{
 		raft:        raft,
 		server:      server,
 		fsm:         fsm,
 		shardID:     shardID,
 		knownShards: make(map[int]string),
-	}
+		shardEpochs: make(map[int]uint64),
+		broadcaster: newBroadcaster(),
+		rebalancer:  newRebalancer(),
+		mux:         http.NewServeMux(),
+		readMux:     http.NewServeMux(),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	registerHandlers(us.mux, us)
+	registerReadHandlers(us.readMux, us)
+	return us
+}
+
+// Handler serves this server's endpoints; it is mounted at the root in
+// single-group mode and under /shard/{id} with -shards
+func (us *UnifiedServer) Handler() http.Handler {
+	return us.mux
+}
+
+// ReadHandler serves only this server's read-only endpoints, mounted like Handler
+func (us *UnifiedServer) ReadHandler() http.Handler {
+	return us.readMux
+}
+
+// StartReadinessTracker keeps the /readyz state current until Stop is called
+func (us *UnifiedServer) StartReadinessTracker() {
+	us.wg.Add(1)
+	go func() {
+		defer us.wg.Done()
+		us.server.trackReadiness(us.ctx)
+	}()
+}
+
+func (us *UnifiedServer) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.ReadyHandler(w, r)
+}
+
+// StartVoterContactTracker follows which voters the leader's heartbeats reach,
+// for -check_write_quorum, until Stop is called
+func (us *UnifiedServer) StartVoterContactTracker() {
+	us.wg.Add(1)
+	go func() {
+		defer us.wg.Done()
+		us.server.trackVoterContact(us.ctx)
+	}()
+}
+
+// StartStateHistory records raft state transitions for /raft/history until
+// Stop is called
+func (us *UnifiedServer) StartStateHistory() {
+	us.wg.Add(1)
+	go func() {
+		defer us.wg.Done()
+		us.server.trackStateHistory(us.ctx)
+	}()
+}
+
+// Stop ends the server's background goroutines and waits for them to exit
+func (us *UnifiedServer) Stop() {
+	us.cancel()
+	us.wg.Wait()
+	us.broadcaster.stop()
 }
 
 // Data server handlers (original functionality)
@@ -71,12 +198,92 @@ func (us *UnifiedServer) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	us.server.DeleteHandler(w, r)
 }
 
+func (us *UnifiedServer) DeleteIfHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.DeleteIfHandler(w, r)
+}
+
+func (us *UnifiedServer) MultiGetHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.MultiGetHandler(w, r)
+}
+
+func (us *UnifiedServer) LockAcquireHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.LockAcquireHandler(w, r)
+}
+
+func (us *UnifiedServer) LockRenewHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.LockRenewHandler(w, r)
+}
+
+func (us *UnifiedServer) LockReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.LockReleaseHandler(w, r)
+}
+
+func (us *UnifiedServer) GetFieldHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.GetFieldHandler(w, r)
+}
+
+func (us *UnifiedServer) DeletePrefixHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.DeletePrefixHandler(w, r)
+}
+
+func (us *UnifiedServer) BatchHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.BatchHandler(w, r)
+}
+
+func (us *UnifiedServer) AppendHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.AppendHandler(w, r)
+}
+
+func (us *UnifiedServer) TxGetHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.TxGetHandler(w, r)
+}
+
+func (us *UnifiedServer) ScanHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.ScanHandler(w, r)
+}
+
+func (us *UnifiedServer) NextIDHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.NextIDHandler(w, r)
+}
+
+func (us *UnifiedServer) StageHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.StageHandler(w, r)
+}
+
+func (us *UnifiedServer) CommitStagedHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.CommitStagedHandler(w, r)
+}
+
+func (us *UnifiedServer) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.ImportHandler(w, r)
+}
+
+func (us *UnifiedServer) NamespaceDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.NamespaceDeleteHandler(w, r)
+}
+
 // Config server handlers (merged from manager/main.go)
 func (us *UnifiedServer) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("[HTTP] config is requested")
 	log.Printf("[DEBUG] ConfigHandler called for shard %d", us.shardID)
 
+	var req ConfigRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	// Long-poll: hold the response until the epoch moves past the client's
+	if req.Wait != "" {
+		wait, err := time.ParseDuration(req.Wait)
+		if err != nil || wait <= 0 {
+			WriteJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Wait must be a positive duration such as 30s")
+			return
+		}
+		us.waitForConfigEpoch(r.Context(), w, req.Epoch, wait)
+	}
+
 	// Build shards map by querying the actual Raft cluster configuration
+	epoch := us.server.configurationIndex()
 	allShards := make(map[int]string)
 	
 	// Get the current Raft configuration
@@ -84,8 +291,8 @@ func (us *UnifiedServer) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 	if err := future.Error(); err != nil {
 		log.Printf("Failed to get Raft configuration: %v", err)
 		// Fallback to known shards
-		for shardID, address := range us.knownShards {
-			allShards[shardID] = address
+		for shardID, entry := range us.shardMap().Shards {
+			allShards[shardID] = entry.Address
 		}
 		// Add current shard
 		allShards[us.shardID] = fmt.Sprintf("shard%d:%d", us.shardID, 8000+us.shardID*10+1)
@@ -116,6 +323,7 @@ func (us *UnifiedServer) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 		Data: map[string]interface{}{
 			"shardCount": len(allShards),
 			"shards":     allShards,
+			"epoch":      epoch,
 		},
 	}
 
@@ -124,43 +332,43 @@ func (us *UnifiedServer) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// ShardInfoRequest announces a shard's address to /addshard and /newleader
+type ShardInfoRequest struct {
+	ShardID      string `json:"shardID"`
+	ShardAddress string `json:"shardAddress"`
+}
+
 func (us *UnifiedServer) AddShardHandler(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		ShardID      string `json:"shardID"`
-		ShardAddress string `json:"shardAddress"`
-	}
+	var req ShardInfoRequest
 	
-	// Try to parse JSON body first, fallback to form data
-	if r.Header.Get("Content-Type") == "application/json" {
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			WriteJSONError(w, http.StatusBadRequest, "Invalid JSON format")
-			return
-		}
-	} else {
-		// Fallback to form data for backward compatibility
-		req.ShardID = r.FormValue("shardID")
-		req.ShardAddress = r.FormValue("shardAddress")
+	if !decodeRequest(w, r, &req) {
+		return
 	}
 
-	if req.ShardID == "" || req.ShardAddress == "" {
-		WriteJSONError(w, http.StatusBadRequest, "ShardID and ShardAddress are required")
+	if req.ShardID == "" {
+		WriteJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("shardID"))
+		return
+	}
+	if req.ShardAddress == "" {
+		WriteJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("shardAddress"))
 		return
 	}
 
 	shardIDInt, err := strconv.Atoi(req.ShardID)
 	if err != nil {
-		WriteJSONError(w, http.StatusBadRequest, "Invalid shard ID format")
+		WriteJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid shard ID format")
 		return
 	}
 
 	// Normalize address to use Docker service name for consistency
 	normalizedAddress := normalizeShardAddress(shardIDInt, req.ShardAddress)
 	
-	// Update local knowledge
-	us.knownShards[shardIDInt] = normalizedAddress
-	
-	// Broadcast to other known shards
-	us.broadcastShardInfo(shardIDInt, normalizedAddress)
+	// Update local knowledge and push the map to other known shards. A shard
+	// registering again is usually one that restarted and missed pushes, so
+	// the map goes out even if its address did not change.
+	if !us.setShard(shardIDInt, normalizedAddress) {
+		us.pushShardMap()
+	}
 
 	log.Printf("Added shard %d with address %s", shardIDInt, req.ShardAddress)
 	
@@ -179,31 +387,24 @@ func (us *UnifiedServer) AddShardHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (us *UnifiedServer) NewLeaderHandler(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		ShardID      string `json:"shardID"`
-		ShardAddress string `json:"shardAddress"`
-	}
+	var req ShardInfoRequest
 	
-	// Try to parse JSON body first, fallback to form data
-	if r.Header.Get("Content-Type") == "application/json" {
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			WriteJSONError(w, http.StatusBadRequest, "Invalid JSON format")
-			return
-		}
-	} else {
-		// Fallback to form data for backward compatibility
-		req.ShardID = r.FormValue("shardID")
-		req.ShardAddress = r.FormValue("shardAddress")
+	if !decodeRequest(w, r, &req) {
+		return
 	}
 
-	if req.ShardID == "" || req.ShardAddress == "" {
-		WriteJSONError(w, http.StatusBadRequest, "ShardID and ShardAddress are required")
+	if req.ShardID == "" {
+		WriteJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("shardID"))
+		return
+	}
+	if req.ShardAddress == "" {
+		WriteJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("shardAddress"))
 		return
 	}
 
 	shardIDInt, err := strconv.Atoi(req.ShardID)
 	if err != nil {
-		WriteJSONError(w, http.StatusBadRequest, "Invalid shard ID format")
+		WriteJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid shard ID format")
 		return
 	}
 
@@ -213,11 +414,8 @@ func (us *UnifiedServer) NewLeaderHandler(w http.ResponseWriter, r *http.Request
 	// Normalize address to use Docker service name for consistency
 	normalizedAddress := normalizeShardAddress(shardIDInt, req.ShardAddress)
 	
-	// Update local knowledge
-	us.knownShards[shardIDInt] = normalizedAddress
-	
-	// Broadcast to other known shards
-	us.broadcastShardInfo(shardIDInt, req.ShardAddress)
+	// Update local knowledge and push the map to other known shards
+	us.setShard(shardIDInt, normalizedAddress)
 
 	response := APIResponse{
 		Success: true,
@@ -246,49 +444,106 @@ func (us *UnifiedServer) RaftLeave(w http.ResponseWriter, r *http.Request) {
 	us.server.RaftLeave(w, r)
 }
 
-// broadcastShardInfo sends shard information to all known peer shards
-func (us *UnifiedServer) broadcastShardInfo(shardID int, address string) {
-	for peerShardID, peerAddress := range us.knownShards {
-		if peerShardID == us.shardID {
-			continue // Don't broadcast to self
-		}
-		
-		go func(peerAddr string) {
-			url := fmt.Sprintf("http://%s/newleader", peerAddr)
-			data := fmt.Sprintf("shardID=%d&shardAddress=%s", shardID, address)
-			
-			resp, err := http.Post(url, "application/x-www-form-urlencoded", 
-				strings.NewReader(data))
-			if err != nil {
-				log.Printf("Failed to broadcast to %s: %v", peerAddr, err)
-				return
-			}
-			defer resp.Body.Close()
-		}(peerAddress)
-	}
+func (us *UnifiedServer) RaftPeers(w http.ResponseWriter, r *http.Request) {
+	us.server.RaftPeers(w, r)
+}
+
+func (us *UnifiedServer) RaftLeader(w http.ResponseWriter, r *http.Request) {
+	us.server.RaftLeader(w, r)
+}
+
+func (us *UnifiedServer) RaftHistory(w http.ResponseWriter, r *http.Request) {
+	us.server.RaftHistory(w, r)
+}
+
+func (us *UnifiedServer) RaftVerify(w http.ResponseWriter, r *http.Request) {
+	us.server.RaftVerify(w, r)
+}
+
+func (us *UnifiedServer) RaftSnapshot(w http.ResponseWriter, r *http.Request) {
+	us.server.RaftSnapshot(w, r)
+}
+
+func (us *UnifiedServer) RaftRestore(w http.ResponseWriter, r *http.Request) {
+	us.server.RaftRestore(w, r)
 }
 
+func (us *UnifiedServer) HotKeysHandler(w http.ResponseWriter, r *http.Request) {
+	us.server.HotKeysHandler(w, r)
+}
+
+// After winning an election the shard map is pushed again leaderRebroadcasts
+// times, first after leaderRebroadcastDelay and then at doubling intervals
+const (
+	leaderRebroadcasts     = 4
+	leaderRebroadcastDelay = time.Second
+)
+
 // LeaderObserver monitors leadership changes and broadcasts to peer shards
+// until Stop is called
 func (us *UnifiedServer) LeaderObserver() {
+	observations := make(chan raft.Observation, 16)
+	observer := raft.NewObserver(observations, false, func(o *raft.Observation) bool {
+		switch o.Data.(type) {
+		case raft.LeaderObservation, raft.RaftState:
+			return true
+		}
+		return false
+	})
+	us.raft.RegisterObserver(observer)
+
+	us.wg.Add(1)
 	go func() {
-		lastAddress := us.raft.Leader()
+		defer us.wg.Done()
+		defer us.raft.DeregisterObserver(observer)
+
+		// Winning an election produces both a state and a leader observation;
+		// broadcast on whichever arrives first and only once per term as leader
+		leading := us.raft.State() == raft.Leader
+
+		// Peers still mid-election can miss the first broadcast, so it is
+		// repeated at doubling intervals while this node keeps leading
+		var rebroadcast <-chan time.Time
+		var rebroadcastDelay time.Duration
+		rebroadcastsLeft := 0
 		for {
-			currentAddress := us.raft.Leader()
-			if currentAddress != lastAddress {
-				lastAddress = currentAddress
+			select {
+			case <-us.ctx.Done():
+				return
+			case <-rebroadcast:
+				rebroadcast = nil
+				if !leading {
+					continue
+				}
+				us.pushShardMap()
+				if rebroadcastsLeft--; rebroadcastsLeft > 0 {
+					rebroadcastDelay *= 2
+					rebroadcast = time.After(rebroadcastDelay)
+				}
+			case o := <-observations:
+				wasLeading := leading
+				switch data := o.Data.(type) {
+				case raft.RaftState:
+					leading = data == raft.Leader
+				case raft.LeaderObservation:
+					leading = data.LeaderID == us.server.self.ID
+				}
 
-				// Check if this node is the leader
-				if us.raft.State() == raft.Leader {
+				// Check if this node has just become the leader
+				if leading && !wasLeading {
 					log.Printf("Became leader for shard %d, broadcasting to peers", us.shardID)
-					
+
 					// Use Docker service name instead of IP address for consistency
 					httpAddress := fmt.Sprintf("shard%d:%d", us.shardID, 8000+us.shardID*10+1)
-					
-					// Broadcast to all known shards
-					us.broadcastShardInfo(us.shardID, httpAddress)
+
+					// Push the updated map to all known shards
+					us.setShard(us.shardID, httpAddress)
+
+					rebroadcastDelay = leaderRebroadcastDelay
+					rebroadcastsLeft = leaderRebroadcasts
+					rebroadcast = time.After(rebroadcastDelay)
 				}
 			}
-			time.Sleep(1 * time.Second)
 		}
 	}()
 }
@@ -322,7 +577,9 @@ func (us *UnifiedServer) initializePeerShards(peerShardsStr string) {
 			// Extract shard ID from the address format (e.g., shard2:8021 -> shard ID 2)
 			peerShardID := extractShardIDFromAddress(peer)
 			if peerShardID > 0 && peerShardID != us.shardID {
+				us.shardsMu.Lock()
 				us.knownShards[peerShardID] = peer
+				us.shardsMu.Unlock()
 				log.Printf("Added peer shard %d at %s", peerShardID, peer)
 			}
 		}
@@ -381,12 +638,101 @@ func normalizeShardAddress(shardID int, address string) string {
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	// `kv-raft proxy ...` runs the proxy front door instead of a shard
+	if len(os.Args) > 1 && os.Args[1] == "proxy" {
+		runProxy(os.Args[2:])
+		return
+	}
+
+	// `kv-raft bootstrap -nodes ...` runs this shard and forms the cluster from the listed nodes
+	bootstrapMode := len(os.Args) > 1 && os.Args[1] == "bootstrap"
+	if bootstrapMode {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 	flag.Parse()
 
+	var bootstrapNodes []raft.Server
+	if bootstrapMode {
+		var err error
+		if bootstrapNodes, err = parseBootstrapNodes(*nodes); err != nil {
+			log.Fatalf("Invalid -nodes: %v", err)
+		}
+		applyBootstrapSelf(bootstrapNodes)
+	}
+
+	build := buildInfo()
+	log.Printf("KV-Raft version %s (commit %s, built %s, %s)", build.Version, build.Commit, build.BuildDate, build.GoVersion)
+
+	if *validate {
+		if !validateConfig() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	switch *valueEncoding {
+	case EncodingRaw, EncodingUTF8, EncodingJSON:
+	default:
+		log.Fatalf("Invalid value_encoding %q: must be raw, utf8 or json", *valueEncoding)
+	}
+	switch *readMode {
+	case ReadModeLinearizable, ReadModeLog, ReadModeLocal:
+	default:
+		log.Fatalf("Invalid read_mode %q: must be linearizable, log or local", *readMode)
+	}
+	if *minVoters < 1 {
+		log.Fatalf("Invalid min_voters %d: must be at least 1", *minVoters)
+	}
+	if *hotKeySample < 0 {
+		log.Fatalf("Invalid hotkey_sample %d: must not be negative", *hotKeySample)
+	}
+	if *httpGzipMinBytes < 0 {
+		log.Fatalf("Invalid http_gzip_min_bytes %d: must not be negative", *httpGzipMinBytes)
+	}
+	if err := fsm.ValidateStoreImpl(*storeImpl); err != nil {
+		log.Fatalf("Invalid store_impl %q: %v", *storeImpl, err)
+	}
+	if *maxRequestBytes < 0 {
+		log.Fatalf("Invalid max_request_bytes %d: must not be negative", *maxRequestBytes)
+	}
+	if *valueCompressionMinBytes < 1 {
+		log.Fatalf("Invalid value_compression_min_bytes %d: must be at least 1", *valueCompressionMinBytes)
+	}
+	if *rebalanceRate < 1 || *rebalanceRate > maxRebalanceRate {
+		log.Fatalf("Invalid rebalance_rate %d: must be between 1 and %d", *rebalanceRate, maxRebalanceRate)
+	}
+	if *stateHistorySize < 0 {
+		log.Fatalf("Invalid state_history %d: must not be negative", *stateHistorySize)
+	}
+	if *commitSLA < 0 {
+		log.Fatalf("Invalid commit_sla %s: must not be negative", *commitSLA)
+	}
+	if *writeQuorum < 0 {
+		log.Fatalf("Invalid write_quorum %d: must not be negative", *writeQuorum)
+	}
+	if *discoveryInterval <= 0 {
+		log.Fatalf("Invalid discovery_interval %s: must be positive", *discoveryInterval)
+	}
+	allowedOrigins, err := parseCORSOrigins(*corsOrigins)
+	if err != nil {
+		log.Fatalf("Invalid cors_origins: %v", err)
+	}
+	if *readPort != 0 && *readPort == *port {
+		log.Fatalf("Invalid read_port %d: must differ from port", *readPort)
+	}
+
 	dir := *storedir
 	if dir != "" {
 		log.Println("Using existing store_dir: ", dir)
 	} else {
+		if !*allowEphemeral {
+			log.Fatalln("No -store_dir given: refusing to start with ephemeral storage that is deleted on exit. Pass -store_dir, or -allow_ephemeral to accept losing all data when the node stops")
+		}
+		log.Println("WARNING: ============================================================")
+		log.Println("WARNING: no -store_dir given, running with EPHEMERAL storage.")
+		log.Println("WARNING: all Raft state and data on this node is deleted on exit.")
+		log.Println("WARNING: ============================================================")
 		log.Println("Creating temp dir for raft")
 		tempDir, err := os.MkdirTemp("", "kv_raft_")
 		if err != nil {
@@ -397,86 +743,246 @@ func main() {
 		dir = tempDir
 	}
 
-	raftConfig := raft.DefaultConfig()
-	raftConfig.LocalID = raft.ServerID(*nodeID)
-	raftConfig.SnapshotInterval = snapInterval
-	raftConfig.SnapshotThreshold = snapThreshold
-
-	fsmStore := fsm.NewFSM()
-
-	// Raft configuration
-	store, err := raftboltdb.NewBoltStore(filepath.Join(dir, "raft.db"))
+	// Held for the life of the process; a second node on the same dir stops here
+	storeLock, err := lockStoreDir(dir)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalln(err)
+	}
+	defer storeLock.Close()
+
+	config := Config{
+		ValueEncoding:   *valueEncoding,
+		AlwaysBase64:    *alwaysBase64,
+		MaxBatchOps:     *maxBatchOps,
+		MaxBatchBytes:   *maxBatchBytes,
+		ReadyMaxLag:     *readyMaxLag,
+		ApplyTimeout:    *applyTimeout,
+		ImportBatchSize: *importBatchSize,
+		MaxValueBytes:   *maxValueBytes,
+		MaxKeys:         *maxKeys,
+		IdempotencyKeys: *idempotencyKeys,
+		ForwardCacheTTL: *forwardCacheTTL,
+		ReadMode:        *readMode,
+		MinVoters:       *minVoters,
+
+		AllowStaleOnNoLeader: *allowStaleOnNoLeader,
+		CheckWriteQuorum:     *checkWriteQuorum,
+		WriteQuorum:          *writeQuorum,
+		RestoreToken:         *restoreToken,
+		CommitSLA:            *commitSLA,
+		StateHistorySize:     *stateHistorySize,
+		RebalanceRate:        *rebalanceRate,
+		StrictRouting:        *strictRouting,
+	}
+
+	// With -shards this process hosts one raft group per listed shard, each
+	// with its own store subdir and raft port and served under /shard/{id};
+	// otherwise it hosts a single group at the root
+	var groups []*shardGroup
+	if *shards == "" {
+		group, err := openShardGroup(0, *shardID, dir, *raftaddr, config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		groups = append(groups, group)
+	} else {
+		groupIDs, err := parseShardGroups(*shards)
+		if err != nil {
+			log.Fatalf("Invalid -shards: %v", err)
+		}
+		if bootstrapMode || *bootstrapExpect > 0 {
+			log.Fatalln("-shards cannot be combined with `kv-raft bootstrap` or -bootstrap_expect")
+		}
+		if *discoverySRV != "" {
+			log.Fatalln("-shards cannot be combined with -discovery_srv")
+		}
+		for _, id := range groupIDs {
+			raftAddr, err := groupRaftAddr(*raftaddr, id)
+			if err != nil {
+				log.Fatalf("Shard %d: %v", id, err)
+			}
+			group, err := openShardGroup(id, id, filepath.Join(dir, fmt.Sprintf("shard-%d", id)), raftAddr, config)
+			if err != nil {
+				log.Fatalf("Shard %d: %v", id, err)
+			}
+			groups = append(groups, group)
+		}
 	}
 
-	cacheStore, err := raft.NewLogCache(256, store)
-	if err != nil {
-		log.Fatal(err)
-	}
+	// Process-wide endpoints plus each group's handler
+	mux := http.NewServeMux()
+	readMux := http.NewServeMux()
+	var servers []*Server
+	for _, group := range groups {
+		id, raftServer, self := group.server.shardID, group.raft, group.self
+
+		// With -bootstrap_expect the cluster bootstraps once all peers are up (see
+		// below); otherwise only shard 1 bootstraps and the others join via /raft/join
+		if group.hasState {
+			log.Printf("Shard %d: Recovered existing Raft state from %s, skipping bootstrap", id, group.dir)
+		} else if group.recovered {
+			log.Printf("Shard %d: Started over from a corrupt store, skipping bootstrap; waiting for the leader to catch this node up", id)
+		} else if bootstrapMode {
+			if bootstrapNodes[0].ID == self.ID {
+				log.Printf("Shard %d: Bootstrapping new Raft cluster of %d nodes", id, len(bootstrapNodes))
+				raftServer.BootstrapCluster(raft.Configuration{
+					Servers: []raft.Server{self},
+				})
+			} else {
+				log.Printf("Shard %d: Waiting for %s to add this node to the Raft cluster", id, bootstrapNodes[0].ID)
+			}
+		} else if *bootstrapExpect > 0 {
+			log.Printf("Shard %d: Waiting for %d peers to bootstrap the Raft cluster", id, *bootstrapExpect)
+		} else if *shardID == 1 {
+			log.Printf("Shard %d: Bootstrapping new Raft cluster", id)
+			raftServer.BootstrapCluster(raft.Configuration{
+				Servers: []raft.Server{self},
+			})
+		} else {
+			log.Printf("Shard %d: Waiting to join existing Raft cluster", id)
+		}
 
-	snapshotStore, err := raft.NewFileSnapshotStore(dir, 1, os.Stdout)
-	if err != nil {
-		log.Fatal(err)
+		unifiedServer := group.server
+		servers = append(servers, unifiedServer.server)
+
+		// Initialize peer shards
+		if group.id == 0 {
+			unifiedServer.initializePeerShards(*peerShards)
+		}
+
+		// Start leader observer
+		unifiedServer.LeaderObserver()
+		if group.id == 0 {
+			unifiedServer.StartShardSync()
+			if *discoverySRV != "" {
+				unifiedServer.StartDiscovery(*discoverySRV, *discoveryInterval)
+			}
+		}
+		unifiedServer.StartReadinessTracker()
+		if *checkWriteQuorum {
+			unifiedServer.StartVoterContactTracker()
+		}
+		if *stateHistorySize > 0 {
+			unifiedServer.StartStateHistory()
+		}
+		unifiedServer.StartStatsCollector(filepath.Join(group.dir, "raft.db"))
+
+		if group.id == 0 {
+			mux.Handle("/", unifiedServer.Handler())
+			readMux.Handle("/", unifiedServer.ReadHandler())
+		} else {
+			prefix := groupPrefix(group.id)
+			mux.Handle(prefix+"/", http.StripPrefix(prefix, unifiedServer.Handler()))
+			readMux.Handle(prefix+"/", http.StripPrefix(prefix, unifiedServer.ReadHandler()))
+		}
 	}
 
-	tcpAddr, err := net.ResolveTCPAddr("tcp", *raftaddr)
-	if err != nil {
-		log.Fatal(err)
+	// Owning shard of a key among the groups hosted here
+	groupLocator := newLocator(groups)
+	for _, group := range groups {
+		group.server.locator = groupLocator
+		group.server.server.locator = groupLocator
 	}
+	locateHandler := instrument("locate", groupLocator.LocateHandler)
+	mux.HandleFunc("/locate", locateHandler)
+	readMux.HandleFunc("/locate", locateHandler)
 
-	transport, err := raft.NewTCPTransport(*raftaddr, tcpAddr, 3, tcpTimeout, os.Stdout)
-	if err != nil {
-		log.Fatal(err)
+	// Build information
+	mux.HandleFunc("/version", VersionHandler)
+	readMux.HandleFunc("/version", VersionHandler)
+
+	// OpenAPI 3 description of this API
+	mux.HandleFunc("/openapi.json", OpenAPIHandler)
+
+	// Prometheus metrics
+	mux.Handle("/metrics", promhttp.Handler())
+
+	peers := staticPeers(*peerShards)
+	if *discoverySRV != "" {
+		peers = srvPeers(*discoverySRV)
+	}
+	if !groups[0].hasState && !groups[0].recovered && *bootstrapExpect > 0 {
+		go bootstrapWhenExpected(groups[0].raft, groups[0].self, *bootstrapExpect, peers)
+	}
+	if groups[0].recovered {
+		groups[0].server.StartRejoin(groups[0].dir, groups[0].rejoin, peers)
+	}
+	if bootstrapMode && bootstrapNodes[0].ID == groups[0].self.ID {
+		go joinBootstrapNodes(groups[0].raft, bootstrapNodes)
 	}
 
-	raftServer, err := raft.NewRaft(raftConfig, fsmStore, cacheStore, store, snapshotStore, transport)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Only bootstrap cluster on shard 1, others will join via /raft/join
-	if *shardID == 1 {
-		log.Printf("Shard 1: Bootstrapping new Raft cluster")
-		raftServer.BootstrapCluster(raft.Configuration{
-			Servers: []raft.Server{
-				{
-					ID:      raft.ServerID(*nodeID),
-					Address: transport.LocalAddr(),
-				},
-			},
-		})
-	} else {
-		log.Printf("Shard %d: Waiting to join existing Raft cluster", *shardID)
+	if *pprofAddr != "" {
+		startPprofServer(*pprofAddr)
 	}
 
-	// Create unified server
-	unifiedServer := NewUnifiedServer(raftServer, fsmStore, *shardID)
-	
-	// Initialize peer shards
-	unifiedServer.initializePeerShards(*peerShards)
-	
-	// Start leader observer
-	unifiedServer.LeaderObserver()
+	if *h2c {
+		forwardClient.Transport = newPeerTransport(true)
+	}
 
-	// Data operation endpoints
-	http.HandleFunc("/get", unifiedServer.GetHandler)
-	http.HandleFunc("/put", unifiedServer.PutHandler)
-	http.HandleFunc("/delete", unifiedServer.DeleteHandler)
+	var handler, readHandler http.Handler = mux, readMux
+	if *maxRequestBytes > 0 {
+		handler = maxBytesHandler(handler, *maxRequestBytes)
+		readHandler = maxBytesHandler(readHandler, *maxRequestBytes)
+	}
+	if *httpGzip {
+		handler = gzipHandler(handler, *httpGzipMinBytes)
+		readHandler = gzipHandler(readHandler, *httpGzipMinBytes)
+	}
+	if len(allowedOrigins) > 0 {
+		handler = corsHandler(handler, allowedOrigins)
+		readHandler = corsHandler(readHandler, allowedOrigins)
+	}
 
-	// Config operation endpoints (merged from config server)
-	http.HandleFunc("/config", unifiedServer.ConfigHandler)
-	http.HandleFunc("/addshard", unifiedServer.AddShardHandler)
-	http.HandleFunc("/newleader", unifiedServer.NewLeaderHandler)
+	httpServer := &http.Server{
+		Addr:              fmt.Sprintf(":%d", *port),
+		Handler:           handler,
+		ReadHeaderTimeout: *readHeaderTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		Protocols:         serverProtocols(*h2c),
+	}
+	httpServers := []*http.Server{httpServer}
 
-	// Raft management endpoints
-	http.HandleFunc("/raft/join", unifiedServer.RaftJoin)
-	http.HandleFunc("/raft/status", unifiedServer.RaftStatus)
-	http.HandleFunc("/raft/leave", unifiedServer.RaftLeave)
+	// The read port shares the groups' servers and state, so it can get its
+	// own network policy without a second copy of the data
+	if *readPort != 0 {
+		readServer := &http.Server{
+			Addr:              fmt.Sprintf(":%d", *readPort),
+			Handler:           readHandler,
+			ReadHeaderTimeout: *readHeaderTimeout,
+			ReadTimeout:       *readTimeout,
+			WriteTimeout:      *writeTimeout,
+			IdleTimeout:       *idleTimeout,
+			Protocols:         serverProtocols(*h2c),
+		}
+		httpServers = append(httpServers, readServer)
+		go func() {
+			log.Printf("Read-only server listening on port %d", *readPort)
+			if err := readServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Read-only server error: %v", err)
+			}
+		}()
+	}
+	shutdownDone := waitForShutdown(httpServers, servers, *leaveOnShutdown)
 
 	log.Printf("Unified server (shard %d) listening on port %d", *shardID, *port)
-	err = http.ListenAndServe(fmt.Sprintf(":%d", *port), nil)
-	if err != nil {
+	err = httpServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		fmt.Printf("Server error: %v\n", err)
 	}
+	if err == http.ErrServerClosed {
+		<-shutdownDone
+	}
+
+	for _, group := range groups {
+		group.server.Stop()
+
+		if err := group.raft.Shutdown().Error(); err != nil {
+			log.Printf("Raft shutdown error: %v", err)
+		}
+		if err := group.store.Close(); err != nil {
+			log.Printf("Raft store close error: %v", err)
+		}
+	}
 }
@@ -0,0 +1,176 @@
+// KV-Raft: Prometheus metrics for handler and raft apply latency and store size
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+// latencyBuckets span 1ms to ~4s, doubling each step
+var latencyBuckets = prometheus.ExponentialBuckets(0.001, 2, 13)
+
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kvraft_http_request_duration_seconds",
+		Help:    "End-to-end HTTP handler duration by operation.",
+		Buckets: latencyBuckets,
+	}, []string{"op"})
+
+	raftApplyDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kvraft_raft_apply_duration_seconds",
+		Help:    "Time from raft.Apply until the entry is committed and applied, by operation.",
+		Buckets: latencyBuckets,
+	}, []string{"op"})
+
+	fsmApplyErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kvraft_fsm_apply_errors_total",
+		Help: "Log entries, and BATCH operations, whose FSM apply returned an error, by operation and error code.",
+	}, []string{"op", "code"})
+
+	deadLetterEntries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kvraft_dead_letter_entries_total",
+		Help: "Log entries a raft group's state machine skipped and wrote to its dead-letter file, as counted by dead_letter_entries in /raft/status.",
+	}, []string{"shard_id", "group"})
+
+	goroutines = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kvraft_goroutines",
+		Help: "Goroutines in the process, sampled every statsInterval.",
+	}, []string{"shard_id"})
+
+	fsmKeys = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kvraft_fsm_keys",
+		Help: "Keys stored by a raft group's state machine, sampled every statsInterval.",
+	}, []string{"shard_id", "group"})
+
+	raftDBBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kvraft_raft_db_bytes",
+		Help: "Size of a raft group's boltdb log store file, sampled every statsInterval.",
+	}, []string{"shard_id", "group"})
+
+	commitLatencyAverage = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kvraft_commit_latency_average_seconds",
+		Help: "Mean commit latency of a raft group's latest successful applies on this node, sampled every statsInterval.",
+	}, []string{"shard_id", "group"})
+
+	commitSLABreached = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kvraft_commit_sla_breached",
+		Help: "1 while a raft group's mean commit latency is above -commit_sla, else 0, sampled every statsInterval.",
+	}, []string{"shard_id", "group"})
+)
+
+// statsInterval is how often the gauges above are sampled. Scrapes read the
+// last sample, so their cost does not grow with the store.
+const statsInterval = 10 * time.Second
+
+// StartStatsCollector samples the goroutine, key count, raft.db size and
+// commit latency gauges of this server's group every statsInterval until Stop
+// is called
+func (us *UnifiedServer) StartStatsCollector(dbPath string) {
+	shardID := strconv.Itoa(us.shardID)
+	group := strconv.Itoa(us.server.config.Group)
+	store, _ := us.fsm.(*fsm.FSM)
+
+	sample := func() {
+		goroutines.WithLabelValues(shardID).Set(float64(runtime.NumGoroutine()))
+		if store != nil {
+			fsmKeys.WithLabelValues(shardID, group).Set(float64(store.KeyCount()))
+		}
+		if info, err := os.Stat(dbPath); err == nil {
+			raftDBBytes.WithLabelValues(shardID, group).Set(float64(info.Size()))
+		}
+		commitLatencyAverage.WithLabelValues(shardID, group).Set(us.server.commitLatency.average().Seconds())
+		breached := 0.0
+		if us.server.commitLatency.isBreached() {
+			breached = 1
+		}
+		commitSLABreached.WithLabelValues(shardID, group).Set(breached)
+	}
+
+	us.wg.Add(1)
+	go func() {
+		defer us.wg.Done()
+
+		ticker := time.NewTicker(statsInterval)
+		defer ticker.Stop()
+		for {
+			sample()
+			select {
+			case <-us.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// recordApplyError is the FSM's OnApplyError callback. Every error is counted;
+// missing keys and versions and mismatched expected values are ordinary client
+// outcomes, so only the others are logged.
+func recordApplyError(applyErr fsm.ApplyError) {
+	_, code := applyErrorStatus(applyErr.Err)
+	fsmApplyErrors.WithLabelValues(applyErr.OP, code).Inc()
+
+	if code == api.CodeKeyNotFound || code == api.CodeVersionNotFound || code == api.CodeCASMismatch {
+		return
+	}
+	log.Printf("[FSM-APPLY-ERROR] index=%d op=%s key=%q code=%s error=%q", applyErr.Index, applyErr.OP, applyErr.Key, code, applyErr.Err)
+}
+
+// instrument records the duration of every call to handler under the given op
+// label, and counts it for /debug/counters
+func instrument(op string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		countRequest(op)
+		handler(w, r)
+		httpRequestDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	}
+}
+
+// timedApply applies data through raft, waits for the result and records how
+// long it took, in the histogram and, when it succeeded, in the -commit_sla window
+func (s *Server) timedApply(op string, data []byte) raft.ApplyFuture {
+	start := time.Now()
+	applyFuture := s.raft.Apply(data, s.config.ApplyTimeout)
+	err := applyFuture.Error()
+	latency := time.Since(start)
+	raftApplyDuration.WithLabelValues(op).Observe(latency.Seconds())
+	if err == nil {
+		s.recordCommit(latency)
+	} else {
+		opCounters.applyFailures.Add(1)
+	}
+	return applyFuture
+}
+
+// proposeApply hands data to raft without waiting for it to commit. The
+// outcome is only recorded: the apply duration as for timedApply, and a log
+// line if the entry never made it into the log.
+func (s *Server) proposeApply(op string, data []byte) {
+	start := time.Now()
+	applyFuture := s.raft.Apply(data, s.config.ApplyTimeout)
+	go func() {
+		if err := applyFuture.Error(); err != nil {
+			opCounters.applyFailures.Add(1)
+			log.Printf("[RAFT-APPLY] unacknowledged %s was not applied: %v", op, err)
+			return
+		}
+		latency := time.Since(start)
+		raftApplyDuration.WithLabelValues(op).Observe(latency.Seconds())
+		s.recordCommit(latency)
+	}()
+}
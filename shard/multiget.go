@@ -0,0 +1,114 @@
+// KV-Raft: Multi-key reads from a single point in the raft log
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+func (s *Server) MultiGetHandler(w http.ResponseWriter, r *http.Request) {
+	var req MultiGetRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if len(req.Keys) == 0 {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("keys"))
+		return
+	}
+
+	if !validNamespace(req.Namespace) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Namespace must not contain '/'")
+		return
+	}
+	for _, key := range req.Keys {
+		if !validKey(req.Namespace, key) {
+			writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, bareKeyMessage)
+			return
+		}
+	}
+
+	// Followers cannot apply the read through raft, so forward it to the leader
+	if s.raft.State() != raft.Leader {
+		body, err := json.Marshal(req)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal request")
+			return
+		}
+		s.forwardToLeader(w, r, http.MethodPost, "/mget", bytes.NewReader(body))
+		return
+	}
+
+	if !s.awaitSession(w, r) {
+		return
+	}
+
+	// All keys are read by a single log entry, so the values are mutually consistent
+	storeKeys := make([]string, len(req.Keys))
+	for i, key := range req.Keys {
+		storeKeys[i] = namespacedKey(req.Namespace, key)
+	}
+
+	payload := fsm.Payload{
+		OP:   fsm.MGET,
+		Keys: storeKeys,
+	}
+
+	data, err := s.marshalPayload(payload)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal payload")
+		return
+	}
+
+	applyFuture := s.applyRead(payload.OP, data)
+	if err := applyFuture.Error(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+
+	applyResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Invalid raft response")
+		return
+	}
+
+	result, ok := applyResponse.Data.(fsm.MultiGetResult)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Invalid raft response")
+		return
+	}
+
+	// Report keys as the client sent them, without the namespace prefix
+	values := make(map[string]string, len(result.Values))
+	missing := []string{}
+	for i, key := range req.Keys {
+		if value, found := result.Values[storeKeys[i]]; found {
+			values[key] = value
+		} else {
+			missing = append(missing, key)
+		}
+	}
+
+	log.Printf("[HTTP-MGET] %d of %d keys were found on this node", len(values), len(req.Keys))
+
+	response := APIResponse{
+		Success: true,
+		Message: "Keys retrieved successfully",
+		Data: map[string]interface{}{
+			"values":  values,
+			"missing": missing,
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,139 @@
+// KV-Raft: Namespaces for partitioning the keyspace between tenants
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+const namespaceSeparator = "/"
+
+type NamespaceDeleteRequest struct {
+	Namespace string `json:"namespace"`
+}
+
+// namespacedKey returns the key as stored in the FSM: namespace + "/" + key,
+// or the bare key when no namespace is given
+func namespacedKey(namespace, key string) string {
+	if namespace == "" {
+		return key
+	}
+	return namespace + namespaceSeparator + key
+}
+
+// validNamespace reports whether namespace can be used without colliding with another one
+func validNamespace(namespace string) bool {
+	return !strings.Contains(namespace, namespaceSeparator)
+}
+
+// bareKeyMessage answers a key that validKey rejects
+const bareKeyMessage = "Key must not contain '/' without a namespace"
+
+// validKey reports whether key can be used in namespace without colliding
+// with a key of another namespace: without a namespace, "team/x" would be
+// stored as key x of namespace team
+func validKey(namespace, key string) bool {
+	return namespace != "" || !strings.Contains(key, namespaceSeparator)
+}
+
+// splitStoreKey returns the namespace and key that namespacedKey stored as storeKey
+func splitStoreKey(storeKey string) (namespace, key string) {
+	if namespace, key, ok := strings.Cut(storeKey, namespaceSeparator); ok {
+		return namespace, key
+	}
+	return "", storeKey
+}
+
+// reservedKeyMessage answers a write to a key that reservedKey rejects
+const reservedKeyMessage = "Namespace " + fsm.SequenceNamespace + " is reserved for the sequences of /nextid"
+
+// reservedKey reports whether storeKey lies in fsm.SequenceNamespace. Only
+// /nextid writes there: a sequence overwritten or deleted by anything else
+// would hand out IDs again.
+func reservedKey(storeKey string) bool {
+	return strings.HasPrefix(storeKey, fsm.SequenceNamespace+namespaceSeparator)
+}
+
+// reservedPrefix reports whether some key under prefix lies in fsm.SequenceNamespace
+func reservedPrefix(prefix string) bool {
+	reserved := fsm.SequenceNamespace + namespaceSeparator
+	return strings.HasPrefix(prefix, reserved) || strings.HasPrefix(reserved, prefix)
+}
+
+func (s *Server) NamespaceDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	var req NamespaceDeleteRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if req.Namespace == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("namespace"))
+		return
+	}
+
+	if !validNamespace(req.Namespace) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Namespace must not contain '/'")
+		return
+	}
+	if reservedPrefix(req.Namespace + namespaceSeparator) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, reservedKeyMessage)
+		return
+	}
+
+	idemKey, ok := idempotencyKey(w, r)
+	if !ok {
+		return
+	}
+
+	// All keys of the namespace are removed by a single log entry
+	payload := fsm.Payload{
+		OP:             fsm.DELPREFIX,
+		Key:            req.Namespace + namespaceSeparator,
+		IdempotencyKey: idemKey,
+	}
+
+	data, err := s.marshalPayload(payload)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal payload")
+		return
+	}
+
+	if err := s.checkWriteQuorum(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+
+	applyFuture := s.timedApply(payload.OP, data)
+	if err := applyFuture.Error(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+	setSessionToken(w, applyFuture.Index())
+
+	applyResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Invalid raft response")
+		return
+	}
+	markReplayed(w, applyResponse)
+
+	log.Printf("[HTTP-NAMESPACE-DELETE] namespace %s was deleted from this node", req.Namespace)
+
+	response := APIResponse{
+		Success: true,
+		Message: "Namespace deleted successfully",
+		Data: map[string]interface{}{
+			"namespace": req.Namespace,
+			"deleted":   applyResponse.Data,
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,117 @@
+// KV-Raft: Unique ID allocation from replicated sequences
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+// maxIDBlock caps how many IDs one /nextid may allocate
+const maxIDBlock = 1000000
+
+// NextIDHandler allocates the next count IDs (default 1) from the sequence
+// name, starting at 1. The allocation is a single log entry, so concurrent
+// callers never receive the same ID, even across a change of leader.
+func (s *Server) NextIDHandler(w http.ResponseWriter, r *http.Request) {
+	var req NextIDRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if req.Name == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("name"))
+		return
+	}
+	if req.Count == 0 {
+		req.Count = 1
+	}
+	if req.Count > maxIDBlock {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("Count must be at most %d", maxIDBlock))
+		return
+	}
+
+	idemKey, ok := idempotencyKey(w, r)
+	if !ok {
+		return
+	}
+
+	// Only the leader allocates, so followers forward the request
+	if s.raft.State() != raft.Leader {
+		query := url.Values{}
+		query.Set("name", req.Name)
+		query.Set("count", strconv.FormatUint(req.Count, 10))
+		s.forwardToLeader(w, r, http.MethodPost, "/nextid?"+query.Encode(), nil)
+		return
+	}
+
+	payload := fsm.Payload{
+		OP:             fsm.NEXTID,
+		Key:            namespacedKey(fsm.SequenceNamespace, req.Name),
+		Count:          req.Count,
+		IdempotencyKey: idemKey,
+	}
+
+	data, err := s.marshalPayload(payload)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal payload")
+		return
+	}
+
+	if err := s.checkWriteQuorum(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+
+	applyFuture := s.timedApply(payload.OP, data)
+	if err := applyFuture.Error(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+	setSessionToken(w, applyFuture.Index())
+
+	applyResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Invalid raft response")
+		return
+	}
+	markReplayed(w, applyResponse)
+
+	if applyResponse.Error != nil {
+		status, code := applyErrorStatus(applyResponse.Error)
+		writeJSONError(w, status, code, "Failed to allocate IDs: "+applyResponse.Error.Error())
+		return
+	}
+
+	allocated, ok := applyResponse.Data.(fsm.IDRange)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Invalid raft response")
+		return
+	}
+
+	log.Printf("[HTTP-NEXTID] sequence %s allocated %d-%d", req.Name, allocated.First, allocated.Last)
+
+	response := APIResponse{
+		Success: true,
+		Message: "IDs allocated successfully",
+		Data: map[string]interface{}{
+			"name":  req.Name,
+			"first": allocated.First,
+			"last":  allocated.Last,
+			"count": req.Count,
+			"index": applyFuture.Index(),
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,268 @@
+// KV-Raft: OpenAPI 3 description of the shard HTTP API
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// apiOperation describes one endpoint of the spec. Schemas are derived from
+// the request and response structs the handlers decode and encode, so they
+// follow the code; which fields are required is listed here.
+type apiOperation struct {
+	path     string
+	method   string
+	summary  string
+	request  interface{} // nil when the endpoint takes no input
+	required []string
+	response interface{}
+	example  map[string]interface{}
+}
+
+var apiOperations = []apiOperation{
+	{path: "/get", method: http.MethodGet, summary: "Read a key linearizably on the leader (or from the read cache)",
+		request: GetRequest{}, required: []string{"key"}, response: GetResponse{},
+		example: map[string]interface{}{"key": "mykey"}},
+	{path: "/get", method: http.MethodHead, summary: "Check that a key exists: 200 with the version as ETag, or 404, without a body",
+		request: GetRequest{}, required: []string{"key"}, response: GetResponse{},
+		example: map[string]interface{}{"key": "mykey"}},
+	{path: "/put", method: http.MethodPost, summary: "Store a value; send val, or val_b64 for binary values",
+		request: PutRequest{}, required: []string{"key"}, response: APIResponse{},
+		example: map[string]interface{}{"key": "mykey", "val": "myvalue"}},
+	{path: "/delete", method: http.MethodDelete, summary: "Delete a key",
+		request: DeleteRequest{}, required: []string{"key"}, response: APIResponse{},
+		example: map[string]interface{}{"key": "mykey"}},
+	{path: "/deleteif", method: http.MethodDelete, summary: "Delete a key only if its latest value is expected; 409 CAS_MISMATCH otherwise, 404 if absent",
+		request: DeleteIfRequest{}, required: []string{"key", "expected"}, response: APIResponse{},
+		example: map[string]interface{}{"key": "job-7-owner", "expected": "worker-3"}},
+	{path: "/locate", method: http.MethodGet, summary: "Shard owning a key: FNV-1a 64 of the stored key mod the size of the sorted shard ring",
+		request: LocateRequest{}, required: []string{"key"}, response: APIResponse{},
+		example: map[string]interface{}{"key": "mykey"}},
+	{path: "/append", method: http.MethodPost, summary: "Atomically add delim (default newline) and val to the end of a key's value, creating it if absent",
+		request: AppendRequest{}, required: []string{"key", "val"}, response: APIResponse{},
+		example: map[string]interface{}{"key": "stream-1", "val": "event-42"}},
+	{path: "/nextid", method: http.MethodPost, summary: "Allocate the next count IDs (default 1) from a sequence in one log entry; IDs start at 1 and are never handed out twice",
+		request: NextIDRequest{}, required: []string{"name"}, response: APIResponse{},
+		example: map[string]interface{}{"name": "orders", "count": 100}},
+	{path: "/txget", method: http.MethodPost, summary: "Read several keys together after one read-index barrier, with no log entry applied between them",
+		request: TxGetRequest{}, required: []string{"keys"}, response: APIResponse{},
+		example: map[string]interface{}{"keys": []string{"balance/alice", "balance/bob"}}},
+	{path: "/scan", method: http.MethodPost, summary: "List keys with a prefix, optionally matching a path.Match glob, in key order, a page at a time, after one read-index barrier",
+		request: ScanRequest{}, response: APIResponse{},
+		example: map[string]interface{}{"prefix": "user/", "glob": "user/[0-9]*", "limit": 100}},
+	{path: "/stage", method: http.MethodPost, summary: "Set the value a key gets at the next /commit-staged, leaving its live value alone",
+		request: StageRequest{}, required: []string{"key", "val"}, response: APIResponse{},
+		example: map[string]interface{}{"namespace": "config", "key": "feature-x", "val": "on"}},
+	{path: "/commit-staged", method: http.MethodPost, summary: "Make staged values live in one log entry: keys, or every key staged in the namespace",
+		request: CommitStagedRequest{}, response: APIResponse{},
+		example: map[string]interface{}{"namespace": "config"}},
+	{path: "/config", method: http.MethodGet, summary: "Cluster shards and configuration epoch, optionally long-polling for a newer epoch",
+		request: ConfigRequest{}, response: APIResponse{},
+		example: map[string]interface{}{"wait": "30s", "epoch": 3}},
+	{path: "/addshard", method: http.MethodPost, summary: "Announce a shard to this shard and its known peers",
+		request: ShardInfoRequest{}, required: []string{"shardID", "shardAddress"}, response: APIResponse{},
+		example: map[string]interface{}{"shardID": "2", "shardAddress": "shard2:8021"}},
+	{path: "/newleader", method: http.MethodPost, summary: "Announce a shard's new leader address",
+		request: ShardInfoRequest{}, required: []string{"shardID", "shardAddress"}, response: APIResponse{},
+		example: map[string]interface{}{"shardID": "1", "shardAddress": "shard1:8011"}},
+	{path: "/syncshards", method: http.MethodPost, summary: "Merge a peer's whole shard map, entry by entry by epoch, and return the result",
+		request: ShardMap{}, required: []string{"shards"}, response: APIResponse{},
+		example: map[string]interface{}{"epoch": 2, "shards": map[string]interface{}{"2": map[string]interface{}{"address": "shard2:8021", "epoch": 2}}}},
+	{path: "/raft/join", method: http.MethodPost, summary: "Add a node as a voter, or as a nonvoter with nonvoter=true, or promote a nonvoter; must be sent to the leader",
+		request: JoinRequest{}, required: []string{"nodeid", "addr"}, response: APIResponse{},
+		example: map[string]interface{}{"nodeid": "2", "addr": "shard2:18021", "nonvoter": true}},
+	{path: "/raft/leave", method: http.MethodPost, summary: "Remove a node from the Raft configuration by nodeid, or by raft addr",
+		request: LeaveRequest{}, response: APIResponse{},
+		example: map[string]interface{}{"nodeid": "2"}},
+	{path: "/hotkeys", method: http.MethodGet, summary: "This node's most accessed keys since the last reset, from sampled local counts",
+		request: HotKeysRequest{}, response: APIResponse{},
+		example: map[string]interface{}{"n": 20, "reset": true}},
+	{path: "/raft/status", method: http.MethodGet, summary: "Raft statistics of this node", response: APIResponse{}},
+	{path: "/raft/peers", method: http.MethodGet, summary: "Servers in the Raft configuration", response: APIResponse{}},
+	{path: "/raft/leader", method: http.MethodGet, summary: "Current leader's raft address, URL and term; 503 during an election", response: APIResponse{}},
+	{path: "/raft/history", method: http.MethodGet, summary: "This node's latest raft state and leader changes, oldest first, for post-mortems", response: APIResponse{}},
+	{path: "/raft/verify", method: http.MethodGet, summary: "Confirm leadership with a quorum; 421 if not the leader", response: APIResponse{}},
+	{path: "/rebalance", method: http.MethodPost, summary: "Start moving the keys this group does not own under ring (default: -shards) to their owners' leaders at rate keys/s; 202, or 409 REBALANCE_RUNNING",
+		request: RebalanceRequest{}, response: APIResponse{},
+		example: map[string]interface{}{"ring": "1,2,3,4", "rate": 200}},
+	{path: "/rebalance/status", method: http.MethodGet, summary: "Progress of the leader's latest rebalance", response: APIResponse{}},
+	{path: "/rebalance/stop", method: http.MethodPost, summary: "Stop the running rebalance; running it again resumes with the keys not yet moved", response: APIResponse{}},
+	{path: "/raft/snapshot", method: http.MethodPost, summary: "Snapshot this node's state and compact its log", response: APIResponse{}},
+	{path: "/raft/restore", method: http.MethodPost, summary: "Replace the whole cluster's state with the snapshot in the body; leader only, needs X-KV-Raft-Restore-Token", response: APIResponse{}},
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPISpec map[string]interface{}
+)
+
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		openAPISpec = buildOpenAPISpec()
+	})
+	writeJSONResponse(w, http.StatusOK, openAPISpec)
+}
+
+func buildOpenAPISpec() map[string]interface{} {
+	schemas := map[string]interface{}{}
+	paths := map[string]interface{}{}
+
+	for _, op := range apiOperations {
+		responseName := addSchema(schemas, reflect.TypeOf(op.response))
+		responseContent := map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schemaRef(responseName)},
+		}
+		operation := map[string]interface{}{
+			"summary": op.summary,
+			"responses": map[string]interface{}{
+				"200":     map[string]interface{}{"description": "Success", "content": responseContent},
+				"default": map[string]interface{}{"description": "Error, with a machine-readable code", "content": responseContent},
+			},
+		}
+
+		if op.request != nil {
+			requestType := reflect.TypeOf(op.request)
+			requestName := addSchema(schemas, requestType)
+			schema := schemas[requestName].(map[string]interface{})
+			if len(op.required) > 0 {
+				schema["required"] = op.required
+			}
+
+			// Every field may also come from the query string (see Request Input in the README)
+			var parameters []interface{}
+			for _, field := range jsonFields(requestType) {
+				parameter := map[string]interface{}{
+					"name":     field.name,
+					"in":       "query",
+					"required": !hasBody(op.method) && contains(op.required, field.name),
+					"schema":   typeSchema(schemas, field.typ),
+				}
+				if example, ok := op.example[field.name]; ok && !hasBody(op.method) {
+					parameter["example"] = example
+				}
+				parameters = append(parameters, parameter)
+			}
+			operation["parameters"] = parameters
+
+			if hasBody(op.method) {
+				body := map[string]interface{}{"schema": schemaRef(requestName)}
+				if op.example != nil {
+					body["example"] = op.example
+				}
+				operation["requestBody"] = map[string]interface{}{
+					"content": map[string]interface{}{
+						"application/json":                  body,
+						"application/x-www-form-urlencoded": map[string]interface{}{"schema": schemaRef(requestName)},
+					},
+				}
+			}
+		}
+
+		if paths[op.path] == nil {
+			paths[op.path] = map[string]interface{}{}
+		}
+		paths[op.path].(map[string]interface{})[strings.ToLower(op.method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "KV-Raft shard API",
+			"version": buildInfo().Version,
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+type jsonField struct {
+	name      string
+	typ       reflect.Type
+	omitEmpty bool
+}
+
+// jsonFields lists the fields of a struct type as encoding/json names them
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, jsonField{name: name, typ: field.Type, omitEmpty: contains(tag[1:], "omitempty")})
+	}
+	return fields
+}
+
+// addSchema adds the object schema of a named struct type to schemas and returns its name
+func addSchema(schemas map[string]interface{}, t reflect.Type) string {
+	name := t.Name()
+	if _, ok := schemas[name]; ok {
+		return name
+	}
+
+	properties := map[string]interface{}{}
+	schemas[name] = map[string]interface{}{"type": "object", "properties": properties}
+	for _, field := range jsonFields(t) {
+		properties[field.name] = typeSchema(schemas, field.typ)
+	}
+	return name
+}
+
+// typeSchema returns the JSON schema of a Go type
+func typeSchema(schemas map[string]interface{}, t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(schemas, t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(schemas, t.Elem())}
+	case reflect.Pointer:
+		return typeSchema(schemas, t.Elem())
+	case reflect.Struct:
+		return schemaRef(addSchema(schemas, t))
+	default:
+		// interface{} fields such as APIResponse.Data hold any JSON value
+		return map[string]interface{}{}
+	}
+}
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// hasBody reports whether operations with method take their fields from a
+// request body as well as the query string
+func hasBody(method string) bool {
+	return method != http.MethodGet && method != http.MethodHead
+}
@@ -0,0 +1,36 @@
+// KV-Raft: Profiling endpoints on a separate admin listener
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// mutexProfileFraction samples 1 in N mutex contention events while profiling is enabled
+const mutexProfileFraction = 5
+
+// startPprofServer serves net/http/pprof and /debug/counters on addr using its
+// own mux, so the profiles and counters never appear on the public data port
+func startPprofServer(addr string) {
+	runtime.SetMutexProfileFraction(mutexProfileFraction)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/counters", CountersHandler)
+
+	go func() {
+		log.Printf("pprof admin server listening on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("pprof admin server error: %v", err)
+		}
+	}()
+}
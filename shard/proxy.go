@@ -0,0 +1,224 @@
+// KV-Raft: Proxy front door forwarding client requests to the owning shard's leader
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"kv-raft/api"
+)
+
+// proxiedPaths are the client endpoints the proxy forwards
+var proxiedPaths = []string{
+	"/get", "/getfield", "/put", "/delete", "/deleteif", "/append", "/nextid", "/batch", "/deleteprefix", "/mget", "/txget", "/namespace/delete",
+	"/stage", "/commit-staged",
+	"/lock/acquire", "/lock/renew", "/lock/release",
+}
+
+// maxProxyBody caps how much of a request body the proxy buffers for
+// retries; larger bodies get 413 rather than being forwarded cut short
+const maxProxyBody = 1 << 20
+
+// proxy forwards client requests to the leader of the cluster owning their
+// keys. Every shard is a member of one Raft group, so that leader owns every
+// key; the proxy tracks which member it is and which members exist.
+type proxy struct {
+	seeds  []string
+	client *http.Client
+
+	mu      sync.RWMutex
+	members []string // HTTP addresses of the current raft configuration
+	leader  string   // HTTP address of the current leader, empty if unknown
+}
+
+func newProxy(seeds []string, h2c bool) *proxy {
+	return &proxy{
+		seeds:   seeds,
+		client:  &http.Client{Timeout: 10 * time.Second, Transport: newPeerTransport(h2c)},
+		members: seeds,
+	}
+}
+
+// runProxy implements `kv-raft proxy`, serving the client API on one stable address
+func runProxy(args []string) {
+	flags := flag.NewFlagSet("proxy", flag.ExitOnError)
+	port := flags.Int("port", 3001, "http port clients connect to")
+	shards := flags.String("shards", "", "comma-separated shard HTTP addresses used to discover the cluster (e.g., localhost:8011,localhost:8021)")
+	refresh := flags.Duration("refresh", 5*time.Second, "how often the leader and cluster membership are refreshed")
+	h2c := flags.Bool("h2c", false, "accept HTTP/2 without TLS from clients, and speak it to the shards, which must run with -h2c too")
+	flags.Parse(args)
+
+	var seeds []string
+	for _, addr := range strings.Split(*shards, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			seeds = append(seeds, addr)
+		}
+	}
+	if len(seeds) == 0 {
+		log.Fatal("proxy requires at least one address in -shards")
+	}
+
+	p := newProxy(seeds, *h2c)
+	p.refresh()
+	go func() {
+		for range time.Tick(*refresh) {
+			p.refresh()
+		}
+	}()
+
+	mux := http.NewServeMux()
+	for _, path := range proxiedPaths {
+		mux.HandleFunc(path, instrument("proxy_"+strings.ReplaceAll(strings.Trim(path, "/"), "/", "_"), p.ServeHTTP))
+	}
+	mux.HandleFunc("/proxy/status", p.StatusHandler)
+
+	log.Printf("Proxy listening on port %d for shards %v", *port, seeds)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", *port), Handler: mux, Protocols: serverProtocols(*h2c)}
+	log.Fatal(server.ListenAndServe())
+}
+
+// refresh asks the known members for the raft configuration, updating the
+// leader and the member list
+func (p *proxy) refresh() {
+	p.mu.RLock()
+	candidates := append(append([]string{}, p.members...), p.seeds...)
+	p.mu.RUnlock()
+
+	for _, addr := range candidates {
+		var peers struct {
+			Data struct {
+				Peers []PeerInfo `json:"peers"`
+			} `json:"data"`
+		}
+		resp, err := p.client.Get("http://" + addr + "/raft/peers")
+		if err != nil {
+			continue
+		}
+		err = json.NewDecoder(resp.Body).Decode(&peers)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK || len(peers.Data.Peers) == 0 {
+			continue
+		}
+
+		members := make([]string, 0, len(peers.Data.Peers))
+		leader := ""
+		for _, peer := range peers.Data.Peers {
+			httpAddr := convertRaftToHTTPAddress(peer.Address)
+			members = append(members, httpAddr)
+			if peer.Leader {
+				leader = httpAddr
+			}
+		}
+
+		p.mu.Lock()
+		if leader != p.leader {
+			log.Printf("[PROXY] leader is now %q", leader)
+		}
+		p.members = members
+		p.leader = leader
+		p.mu.Unlock()
+		return
+	}
+
+	log.Printf("[PROXY] no shard answered /raft/peers")
+}
+
+func (p *proxy) currentLeader() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.leader
+}
+
+// ServeHTTP forwards the request to the leader, rediscovering it and retrying
+// once when the cached leader is unreachable or no longer leads
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxProxyBody))
+	if err != nil {
+		if bodyTooLarge(w, err) {
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Failed to read request body")
+		return
+	}
+
+	// A write whose connection failed may still have been applied, so only
+	// retry it when the client made it safe to with an Idempotency-Key
+	safeToRetry := r.Method == http.MethodGet || r.Header.Get(idempotencyHeader) != ""
+
+	for attempt := 0; ; attempt++ {
+		leader := p.currentLeader()
+		if leader == "" {
+			p.refresh()
+			if leader = p.currentLeader(); leader == "" {
+				writeJSONError(w, http.StatusServiceUnavailable, api.CodeNoLeader, "No raft leader available")
+				return
+			}
+		}
+
+		resp, err := p.send(r, leader, body)
+		if err != nil {
+			if attempt == 0 && safeToRetry {
+				p.refresh()
+				continue
+			}
+			writeJSONError(w, http.StatusBadGateway, api.CodeForwardFailed, "Failed to reach leader: "+err.Error())
+			return
+		}
+
+		// The shard answers 503 when leadership moved and it could not forward
+		if resp.StatusCode == http.StatusServiceUnavailable && attempt == 0 {
+			resp.Body.Close()
+			p.refresh()
+			continue
+		}
+
+		defer resp.Body.Close()
+		for name, values := range resp.Header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+}
+
+func (p *proxy) send(r *http.Request, addr string, body []byte) (*http.Response, error) {
+	url := "http://" + addr + r.URL.RequestURI()
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"Content-Type", idempotencyHeader, sessionHeader} {
+		if value := r.Header.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	return p.client.Do(req)
+}
+
+func (p *proxy) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	p.mu.RLock()
+	data := map[string]interface{}{
+		"leader":  p.leader,
+		"members": p.members,
+	}
+	p.mu.RUnlock()
+
+	response := APIResponse{
+		Success: true,
+		Message: "Proxy status retrieved successfully",
+		Data:    data,
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,122 @@
+// KV-Raft: Rejecting writes up front while too few voters are reachable
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// errNoQuorum reports that a write was refused because the leader cannot
+// reach enough voters to commit it
+var errNoQuorum = errors.New("too few voters are reachable to commit a write")
+
+// voterContactWindow is how long a failed heartbeat counts a voter as
+// unreachable. Raft reports a failing peer again on every failed heartbeat,
+// which with the 1s transport timeout and the default heartbeat timeout is
+// under two seconds apart, so a report older than the window means the peer
+// has answered since, even if the observation saying so was dropped.
+const voterContactWindow = 5 * time.Second
+
+// voterContact remembers, for each voter the leader's heartbeats are failing
+// to reach, when the latest failure was reported. A voter absent from the map,
+// or whose failure is older than voterContactWindow, is reachable as far as
+// this node knows.
+type voterContact struct {
+	mu          sync.Mutex
+	unreachable map[raft.ServerID]time.Time
+}
+
+// failing reports whether the latest failed heartbeat to id is recent
+// enough to count id as unreachable at now. The caller holds mu.
+func (c *voterContact) failing(id raft.ServerID, now time.Time) bool {
+	failedAt, ok := c.unreachable[id]
+	return ok && now.Sub(failedAt) < voterContactWindow
+}
+
+// newVoterContact returns a voterContact with every voter reachable
+func newVoterContact() *voterContact {
+	return &voterContact{unreachable: map[raft.ServerID]time.Time{}}
+}
+
+// trackVoterContact follows this node's heartbeat observations until ctx is
+// done. Raft reports every failed heartbeat, and a resumed one once the peer
+// answers again. Observations are dropped while the channel is full, so a
+// resumed one may never arrive; failures therefore expire after
+// voterContactWindow rather than waiting for it. The map is cleared on every
+// leader change, since a new leader starts replication afresh.
+func (s *Server) trackVoterContact(ctx context.Context) {
+	observations := make(chan raft.Observation, 16)
+	observer := raft.NewObserver(observations, false, func(o *raft.Observation) bool {
+		switch o.Data.(type) {
+		case raft.FailedHeartbeatObservation, raft.ResumedHeartbeatObservation, raft.LeaderObservation:
+			return true
+		}
+		return false
+	})
+	s.raft.RegisterObserver(observer)
+	defer s.raft.DeregisterObserver(observer)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case o := <-observations:
+			s.voterContact.mu.Lock()
+			switch data := o.Data.(type) {
+			case raft.FailedHeartbeatObservation:
+				s.voterContact.unreachable[data.PeerID] = time.Now()
+			case raft.ResumedHeartbeatObservation:
+				delete(s.voterContact.unreachable, data.PeerID)
+			case raft.LeaderObservation:
+				s.voterContact.unreachable = map[raft.ServerID]time.Time{}
+			}
+			s.voterContact.mu.Unlock()
+		}
+	}
+}
+
+// checkWriteQuorum returns an error wrapping errNoQuorum when
+// -check_write_quorum is set and this leader reaches fewer voters, itself
+// included, than config.WriteQuorum, or than a majority when that is 0. A
+// follower passes the check; its apply fails with ErrNotLeader as before.
+func (s *Server) checkWriteQuorum() error {
+	if !s.config.CheckWriteQuorum || s.raft.State() != raft.Leader {
+		return nil
+	}
+
+	configFuture := s.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+
+	voters, reachable := 0, 0
+	now := time.Now()
+	s.voterContact.mu.Lock()
+	for _, server := range configFuture.Configuration().Servers {
+		if server.Suffrage != raft.Voter {
+			continue
+		}
+		voters++
+		if !s.voterContact.failing(server.ID, now) || server.ID == s.self.ID {
+			reachable++
+		}
+	}
+	s.voterContact.mu.Unlock()
+
+	required := s.config.WriteQuorum
+	if majority := voters/2 + 1; required < majority {
+		required = majority
+	}
+	if reachable < required {
+		return fmt.Errorf("%w: %d of %d voters reachable, %d required", errNoQuorum, reachable, voters, required)
+	}
+	return nil
+}
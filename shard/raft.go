@@ -5,61 +5,160 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/hashicorp/raft"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
 )
 
 type JoinRequest struct {
 	NodeID string `json:"nodeid"`
 	Addr   string `json:"addr"`
+	// Nonvoter adds the node without a vote; joining again without it promotes the node
+	Nonvoter bool `json:"nonvoter,omitempty"`
 }
 
+// LeaveRequest names the server to remove by ID, or by raft address when the ID is not known
 type LeaveRequest struct {
-	NodeID string `json:"nodeid"`
+	NodeID string `json:"nodeid,omitempty"`
+	Addr   string `json:"addr,omitempty"`
+}
+
+// membershipRetries is how many times a membership change is retried after
+// losing a race with a concurrent change. Retries back off from
+// membershipRetryBackoff, giving the competing change time to commit.
+const (
+	membershipRetries      = 8
+	membershipRetryBackoff = 50 * time.Millisecond
+)
+
+// leaderElectionWait is how long /raft/leave waits for the remaining servers
+// to elect a new leader after removing the current one
+const leaderElectionWait = 5 * time.Second
+
+// configurationIndex returns the log index of the latest committed raft
+// configuration, for use as the prevIndex of a membership change
+func (s Server) configurationIndex() uint64 {
+	if store, ok := s.fsm.(*fsm.FSM); ok {
+		return store.ConfigurationIndex()
+	}
+	return 0
+}
+
+// isConfigurationChanged reports whether a membership change was rejected
+// because the configuration moved past the prevIndex it was made against
+func isConfigurationChanged(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "configuration changed since")
+}
+
+// membershipErrorStatus maps a failed membership change like a failed apply,
+// so a leader that lost leadership midway answers 421 NOT_LEADER and a
+// timeout 504; other failures keep MEMBERSHIP_ERROR
+func membershipErrorStatus(err error) (int, string) {
+	status, code := raftErrorStatus(err)
+	if code == api.CodeRaftApplyFailed {
+		code = api.CodeMembershipError
+	}
+	return status, code
 }
 
 func (s Server) RaftJoin(w http.ResponseWriter, r *http.Request) {
 	var req JoinRequest
-	
-	// Try to parse JSON body first, fallback to form data
-	if r.Header.Get("Content-Type") == "application/json" {
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			writeJSONError(w, http.StatusBadRequest, "Invalid JSON format")
-			return
-		}
-	} else {
-		// Fallback to form data for backward compatibility
-		req.NodeID = r.FormValue("nodeid")
-		req.Addr = r.FormValue("addr")
+	if !decodeRequest(w, r, &req) {
+		return
 	}
 
-	if req.NodeID == "" || req.Addr == "" {
-		writeJSONError(w, http.StatusBadRequest, "NodeID and address are required")
+	if req.NodeID == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("nodeid"))
 		return
 	}
-
-	if s.raft.State() != raft.Leader {
-		writeJSONError(w, http.StatusBadRequest, "This node is not the leader")
+	if req.Addr == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("addr"))
 		return
 	}
 
-	configFuture := s.raft.GetConfiguration()
-	if err := configFuture.Error(); err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "Failed to get raft configuration")
+	if s.raft.State() != raft.Leader {
+		s.writeApplyError(w, raft.ErrNotLeader)
 		return
 	}
 
-	f := s.raft.AddVoter(raft.ServerID(req.NodeID), raft.ServerAddress(req.Addr), 0, 0)
-	if f.Error() != nil {
-		writeJSONError(w, http.StatusInternalServerError, "Failed to add voter: "+f.Error().Error())
-		return
+	// Each attempt is checked and applied against one configuration index, so
+	// a concurrent membership change makes AddVoter fail instead of racing it
+	message := "Node joined successfully"
+	for attempt := 0; ; attempt++ {
+		// Read the index before the configuration: if they disagree, the
+		// configuration is the newer one and AddVoter fails and retries
+		prevIndex := s.configurationIndex()
+		configFuture := s.raft.GetConfiguration()
+		if err := configFuture.Error(); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, api.CodeConfigError, "Failed to get raft configuration")
+			return
+		}
+
+		// Reject joins that would give one node ID two addresses (or one address two IDs)
+		promote := false
+		for _, server := range configFuture.Configuration().Servers {
+			idMatch := server.ID == raft.ServerID(req.NodeID)
+			addrMatch := server.Address == raft.ServerAddress(req.Addr)
+
+			// A nonvoter joining again as a voter is promoted
+			if idMatch && addrMatch && server.Suffrage != raft.Voter && !req.Nonvoter {
+				promote = true
+				continue
+			}
+			if idMatch && addrMatch {
+				response := APIResponse{
+					Success: true,
+					Message: "Node is already a member of the cluster",
+					Data: map[string]string{
+						"nodeid": req.NodeID,
+						"addr":   req.Addr,
+					},
+				}
+				writeJSONResponse(w, http.StatusOK, response)
+				return
+			}
+			if idMatch {
+				writeJSONError(w, http.StatusConflict, api.CodeNodeConflict, fmt.Sprintf("Node ID %s is already in use by %s; remove it with /raft/leave before joining from %s", req.NodeID, server.Address, req.Addr))
+				return
+			}
+			if addrMatch {
+				writeJSONError(w, http.StatusConflict, api.CodeNodeConflict, fmt.Sprintf("Address %s is already in use by node %s", req.Addr, server.ID))
+				return
+			}
+		}
+
+		addServer := s.raft.AddVoter
+		if req.Nonvoter {
+			addServer = s.raft.AddNonvoter
+		}
+		err := addServer(raft.ServerID(req.NodeID), raft.ServerAddress(req.Addr), prevIndex, 0).Error()
+		if isConfigurationChanged(err) && attempt < membershipRetries {
+			log.Printf("[RAFT-JOIN] configuration changed while adding %s, retrying", req.NodeID)
+			time.Sleep(membershipRetryBackoff << attempt)
+			continue
+		}
+		if err != nil {
+			status, code := membershipErrorStatus(err)
+			writeJSONError(w, status, code, "Failed to add voter: "+err.Error())
+			return
+		}
+		if promote {
+			message = "Node promoted to voter"
+		}
+		break
 	}
 
 	response := APIResponse{
 		Success: true,
-		Message: "Node joined successfully",
+		Message: message,
 		Data: map[string]string{
 			"nodeid": req.NodeID,
 			"addr":   req.Addr,
@@ -70,6 +169,22 @@ func (s Server) RaftJoin(w http.ResponseWriter, r *http.Request) {
 
 func (s Server) RaftStatus(w http.ResponseWriter, r *http.Request) {
 	stats := s.raft.Stats()
+	if store, ok := s.fsm.(*fsm.FSM); ok {
+		stats["dead_letter_entries"] = strconv.FormatUint(store.DeadLetterCount(), 10)
+		eviction := store.EvictionStats()
+		stats["max_keys"] = strconv.Itoa(eviction.MaxKeys)
+		stats["evicted_keys"] = strconv.FormatUint(eviction.Evicted, 10)
+		stats["eviction_digest"] = strconv.FormatUint(eviction.Digest, 16)
+	}
+	stats["node_id"] = string(s.self.ID)
+	stats["raft_addr"] = string(s.self.Address)
+	build := buildInfo()
+	stats["version"] = build.Version
+	stats["commit"] = build.Commit
+	stats["build_date"] = build.BuildDate
+	stats["commit_latency_avg"] = s.commitLatency.average().String()
+	stats["commit_sla"] = s.config.CommitSLA.String()
+	stats["commit_sla_breached"] = strconv.FormatBool(s.commitLatency.isBreached())
 	
 	response := APIResponse{
 		Success: true,
@@ -81,45 +196,270 @@ func (s Server) RaftStatus(w http.ResponseWriter, r *http.Request) {
 
 func (s Server) RaftLeave(w http.ResponseWriter, r *http.Request) {
 	var req LeaveRequest
-	
-	// Try to parse JSON body first, fallback to form data
-	if r.Header.Get("Content-Type") == "application/json" {
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			writeJSONError(w, http.StatusBadRequest, "Invalid JSON format")
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if req.NodeID == "" && req.Addr == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "\"nodeid\" or \"addr\" is required: "+inputPrecedence)
+		return
+	}
+
+	if s.raft.State() != raft.Leader {
+		s.writeApplyError(w, raft.ErrNotLeader)
+		return
+	}
+
+	var remaining []raft.Server
+	for attempt := 0; ; attempt++ {
+		// As in RaftJoin, the index is read before the configuration it guards
+		prevIndex := s.configurationIndex()
+		configFuture := s.raft.GetConfiguration()
+		if err := configFuture.Error(); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, api.CodeConfigError, "Failed to get raft configuration")
 			return
 		}
-	} else {
-		// Fallback to form data for backward compatibility
-		req.NodeID = r.FormValue("nodeid")
+		servers := configFuture.Configuration().Servers
+
+		// A server known only by address is looked up against the same
+		// configuration index the removal is made against
+		if req.Addr != "" {
+			id, status, code, msg := serverAtAddress(servers, req.Addr)
+			if msg != "" {
+				writeJSONError(w, status, code, msg)
+				return
+			}
+			if req.NodeID != "" && req.NodeID != string(id) {
+				writeJSONError(w, http.StatusConflict, api.CodeNodeConflict, fmt.Sprintf("Address %s belongs to node %s, not %s", req.Addr, id, req.NodeID))
+				return
+			}
+			req.NodeID = string(id)
+		}
+
+		// Without a voter nobody can ever be elected again, so the cluster
+		// could not even take the node back; -min_voters raises the floor
+		remaining = withoutServer(servers, raft.ServerID(req.NodeID))
+		if voters := countVoters(remaining); voters < countVoters(servers) && voters < s.config.MinVoters {
+			msg := fmt.Sprintf("Removing node %s would leave %d voters, below -min_voters %d", req.NodeID, voters, s.config.MinVoters)
+			if voters == 0 {
+				msg = fmt.Sprintf("Node %s is the last voter; removing it would leave the cluster permanently unavailable", req.NodeID)
+			}
+			writeJSONError(w, http.StatusConflict, api.CodeNodeConflict, msg)
+			return
+		}
+
+		err := s.raft.RemoveServer(raft.ServerID(req.NodeID), prevIndex, 0).Error()
+		if isConfigurationChanged(err) && attempt < membershipRetries {
+			log.Printf("[RAFT-LEAVE] configuration changed while removing %s, retrying", req.NodeID)
+			time.Sleep(membershipRetryBackoff << attempt)
+			continue
+		}
+		if err != nil {
+			status, code := membershipErrorStatus(err)
+			writeJSONError(w, status, code, fmt.Sprintf("Failed to remove node %s: %s", req.NodeID, err.Error()))
+			return
+		}
+		break
 	}
 
-	if req.NodeID == "" {
-		writeJSONError(w, http.StatusBadRequest, "NodeID is required")
+	data := map[string]string{"nodeid": req.NodeID}
+	if req.Addr != "" {
+		data["addr"] = req.Addr
+	}
+	message := "Node removed successfully"
+
+	// A leader that removes itself steps down; the remaining servers elect a
+	// new one, which this node no longer hears about from raft
+	if raft.ServerID(req.NodeID) == s.self.ID {
+		if leader, ok := s.awaitNewLeader(remaining); ok {
+			data["new_leader"] = string(leader)
+			message = fmt.Sprintf("Node removed successfully; node %s is the new leader", leader)
+		} else {
+			data["warning"] = "re-election in progress"
+			message = fmt.Sprintf("Node removed; no new leader was elected within %s", leaderElectionWait)
+		}
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: message,
+		Data:    data,
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
+
+// withoutServer returns servers minus the one with the given ID
+func withoutServer(servers []raft.Server, id raft.ServerID) []raft.Server {
+	var rest []raft.Server
+	for _, server := range servers {
+		if server.ID != id {
+			rest = append(rest, server)
+		}
+	}
+	return rest
+}
+
+func countVoters(servers []raft.Server) int {
+	voters := 0
+	for _, server := range servers {
+		if server.Suffrage == raft.Voter {
+			voters++
+		}
+	}
+	return voters
+}
+
+// awaitNewLeader polls the /raft/status of servers until one of them reports
+// being leader, for up to leaderElectionWait
+func (s Server) awaitNewLeader(servers []raft.Server) (raft.ServerID, bool) {
+	deadline := time.Now().Add(leaderElectionWait)
+	for time.Now().Before(deadline) {
+		for _, server := range servers {
+			status, err := fetchRaftStatus(s.peerURL(server.Address))
+			if err == nil && status["state"] == raft.Leader.String() {
+				return server.ID, true
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return "", false
+}
+
+// serverAtAddress returns the ID of the one server in servers with the given
+// raft address. When there is not exactly one, it returns the error status,
+// code and message to answer with instead.
+func serverAtAddress(servers []raft.Server, addr string) (raft.ServerID, int, string, string) {
+	var matches []raft.ServerID
+	for _, server := range servers {
+		if server.Address == raft.ServerAddress(addr) {
+			matches = append(matches, server.ID)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", http.StatusNotFound, api.CodeNodeNotFound, fmt.Sprintf("No server has raft address %s; see /raft/peers", addr)
+	case 1:
+		return matches[0], 0, "", ""
+	default:
+		return "", http.StatusConflict, api.CodeNodeConflict, fmt.Sprintf("Raft address %s is used by %d servers (%v); remove one by nodeid", addr, len(matches), matches)
+	}
+}
+
+// RaftVerify confirms through a quorum heartbeat that this node is still the
+// leader, which State() alone cannot tell during a partition
+func (s Server) RaftVerify(w http.ResponseWriter, r *http.Request) {
+	if err := s.raft.VerifyLeader().Error(); err != nil {
+		writeJSONError(w, http.StatusMisdirectedRequest, api.CodeNotLeader, "Leadership not confirmed: "+err.Error())
 		return
 	}
 
-	if s.raft.State() != raft.Leader {
-		writeJSONError(w, http.StatusBadRequest, "This node is not the leader")
+	response := APIResponse{
+		Success: true,
+		Message: "Leadership confirmed by a quorum",
+		Data: map[string]string{
+			"node_id":   string(s.self.ID),
+			"raft_addr": string(s.self.Address),
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
+
+// RaftSnapshot takes a snapshot of this node's FSM now, compacting its log
+func (s Server) RaftSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshotFuture := s.raft.Snapshot()
+	if err := snapshotFuture.Error(); err != nil {
+		if errors.Is(err, raft.ErrNothingNewToSnapshot) {
+			writeJSONResponse(w, http.StatusOK, APIResponse{
+				Success: true,
+				Message: "No new log entries since the last snapshot",
+			})
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Snapshot failed: "+err.Error())
 		return
 	}
 
+	meta, reader, err := snapshotFuture.Open()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to open snapshot: "+err.Error())
+		return
+	}
+	reader.Close()
+
+	log.Printf("[RAFT-SNAPSHOT] snapshot %s taken at index %d", meta.ID, meta.Index)
+
+	response := APIResponse{
+		Success: true,
+		Message: "Snapshot taken successfully",
+		Data: map[string]interface{}{
+			"id":    meta.ID,
+			"index": meta.Index,
+			"term":  meta.Term,
+			"size":  meta.Size,
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
+
+// PeerInfo describes a single server in the raft configuration
+type PeerInfo struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	Suffrage string `json:"suffrage"`
+	Leader   bool   `json:"leader"`
+}
+
+func (s Server) RaftPeers(w http.ResponseWriter, r *http.Request) {
 	configFuture := s.raft.GetConfiguration()
 	if err := configFuture.Error(); err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "Failed to get raft configuration")
+		writeJSONError(w, http.StatusInternalServerError, api.CodeConfigError, "Failed to get raft configuration")
 		return
 	}
 
-	future := s.raft.RemoveServer(raft.ServerID(req.NodeID), 0, 0)
-	if err := future.Error(); err != nil {
-		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to remove node %s: %s", req.NodeID, err.Error()))
+	_, leaderID := s.raft.LeaderWithID()
+
+	peers := make([]PeerInfo, 0, len(configFuture.Configuration().Servers))
+	for _, server := range configFuture.Configuration().Servers {
+		peers = append(peers, PeerInfo{
+			ID:       string(server.ID),
+			Address:  string(server.Address),
+			Suffrage: server.Suffrage.String(),
+			Leader:   server.ID == leaderID,
+		})
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: "Raft peers retrieved successfully",
+		Data: map[string]interface{}{
+			"index": s.configurationIndex(),
+			"peers": peers,
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
+
+// RaftLeader reports the leader this node knows of, without the rest of
+// /raft/status; 503 while there is none, such as during an election
+func (s Server) RaftLeader(w http.ResponseWriter, r *http.Request) {
+	leaderAddr, leaderID := s.raft.LeaderWithID()
+	if leaderAddr == "" {
+		writeJSONError(w, http.StatusServiceUnavailable, api.CodeNoLeader, "No leader is currently known; an election may be in progress")
 		return
 	}
 
+	term, _ := strconv.ParseUint(s.raft.Stats()["term"], 10, 64)
+
 	response := APIResponse{
 		Success: true,
-		Message: "Node removed successfully",
-		Data: map[string]string{
-			"nodeid": req.NodeID,
+		Message: "Raft leader retrieved successfully",
+		Data: map[string]interface{}{
+			"leader":    string(leaderAddr),
+			"leader_id": string(leaderID),
+			"url":       s.peerURL(leaderAddr),
+			"is_self":   leaderID == s.self.ID,
+			"term":      term,
 		},
 	}
 	writeJSONResponse(w, http.StatusOK, response)
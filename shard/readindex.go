@@ -0,0 +1,71 @@
+// KV-Raft: Linearizable reads by read index, without writing to the log
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+// errReadIndexTimeout reports that the state machine did not reach a read's
+// index within maxIndexWait
+var errReadIndexTimeout = errors.New("state machine did not reach the read index in time")
+
+// leaderTerm remembers this node's last log index when it was first seen
+// leading a term. Every entry an earlier leader committed is at or below it.
+type leaderTerm struct {
+	mu         sync.Mutex
+	term       uint64
+	startIndex uint64
+}
+
+// termStartIndex returns the last log index recorded for term, recording the
+// current one the first time term is seen
+func (s *Server) termStartIndex(term uint64) uint64 {
+	s.leaderTerm.mu.Lock()
+	defer s.leaderTerm.mu.Unlock()
+
+	if s.leaderTerm.term != term {
+		s.leaderTerm.term = term
+		s.leaderTerm.startIndex = s.raft.LastIndex()
+	}
+	return s.leaderTerm.startIndex
+}
+
+// readIndex makes the state machine safe to read linearizably on the leader.
+// It takes the commit index, confirms with a quorum heartbeat that this node
+// still leads, and waits until that index has been applied here. A write
+// acknowledged before the read started is then visible, with nothing written
+// to the log.
+//
+// A new leader's commit index can trail what the old leader committed until
+// an entry of its own term commits, so the read also waits for the leader's
+// log as of the start of its term.
+func (s *Server) readIndex(ctx context.Context) error {
+	if s.raft.State() != raft.Leader {
+		return raft.ErrNotLeader
+	}
+
+	term := s.raft.CurrentTerm()
+	index := s.raft.CommitIndex()
+	if start := s.termStartIndex(term); start > index {
+		index = start
+	}
+
+	if err := s.raft.VerifyLeader().Error(); err != nil {
+		return err
+	}
+	if s.raft.CurrentTerm() != term {
+		return raft.ErrLeadershipLost
+	}
+
+	if !s.waitForIndex(ctx, index) {
+		return errReadIndexTimeout
+	}
+	return nil
+}
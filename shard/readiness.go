@@ -0,0 +1,144 @@
+// KV-Raft: Readiness gate based on how far this node's log lags the leader
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+)
+
+const readinessInterval = 1 * time.Second
+
+// readiness is the last measured replication lag of this node
+type readiness struct {
+	mu                sync.RWMutex
+	ready             bool
+	appliedIndex      uint64
+	leaderCommitIndex uint64
+	reason            string
+}
+
+// isReady reports whether this node was within the lag threshold at the last check
+func (s *Server) isReady() bool {
+	s.readiness.mu.RLock()
+	defer s.readiness.mu.RUnlock()
+	return s.readiness.ready
+}
+
+// checkReadiness compares the index this node has applied with the leader's
+// commit index. The leader is always ready; a follower is ready once it is at
+// most config.ReadyMaxLag entries behind.
+func (s *Server) checkReadiness() {
+	applied := s.raft.AppliedIndex()
+	ready, leaderCommit, reason := false, uint64(0), ""
+
+	leaderAddr, _ := s.raft.LeaderWithID()
+	switch {
+	case s.raft.State() == raft.Leader:
+		ready, leaderCommit = true, s.raft.CommitIndex()
+	case leaderAddr == "":
+		reason = "no raft leader"
+	default:
+		commit, err := fetchCommitIndex(s.peerURL(leaderAddr))
+		if err != nil {
+			reason = "leader unreachable: " + err.Error()
+			break
+		}
+		leaderCommit = commit
+		if commit <= applied || commit-applied <= s.config.ReadyMaxLag {
+			ready = true
+		} else {
+			reason = fmt.Sprintf("%d entries behind the leader", commit-applied)
+		}
+	}
+
+	s.readiness.mu.Lock()
+	s.readiness.ready = ready
+	s.readiness.appliedIndex = applied
+	s.readiness.leaderCommitIndex = leaderCommit
+	s.readiness.reason = reason
+	s.readiness.mu.Unlock()
+}
+
+// fetchCommitIndex asks the /raft/status of the node at baseURL for its commit index
+func fetchCommitIndex(baseURL string) (uint64, error) {
+	status, err := fetchRaftStatus(baseURL)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(status["commit_index"], 10, 64)
+}
+
+// fetchRaftStatus returns the raft stats the node at baseURL reports on /raft/status
+func fetchRaftStatus(baseURL string) (map[string]string, error) {
+	client := http.Client{Timeout: tcpTimeout}
+	resp, err := client.Get(baseURL + "/raft/status")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var status struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+	return status.Data, nil
+}
+
+// trackReadiness re-checks readiness every readinessInterval until ctx is done
+func (s *Server) trackReadiness(ctx context.Context) {
+	ticker := time.NewTicker(readinessInterval)
+	defer ticker.Stop()
+
+	for {
+		s.checkReadiness()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	s.readiness.mu.RLock()
+	data := map[string]interface{}{
+		"ready":               s.readiness.ready,
+		"applied_index":       s.readiness.appliedIndex,
+		"leader_commit_index": s.readiness.leaderCommitIndex,
+		"max_lag":             s.config.ReadyMaxLag,
+	}
+	ready, reason := s.readiness.ready, s.readiness.reason
+	s.readiness.mu.RUnlock()
+
+	if !ready {
+		response := APIResponse{
+			Success: false,
+			Error:   "Node is not ready: " + reason,
+			Code:    api.CodeNotReady,
+			Data:    data,
+		}
+		writeJSONResponse(w, http.StatusServiceUnavailable, response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: "Node is ready",
+		Data:    data,
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
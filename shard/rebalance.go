@@ -0,0 +1,460 @@
+// KV-Raft: Moving keys to the shard that owns them after the ring changes
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+// maxRebalanceRate is the highest -rebalance_rate, and rate a /rebalance may ask for
+const maxRebalanceRate = 10000
+
+// States of a group's latest rebalance, reported by /rebalance/status
+const (
+	RebalanceIdle    = "idle"    // none has run since this node became leader
+	RebalanceRunning = "running" // moving keys
+	RebalanceStopped = "stopped" // ended early by /rebalance/stop or shutdown
+	RebalanceDone    = "done"    // every key found was dealt with
+	RebalanceFailed  = "failed"  // ended by an error, see Error
+)
+
+// RebalanceStatus is the progress of a group's latest rebalance
+type RebalanceStatus struct {
+	State string `json:"state"`
+	Ring  []int  `json:"ring,omitempty"`
+	Rate  int    `json:"rate,omitempty"` // keys moved per second at most
+
+	Found      int `json:"found"`      // keys owned by another shard when the run started
+	Moved      int `json:"moved"`      // copied to their owner, then deleted here
+	Superseded int `json:"superseded"` // already held by their owner, so deleted here without copying
+	Skipped    int `json:"skipped"`    // written here during their move, so left for the next run
+	Deleted    int `json:"deleted"`    // deleted here by a client before their move
+
+	LastKey    string     `json:"last_key,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// rebalancer tracks the rebalance run by this group's leader. At most one
+// runs at a time.
+type rebalancer struct {
+	mu     sync.Mutex
+	status RebalanceStatus
+	cancel context.CancelFunc
+}
+
+func newRebalancer() *rebalancer {
+	return &rebalancer{status: RebalanceStatus{State: RebalanceIdle}}
+}
+
+func (b *rebalancer) current() RebalanceStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status
+}
+
+func (b *rebalancer) update(f func(status *RebalanceStatus)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f(&b.status)
+}
+
+// finish records how the run ended
+func (b *rebalancer) finish(state string, err error) {
+	b.update(func(status *RebalanceStatus) {
+		now := time.Now()
+		status.State = state
+		status.FinishedAt = &now
+		if err != nil {
+			status.Error = err.Error()
+		}
+	})
+}
+
+// stop ends the running rebalance, if any
+func (b *rebalancer) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.status.State == RebalanceRunning {
+		b.cancel()
+	}
+}
+
+// moveOutcome is what became of one key in a rebalance
+type moveOutcome int
+
+const (
+	keyMoved moveOutcome = iota
+	keySuperseded
+	keySkipped
+	keyDeleted
+)
+
+// RebalanceHandler starts moving the keys this group no longer owns under
+// the given ring, by default the -shards list, to their owners. The keys
+// are moved one at a time at up to rate per second, in the background; poll
+// /rebalance/status for progress. Only moved keys are deleted here, so
+// running it again after a failure or a stop picks up where it ended.
+func (us *UnifiedServer) RebalanceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSONError(w, http.StatusMethodNotAllowed, api.CodeInvalidRequest, "Use POST to start a rebalance")
+		return
+	}
+
+	var req RebalanceRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if us.locator == nil || us.server.config.Group == 0 {
+		WriteJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Rebalancing moves keys between the groups of -shards; this process hosts a single group")
+		return
+	}
+
+	ring := us.locator.ring
+	if req.Ring != "" {
+		parsed, err := parseShardGroups(req.Ring)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid ring: "+err.Error())
+			return
+		}
+		ring = parsed
+	}
+	// The owners' leaders are found through the groups hosted here
+	for _, id := range ring {
+		if us.locator.servers[id] == nil {
+			WriteJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("Shard %d is not hosted by this process", id))
+			return
+		}
+	}
+
+	rate := us.server.config.RebalanceRate
+	if req.Rate < 0 || req.Rate > maxRebalanceRate {
+		WriteJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("Rate must be between 1 and %d keys per second", maxRebalanceRate))
+		return
+	}
+	if req.Rate > 0 {
+		rate = req.Rate
+	}
+
+	store, ok := us.fsm.(*fsm.FSM)
+	if !ok {
+		WriteJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Rebalancing needs the key-value state machine")
+		return
+	}
+
+	// Only the leader can delete the moved keys, so it runs the rebalance
+	if us.raft.State() != raft.Leader {
+		query := url.Values{}
+		query.Set("ring", req.Ring)
+		query.Set("rate", strconv.Itoa(req.Rate))
+		us.server.forwardToLeader(w, r, http.MethodPost, "/rebalance?"+query.Encode(), nil)
+		return
+	}
+
+	status, started := us.startRebalance(store, ring, rate)
+	if !started {
+		writeJSONResponse(w, http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   "A rebalance is already running",
+			Code:    api.CodeRebalancing,
+			Data:    status,
+		})
+		return
+	}
+
+	log.Printf("[REBALANCE] shard %d started moving keys to ring %v at %d keys/s", us.server.config.Group, ring, rate)
+
+	writeJSONResponse(w, http.StatusAccepted, APIResponse{
+		Success: true,
+		Message: "Rebalance started",
+		Data:    status,
+	})
+}
+
+// RebalanceStatusHandler reports the progress of the leader's latest rebalance
+func (us *UnifiedServer) RebalanceStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if us.raft.State() != raft.Leader {
+		us.server.forwardToLeader(w, r, http.MethodGet, "/rebalance/status", nil)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Rebalance status",
+		Data:    us.rebalancer.current(),
+	})
+}
+
+// RebalanceStopHandler ends the running rebalance after the key being moved.
+// Keys moved so far stay moved.
+func (us *UnifiedServer) RebalanceStopHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSONError(w, http.StatusMethodNotAllowed, api.CodeInvalidRequest, "Use POST to stop a rebalance")
+		return
+	}
+	if us.raft.State() != raft.Leader {
+		us.server.forwardToLeader(w, r, http.MethodPost, "/rebalance/stop", nil)
+		return
+	}
+
+	us.rebalancer.stop()
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Rebalance stopping",
+		Data:    us.rebalancer.current(),
+	})
+}
+
+// startRebalance starts a run unless one is running, returning its status
+// and whether it started
+func (us *UnifiedServer) startRebalance(store *fsm.FSM, ring []int, rate int) (RebalanceStatus, bool) {
+	b := us.rebalancer
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.status.State == RebalanceRunning {
+		return b.status, false
+	}
+
+	ctx, cancel := context.WithCancel(us.ctx)
+	now := time.Now()
+	b.status = RebalanceStatus{
+		State:     RebalanceRunning,
+		Ring:      ring,
+		Rate:      rate,
+		StartedAt: &now,
+	}
+	b.cancel = cancel
+
+	us.wg.Add(1)
+	go func() {
+		defer us.wg.Done()
+		defer cancel()
+		us.runRebalance(ctx, store, ring, rate)
+	}()
+	return b.status, true
+}
+
+// runRebalance moves every key owned by another shard under ring, one per
+// tick of the rate limit, and stops at the first error
+func (us *UnifiedServer) runRebalance(ctx context.Context, store *fsm.FSM, ring []int, rate int) {
+	group := us.server.config.Group
+	var moving []string
+	for _, key := range store.Keys() {
+		if api.OwningShard("", key, ring) != group {
+			moving = append(moving, key)
+		}
+	}
+	us.rebalancer.update(func(status *RebalanceStatus) {
+		status.Found = len(moving)
+	})
+
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+
+	for _, key := range moving {
+		select {
+		case <-ctx.Done():
+			us.rebalancer.finish(RebalanceStopped, nil)
+			return
+		case <-ticker.C:
+		}
+
+		if us.raft.State() != raft.Leader {
+			us.rebalancer.finish(RebalanceFailed, errors.New("this node is no longer the leader; start the rebalance again on the new leader"))
+			return
+		}
+
+		outcome, err := us.moveKey(ctx, store, key, api.OwningShard("", key, ring))
+		if err != nil {
+			if ctx.Err() != nil {
+				us.rebalancer.finish(RebalanceStopped, nil)
+				return
+			}
+			log.Printf("[REBALANCE] shard %d stopped at key %s: %v", group, key, err)
+			us.rebalancer.finish(RebalanceFailed, fmt.Errorf("key %q: %w", key, err))
+			return
+		}
+
+		us.rebalancer.update(func(status *RebalanceStatus) {
+			status.LastKey = key
+			switch outcome {
+			case keyMoved:
+				status.Moved++
+			case keySuperseded:
+				status.Superseded++
+			case keySkipped:
+				status.Skipped++
+			case keyDeleted:
+				status.Deleted++
+			}
+		})
+	}
+
+	log.Printf("[REBALANCE] shard %d finished moving %d keys", group, len(moving))
+	us.rebalancer.finish(RebalanceDone, nil)
+}
+
+// moveKey copies key to the leader of shard owner, unless the owner already
+// holds it, and then deletes it here if it still holds the value copied.
+// Writes reach the owner once clients use the new ring, so a value the owner
+// already holds is newer than this one, which is dropped.
+func (us *UnifiedServer) moveKey(ctx context.Context, store *fsm.FSM, key string, owner int) (moveOutcome, error) {
+	result, err := store.GetVersion(key, 0)
+	if errors.Is(err, fsm.ErrKeyNotFound) {
+		return keyDeleted, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	target := us.locator.servers[owner]
+	leaderAddr, _ := target.raft.LeaderWithID()
+	if leaderAddr == "" {
+		return 0, fmt.Errorf("shard %d has no leader", owner)
+	}
+	ownerURL := target.peerURL(leaderAddr)
+
+	held, err := ownerHolds(ctx, ownerURL, key)
+	if err != nil {
+		return 0, err
+	}
+	outcome := keySuperseded
+	if !held {
+		if err := putToOwner(ctx, ownerURL, key, result.Value); err != nil {
+			return 0, err
+		}
+		outcome = keyMoved
+	}
+
+	deleted, err := us.deleteIfUnchanged(key, result.Value)
+	if err != nil {
+		return 0, err
+	}
+	if !deleted {
+		return keySkipped, nil
+	}
+	return outcome, nil
+}
+
+// ownerHolds reports whether the leader at ownerURL has key, a key as stored
+// in the FSM. It is sent split into its namespace and key, since the owner
+// rejects a bare key containing "/".
+func ownerHolds(ctx context.Context, ownerURL, key string) (bool, error) {
+	namespace, key := splitStoreKey(key)
+	query := url.Values{"key": {key}}
+	if namespace != "" {
+		query.Set("namespace", namespace)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ownerURL+"/get?"+query.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := forwardClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, ownerError("read", resp)
+	}
+}
+
+// putToOwner writes key, split like in ownerHolds, to the leader at ownerURL.
+// The value goes as val_b64, so binary values arrive unchanged.
+func putToOwner(ctx context.Context, ownerURL, key, value string) error {
+	namespace, key := splitStoreKey(key)
+	body, err := json.Marshal(PutRequest{
+		Namespace: namespace,
+		Key:       key,
+		ValueB64:  base64.StdEncoding.EncodeToString([]byte(value)),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ownerURL+"/put", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := forwardClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ownerError("write", resp)
+	}
+	return nil
+}
+
+// ownerError describes a failed request to an owner's leader by its status and error message
+func ownerError(action string, resp *http.Response) error {
+	var apiResp APIResponse
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(body, &apiResp) == nil && apiResp.Error != "" {
+		return fmt.Errorf("owner failed to %s the key with %d: %s", action, resp.StatusCode, apiResp.Error)
+	}
+	return fmt.Errorf("owner failed to %s the key with %d", action, resp.StatusCode)
+}
+
+// deleteIfUnchanged deletes key through the log if it still holds value,
+// reporting whether it did
+func (us *UnifiedServer) deleteIfUnchanged(key, value string) (bool, error) {
+	payload := fsm.Payload{
+		OP:       fsm.DELIF,
+		Key:      key,
+		Expected: value,
+	}
+
+	data, err := us.server.marshalPayload(payload)
+	if err != nil {
+		return false, err
+	}
+
+	applyFuture := us.server.timedApply(payload.OP, data)
+	if err := applyFuture.Error(); err != nil {
+		return false, err
+	}
+
+	applyResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		return false, errors.New("invalid raft response")
+	}
+	switch {
+	case applyResponse.Error == nil:
+		return true, nil
+	case errors.Is(applyResponse.Error, fsm.ErrCASMismatch), errors.Is(applyResponse.Error, fsm.ErrKeyNotFound):
+		return false, nil
+	default:
+		return false, applyResponse.Error
+	}
+}
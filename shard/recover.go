@@ -0,0 +1,196 @@
+// KV-Raft: Starting over from a corrupt raft.db with -recover_corrupt
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+// isCorruptStore reports whether err from opening raft.db means the file is
+// damaged, as opposed to being locked or unreadable for some other reason
+func isCorruptStore(err error) bool {
+	return errors.Is(err, bolt.ErrInvalid) || errors.Is(err, bolt.ErrChecksum) || errors.Is(err, bolt.ErrVersionMismatch)
+}
+
+// recoveryFile in a store dir records the node ID a recovered node took on
+// and the one it replaced. It stays after the node has rejoined, since the
+// node keeps the new ID for good.
+const recoveryFile = "recovery.json"
+
+// recoveryState is the content of recoveryFile
+type recoveryState struct {
+	NodeID   string `json:"node_id"`
+	Replaces string `json:"replaces"`
+	Rejoined bool   `json:"rejoined"` // set once the node is a voter under NodeID
+}
+
+// readRecoveryState returns the recoveryFile of dir, and false if there is none
+func readRecoveryState(dir string) (recoveryState, bool, error) {
+	var state recoveryState
+	data, err := os.ReadFile(filepath.Join(dir, recoveryFile))
+	if os.IsNotExist(err) {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, false, fmt.Errorf("%s: %w", recoveryFile, err)
+	}
+	return state, true, nil
+}
+
+// writeRecoveryState replaces the recoveryFile of dir
+func writeRecoveryState(dir string, state recoveryState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, recoveryFile)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// localServerID is the raft ID of the node keeping its state in dir:
+// -node_id, unless the node recovered a corrupt store under a new ID
+func localServerID(dir string) (raft.ServerID, error) {
+	state, ok, err := readRecoveryState(dir)
+	if err != nil || !ok {
+		return raft.ServerID(*nodeID), err
+	}
+	return raft.ServerID(state.NodeID), nil
+}
+
+// openLogStore opens dir/raft.db. When bolt reports it corrupt and
+// -recover_corrupt is set, the store and the snapshots are moved aside with a
+// .corrupt-<unix time> suffix and the node starts with no raft state at all.
+//
+// The current term and vote were in raft.db too. A voter that forgot them
+// could vote twice in one term and help elect two leaders, so the node does
+// not come back as its old self: it takes a new ID, recorded in
+// recoveryFile, and StartRejoin replaces the old one in the cluster.
+func openLogStore(dir string) (*raftboltdb.BoltStore, error) {
+	path := filepath.Join(dir, "raft.db")
+	store, openErr := raftboltdb.NewBoltStore(path)
+	if openErr == nil || !isCorruptStore(openErr) {
+		return store, openErr
+	}
+	if !*recoverCorrupt {
+		return nil, fmt.Errorf("%s is corrupt: %w; move it aside or restart with -recover_corrupt to start this node over from the leader", path, openErr)
+	}
+	if *shards != "" || (*peerShards == "" && *discoverySRV == "") {
+		return nil, fmt.Errorf("%s is corrupt: %w; -recover_corrupt rejoins under a new node ID through -peer_shards or -discovery_srv, and not with -shards", path, openErr)
+	}
+
+	previous, err := localServerID(dir)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	state := recoveryState{NodeID: fmt.Sprintf("%s-r%d", *nodeID, now), Replaces: string(previous)}
+
+	suffix := fmt.Sprintf(".corrupt-%d", now)
+	log.Printf("[RECOVER] !!! %s is corrupt: %v", path, openErr)
+	if err := os.Rename(path, path+suffix); err != nil {
+		return nil, err
+	}
+	log.Printf("[RECOVER] !!! moved the corrupt store to %s", path+suffix)
+
+	snapshots := filepath.Join(dir, "snapshots")
+	if err := os.Rename(snapshots, snapshots+suffix); err == nil {
+		log.Printf("[RECOVER] !!! moved the snapshots to %s", snapshots+suffix)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := writeRecoveryState(dir, state); err != nil {
+		return nil, err
+	}
+	log.Printf("[RECOVER] !!! this node rejoins as %s in place of %s", state.NodeID, state.Replaces)
+
+	store, err = raftboltdb.NewBoltStore(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[RECOVER] !!! started %s empty; this node will not bootstrap", path)
+	return store, nil
+}
+
+// pendingRejoin returns the recovery of dir that has not yet finished
+// rejoining, if any, so that a node restarted midway carries on with it
+func pendingRejoin(dir string) (recoveryState, bool, error) {
+	state, ok, err := readRecoveryState(dir)
+	return state, ok && !state.Rejoined, err
+}
+
+// StartRejoin replaces the node ID a recovered node used to have with its new
+// one, through the leader found through the peers, retrying each step until
+// it succeeds or Stop is called:
+//
+//  1. the old ID is removed, so no voter is left that forgot its vote;
+//  2. the node joins as a nonvoter and the leader catches it up;
+//  3. once it is ready (see -ready_max_lag) it is promoted to voter.
+//
+// Every step may be repeated safely, so a node restarted midway starts over.
+func (us *UnifiedServer) StartRejoin(dir string, state recoveryState, peers func() []string) {
+	us.wg.Add(1)
+	go func() {
+		defer us.wg.Done()
+		defer us.rejoining.Store(false)
+
+		steps := []struct {
+			what   string
+			path   string
+			form   url.Values
+			alsoOK int         // an answer besides 200 that completes the step
+			ready  func() bool // holds the step back until it returns true
+		}{
+			{"remove " + state.Replaces, "/raft/leave", url.Values{"nodeid": {state.Replaces}}, http.StatusNotFound, nil},
+			{"join as a nonvoter", "/raft/join", url.Values{"nodeid": {state.NodeID}, "addr": {*raftaddr}, "nonvoter": {"true"}}, http.StatusOK, nil},
+			{"promote to voter", "/raft/join", url.Values{"nodeid": {state.NodeID}, "addr": {*raftaddr}}, http.StatusOK, us.server.isReady},
+		}
+
+		ticker := time.NewTicker(bootstrapPollInterval)
+		defer ticker.Stop()
+		for _, step := range steps {
+			for {
+				if step.ready == nil || step.ready() {
+					status, err := postToLeader(peers(), step.path, step.form)
+					if err == nil && (status == http.StatusOK || status == step.alsoOK) {
+						log.Printf("[RECOVER] %s: done", step.what)
+						break
+					}
+					log.Printf("[RECOVER] %s: not done (status %d, %v); retrying", step.what, status, err)
+				}
+				select {
+				case <-us.ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}
+
+		state.Rejoined = true
+		if err := writeRecoveryState(dir, state); err != nil {
+			log.Printf("[RECOVER] failed to record that %s rejoined: %v", state.NodeID, err)
+			return
+		}
+		log.Printf("[RECOVER] rejoined the cluster as voter %s", state.NodeID)
+	}()
+}
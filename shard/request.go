@@ -0,0 +1,162 @@
+// KV-Raft: Uniform request decoding from JSON bodies, query strings and forms
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"kv-raft/api"
+)
+
+// inputPrecedence is appended to 400 messages so clients know where fields are read from
+const inputPrecedence = "send it as a JSON body field or a query/form parameter; JSON body fields take precedence"
+
+// decodeRequest fills dst, a pointer to a struct with json tags, from the
+// request. A JSON body (Content-Type: application/json) is decoded first, then
+// any field it left empty is taken from the query string or a url-encoded
+// form body under the same name. On failure it writes a 400 and returns false,
+// or a 413 for a body past -max_request_bytes.
+//
+// With -strict_json, a body with fields dst does not have, or with anything
+// but whitespace after its first value, is rejected instead of half-read.
+func decodeRequest(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	if isJSONRequest(r) {
+		decoder := json.NewDecoder(r.Body)
+		if *strictJSON {
+			decoder.DisallowUnknownFields()
+		}
+		err := decoder.Decode(dst)
+		if bodyTooLarge(w, err) {
+			return false
+		}
+		if msg, ok := strictJSONError(decoder, err); !ok {
+			writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, msg)
+			return false
+		}
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			msg := fmt.Sprintf("%q must be a JSON %s, not %s", typeErr.Field, jsonKind(typeErr.Type), typeErr.Value)
+			writeJSONError(w, http.StatusBadRequest, api.CodeTypeMismatch, msg)
+			return false
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			writeJSONError(w, http.StatusBadRequest, api.CodeInvalidJSON, "Invalid JSON format")
+			return false
+		}
+	}
+
+	if err := r.ParseForm(); err != nil {
+		if bodyTooLarge(w, err) {
+			return false
+		}
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid query or form parameters")
+		return false
+	}
+
+	if err := fillFromForm(r, dst); err != nil {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+// strictJSONError reports, under -strict_json, why a body decoded by decoder
+// is not a single value with known fields; err is the result of decoding it
+func strictJSONError(decoder *json.Decoder, err error) (string, bool) {
+	if !*strictJSON {
+		return "", true
+	}
+	// encoding/json has no typed error for unknown fields
+	if err != nil && strings.HasPrefix(err.Error(), "json: unknown field ") {
+		return "Unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field "), false
+	}
+	if err == nil {
+		if extra := decoder.Decode(&json.RawMessage{}); !errors.Is(extra, io.EOF) {
+			return "Request body must hold a single JSON value, but more follows it", false
+		}
+	}
+	return "", true
+}
+
+// jsonKind names the JSON type a Go field type is decoded from
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	default:
+		return "number"
+	}
+}
+
+// missingField returns the 400 message for a required field that was not sent
+func missingField(name string) string {
+	return fmt.Sprintf("%q is required: %s", name, inputPrecedence)
+}
+
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// fillFromForm sets every empty field of dst that has a matching form value
+func fillFromForm(r *http.Request, dst interface{}) error {
+	v := reflect.ValueOf(dst).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		field := v.Field(i)
+		values, ok := r.Form[name]
+		if !ok || len(values) == 0 || !field.IsZero() {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(values[0])
+		case reflect.Slice:
+			if field.Type().Elem().Kind() == reflect.String {
+				field.Set(reflect.ValueOf(values))
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n, err := strconv.ParseUint(values[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("%q must be a non-negative integer", name)
+			}
+			field.SetUint(n)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(values[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("%q must be an integer", name)
+			}
+			field.SetInt(n)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(values[0])
+			if err != nil {
+				return fmt.Errorf("%q must be true or false", name)
+			}
+			field.SetBool(b)
+		}
+	}
+	return nil
+}
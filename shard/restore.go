@@ -0,0 +1,148 @@
+// KV-Raft: Disaster recovery by restoring an external snapshot
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+// restoreTokenHeader carries the -restore_token a /raft/restore must present
+const restoreTokenHeader = "X-KV-Raft-Restore-Token"
+
+// restoreTimeout bounds how long a restore waits for raft to take the
+// snapshot and for a quorum to commit the first entry after it
+const restoreTimeout = time.Minute
+
+// restoreIdleTimeout is how long the upload of a snapshot may stall. As with
+// /import, the upload as a whole may outlast -read_timeout and -write_timeout.
+const restoreIdleTimeout = 30 * time.Second
+
+// deadlineReader extends the connection's deadlines each time data arrives
+type deadlineReader struct {
+	r          io.Reader
+	controller *http.ResponseController
+	extended   time.Time
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if n > 0 && time.Since(d.extended) >= time.Second {
+		d.extended = time.Now()
+		d.controller.SetReadDeadline(d.extended.Add(restoreIdleTimeout))
+		d.controller.SetWriteDeadline(d.extended.Add(restoreIdleTimeout))
+	}
+	return n, err
+}
+
+// RaftRestore replaces the state of the whole cluster with the snapshot in the
+// request body. The body is the FSM snapshot JSON that every node writes to
+// store_dir/snapshots/<id>/state.bin. The leader adopts it through raft's
+// user restore and the followers install it as a snapshot, so every write not
+// in the uploaded snapshot is lost; in-flight writes fail with an error.
+//
+// It is destructive, so it is disabled unless the shard runs with
+// -restore_token, and the request must carry that token in
+// X-KV-Raft-Restore-Token.
+func (s *Server) RaftRestore(w http.ResponseWriter, r *http.Request) {
+	if s.config.RestoreToken == "" {
+		writeJSONError(w, http.StatusNotFound, api.CodeInvalidRequest, "Restore is disabled; start the shard with -restore_token")
+		return
+	}
+	token := r.Header.Get(restoreTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.RestoreToken)) != 1 {
+		writeJSONError(w, http.StatusForbidden, api.CodeForbidden, "Missing or wrong "+restoreTokenHeader)
+		return
+	}
+
+	// The body cannot be buffered for forwarding, so the client must send it to the leader
+	if s.raft.State() != raft.Leader {
+		leaderAddr, _ := s.raft.LeaderWithID()
+		writeJSONError(w, http.StatusMisdirectedRequest, api.CodeNotLeader, fmt.Sprintf("Restores must be sent to the leader at %s", s.peerURL(leaderAddr)))
+		return
+	}
+
+	// Raft needs the snapshot's exact size up front, so spool it to disk first
+	upload, err := os.CreateTemp("", "kv-raft-restore-*")
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to buffer snapshot: "+err.Error())
+		return
+	}
+	defer os.Remove(upload.Name())
+	defer upload.Close()
+
+	controller := http.NewResponseController(w)
+	controller.SetReadDeadline(time.Now().Add(restoreIdleTimeout))
+	controller.SetWriteDeadline(time.Now().Add(restoreIdleTimeout))
+	size, err := io.Copy(upload, &deadlineReader{r: r.Body, controller: controller})
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Failed to read snapshot: "+err.Error())
+		return
+	}
+	if size == 0 {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Request body must be a snapshot")
+		return
+	}
+
+	// Checking and rewriting the snapshot and raft's restore take as long as
+	// they need to; the response is written once they are done
+	controller.SetWriteDeadline(time.Time{})
+
+	// The snapshot is restored with this cluster's configuration index, not
+	// the one it was taken under
+	spool, err := os.CreateTemp("", "kv-raft-restore-*")
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to buffer snapshot: "+err.Error())
+		return
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	if _, err := upload.Seek(0, io.SeekStart); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to buffer snapshot: "+err.Error())
+		return
+	}
+	if err := fsm.PrepareRestore(upload, spool, s.configurationIndex()); err != nil {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Not a valid snapshot: "+err.Error())
+		return
+	}
+	spoolSize, err := spool.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to buffer snapshot: "+err.Error())
+		return
+	}
+
+	log.Printf("[RAFT-RESTORE] replacing the cluster state with a %d byte snapshot from %s", size, r.RemoteAddr)
+	meta := &raft.SnapshotMeta{Version: raft.SnapshotVersionMax, Size: spoolSize}
+	if err := s.raft.Restore(meta, spool, restoreTimeout); err != nil {
+		log.Printf("[RAFT-RESTORE] restore failed: %v", err)
+		s.writeApplyError(w, err)
+		return
+	}
+	log.Printf("[RAFT-RESTORE] cluster state replaced; applied index is now %d", s.raft.AppliedIndex())
+
+	response := APIResponse{
+		Success: true,
+		Message: "Snapshot restored; the cluster now holds its state",
+		Data: map[string]interface{}{
+			"size":          size,
+			"applied_index": s.raft.AppliedIndex(),
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/fsm"
+)
+
+// memorySink is a raft.SnapshotSink writing to memory
+type memorySink struct {
+	bytes.Buffer
+}
+
+func (s *memorySink) ID() string    { return "memory" }
+func (s *memorySink) Cancel() error { return nil }
+func (s *memorySink) Close() error  { return nil }
+
+// snapshotOf returns the bytes a node holding store writes to state.bin
+func snapshotOf(t *testing.T, store *fsm.FSM) []byte {
+	t.Helper()
+	snap, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	sink := &memorySink{}
+	if err := snap.Persist(sink); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	return sink.Bytes()
+}
+
+func TestRaftRestoreRoundTrip(t *testing.T) {
+	// The backup comes from a cluster whose configuration is at index 42
+	backup := fsm.NewFSM(fsm.Options{HistoryDepth: 1}).(*fsm.FSM)
+	backup.Put("a", "backup")
+	backup.Put("b", "backup")
+	backup.StoreConfiguration(42, raft.Configuration{})
+
+	// The cluster restored into has its configuration at index 7 and a key the backup lacks
+	store := fsm.NewFSM(fsm.Options{HistoryDepth: 1}).(*fsm.FSM)
+	store.Put("c", "lost")
+	store.StoreConfiguration(7, raft.Configuration{})
+	s := New(&fakeRaft{fsm: store, state: raft.Leader}, store, raft.Server{ID: "n1", Address: "127.0.0.1:1"}, Config{
+		ApplyTimeout: 50 * time.Millisecond,
+		RestoreToken: "token",
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/raft/restore", bytes.NewReader(snapshotOf(t, backup)))
+	req.Header.Set(restoreTokenHeader, "token")
+	rec := httptest.NewRecorder()
+	s.RaftRestore(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("restore answered %d: %s", rec.Code, rec.Body.String())
+	}
+
+	for key, want := range map[string]string{"a": "backup", "b": "backup"} {
+		if value, err := store.Get(key); err != nil || value != want {
+			t.Errorf("Get(%s) = %v, %v; want %s", key, value, err, want)
+		}
+	}
+	if _, err := store.Get("c"); err == nil {
+		t.Error("c is still present after the restore")
+	}
+	// Membership changes are made against this cluster's index, not the backup's
+	if got := store.ConfigurationIndex(); got != 7 {
+		t.Errorf("ConfigurationIndex after restore = %d, want 7", got)
+	}
+
+	// A snapshot taken after the restore holds the same state
+	again := fsm.NewFSM(fsm.Options{HistoryDepth: 1}).(*fsm.FSM)
+	if err := again.Restore(io.NopCloser(bytes.NewReader(snapshotOf(t, store)))); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if value, err := again.Get("a"); err != nil || value != "backup" || again.ConfigurationIndex() != 7 {
+		t.Errorf("after a second round trip Get(a) = %v, %v and the index is %d", value, err, again.ConfigurationIndex())
+	}
+}
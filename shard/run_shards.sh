@@ -13,7 +13,7 @@ run_single_shard () {
     echo "Starting shard $SHARD_ID on port $PORT (raft: $RAFT_PORT)"
     
     # Start single shard node
-    go run -C shard . --shard_id "$SHARD_ID" --node_id "$SHARD_ID" --port "$PORT" --raft_addr "localhost:$RAFT_PORT" \
+    go run -C shard . --shard_id "$SHARD_ID" --node_id "$SHARD_ID" --port "$PORT" --raft_addr "localhost:$RAFT_PORT" --allow_ephemeral \
         &> "$LOGDIR/shard_${SHARD_ID}.log" &
     echo "Shard $SHARD_ID started (pid $!)"
 }
@@ -0,0 +1,144 @@
+// KV-Raft: Paged listing of keys by prefix
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+// defaultScanLimit is the page size of a scan without a limit, and
+// maxScanLimit the largest one accepted
+const (
+	defaultScanLimit = 100
+	maxScanLimit     = 1000
+)
+
+// scanTimeout bounds the walk over the store for one page. A selective glob
+// can walk every key of a large store to fill a page.
+const scanTimeout = 5 * time.Second
+
+// ScanHandler lists the keys starting with a prefix in key order, a page at
+// a time. Like /txget it runs one read-index barrier on the leader and then
+// reads the page with no entry applied in between.
+func (s *Server) ScanHandler(w http.ResponseWriter, r *http.Request) {
+	var req ScanRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if req.Limit == 0 {
+		req.Limit = defaultScanLimit
+	}
+	if req.Limit < 0 || req.Limit > maxScanLimit {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("limit must be between 1 and %d", maxScanLimit))
+		return
+	}
+
+	if !validNamespace(req.Namespace) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Namespace must not contain '/'")
+		return
+	}
+	if req.Unsorted && req.After != "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "An unsorted scan has no order to resume after; leave out after")
+		return
+	}
+	if _, err := path.Match(req.Glob, ""); err != nil {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("Invalid glob %q: %v", req.Glob, err))
+		return
+	}
+
+	store, ok := s.fsm.(*fsm.FSM)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Scans need the key-value state machine")
+		return
+	}
+
+	forward := func() {
+		query := url.Values{}
+		query.Set("namespace", req.Namespace)
+		query.Set("prefix", req.Prefix)
+		query.Set("glob", req.Glob)
+		query.Set("after", req.After)
+		query.Set("limit", strconv.Itoa(req.Limit))
+		query.Set("unsorted", strconv.FormatBool(req.Unsorted))
+		s.forwardToLeader(w, r, http.MethodPost, "/scan?"+query.Encode(), nil)
+	}
+
+	// Followers cannot confirm the read with a quorum, so forward it to the leader
+	if s.raft.State() != raft.Leader {
+		forward()
+		return
+	}
+
+	if err := s.readIndex(r.Context()); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			if leaderAddr, _ := s.raft.LeaderWithID(); leaderAddr != "" && r.Header.Get(forwardedHeader) == "" && s.raft.State() != raft.Leader {
+				forward()
+				return
+			}
+		}
+		s.writeApplyError(w, err)
+		return
+	}
+	if !s.awaitSession(w, r) {
+		return
+	}
+
+	// Keys are matched and reported as the client sent them, without the
+	// namespace prefix
+	trim := len(namespacedKey(req.Namespace, ""))
+	opts := fsm.ScanOptions{Prefix: namespacedKey(req.Namespace, req.Prefix), Limit: req.Limit, Unsorted: req.Unsorted}
+	if req.After != "" {
+		opts.After = namespacedKey(req.Namespace, req.After)
+	}
+	if req.Glob != "" {
+		opts.Match = func(key string) bool {
+			matched, _ := path.Match(req.Glob, key[trim:])
+			return matched
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), scanTimeout)
+	defer cancel()
+	page, more, err := store.Scan(ctx, opts)
+	if err != nil {
+		writeJSONError(w, http.StatusGatewayTimeout, api.CodeScanTimeout, fmt.Sprintf("Scan did not finish within %s; narrow the prefix", scanTimeout))
+		return
+	}
+
+	result := api.ScanResult{Items: make([]api.ScanItem, len(page)), More: more}
+	for i, item := range page {
+		result.Items[i] = api.ScanItem{Key: item.Key[trim:], Value: item.Value}
+		// Binary values go in val_b64, as from /get
+		if s.needsBase64(item.Value) {
+			result.Items[i].Value = ""
+			result.Items[i].ValueB64 = base64.StdEncoding.EncodeToString([]byte(item.Value))
+		}
+	}
+
+	log.Printf("[HTTP-SCAN] listed %d keys with prefix %q and glob %q", len(page), req.Prefix, req.Glob)
+
+	response := APIResponse{
+		Success: true,
+		Message: "Keys listed successfully",
+		Data:    result,
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+// newScanServer returns a leader over a real FSM holding keys, each with its
+// own name as the value
+func newScanServer(keys ...string) *Server {
+	store := fsm.NewFSM(fsm.Options{HistoryDepth: 1}).(*fsm.FSM)
+	for _, key := range keys {
+		store.Put(key, key)
+	}
+	return New(&fakeRaft{fsm: store, state: raft.Leader}, store, raft.Server{ID: "n1", Address: "127.0.0.1:1"}, Config{
+		ApplyTimeout: 50 * time.Millisecond,
+	})
+}
+
+// scan sends query to s's /scan and returns the listed keys
+func scan(t *testing.T, s *Server, query url.Values) (int, []string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.ScanHandler(rec, httptest.NewRequest(http.MethodGet, "/scan?"+query.Encode(), nil))
+	var resp struct {
+		Data api.ScanResult `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response %q is not JSON: %v", rec.Body.String(), err)
+	}
+	var keys []string
+	for _, item := range resp.Data.Items {
+		keys = append(keys, item.Key)
+	}
+	return rec.Code, keys
+}
+
+func TestScanGlob(t *testing.T) {
+	s := newScanServer("user/1", "user/2", "user/10", "user/a", "user/1/orders", "team/x", "users", "ns/user/3", "q?")
+
+	tests := []struct {
+		name      string
+		namespace string
+		prefix    string
+		glob      string
+		want      string
+	}{
+		{"star", "", "", "user/*", "[user/1 user/10 user/2 user/a]"},
+		{"star stops at a slash", "", "", "*", "[q? users]"},
+		{"star within a segment", "", "", "user/1*", "[user/1 user/10]"},
+		{"question mark", "", "", "user/?", "[user/1 user/2 user/a]"},
+		{"character class", "", "", "user/[12]", "[user/1 user/2]"},
+		{"character range", "", "", "user/[0-9]*", "[user/1 user/10 user/2]"},
+		{"negated class", "", "", "user/[^0-9]", "[user/a]"},
+		{"with a prefix", "", "user/1", "*/*/*", "[user/1/orders]"},
+		{"within a namespace", "ns", "", "user/?", "[user/3]"},
+		{"escaped metacharacter", "", "", `q\?`, "[q?]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{"glob": {tt.glob}}
+			if tt.namespace != "" {
+				query.Set("namespace", tt.namespace)
+			}
+			if tt.prefix != "" {
+				query.Set("prefix", tt.prefix)
+			}
+			status, keys := scan(t, s, query)
+			if got := fmt.Sprint(keys); status != http.StatusOK || got != tt.want {
+				t.Errorf("glob %q = %d %s, want %s", tt.glob, status, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("pages", func(t *testing.T) {
+		_, first := scan(t, s, url.Values{"glob": {"user/?"}, "limit": {"2"}})
+		_, second := scan(t, s, url.Values{"glob": {"user/?"}, "limit": {"2"}, "after": {first[len(first)-1]}})
+		if got := fmt.Sprint(append(first, second...)); got != "[user/1 user/2 user/a]" {
+			t.Errorf("pages = %s", got)
+		}
+	})
+
+	t.Run("unsorted", func(t *testing.T) {
+		status, keys := scan(t, s, url.Values{"glob": {"user/?"}, "unsorted": {"true"}})
+		sort.Strings(keys)
+		if got := fmt.Sprint(keys); status != http.StatusOK || got != "[user/1 user/2 user/a]" {
+			t.Errorf("unsorted scan = %d %s", status, got)
+		}
+		if status, _ := scan(t, s, url.Values{"unsorted": {"true"}, "after": {"user/1"}}); status != http.StatusBadRequest {
+			t.Errorf("unsorted scan with after answered %d", status)
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		s.ScanHandler(rec, httptest.NewRequest(http.MethodGet, "/scan?glob="+url.QueryEscape("user/[1"), nil))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), api.CodeInvalidRequest) {
+			t.Errorf("malformed glob answered %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestScanEncodesBinaryValues(t *testing.T) {
+	s := newScanServer("text")
+	s.fsm.(*fsm.FSM).Put("binary", "\xff\x00")
+
+	rec := httptest.NewRecorder()
+	s.ScanHandler(rec, httptest.NewRequest(http.MethodGet, "/scan", nil))
+	var resp struct {
+		Data api.ScanResult `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response %q is not JSON: %v", rec.Body.String(), err)
+	}
+	want := []api.ScanItem{{Key: "binary", ValueB64: "/wA="}, {Key: "text", Value: "text"}}
+	if fmt.Sprint(resp.Data.Items) != fmt.Sprint(want) {
+		t.Errorf("items = %+v, want %+v", resp.Data.Items, want)
+	}
+}
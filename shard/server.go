@@ -5,17 +5,107 @@
 package main
 
 import (
+	"io"
+	"time"
+
 	"github.com/hashicorp/raft"
 )
 
+// Value encodings accepted by -value_encoding
+const (
+	EncodingRaw  = "raw"
+	EncodingUTF8 = "utf8"
+	EncodingJSON = "json"
+)
+
+// Read modes accepted by -read_mode
+const (
+	ReadModeLinearizable = "linearizable"
+	ReadModeLog          = "log"
+	ReadModeLocal        = "local"
+)
+
+// Config holds the handler settings taken from command-line flags
+type Config struct {
+	ValueEncoding   string        // one of EncodingRaw, EncodingUTF8, EncodingJSON
+	AlwaysBase64    bool          // return every GET value as val_b64, not only invalid UTF-8
+	MaxBatchOps     int           // most operations accepted in one /batch request
+	MaxBatchBytes   int           // largest /batch raft log entry accepted, in bytes
+	ReadyMaxLag     uint64        // most entries a follower may trail the leader's commit index and still be ready
+	ApplyTimeout    time.Duration // how long raft.Apply may wait to enqueue, and reads may retry during an election
+	ImportBatchSize int           // most keys /import applies in one raft log entry
+	MaxValueBytes   int           // largest value a PUT or APPEND may leave, in bytes; 0 disables the limit
+	MaxKeys         int           // key cap stamped on the entries this node proposes as leader; 0 proposes none
+	IdempotencyKeys int           // idempotency LRU size stamped on the entries with an idempotency key this node proposes
+	ReadMode        string        // one of ReadModeLinearizable, ReadModeLog, ReadModeLocal
+	MinVoters       int           // fewest voters /raft/leave may leave in the cluster
+	ForwardCacheTTL time.Duration // how long a follower reuses a GET response forwarded from the leader; 0 disables it
+	Group           int           // raft group served under /shard/{Group}; 0 when the process hosts one group at the root
+
+	AllowStaleOnNoLeader bool          // answer GETs from local state, flagged stale, when no leader appears within ApplyTimeout
+	CheckWriteQuorum     bool          // reject writes up front while the leader reaches fewer than WriteQuorum voters
+	WriteQuorum          int           // fewest reachable voters, the leader included, a write needs; raised to a majority
+	RestoreToken         string        // token /raft/restore requires in X-KV-Raft-Restore-Token; empty disables it
+	CommitSLA            time.Duration // warn when the mean commit latency goes above it; 0 disables the alarm
+	StateHistorySize     int           // raft state transitions /raft/history keeps; 0 disables it
+	RebalanceRate        int           // keys a /rebalance moves per second unless it asks for another rate
+	StrictRouting        bool          // answer /put and /get for keys another shard owns with 421 MISDIRECTED_KEY
+}
+
+// raftNode is the part of *raft.Raft the servers use. Server and
+// UnifiedServer depend on it rather than on the concrete type, so that a fake
+// or another consensus backend can stand in for hashicorp/raft.
+type raftNode interface {
+	Apply(cmd []byte, timeout time.Duration) raft.ApplyFuture
+	State() raft.RaftState
+	LeaderWithID() (raft.ServerAddress, raft.ServerID)
+	AppliedIndex() uint64
+	CommitIndex() uint64
+	CurrentTerm() uint64
+	LastIndex() uint64
+	GetConfiguration() raft.ConfigurationFuture
+	AddVoter(id raft.ServerID, address raft.ServerAddress, prevIndex uint64, timeout time.Duration) raft.IndexFuture
+	AddNonvoter(id raft.ServerID, address raft.ServerAddress, prevIndex uint64, timeout time.Duration) raft.IndexFuture
+	RemoveServer(id raft.ServerID, prevIndex uint64, timeout time.Duration) raft.IndexFuture
+	Stats() map[string]string
+	VerifyLeader() raft.Future
+	Snapshot() raft.SnapshotFuture
+	LeadershipTransfer() raft.Future
+	Restore(meta *raft.SnapshotMeta, reader io.Reader, timeout time.Duration) error
+	RegisterObserver(or *raft.Observer)
+	DeregisterObserver(or *raft.Observer)
+}
+
+// *raft.Raft is the production raftNode
+var _ raftNode = (*raft.Raft)(nil)
+
 type Server struct {
-	raft *raft.Raft
-	fsm  raft.FSM
+	raft   raftNode
+	fsm    raft.FSM
+	self   raft.Server // this node's ID and advertised raft address
+	config Config
+
+	readiness     *readiness
+	forwardCache  *forwardCache
+	leaderTerm    *leaderTerm
+	voterContact  *voterContact
+	commitLatency *commitLatency
+	stateHistory  *stateHistory
+	locator       *locator // the groups hosted by this process; nil until main sets it
 }
 
-func New(raft *raft.Raft, fsm raft.FSM) *Server {
+func New(raft raftNode, fsm raft.FSM, self raft.Server, config Config) *Server {
 	return &Server{
-		raft: raft,
-		fsm:  fsm,
+		raft:   raft,
+		fsm:    fsm,
+		self:   self,
+		config: config,
+
+		readiness:     &readiness{},
+		forwardCache:  newForwardCache(config.ForwardCacheTTL),
+		leaderTerm:    &leaderTerm{},
+		voterContact:  newVoterContact(),
+		commitLatency: &commitLatency{},
+		stateHistory:  newStateHistory(config.StateHistorySize),
 	}
 }
@@ -0,0 +1,85 @@
+// KV-Raft: Session tokens for read-your-own-writes across nodes
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"kv-raft/api"
+)
+
+// sessionHeader carries the session token: set on write responses, and
+// echoed back by the client on reads
+const sessionHeader = "X-KV-Session"
+
+// sessionTokenVersion is the first byte of every token, so the format can
+// change without an old token being misread
+const sessionTokenVersion byte = 1
+
+var errInvalidSession = errors.New("invalid " + sessionHeader + " token")
+
+// encodeSessionToken returns the token for raft index: the version byte and
+// the index as a uvarint, base64url-encoded without padding
+func encodeSessionToken(index uint64) string {
+	buf := make([]byte, 1+binary.MaxVarintLen64)
+	buf[0] = sessionTokenVersion
+	n := binary.PutUvarint(buf[1:], index)
+	return base64.RawURLEncoding.EncodeToString(buf[:1+n])
+}
+
+// decodeSessionToken returns the raft index a token encodes
+func decodeSessionToken(token string) (uint64, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(buf) < 2 || buf[0] != sessionTokenVersion {
+		return 0, errInvalidSession
+	}
+	index, n := binary.Uvarint(buf[1:])
+	if n != len(buf)-1 {
+		return 0, errInvalidSession
+	}
+	return index, nil
+}
+
+// setSessionToken sets the session token for a write committed at index on
+// the response. A follower that forwarded the write copies it from the leader.
+func setSessionToken(w http.ResponseWriter, index uint64) {
+	w.Header().Set(sessionHeader, encodeSessionToken(index))
+}
+
+// sessionMinIndex returns the index a read must wait for: the greater of
+// minIndex and the index of the request's session token, if it has one
+func sessionMinIndex(r *http.Request, minIndex uint64) (uint64, error) {
+	token := r.Header.Get(sessionHeader)
+	if token == "" {
+		return minIndex, nil
+	}
+	index, err := decodeSessionToken(token)
+	if err != nil {
+		return 0, err
+	}
+	return max(minIndex, index), nil
+}
+
+// awaitSession waits, before a read on the leader, until this node has
+// applied the request's session token, answering the request itself and
+// reporting false if the token is malformed or not applied within
+// maxIndexWait. A leader that has just taken over may still be applying the
+// entries its predecessor committed.
+func (s *Server) awaitSession(w http.ResponseWriter, r *http.Request) bool {
+	minIndex, err := sessionMinIndex(r, 0)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, err.Error())
+		return false
+	}
+	if !s.waitForIndex(r.Context(), minIndex) {
+		writeJSONError(w, http.StatusServiceUnavailable, api.CodeNotReady, fmt.Sprintf("Index %d not yet applied on this node", minIndex))
+		return false
+	}
+	return true
+}
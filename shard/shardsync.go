@@ -0,0 +1,163 @@
+// KV-Raft: Exchanging the whole shard map between peer shards
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"kv-raft/api"
+)
+
+// shardSyncInterval is how often a shard pushes its whole map to its peers
+// even when nothing changed, so a peer that missed an update still converges
+const shardSyncInterval = 30 * time.Second
+
+// ShardEntry is one shard's address with the epoch it was set at
+type ShardEntry struct {
+	Address string `json:"address"`
+	Epoch   uint64 `json:"epoch"`
+}
+
+// ShardMap is the body of /syncshards. Epoch is the highest entry epoch; it
+// tells at a glance whether one map is newer than another.
+type ShardMap struct {
+	Epoch  uint64             `json:"epoch"`
+	Shards map[int]ShardEntry `json:"shards"`
+}
+
+// shardMap returns a copy of what this server knows about its peer shards
+func (us *UnifiedServer) shardMap() ShardMap {
+	us.shardsMu.Lock()
+	defer us.shardsMu.Unlock()
+
+	m := ShardMap{Shards: make(map[int]ShardEntry, len(us.knownShards))}
+	for shardID, address := range us.knownShards {
+		entry := ShardEntry{Address: address, Epoch: us.shardEpochs[shardID]}
+		m.Shards[shardID] = entry
+		if entry.Epoch > m.Epoch {
+			m.Epoch = entry.Epoch
+		}
+	}
+	return m
+}
+
+// setShard records a shard address learned locally, at an epoch past every
+// entry known so far, and pushes the map to the peers if it changed. It
+// reports whether it did.
+func (us *UnifiedServer) setShard(shardID int, address string) bool {
+	us.shardsMu.Lock()
+	if current, ok := us.knownShards[shardID]; ok && current == address {
+		us.shardsMu.Unlock()
+		return false
+	}
+	var epoch uint64
+	for _, e := range us.shardEpochs {
+		if e > epoch {
+			epoch = e
+		}
+	}
+	us.knownShards[shardID] = address
+	us.shardEpochs[shardID] = epoch + 1
+	us.shardsMu.Unlock()
+
+	us.pushShardMap()
+	return true
+}
+
+// mergeShardMap takes every entry of m that is newer than the local one and
+// reports whether anything changed. Equal epochs with different addresses,
+// set concurrently on two shards, resolve to the greater address so every
+// shard picks the same one.
+func (us *UnifiedServer) mergeShardMap(m ShardMap) bool {
+	us.shardsMu.Lock()
+	defer us.shardsMu.Unlock()
+
+	changed := false
+	for shardID, entry := range m.Shards {
+		current, known := us.knownShards[shardID]
+		epoch := us.shardEpochs[shardID]
+		if known && (entry.Epoch < epoch || entry.Epoch == epoch && entry.Address <= current) {
+			continue
+		}
+		us.knownShards[shardID] = entry.Address
+		us.shardEpochs[shardID] = entry.Epoch
+		changed = true
+	}
+	return changed
+}
+
+// pushShardMap queues the current map for every known peer shard
+func (us *UnifiedServer) pushShardMap() {
+	m := us.shardMap()
+	for shardID, entry := range m.Shards {
+		if shardID == us.shardID {
+			continue // Don't send to self
+		}
+		us.broadcaster.send(entry.Address, m)
+	}
+}
+
+// StartShardSync pushes the shard map to the peers every shardSyncInterval
+// until Stop is called
+func (us *UnifiedServer) StartShardSync() {
+	us.wg.Add(1)
+	go func() {
+		defer us.wg.Done()
+
+		ticker := time.NewTicker(shardSyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-us.ctx.Done():
+				return
+			case <-ticker.C:
+				us.pushShardMap()
+			}
+		}
+	}()
+}
+
+// SyncShardsHandler merges a peer's whole shard map into this one, entry by
+// entry by epoch, and answers with the merged map. Changes are passed on to
+// the other peers, so an update spreads even to shards the sender does not know.
+func (us *UnifiedServer) SyncShardsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSONError(w, http.StatusMethodNotAllowed, api.CodeInvalidRequest, "Use POST with a JSON shard map")
+		return
+	}
+
+	var req ShardMap
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Shards == nil {
+		WriteJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "\"shards\" is required and must be sent in a JSON body")
+		return
+	}
+	for shardID, entry := range req.Shards {
+		if shardID <= 0 || entry.Address == "" {
+			WriteJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Every shard needs a positive ID and an address")
+			return
+		}
+	}
+
+	changed := us.mergeShardMap(req)
+	if changed {
+		log.Printf("Merged shard map at epoch %d", req.Epoch)
+		us.pushShardMap()
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: "Shard map synchronized",
+		Data: map[string]interface{}{
+			"changed": changed,
+			"map":     us.shardMap(),
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
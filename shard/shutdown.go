@@ -0,0 +1,108 @@
+// KV-Raft: Graceful shutdown and leaving the cluster on exit
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	shutdownTimeout       = 10 * time.Second
+	leaderTransferTimeout = 10 * time.Second
+)
+
+// waitForShutdown blocks until SIGINT or SIGTERM, optionally removes this node
+// from the raft configuration of each hosted group, then stops the HTTP
+// servers so main can shut raft down. The returned channel is closed once
+// they have stopped.
+func waitForShutdown(httpServers []*http.Server, servers []*Server, leave bool) <-chan struct{} {
+	done := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, shutting down", sig)
+
+		if leave {
+			for _, s := range servers {
+				if err := s.leaveCluster(); err != nil {
+					log.Printf("Failed to leave cluster: %v", err)
+				} else {
+					log.Printf("Node %s left the cluster", s.self.ID)
+				}
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		for _, httpServer := range httpServers {
+			if err := httpServer.Shutdown(ctx); err != nil {
+				log.Printf("HTTP server shutdown error: %v", err)
+			}
+		}
+		close(done)
+	}()
+
+	return done
+}
+
+// leaveCluster asks the leader to remove this node from the configuration. A
+// leader first hands leadership to another voter so it can remove itself.
+func (s *Server) leaveCluster() error {
+	if s.raft.State() == raft.Leader {
+		log.Printf("Node %s is the leader, transferring leadership before leaving", s.self.ID)
+		if err := s.raft.LeadershipTransfer().Error(); err != nil {
+			return fmt.Errorf("leadership transfer failed: %w", err)
+		}
+	}
+
+	deadline := time.Now().Add(leaderTransferTimeout)
+	for {
+		leaderAddr, leaderID := s.raft.LeaderWithID()
+		if leaderAddr != "" && leaderID != s.self.ID {
+			return requestLeave(s.peerURL(leaderAddr), s.self.ID)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("no other leader available")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// requestLeave posts to the leader's /raft/leave for the given node
+func requestLeave(leaderURL string, nodeID raft.ServerID) error {
+	body, err := json.Marshal(LeaveRequest{NodeID: string(nodeID)})
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: shutdownTimeout}
+	resp, err := client.Post(leaderURL+"/raft/leave", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var response APIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return err
+	}
+	if !response.Success {
+		return fmt.Errorf("leader rejected leave: %s", response.Error)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+// KV-Raft: Staging values and committing them to live keys together
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+// StageHandler sets the value a key gets at the next /commit-staged. The key
+// keeps its live value, and readers keep seeing it, until then.
+func (s *Server) StageHandler(w http.ResponseWriter, r *http.Request) {
+	var req StageRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if req.Key == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("key"))
+		return
+	}
+	if req.Value == "" {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("val"))
+		return
+	}
+
+	if !validNamespace(req.Namespace) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Namespace must not contain '/'")
+		return
+	}
+	if !validKey(req.Namespace, req.Key) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, bareKeyMessage)
+		return
+	}
+	if reservedKey(namespacedKey(req.Namespace, req.Key)) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, reservedKeyMessage)
+		return
+	}
+
+	// A staged value becomes live as is, so it is held to the same rules as a PUT
+	if err := validateValueEncoding(s.config.ValueEncoding, req.Value); err != nil {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidValue, err.Error())
+		return
+	}
+	if s.config.MaxValueBytes > 0 && len(req.Value) > s.config.MaxValueBytes {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, api.CodeTooLarge, fmt.Sprintf("Value is %d bytes, the limit is %d", len(req.Value), s.config.MaxValueBytes))
+		return
+	}
+
+	idemKey, ok := idempotencyKey(w, r)
+	if !ok {
+		return
+	}
+
+	if s.raft.State() != raft.Leader {
+		query := url.Values{}
+		query.Set("key", req.Key)
+		query.Set("val", req.Value)
+		if req.Namespace != "" {
+			query.Set("namespace", req.Namespace)
+		}
+		s.forwardToLeader(w, r, http.MethodPost, "/stage?"+query.Encode(), nil)
+		return
+	}
+
+	payload := fsm.Payload{
+		OP:             fsm.STAGE,
+		Key:            namespacedKey(req.Namespace, req.Key),
+		Value:          req.Value,
+		IdempotencyKey: idemKey,
+	}
+
+	data, err := s.marshalPayload(payload)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal payload")
+		return
+	}
+
+	if err := s.checkWriteQuorum(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+
+	applyFuture := s.timedApply(payload.OP, data)
+	if err := applyFuture.Error(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+	setSessionToken(w, applyFuture.Index())
+
+	applyResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Invalid raft response")
+		return
+	}
+	markReplayed(w, applyResponse)
+
+	if applyResponse.Error != nil {
+		status, code := applyErrorStatus(applyResponse.Error)
+		writeJSONError(w, status, code, "Failed to stage value: "+applyResponse.Error.Error())
+		return
+	}
+
+	log.Printf("[HTTP-STAGE] key %s was staged", req.Key)
+
+	response := APIResponse{
+		Success: true,
+		Message: "Value staged; it becomes live at the next commit",
+		Data: map[string]interface{}{
+			"key":   req.Key,
+			"index": applyFuture.Index(),
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
+
+// CommitStagedHandler makes staged values live in a single log entry: the
+// listed keys, or every key staged in the namespace when none are listed
+func (s *Server) CommitStagedHandler(w http.ResponseWriter, r *http.Request) {
+	var req CommitStagedRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if !validNamespace(req.Namespace) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Namespace must not contain '/'")
+		return
+	}
+	for _, key := range req.Keys {
+		if key == "" {
+			writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Keys must not be empty")
+			return
+		}
+		if !validKey(req.Namespace, key) {
+			writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, bareKeyMessage)
+			return
+		}
+	}
+
+	idemKey, ok := idempotencyKey(w, r)
+	if !ok {
+		return
+	}
+
+	if s.raft.State() != raft.Leader {
+		query := url.Values{"keys": req.Keys}
+		if req.Namespace != "" {
+			query.Set("namespace", req.Namespace)
+		}
+		s.forwardToLeader(w, r, http.MethodPost, "/commit-staged?"+query.Encode(), nil)
+		return
+	}
+
+	payload := fsm.Payload{
+		OP:             fsm.COMMIT_STAGED,
+		IdempotencyKey: idemKey,
+	}
+	prefix := ""
+	if req.Namespace != "" {
+		prefix = req.Namespace + namespaceSeparator
+	}
+	if len(req.Keys) == 0 {
+		payload.Key = prefix
+	}
+	for _, key := range req.Keys {
+		payload.Keys = append(payload.Keys, namespacedKey(req.Namespace, key))
+	}
+
+	data, err := s.marshalPayload(payload)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal payload")
+		return
+	}
+
+	if err := s.checkWriteQuorum(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+
+	applyFuture := s.timedApply(payload.OP, data)
+	if err := applyFuture.Error(); err != nil {
+		s.writeApplyError(w, err)
+		return
+	}
+	setSessionToken(w, applyFuture.Index())
+
+	applyResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Invalid raft response")
+		return
+	}
+	markReplayed(w, applyResponse)
+
+	if applyResponse.Error != nil {
+		status, code := applyErrorStatus(applyResponse.Error)
+		writeJSONError(w, status, code, "Failed to commit staged values: "+applyResponse.Error.Error())
+		return
+	}
+
+	storeKeys, _ := applyResponse.Data.([]string)
+	committed := make([]string, 0, len(storeKeys))
+	for _, key := range storeKeys {
+		committed = append(committed, strings.TrimPrefix(key, prefix))
+	}
+
+	log.Printf("[HTTP-COMMIT-STAGED] %d staged keys were committed", len(committed))
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Committed %d staged keys", len(committed)),
+		Data: map[string]interface{}{
+			"committed": committed,
+			"index":     applyFuture.Index(),
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
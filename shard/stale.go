@@ -0,0 +1,51 @@
+// KV-Raft: Stale local reads while the cluster has no leader
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// staleHeader marks a GET answered from this node's state because no leader
+// could be reached, so the value may be out of date
+const staleHeader = "X-KV-Raft-Stale"
+
+// leaderPollInterval is how often awaitLeader checks for a leader
+const leaderPollInterval = 50 * time.Millisecond
+
+// awaitLeader waits up to config.ApplyTimeout for this node to know a leader,
+// reporting whether it does
+func (s *Server) awaitLeader(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, s.config.ApplyTimeout)
+	defer cancel()
+
+	for {
+		if leaderAddr, _ := s.raft.LeaderWithID(); leaderAddr != "" {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(leaderPollInterval):
+		}
+	}
+}
+
+// serveStale answers a GET from this node's state machine when
+// -allow_stale_on_no_leader is set and no leader appears within the apply
+// timeout, reporting whether it did. Writes have no such fallback.
+func (s *Server) serveStale(w http.ResponseWriter, r *http.Request, key, storeKey string, version, minIndex uint64) bool {
+	if !s.config.AllowStaleOnNoLeader || s.awaitLeader(r.Context()) {
+		return false
+	}
+
+	w.Header().Set(staleHeader, "true")
+	w.Header().Set(staleHeader+"-Applied-Index", strconv.FormatUint(s.raft.AppliedIndex(), 10))
+	s.localGet(w, r, key, storeKey, version, minIndex)
+	return true
+}
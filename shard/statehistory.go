@@ -0,0 +1,141 @@
+// KV-Raft: Ring buffer of raft state and leader changes for post-mortems
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+)
+
+// stateTransition is one change of this node's raft state, or of the leader
+// it knows, as observed by this node
+type stateTransition struct {
+	Time     time.Time `json:"time"`
+	OldState string    `json:"old_state"` // empty for the entry recorded at startup
+	NewState string    `json:"new_state"`
+	Term     uint64    `json:"term"`
+	Leader   string    `json:"leader"` // leader ID, empty while none is known
+}
+
+// stateHistory keeps the latest transitions in a ring of fixed size; a nil
+// *stateHistory records nothing
+type stateHistory struct {
+	mu      sync.Mutex
+	entries []stateTransition
+	next    int
+	full    bool
+
+	state  raft.RaftState
+	leader raft.ServerID
+}
+
+// newStateHistory returns a history of the last size transitions, or nil when
+// size is not positive
+func newStateHistory(size int) *stateHistory {
+	if size <= 0 {
+		return nil
+	}
+	return &stateHistory{entries: make([]stateTransition, size)}
+}
+
+// add records a transition to state and leader in term, unless neither changed
+func (h *stateHistory) add(state raft.RaftState, leader raft.ServerID, term uint64, initial bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !initial && state == h.state && leader == h.leader {
+		return
+	}
+	transition := stateTransition{
+		Time:     time.Now(),
+		NewState: state.String(),
+		Term:     term,
+		Leader:   string(leader),
+	}
+	if !initial {
+		transition.OldState = h.state.String()
+	}
+	h.state, h.leader = state, leader
+
+	h.entries[h.next] = transition
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// transitions returns the recorded transitions, oldest first
+func (h *stateHistory) transitions() []stateTransition {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.full {
+		return append([]stateTransition{}, h.entries[:h.next]...)
+	}
+	return append(append([]stateTransition{}, h.entries[h.next:]...), h.entries[:h.next]...)
+}
+
+// trackStateHistory records this node's state at startup and then every
+// change of its state or leader until ctx is done. The term is read when the
+// observation arrives, so it can already be a later one.
+func (s *Server) trackStateHistory(ctx context.Context) {
+	observations := make(chan raft.Observation, 16)
+	observer := raft.NewObserver(observations, false, func(o *raft.Observation) bool {
+		switch o.Data.(type) {
+		case raft.RaftState, raft.LeaderObservation:
+			return true
+		}
+		return false
+	})
+	s.raft.RegisterObserver(observer)
+	defer s.raft.DeregisterObserver(observer)
+
+	_, leader := s.raft.LeaderWithID()
+	s.stateHistory.add(s.raft.State(), leader, s.raft.CurrentTerm(), true)
+
+	state := s.raft.State()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case o := <-observations:
+			switch data := o.Data.(type) {
+			case raft.RaftState:
+				state = data
+			case raft.LeaderObservation:
+				leader = data.LeaderID
+			}
+			s.stateHistory.add(state, leader, s.raft.CurrentTerm(), false)
+		}
+	}
+}
+
+// RaftHistory returns the transitions this node recorded, oldest first: a
+// timeline of its elections and failovers since it started, as far back as
+// -state_history entries
+func (s Server) RaftHistory(w http.ResponseWriter, r *http.Request) {
+	if s.stateHistory == nil {
+		writeJSONError(w, http.StatusNotFound, api.CodeInvalidRequest, "State history is disabled; start the shard with -state_history above 0")
+		return
+	}
+
+	transitions := s.stateHistory.transitions()
+	response := APIResponse{
+		Success: true,
+		Message: "Raft state history retrieved successfully",
+		Data: map[string]interface{}{
+			"node_id":     string(s.self.ID),
+			"capacity":    len(s.stateHistory.entries),
+			"transitions": transitions,
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
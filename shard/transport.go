@@ -0,0 +1,68 @@
+// KV-Raft: Pooled HTTP connections between nodes, and HTTP/2 without TLS
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// peerMaxIdleConnsPerHost is how many idle connections to one node are
+	// kept for reuse. http.DefaultTransport keeps 2, so a follower forwarding
+	// more than 2 requests at a time dialed the leader for nearly every one.
+	peerMaxIdleConnsPerHost = 64
+	// peerMaxIdleConns caps the idle connections kept to all nodes together
+	peerMaxIdleConns = 256
+	// peerIdleConnTimeout is how long an unused connection stays in the pool;
+	// it is below the default -idle_timeout, so the peer rarely closes one
+	// the pool is about to reuse
+	peerIdleConnTimeout = 90 * time.Second
+)
+
+var peerConnectionsOpened = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "kvraft_peer_connections_opened_total",
+	Help: "TCP connections dialed to other nodes or shards for forwarded and proxied requests.",
+})
+
+// newPeerTransport returns the transport for requests from this process to
+// other nodes, pooling connections to each. With h2c it speaks HTTP/2 over
+// plaintext, multiplexing every request to a node over one connection; the
+// nodes must then all run with -h2c.
+func newPeerTransport(h2c bool) *http.Transport {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err == nil {
+				peerConnectionsOpened.Inc()
+			}
+			return conn, err
+		},
+		MaxIdleConns:        peerMaxIdleConns,
+		MaxIdleConnsPerHost: peerMaxIdleConnsPerHost,
+		IdleConnTimeout:     peerIdleConnTimeout,
+	}
+	if h2c {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return transport
+}
+
+// serverProtocols returns the protocols the API servers accept: HTTP/1.1
+// always, and with h2c also HTTP/2 over plaintext from clients that start
+// with it (prior knowledge), such as curl --http2-prior-knowledge
+func serverProtocols(h2c bool) *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(h2c)
+	return protocols
+}
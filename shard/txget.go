@@ -0,0 +1,109 @@
+// KV-Raft: Multi-key reads at a single read-index barrier
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/api"
+	"kv-raft/fsm"
+)
+
+// TxGetHandler reads several keys as of one point in the log, like /mget,
+// but without a log entry: a single read-index barrier confirms leadership,
+// and the keys are then read together with no entry applied in between
+func (s *Server) TxGetHandler(w http.ResponseWriter, r *http.Request) {
+	var req TxGetRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if len(req.Keys) == 0 {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, missingField("keys"))
+		return
+	}
+
+	if !validNamespace(req.Namespace) {
+		writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, "Namespace must not contain '/'")
+		return
+	}
+	for _, key := range req.Keys {
+		if !validKey(req.Namespace, key) {
+			writeJSONError(w, http.StatusBadRequest, api.CodeInvalidRequest, bareKeyMessage)
+			return
+		}
+	}
+
+	store, ok := s.fsm.(*fsm.FSM)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Transactional reads need the key-value state machine")
+		return
+	}
+
+	forward := func() {
+		body, err := json.Marshal(req)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, api.CodeInternalError, "Failed to marshal request")
+			return
+		}
+		s.forwardToLeader(w, r, http.MethodPost, "/txget", bytes.NewReader(body))
+	}
+
+	// Followers cannot confirm the read with a quorum, so forward it to the leader
+	if s.raft.State() != raft.Leader {
+		forward()
+		return
+	}
+
+	if err := s.readIndex(r.Context()); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			if leaderAddr, _ := s.raft.LeaderWithID(); leaderAddr != "" && r.Header.Get(forwardedHeader) == "" && s.raft.State() != raft.Leader {
+				forward()
+				return
+			}
+		}
+		s.writeApplyError(w, err)
+		return
+	}
+	if !s.awaitSession(w, r) {
+		return
+	}
+
+	storeKeys := make([]string, len(req.Keys))
+	for i, key := range req.Keys {
+		storeKeys[i] = namespacedKey(req.Namespace, key)
+	}
+	result := store.TxGet(storeKeys)
+
+	// Report keys as the client sent them, without the namespace prefix
+	values := make(map[string]string, len(result.Values))
+	missing := []string{}
+	for i, key := range req.Keys {
+		if value, found := result.Values[storeKeys[i]]; found {
+			values[key] = value
+		} else {
+			missing = append(missing, key)
+		}
+	}
+
+	log.Printf("[HTTP-TXGET] %d of %d keys were found on this node", len(values), len(req.Keys))
+
+	response := APIResponse{
+		Success: true,
+		Message: "Keys retrieved successfully",
+		Data: map[string]interface{}{
+			"values":  values,
+			"missing": missing,
+		},
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,231 @@
+// KV-Raft: Dry-run validation of the shard configuration
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/raft"
+
+	"kv-raft/fsm"
+)
+
+// configReport collects the outcome of each validation check
+type configReport struct {
+	failed bool
+}
+
+func (r *configReport) ok(format string, args ...interface{}) {
+	fmt.Printf("[OK]   "+format+"\n", args...)
+}
+
+func (r *configReport) fail(format string, args ...interface{}) {
+	r.failed = true
+	fmt.Printf("[FAIL] "+format+"\n", args...)
+}
+
+// validateConfig runs the startup checks for the parsed flags without starting
+// raft, printing one line per check. It reports whether every check passed.
+func validateConfig() bool {
+	report := &configReport{}
+
+	if *nodeID == "" {
+		report.fail("node_id is empty")
+	} else {
+		report.ok("node_id %q", *nodeID)
+	}
+
+	switch *valueEncoding {
+	case EncodingRaw, EncodingUTF8, EncodingJSON:
+		report.ok("value_encoding %q", *valueEncoding)
+	default:
+		report.fail("value_encoding %q: must be raw, utf8 or json", *valueEncoding)
+	}
+
+	switch *readMode {
+	case ReadModeLinearizable, ReadModeLog, ReadModeLocal:
+		report.ok("read_mode %q", *readMode)
+	default:
+		report.fail("read_mode %q: must be linearizable, log or local", *readMode)
+	}
+
+	if *historyDepth < 1 {
+		report.fail("history_depth %d must be at least 1", *historyDepth)
+	}
+	if *bootstrapExpect < 0 {
+		report.fail("bootstrap_expect %d must not be negative", *bootstrapExpect)
+	}
+	if *readCacheTTL < 0 {
+		report.fail("read_cache_ttl %d must not be negative", *readCacheTTL)
+	}
+	if *maxBatchOps < 1 {
+		report.fail("max_batch_ops %d must be at least 1", *maxBatchOps)
+	}
+	if *maxBatchBytes < 1 {
+		report.fail("max_batch_bytes %d must be at least 1", *maxBatchBytes)
+	}
+	if *importBatchSize < 1 {
+		report.fail("import_batch_size %d must be at least 1", *importBatchSize)
+	}
+	if *minVoters < 1 {
+		report.fail("min_voters %d must be at least 1", *minVoters)
+	}
+	if *forwardCacheTTL < 0 {
+		report.fail("forward_cache_ttl %s must not be negative", *forwardCacheTTL)
+	}
+	if *applyTimeout <= 0 {
+		report.fail("apply_timeout %s must be positive", *applyTimeout)
+	}
+	if *snapshotRetain < 1 {
+		report.fail("snapshot_retain %d must be at least 1", *snapshotRetain)
+	}
+	if *maxValueBytes < 0 {
+		report.fail("max_value_bytes %d must not be negative", *maxValueBytes)
+	}
+	if *maxKeys < 0 {
+		report.fail("max_keys %d must not be negative", *maxKeys)
+	}
+	if *idempotencyKeys < 0 {
+		report.fail("idempotency_keys %d must not be negative", *idempotencyKeys)
+	}
+	if *hotKeySample < 0 {
+		report.fail("hotkey_sample %d must not be negative", *hotKeySample)
+	}
+	if *httpGzipMinBytes < 0 {
+		report.fail("http_gzip_min_bytes %d must not be negative", *httpGzipMinBytes)
+	}
+	if err := fsm.ValidateStoreImpl(*storeImpl); err != nil {
+		report.fail("store_impl %q %v", *storeImpl, err)
+	}
+	if *maxRequestBytes < 0 {
+		report.fail("max_request_bytes %d must not be negative", *maxRequestBytes)
+	}
+	if *valueCompressionMinBytes < 1 {
+		report.fail("value_compression_min_bytes %d must be at least 1", *valueCompressionMinBytes)
+	}
+	if _, err := parseCORSOrigins(*corsOrigins); err != nil {
+		report.fail("cors_origins: %v", err)
+	}
+	if *rebalanceRate < 1 || *rebalanceRate > maxRebalanceRate {
+		report.fail("rebalance_rate %d must be between 1 and %d", *rebalanceRate, maxRebalanceRate)
+	}
+	if *stateHistorySize < 0 {
+		report.fail("state_history %d must not be negative", *stateHistorySize)
+	}
+	if *commitSLA < 0 {
+		report.fail("commit_sla %s must not be negative", *commitSLA)
+	}
+	if *writeQuorum < 0 {
+		report.fail("write_quorum %d must not be negative", *writeQuorum)
+	}
+	if *discoveryInterval <= 0 {
+		report.fail("discovery_interval %s must be positive", *discoveryInterval)
+	}
+
+	// Other nodes derive this node's HTTP address from its raft address
+	if _, err := net.ResolveTCPAddr("tcp", *raftaddr); err != nil {
+		report.fail("raft_addr %s does not resolve: %v", *raftaddr, err)
+	} else {
+		report.ok("raft_addr %s resolves", *raftaddr)
+	}
+	expectedHTTP := convertRaftToHTTPAddress(*raftaddr)
+	if !strings.HasSuffix(expectedHTTP, ":"+strconv.Itoa(*port)) {
+		report.fail("port %d does not match raft_addr %s; peers will expect HTTP on %s", *port, *raftaddr, expectedHTTP)
+	} else {
+		report.ok("port %d is raft_addr port - 10000", *port)
+	}
+
+	checkPortFree(report, "port", fmt.Sprintf(":%d", *port))
+	if _, raftPort, err := net.SplitHostPort(*raftaddr); err == nil {
+		checkPortFree(report, "raft_addr", ":"+raftPort)
+	}
+	if *readPort != 0 {
+		if *readPort == *port {
+			report.fail("read_port %d must differ from port", *readPort)
+		} else {
+			checkPortFree(report, "read_port", fmt.Sprintf(":%d", *readPort))
+		}
+	}
+	if *pprofAddr != "" {
+		checkPortFree(report, "pprof", *pprofAddr)
+	}
+
+	// Each hosted group listens for raft on a port derived from raft_addr
+	if *shards != "" {
+		if groups, err := parseShardGroups(*shards); err != nil {
+			report.fail("shards %q: %v", *shards, err)
+		} else {
+			for _, id := range groups {
+				raftAddr, err := groupRaftAddr(*raftaddr, id)
+				if err != nil {
+					report.fail("shard %d: %v", id, err)
+					continue
+				}
+				checkPortFree(report, fmt.Sprintf("shard %d raft_addr", id), raftAddr)
+			}
+		}
+	}
+
+	if *storedir == "" && !*allowEphemeral {
+		report.fail("store_dir is empty; pass -allow_ephemeral to run with storage deleted on exit")
+	}
+	if *storedir != "" {
+		if lock, err := lockStoreDir(*storedir); err != nil {
+			report.fail("%v", err)
+		} else {
+			lock.Close()
+			report.ok("store_dir %s is writable and not in use", *storedir)
+		}
+	}
+
+	if *discoverySRV != "" {
+		if peers, err := lookupSRVPeers(*discoverySRV); err != nil {
+			report.fail("discovery_srv %s does not resolve: %v", *discoverySRV, err)
+		} else {
+			report.ok("discovery_srv %s resolves to %d peers", *discoverySRV, len(peers))
+		}
+	}
+
+	// Every peer must be reachable and must not already use this node's ID or raft address
+	for _, peer := range strings.Split(*peerShards, ",") {
+		if peer = strings.TrimSpace(peer); peer == "" {
+			continue
+		}
+		server, err := fetchPeerIdentity(peer)
+		if err != nil {
+			report.fail("peer %s is unreachable: %v", peer, err)
+			continue
+		}
+		switch {
+		case server.ID == raft.ServerID(*nodeID):
+			report.fail("peer %s already uses node_id %q", peer, *nodeID)
+		case server.Address == raft.ServerAddress(*raftaddr):
+			report.fail("peer %s already uses raft_addr %s", peer, *raftaddr)
+		default:
+			report.ok("peer %s is reachable as node %s", peer, server.ID)
+		}
+	}
+
+	if report.failed {
+		fmt.Println("Configuration is invalid")
+	} else {
+		fmt.Println("Configuration is valid")
+	}
+	return !report.failed
+}
+
+// checkPortFree reports whether addr can be listened on
+func checkPortFree(report *configReport, name, addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		report.fail("%s %s is not free: %v", name, addr, err)
+		return
+	}
+	listener.Close()
+	report.ok("%s %s is free", name, addr)
+}
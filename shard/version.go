@@ -0,0 +1,73 @@
+// KV-Raft: Build information for fleet management
+// Inspired by: https://github.com/aemirbosnak/distributed-key-value-store
+
+
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = ""
+	commit    = ""
+	buildDate = ""
+)
+
+// BuildInfo identifies the binary a shard is running
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// buildInfo returns the values set through -ldflags, falling back to the
+// module version and VCS stamp recorded by the Go toolchain
+func buildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" {
+			info.Version = bi.Main.Version
+		}
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value
+				}
+			}
+		}
+	}
+
+	for _, field := range []*string{&info.Version, &info.Commit, &info.BuildDate} {
+		if *field == "" {
+			*field = "unknown"
+		}
+	}
+	return info
+}
+
+func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	response := APIResponse{
+		Success: true,
+		Message: "Version retrieved successfully",
+		Data:    buildInfo(),
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,45 @@
+#!/bin/bash
+
+echo "=== DELETE Operation (Non-existent Key) ==="
+echo ""
+
+# Find the current Raft leader so the delete is applied through consensus
+leader_url=""
+for shard in 1 2 3; do
+    port="80${shard}1"
+    if curl -s "http://shard${shard}:$port/raft/status" | jq -e '.data.state == "Leader"' >/dev/null 2>&1; then
+        leader_url="http://shard${shard}:$port"
+        break
+    fi
+done
+
+if [[ -z "$leader_url" ]]; then
+    echo "❌ Could not find a Raft leader"
+    exit 1
+fi
+
+echo "Deleting a key that was never written..."
+echo "URL: $leader_url/delete"
+echo "Body: {\"key\": \"never_existed_key\"}"
+echo ""
+
+response=$(curl -s -w "\n%{http_code}" -X DELETE "$leader_url/delete" \
+    -H "Content-Type: application/json" \
+    -d '{"key": "never_existed_key"}')
+status=$(echo "$response" | tail -n 1)
+response=$(echo "$response" | sed '$d')
+echo "Raw response: $response"
+echo "HTTP status: $status"
+echo ""
+
+echo "Formatted response:"
+echo "$response" | jq '.' 2>/dev/null || echo "Failed to parse JSON: $response"
+echo ""
+
+# Check that DELETE of a missing key is reported as not found
+if [[ "$status" == "404" ]] && echo "$response" | jq -e '.success == false' >/dev/null 2>&1; then
+    echo "✅ Correctly returned 404 for non-existent key"
+    echo "Error: $(echo "$response" | jq -r '.error // "Unknown error"')"
+else
+    echo "❌ Expected 404 for non-existent key, got $status"
+fi
@@ -0,0 +1,45 @@
+#!/bin/bash
+
+echo "=== Raft Join (Duplicate Node ID) ==="
+echo ""
+
+# Find the current Raft leader, only the leader accepts joins
+leader_url=""
+for shard in 1 2 3; do
+    port="80${shard}1"
+    if curl -s "http://shard${shard}:$port/raft/status" | jq -e '.data.state == "Leader"' >/dev/null 2>&1; then
+        leader_url="http://shard${shard}:$port"
+        break
+    fi
+done
+
+if [[ -z "$leader_url" ]]; then
+    echo "❌ Could not find a Raft leader"
+    exit 1
+fi
+
+echo "Joining with node ID 2 (already in use) from a different address..."
+echo "URL: $leader_url/raft/join"
+echo "Body: {\"nodeid\": \"2\", \"addr\": \"shard9:18091\"}"
+echo ""
+
+response=$(curl -s -w "\n%{http_code}" -X POST "$leader_url/raft/join" \
+    -H "Content-Type: application/json" \
+    -d '{"nodeid": "2", "addr": "shard9:18091"}')
+status=$(echo "$response" | tail -n 1)
+response=$(echo "$response" | sed '$d')
+echo "Raw response: $response"
+echo "HTTP status: $status"
+echo ""
+
+echo "Formatted response:"
+echo "$response" | jq '.' 2>/dev/null || echo "Failed to parse JSON: $response"
+echo ""
+
+# Check that the duplicate node ID was rejected
+if [[ "$status" == "409" ]] && echo "$response" | jq -e '.success == false' >/dev/null 2>&1; then
+    echo "✅ Correctly rejected duplicate node ID"
+    echo "Error: $(echo "$response" | jq -r '.error // "Unknown error"')"
+else
+    echo "❌ Expected 409 for duplicate node ID, got $status"
+fi
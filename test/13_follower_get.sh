@@ -0,0 +1,53 @@
+#!/bin/bash
+
+echo "=== GET Operation via Follower ==="
+echo ""
+
+# Find the current Raft leader and one follower
+leader_url=""
+follower_url=""
+for shard in 1 2 3; do
+    port="80${shard}1"
+    state=$(curl -s "http://shard${shard}:$port/raft/status" | jq -r '.data.state' 2>/dev/null)
+    if [[ "$state" == "Leader" ]]; then
+        leader_url="http://shard${shard}:$port"
+    elif [[ "$state" == "Follower" && -z "$follower_url" ]]; then
+        follower_url="http://shard${shard}:$port"
+    fi
+done
+
+if [[ -z "$leader_url" || -z "$follower_url" ]]; then
+    echo "❌ Could not find both a leader and a follower"
+    exit 1
+fi
+
+value="follower_read_$(date +%s)"
+
+echo "Writing key follower_test to the leader..."
+echo "URL: $leader_url/put"
+echo "Body: {\"key\": \"follower_test\", \"val\": \"$value\"}"
+echo ""
+
+curl -s -X POST "$leader_url/put" \
+    -H "Content-Type: application/json" \
+    -d "{\"key\": \"follower_test\", \"val\": \"$value\"}" >/dev/null
+
+echo "Reading key follower_test from a follower..."
+echo "URL: $follower_url/get?key=follower_test"
+echo ""
+
+response=$(curl -s "$follower_url/get?key=follower_test")
+echo "Raw response: $response"
+echo ""
+
+echo "Formatted response:"
+echo "$response" | jq '.' 2>/dev/null || echo "Failed to parse JSON: $response"
+echo ""
+
+# Check that the follower returned the committed value
+if echo "$response" | jq -e --arg value "$value" '.success == true and .value == $value' >/dev/null 2>&1; then
+    echo "✅ Follower returned the committed value"
+else
+    echo "❌ Follower did not return the committed value"
+    echo "Error: $(echo "$response" | jq -r '.error // "Unknown error"')"
+fi
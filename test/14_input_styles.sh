@@ -0,0 +1,78 @@
+#!/bin/bash
+
+echo "=== Request Input Styles ==="
+echo ""
+
+# Find the current Raft leader so writes are applied directly
+leader_url=""
+for shard in 1 2 3; do
+    port="80${shard}1"
+    if curl -s "http://shard${shard}:$port/raft/status" | jq -e '.data.state == "Leader"' >/dev/null 2>&1; then
+        leader_url="http://shard${shard}:$port"
+        break
+    fi
+done
+
+if [[ -z "$leader_url" ]]; then
+    echo "❌ Could not find a Raft leader"
+    exit 1
+fi
+
+passed=0
+failed=0
+
+# check <description> <expected success> <curl args...>
+check() {
+    local description="$1"
+    local expected="$2"
+    shift 2
+
+    response=$(curl -s "$@")
+    if echo "$response" | jq -e ".success == $expected" >/dev/null 2>&1; then
+        echo "✅ $description"
+        ((passed++))
+    else
+        echo "❌ $description"
+        echo "   Response: $response"
+        ((failed++))
+    fi
+}
+
+echo "--- PUT ---"
+check "PUT with JSON body" true -X POST "$leader_url/put" \
+    -H "Content-Type: application/json" -d '{"key": "style_json", "val": "v1"}'
+check "PUT with form body" true -X POST "$leader_url/put" \
+    -d "key=style_form&val=v2"
+check "PUT with query parameters" true -X POST "$leader_url/put?key=style_query&val=v3"
+check "PUT without value is rejected" false -X POST "$leader_url/put?key=style_query"
+
+echo ""
+echo "--- GET ---"
+check "GET with query parameters" true "$leader_url/get?key=style_json"
+check "GET with JSON body" true -X GET "$leader_url/get" \
+    -H "Content-Type: application/json" -d '{"key": "style_form"}'
+check "GET without key is rejected" false "$leader_url/get"
+
+echo ""
+echo "--- MGET ---"
+check "MGET with JSON body" true -X POST "$leader_url/mget" \
+    -H "Content-Type: application/json" -d '{"keys": ["style_json", "style_form"]}'
+check "MGET with query parameters" true -X POST "$leader_url/mget?keys=style_json&keys=style_query"
+
+echo ""
+echo "--- DELETE ---"
+check "DELETE with JSON body" true -X DELETE "$leader_url/delete" \
+    -H "Content-Type: application/json" -d '{"key": "style_json"}'
+check "DELETE with form body" true -X POST "$leader_url/delete" \
+    -d "key=style_form"
+check "DELETE with query parameters" true -X DELETE "$leader_url/delete?key=style_query"
+
+echo ""
+echo "--- Raft membership ---"
+check "JOIN without addr is rejected (JSON)" false -X POST "$leader_url/raft/join" \
+    -H "Content-Type: application/json" -d '{"nodeid": "9"}'
+check "JOIN without addr is rejected (form)" false -X POST "$leader_url/raft/join" \
+    -d "nodeid=9"
+
+echo ""
+echo "Passed: $passed, Failed: $failed"
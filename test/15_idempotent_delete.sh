@@ -0,0 +1,54 @@
+#!/bin/bash
+
+echo "=== Idempotent DELETE Retry ==="
+echo ""
+
+# Find the current Raft leader so the writes are applied through consensus
+leader_url=""
+for shard in 1 2 3; do
+    port="80${shard}1"
+    if curl -s "http://shard${shard}:$port/raft/status" | jq -e '.data.state == "Leader"' >/dev/null 2>&1; then
+        leader_url="http://shard${shard}:$port"
+        break
+    fi
+done
+
+if [[ -z "$leader_url" ]]; then
+    echo "❌ Could not find a Raft leader"
+    exit 1
+fi
+
+idem_key="idem-delete-$(date +%s%N)"
+
+echo "Storing idem_test_key..."
+curl -s -X POST "$leader_url/put" \
+    -H "Content-Type: application/json" \
+    -d '{"key": "idem_test_key", "val": "v1"}' >/dev/null
+echo ""
+
+# Send the same delete twice, as a client would after a timed-out first attempt
+echo "Deleting idem_test_key twice with Idempotency-Key: $idem_key"
+for attempt in 1 2; do
+    response=$(curl -s -D - -w "\n%{http_code}" -X DELETE "$leader_url/delete" \
+        -H "Content-Type: application/json" \
+        -H "Idempotency-Key: $idem_key" \
+        -d '{"key": "idem_test_key"}')
+    status=$(echo "$response" | tail -n 1)
+    replayed=$(echo "$response" | grep -i '^Idempotent-Replayed:' | tr -d '\r' | awk '{print $2}')
+    echo "Attempt $attempt: HTTP $status, Idempotent-Replayed: ${replayed:-<none>}"
+
+    if [[ $attempt == 1 ]]; then
+        first_status="$status"
+    else
+        second_status="$status"
+        second_replayed="$replayed"
+    fi
+done
+echo ""
+
+# The retry must return the first result instead of a 404 for the already deleted key
+if [[ "$first_status" == "200" && "$second_status" == "200" && "$second_replayed" == "true" ]]; then
+    echo "✅ Retried delete returned the recorded result"
+else
+    echo "❌ Expected 200 then a replayed 200, got $first_status then $second_status"
+fi
@@ -0,0 +1,53 @@
+#!/bin/bash
+
+echo "=== Raft Join (Concurrent Joins) ==="
+echo ""
+
+# Find the current Raft leader, only the leader accepts joins
+leader_url=""
+for shard in 1 2 3; do
+    port="80${shard}1"
+    if curl -s "http://shard${shard}:$port/raft/status" | jq -e '.data.state == "Leader"' >/dev/null 2>&1; then
+        leader_url="http://shard${shard}:$port"
+        break
+    fi
+done
+
+if [[ -z "$leader_url" ]]; then
+    echo "❌ Could not find a Raft leader"
+    exit 1
+fi
+
+# Two nodes that never start; with three live voters the cluster keeps quorum
+echo "Joining nodes 91 and 92 at the same time..."
+tmpdir=$(mktemp -d)
+for id in 91 92; do
+    curl -s -o "$tmpdir/$id.json" -w "%{http_code}" -X POST "$leader_url/raft/join" \
+        -H "Content-Type: application/json" \
+        -d "{\"nodeid\": \"$id\", \"addr\": \"shard$id:180${id}\"}" > "$tmpdir/$id.status" &
+done
+wait
+
+for id in 91 92; do
+    echo "Node $id: HTTP $(cat "$tmpdir/$id.status") $(cat "$tmpdir/$id.json")"
+done
+echo ""
+
+peers=$(curl -s "$leader_url/raft/peers")
+joined=$(echo "$peers" | jq '[.data.peers[] | select(.id == "91" or .id == "92")] | length')
+echo "Peers: $(echo "$peers" | jq -c '[.data.peers[].id]')"
+echo ""
+
+# Remove the placeholder nodes again so later tests see the original cluster
+for id in 91 92; do
+    curl -s -X POST "$leader_url/raft/leave" \
+        -H "Content-Type: application/json" \
+        -d "{\"nodeid\": \"$id\"}" >/dev/null
+done
+rm -rf "$tmpdir"
+
+if [[ "$joined" == "2" ]]; then
+    echo "✅ Both concurrent joins were applied"
+else
+    echo "❌ Expected both nodes in the configuration, found $joined"
+fi
@@ -0,0 +1,39 @@
+#!/bin/bash
+
+echo "=== Binary Value Round Trip (val_b64) ==="
+echo ""
+
+# Find the current Raft leader so the write is applied through consensus
+leader_url=""
+for shard in 1 2 3; do
+    port="80${shard}1"
+    if curl -s "http://shard${shard}:$port/raft/status" | jq -e '.data.state == "Leader"' >/dev/null 2>&1; then
+        leader_url="http://shard${shard}:$port"
+        break
+    fi
+done
+
+if [[ -z "$leader_url" ]]; then
+    echo "❌ Could not find a Raft leader"
+    exit 1
+fi
+
+# Arbitrary bytes, starting with ones that are never valid UTF-8
+sent=$( (printf '\xff\xfe\x00\x80'; head -c 60 /dev/urandom) | base64 | tr -d '\n')
+echo "Storing binary_test_key with val_b64: $sent"
+put_response=$(curl -s -X POST "$leader_url/put" \
+    -H "Content-Type: application/json" \
+    -d "{\"key\": \"binary_test_key\", \"val_b64\": \"$sent\"}")
+echo "Put response: $put_response"
+echo ""
+
+get_response=$(curl -s "$leader_url/get?key=binary_test_key")
+echo "Get response: $get_response"
+received=$(echo "$get_response" | jq -r '.val_b64 // empty')
+echo ""
+
+if [[ -n "$received" && "$received" == "$sent" ]]; then
+    echo "✅ Binary value survived the round trip unchanged"
+else
+    echo "❌ Expected val_b64 $sent, got ${received:-<none>}"
+fi
@@ -0,0 +1,60 @@
+#!/bin/bash
+
+echo "=== DELETE by Prefix (Nested Keys) ==="
+echo ""
+
+# Find the current Raft leader so the writes are applied through consensus
+leader_url=""
+for shard in 1 2 3; do
+    port="80${shard}1"
+    if curl -s "http://shard${shard}:$port/raft/status" | jq -e '.data.state == "Leader"' >/dev/null 2>&1; then
+        leader_url="http://shard${shard}:$port"
+        break
+    fi
+done
+
+if [[ -z "$leader_url" ]]; then
+    echo "❌ Could not find a Raft leader"
+    exit 1
+fi
+
+inside=("tree/a" "tree/a/b" "tree/a/b/c" "tree/a/d")
+outside=("tree/x" "treetop" "other/tree/a")
+
+echo "Storing nested keys..."
+for key in "${inside[@]}" "${outside[@]}"; do
+    curl -s -X POST "$leader_url/put" \
+        -H "Content-Type: application/json" \
+        -d "{\"key\": \"$key\", \"val\": \"v\"}" >/dev/null
+    echo "  $key"
+done
+echo ""
+
+echo "Deleting prefix tree/a..."
+response=$(curl -s -X POST "$leader_url/deleteprefix?prefix=tree/a")
+echo "Response: $response"
+deleted=$(echo "$response" | jq -r '.data.deleted // empty')
+echo ""
+
+keys_json=$(printf '%s\n' "${inside[@]}" "${outside[@]}" | jq -R . | jq -s -c .)
+result=$(curl -s -X POST "$leader_url/mget" \
+    -H "Content-Type: application/json" \
+    -d "{\"keys\": $keys_json}")
+missing=$(echo "$result" | jq -c '.data.missing | sort')
+expected=$(printf '%s\n' "${inside[@]}" | jq -R . | jq -s -c 'sort')
+echo "Missing after delete: $missing"
+echo ""
+
+# Every key under the prefix is gone and every key outside it survives
+if [[ "$deleted" == "${#inside[@]}" && "$missing" == "$expected" ]]; then
+    echo "✅ Deleted exactly the ${#inside[@]} keys under tree/a"
+else
+    echo "❌ Expected ${#inside[@]} deleted keys $expected, got $deleted deleted and $missing missing"
+fi
+
+# Clean up the keys outside the prefix
+for key in "${outside[@]}"; do
+    curl -s -X DELETE "$leader_url/delete" \
+        -H "Content-Type: application/json" \
+        -d "{\"key\": \"$key\"}" >/dev/null
+done
@@ -0,0 +1,69 @@
+#!/bin/bash
+
+echo "=== Batch Size Limits ==="
+echo ""
+
+# Find the current Raft leader so the batch is applied through consensus
+leader_url=""
+for shard in 1 2 3; do
+    port="80${shard}1"
+    if curl -s "http://shard${shard}:$port/raft/status" | jq -e '.data.state == "Leader"' >/dev/null 2>&1; then
+        leader_url="http://shard${shard}:$port"
+        break
+    fi
+done
+
+if [[ -z "$leader_url" ]]; then
+    echo "❌ Could not find a Raft leader"
+    exit 1
+fi
+
+tmpdir=$(mktemp -d)
+failed=0
+
+# post_batch FILE prints the HTTP status of POST /batch with FILE as the body
+post_batch() {
+    curl -s -o "$tmpdir/response.json" -w "%{http_code}" -X POST "$leader_url/batch" \
+        -H "Content-Type: application/json" \
+        --data-binary "@$1"
+}
+
+echo "Sending a batch of 2 operations..."
+echo '{"ops": [{"op": "put", "key": "batch_a", "val": "1"}, {"op": "put", "key": "batch_b", "val": "2"}]}' > "$tmpdir/small.json"
+status=$(post_batch "$tmpdir/small.json")
+echo "HTTP $status: $(cat "$tmpdir/response.json")"
+if [[ "$status" == "200" ]] && jq -e '.success == true' "$tmpdir/response.json" >/dev/null 2>&1; then
+    echo "✅ Small batch applied"
+else
+    echo "❌ Expected 200 for a small batch, got $status"
+    failed=1
+fi
+echo ""
+
+# Default -max_batch_ops is 1000
+echo "Sending a batch of 1001 operations..."
+jq -n -c '{ops: [range(1001) | {op: "put", key: "batch_count_\(.)", val: "v"}]}' > "$tmpdir/count.json"
+status=$(post_batch "$tmpdir/count.json")
+echo "HTTP $status: $(cat "$tmpdir/response.json")"
+if [[ "$status" == "413" ]] && jq -e '.code == "TOO_LARGE"' "$tmpdir/response.json" >/dev/null 2>&1; then
+    echo "✅ Batch over the operation limit rejected with 413"
+else
+    echo "❌ Expected 413 for 1001 operations, got $status"
+    failed=1
+fi
+echo ""
+
+# Default -max_batch_bytes is 1 MiB
+echo "Sending a batch of 2 operations with 600 KB values..."
+value=$(head -c 600000 /dev/zero | tr '\0' 'x')
+printf '{"ops": [{"op": "put", "key": "batch_big_1", "val": "%s"}, {"op": "put", "key": "batch_big_2", "val": "%s"}]}' "$value" "$value" > "$tmpdir/bytes.json"
+status=$(post_batch "$tmpdir/bytes.json")
+echo "HTTP $status: $(cat "$tmpdir/response.json")"
+if [[ "$status" == "413" ]] && jq -e '.code == "TOO_LARGE"' "$tmpdir/response.json" >/dev/null 2>&1; then
+    echo "✅ Batch over the byte limit rejected with 413"
+else
+    echo "❌ Expected 413 for a 1.2 MB batch, got $status"
+    failed=1
+fi
+
+rm -rf "$tmpdir"
@@ -0,0 +1,87 @@
+#!/bin/bash
+
+echo "=== Deterministic LRU Eviction (--max_keys) ==="
+echo ""
+
+# Find the current Raft leader so the writes are applied through consensus
+leader_url=""
+for shard in 1 2 3; do
+    port="80${shard}1"
+    if curl -s "http://shard${shard}:$port/raft/status" | jq -e '.data.state == "Leader"' >/dev/null 2>&1; then
+        leader_url="http://shard${shard}:$port"
+        break
+    fi
+done
+
+if [[ -z "$leader_url" ]]; then
+    echo "❌ Could not find a Raft leader"
+    exit 1
+fi
+
+# The cap is replicated on the entries the leader proposes, so one is written first
+curl -s -X POST "$leader_url/put" -d "key=evict_probe&val=v" >/dev/null
+max_keys=$(curl -s "$leader_url/raft/status" | jq -r '.data.max_keys // "0"')
+if [[ "$max_keys" == "0" ]]; then
+    echo "⚠️  Shards run without --max_keys; start them with e.g. --max_keys=100 to run this test"
+    exit 0
+fi
+echo "Shards keep at most $max_keys keys"
+echo ""
+
+# put_keys FROM TO writes evict_FROM .. evict_TO in batches of 500
+put_keys() {
+    local from=$1 to=$2
+    for ((start = from; start <= to; start += 500)); do
+        local end=$((start + 499 < to ? start + 499 : to))
+        jq -n -c --argjson s "$start" --argjson e "$end" \
+            '{ops: [range($s; $e + 1) | {op: "put", key: "evict_\(.)", val: "v"}]}' |
+            curl -s -X POST "$leader_url/batch" -H "Content-Type: application/json" --data-binary @- >/dev/null
+    done
+}
+
+# Filling the store with max_keys new keys evicts everything older, so the
+# LRU then holds exactly evict_0 .. evict_{max_keys-1}
+echo "Writing $max_keys keys, reading evict_0, then writing one more..."
+put_keys 0 $((max_keys - 1))
+curl -s "$leader_url/get?key=evict_0" >/dev/null
+put_keys "$max_keys" "$max_keys"
+
+# Reading evict_0 made evict_1 the least recently used key
+result=$(curl -s -X POST "$leader_url/mget" \
+    -H "Content-Type: application/json" \
+    -d '{"keys": ["evict_0", "evict_1"]}')
+missing=$(echo "$result" | jq -c '.data.missing')
+echo "Missing: $missing"
+if [[ "$missing" == '["evict_1"]' ]]; then
+    echo "✅ The least recently used key was evicted"
+else
+    echo "❌ Expected only evict_1 to be evicted, got $missing"
+fi
+echo ""
+
+# Every node applied the same log, so it must have evicted the same keys in the same order
+leader_index=$(curl -s "$leader_url/raft/status" | jq -r '.data.commit_index')
+echo "Comparing eviction digests once every shard has applied index $leader_index..."
+digests=()
+for shard in 1 2 3; do
+    port="80${shard}1"
+    for _ in $(seq 1 20); do
+        status=$(curl -s "http://shard${shard}:$port/raft/status")
+        if [[ $(echo "$status" | jq -r '.data.applied_index') -ge $leader_index ]]; then
+            break
+        fi
+        sleep 0.5
+    done
+    digest=$(echo "$status" | jq -r '"\(.data.evicted_keys) \(.data.eviction_digest)"')
+    echo "  shard$shard: evicted/digest $digest"
+    digests+=("$digest")
+done
+
+if [[ "${digests[0]}" == "${digests[1]}" && "${digests[1]}" == "${digests[2]}" ]]; then
+    echo "✅ Every shard evicted the same keys in the same order"
+else
+    echo "❌ Shards disagree on evicted keys"
+fi
+
+# Clean up the keys written by this test
+curl -s -X POST "$leader_url/deleteprefix?prefix=evict_" >/dev/null
@@ -0,0 +1,51 @@
+#!/bin/bash
+
+echo "=== PUT Value Types (null, number, object) ==="
+echo ""
+
+# Find the current Raft leader so a stored value could be read back
+leader_url=""
+for shard in 1 2 3; do
+    port="80${shard}1"
+    if curl -s "http://shard${shard}:$port/raft/status" | jq -e '.data.state == "Leader"' >/dev/null 2>&1; then
+        leader_url="http://shard${shard}:$port"
+        break
+    fi
+done
+
+if [[ -z "$leader_url" ]]; then
+    echo "❌ Could not find a Raft leader"
+    exit 1
+fi
+
+tmpdir=$(mktemp -d)
+
+# check_rejected NAME VALUE CODE sends VALUE as val and expects a 400 with CODE
+check_rejected() {
+    local name=$1 value=$2 code=$3
+    echo "PUT with $name value: {\"val\": $value}"
+    status=$(curl -s -o "$tmpdir/response.json" -w "%{http_code}" -X POST "$leader_url/put" \
+        -H "Content-Type: application/json" \
+        -d "{\"key\": \"value_types\", \"val\": $value}")
+    echo "HTTP $status: $(cat "$tmpdir/response.json")"
+    if [[ "$status" == "400" ]] && jq -e --arg code "$code" '.code == $code' "$tmpdir/response.json" >/dev/null 2>&1; then
+        echo "✅ $name value rejected with 400 $code"
+    else
+        echo "❌ Expected 400 $code for $name value, got $status"
+    fi
+    echo ""
+}
+
+check_rejected "null" "null" "INVALID_REQUEST"
+check_rejected "numeric" "42" "TYPE_MISMATCH"
+check_rejected "object" '{"nested": true}' "TYPE_MISMATCH"
+
+# None of the rejected values may have been stored
+status=$(curl -s -o /dev/null -w "%{http_code}" "$leader_url/get?key=value_types")
+if [[ "$status" == "404" ]]; then
+    echo "✅ No rejected value was stored"
+else
+    echo "❌ Expected 404 for value_types, got $status"
+fi
+
+rm -rf "$tmpdir"
@@ -0,0 +1,74 @@
+#!/bin/bash
+
+echo "=== OpenAPI Spec ==="
+echo ""
+
+# Find the current Raft leader so the example request can be applied
+leader_url=""
+for shard in 1 2 3; do
+    port="80${shard}1"
+    if curl -s "http://shard${shard}:$port/raft/status" | jq -e '.data.state == "Leader"' >/dev/null 2>&1; then
+        leader_url="http://shard${shard}:$port"
+        break
+    fi
+done
+
+if [[ -z "$leader_url" ]]; then
+    echo "❌ Could not find a Raft leader"
+    exit 1
+fi
+
+spec=$(curl -s "$leader_url/openapi.json")
+version=$(echo "$spec" | jq -r '.openapi // empty')
+if [[ "$version" == 3.* ]]; then
+    echo "✅ /openapi.json is an OpenAPI $version document"
+else
+    echo "❌ Expected an OpenAPI 3 document, got version \"$version\""
+fi
+echo ""
+
+for path in /get /put /delete /config /addshard /newleader /raft/join /raft/leave /raft/status /raft/peers; do
+    if ! echo "$spec" | jq -e --arg p "$path" '.paths[$p]' >/dev/null 2>&1; then
+        echo "❌ $path is missing from the spec"
+    fi
+done
+echo "Checked the documented paths"
+echo ""
+
+# Every JSON body example must carry its schema's required fields and only known fields
+echo "Validating request examples against their schemas..."
+invalid=$(echo "$spec" | jq -r '
+    . as $spec
+    | .paths | to_entries[] | .key as $path
+    | .value | to_entries[] | .key as $method
+    | .value.requestBody.content["application/json"] // empty
+    | select(.example)
+    | .example as $example
+    | ($spec.components.schemas[.schema["$ref"] | split("/") | last]) as $schema
+    | (($schema.required // []) - ($example | keys)) as $missing
+    | (($example | keys) - ($schema.properties | keys)) as $unknown
+    | select(($missing | length) > 0 or ($unknown | length) > 0)
+    | "\($method) \($path): missing \($missing), unknown \($unknown)"')
+if [[ -z "$invalid" ]]; then
+    echo "✅ Every request example matches its schema"
+else
+    echo "❌ Examples not matching their schema:"
+    echo "$invalid"
+fi
+echo ""
+
+# The handler must accept the spec's own example
+example=$(echo "$spec" | jq -c '.paths["/put"].post.requestBody.content["application/json"].example')
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST "$leader_url/put" \
+    -H "Content-Type: application/json" \
+    -d "$example")
+if [[ "$status" == "200" ]]; then
+    echo "✅ /put accepted the spec's example $example"
+else
+    echo "❌ /put rejected the spec's example $example with HTTP $status"
+fi
+
+key=$(echo "$example" | jq -r '.key')
+curl -s -X DELETE "$leader_url/delete" \
+    -H "Content-Type: application/json" \
+    -d "{\"key\": \"$key\"}" >/dev/null
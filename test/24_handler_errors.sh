@@ -0,0 +1,75 @@
+#!/bin/bash
+
+echo "=== PUT/GET/DELETE Handler Paths ==="
+echo ""
+
+# Find the current Raft leader and one follower
+leader_url=""
+follower_url=""
+for shard in 1 2 3; do
+    port="80${shard}1"
+    state=$(curl -s "http://shard${shard}:$port/raft/status" | jq -r '.data.state // empty' 2>/dev/null)
+    if [[ "$state" == "Leader" ]]; then
+        leader_url="http://shard${shard}:$port"
+    elif [[ "$state" == "Follower" && -z "$follower_url" ]]; then
+        follower_url="http://shard${shard}:$port"
+    fi
+done
+
+if [[ -z "$leader_url" || -z "$follower_url" ]]; then
+    echo "❌ Could not find a Raft leader and a follower"
+    exit 1
+fi
+
+tmpdir=$(mktemp -d)
+
+# check NAME STATUS CODE CURL_ARGS... runs curl and expects STATUS, and CODE unless it is empty
+check() {
+    local name=$1 want_status=$2 want_code=$3
+    shift 3
+    status=$(curl -s -o "$tmpdir/response.json" -w "%{http_code}" "$@")
+    echo "$name: HTTP $status $(cat "$tmpdir/response.json")"
+    if [[ "$status" != "$want_status" ]]; then
+        echo "❌ Expected $want_status, got $status"
+    elif [[ -n "$want_code" ]] && ! jq -e --arg code "$want_code" '.code == $code' "$tmpdir/response.json" >/dev/null 2>&1; then
+        echo "❌ Expected code $want_code"
+    else
+        echo "✅ $name"
+    fi
+    echo ""
+}
+
+json=(-H "Content-Type: application/json")
+
+echo "--- Success ---"
+check "PUT" 200 "" -X POST "$leader_url/put" "${json[@]}" -d '{"key": "handler_paths", "val": "v1"}'
+check "GET" 200 "" "$leader_url/get?key=handler_paths"
+check "DELETE" 200 "" -X DELETE "$leader_url/delete?key=handler_paths"
+check "GET after DELETE" 404 "KEY_NOT_FOUND" "$leader_url/get?key=handler_paths"
+
+echo "--- Missing key ---"
+check "PUT without key" 400 "INVALID_REQUEST" -X POST "$leader_url/put" "${json[@]}" -d '{"val": "v1"}'
+check "GET without key" 400 "INVALID_REQUEST" "$leader_url/get"
+check "DELETE without key" 400 "INVALID_REQUEST" -X DELETE "$leader_url/delete"
+
+echo "--- Wrong content type: a JSON body sent as text/plain is not read ---"
+check "PUT text/plain" 400 "INVALID_REQUEST" -X POST "$leader_url/put" -H "Content-Type: text/plain" -d '{"key": "handler_paths", "val": "v1"}'
+check "DELETE text/plain" 400 "INVALID_REQUEST" -X DELETE "$leader_url/delete" -H "Content-Type: text/plain" -d '{"key": "handler_paths"}'
+
+echo "--- Invalid JSON ---"
+check "PUT invalid JSON" 400 "INVALID_JSON" -X POST "$leader_url/put" "${json[@]}" -d '{"key": '
+check "GET invalid JSON" 400 "INVALID_JSON" "$leader_url/get" "${json[@]}" -d '{"key": '
+check "DELETE invalid JSON" 400 "INVALID_JSON" -X DELETE "$leader_url/delete" "${json[@]}" -d '{"key": '
+
+echo "--- Strict JSON: unknown fields and trailing content are rejected ---"
+check "PUT unknown field" 400 "INVALID_REQUEST" -X POST "$leader_url/put" "${json[@]}" -d '{"key": "handler_paths", "vla": "v1"}'
+check "PUT two bodies" 400 "INVALID_REQUEST" -X POST "$leader_url/put" "${json[@]}" -d '{"key": "handler_paths", "val": "v1"}{"key": "other", "val": "v2"}'
+
+echo "--- Not leader: writes are rejected, reads are forwarded ---"
+check "PUT on follower" 421 "NOT_LEADER" -X POST "$follower_url/put?key=handler_paths&val=v1"
+check "DELETE on follower" 421 "NOT_LEADER" -X DELETE "$follower_url/delete?key=handler_paths"
+curl -s -o /dev/null -X POST "$leader_url/put?key=handler_paths&val=v2"
+check "GET on follower" 200 "" "$follower_url/get?key=handler_paths"
+curl -s -o /dev/null -X DELETE "$leader_url/delete?key=handler_paths"
+
+rm -rf "$tmpdir"
@@ -0,0 +1,55 @@
+#!/bin/bash
+
+echo "=== Removing the Leader ==="
+echo ""
+
+# This test shrinks its cluster down to one node, so it runs its own from a
+# local build instead of using the shared one:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/26_leave_leader.sh
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+cluster_start || exit 1
+echo ""
+
+echo "--- The leader removes itself and reports its successor ---"
+response=$(curl -s -X POST "${NODE_URLS[1]}/raft/leave" -d "nodeid=node1")
+echo "Response: $response"
+new_leader=$(jq -r '.data.new_leader // empty' <<<"$response" 2>/dev/null)
+if [[ "$new_leader" == "node2" || "$new_leader" == "node3" ]]; then
+    echo "✅ $new_leader was elected"
+else
+    echo "❌ Expected node2 or node3 as the new leader"
+    exit 1
+fi
+stop_node 1
+leader=${new_leader#node}
+echo ""
+
+echo "--- The cluster still takes writes ---"
+response=$(curl -s -X POST "${NODE_URLS[$leader]}/put?key=leave_leader&val=ok")
+echo "Response: $response"
+if jq -e '.success' <<<"$response" >/dev/null 2>&1; then
+    echo "✅ Write succeeded on node $leader"
+else
+    echo "❌ Write failed on node $leader"
+fi
+echo ""
+
+echo "--- The last voter cannot be removed ---"
+follower=2
+[[ "$leader" == "2" ]] && follower=3
+echo "Removing follower node $follower: $(curl -s -X POST "${NODE_URLS[$leader]}/raft/leave" -d "nodeid=node$follower")"
+stop_node "$follower"
+status=$(curl -s -o "$CLUSTER_DIR/leave.json" -w "%{http_code}" -X POST "${NODE_URLS[$leader]}/raft/leave" -d "nodeid=node$leader")
+echo "Removing node $leader: HTTP $status $(cat "$CLUSTER_DIR/leave.json")"
+if [[ "$status" == "409" ]] && jq -e '.code == "NODE_CONFLICT"' "$CLUSTER_DIR/leave.json" >/dev/null 2>&1; then
+    echo "✅ Leave of the last voter was rejected"
+else
+    echo "❌ Expected 409 NODE_CONFLICT"
+fi
+if [[ "$(node_state "$leader")" == "Leader" ]]; then
+    echo "✅ Node $leader is still leader"
+else
+    echo "❌ Node $leader is no longer leader"
+fi
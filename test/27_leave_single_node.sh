@@ -0,0 +1,47 @@
+#!/bin/bash
+
+echo "=== Leave Guard on a Single-Node Cluster ==="
+echo ""
+
+# This test runs its own single-node cluster from a local build:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/27_leave_single_node.sh
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+CLUSTER_SIZE=1
+cluster_start || exit 1
+echo ""
+
+# The address as raft recorded it, which may be resolved from localhost
+addr=$(curl -s "${NODE_URLS[1]}/raft/peers" | jq -r '.data.peers[0].address // empty' 2>/dev/null)
+for args in "nodeid=node1" "addr=$addr"; do
+    status=$(curl -s -o "$CLUSTER_DIR/leave.json" -w "%{http_code}" -X POST "${NODE_URLS[1]}/raft/leave" -d "$args")
+    echo "Leave with $args: HTTP $status $(cat "$CLUSTER_DIR/leave.json")"
+    if [[ "$status" == "409" ]] && jq -e '.code == "NODE_CONFLICT"' "$CLUSTER_DIR/leave.json" >/dev/null 2>&1; then
+        echo "✅ Leave of the only voter was rejected"
+    else
+        echo "❌ Expected 409 NODE_CONFLICT"
+    fi
+    echo ""
+done
+
+response=$(curl -s -X POST "${NODE_URLS[1]}/put?key=single_node_leave&val=ok")
+echo "PUT after the rejected leaves: $response"
+if jq -e '.success' <<<"$response" >/dev/null 2>&1; then
+    echo "✅ The cluster is still available"
+else
+    echo "❌ The cluster no longer takes writes"
+fi
+echo ""
+
+echo "--- -min_voters 2 protects a 2-node cluster ---"
+cluster_stop
+CLUSTER_SIZE=2
+cluster_start --min_voters=2 || exit 1
+status=$(curl -s -o "$CLUSTER_DIR/leave.json" -w "%{http_code}" -X POST "${NODE_URLS[1]}/raft/leave" -d "nodeid=node2")
+echo "Leave of node2: HTTP $status $(cat "$CLUSTER_DIR/leave.json")"
+if [[ "$status" == "409" ]]; then
+    echo "✅ Leave below -min_voters was rejected"
+else
+    echo "❌ Expected 409"
+fi
@@ -0,0 +1,87 @@
+#!/bin/bash
+
+echo "=== Read-Index Linearizable Reads ==="
+echo ""
+
+# Readers on every node race a writer, and each read must see at least the
+# last write acknowledged before it started. It runs its own cluster from a
+# local build:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/28_read_index.sh
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+WRITES=200
+
+cluster_start || exit 1
+echo ""
+
+echo "--- Reads do not append to the Raft log ---"
+curl -s -X POST "${NODE_URLS[1]}/put?key=read_index&val=0" >/dev/null
+before=$(curl -s "${NODE_URLS[1]}/raft/status" | jq -r '.data.last_log_index')
+for _ in $(seq 1 20); do
+    curl -s "${NODE_URLS[1]}/get?key=read_index" >/dev/null
+    curl -s "${NODE_URLS[2]}/get?key=read_index" >/dev/null
+done
+after=$(curl -s "${NODE_URLS[1]}/raft/status" | jq -r '.data.last_log_index')
+if [[ "$before" == "$after" ]]; then
+    echo "✅ 40 reads left the last log index at $after"
+else
+    echo "❌ 40 reads moved the last log index from $before to $after"
+fi
+echo ""
+
+echo "--- Reads under concurrent writes see every acknowledged write ---"
+echo 0 >"$CLUSTER_DIR/acked"
+touch "$CLUSTER_DIR/violations"
+
+# The writer publishes a value only after its PUT is acknowledged
+writer() {
+    for i in $(seq 1 "$WRITES"); do
+        if curl -s -X POST "${NODE_URLS[1]}/put?key=read_index&val=$i" | jq -e '.success' >/dev/null 2>&1; then
+            echo "$i" >"$CLUSTER_DIR/acked.tmp" && mv "$CLUSTER_DIR/acked.tmp" "$CLUSTER_DIR/acked"
+        fi
+    done
+    touch "$CLUSTER_DIR/done"
+}
+
+# reader N reads through node N until the writer finishes, recording any read
+# older than the last write acknowledged before the read was sent
+reader() {
+    local n=$1 reads=0
+    while [[ ! -e "$CLUSTER_DIR/done" ]]; do
+        acked=$(cat "$CLUSTER_DIR/acked")
+        value=$(curl -s "${NODE_URLS[$n]}/get?key=read_index" | jq -r '.value // empty' 2>/dev/null)
+        if [[ -z "$value" || "$value" -lt "$acked" ]]; then
+            echo "node $n read '$value' after write $acked was acknowledged" >>"$CLUSTER_DIR/violations"
+        fi
+        reads=$((reads + 1))
+    done
+    echo "$reads" >"$CLUSTER_DIR/reads$n"
+}
+
+# The nodes are background jobs too, so wait for these jobs only
+writer &
+pids=($!)
+for n in 1 2 3; do
+    reader "$n" &
+    pids+=($!)
+done
+wait "${pids[@]}"
+
+total=$(( $(cat "$CLUSTER_DIR/reads1") + $(cat "$CLUSTER_DIR/reads2") + $(cat "$CLUSTER_DIR/reads3") ))
+echo "$total reads raced $WRITES writes"
+if [[ ! -s "$CLUSTER_DIR/violations" ]]; then
+    echo "✅ No read returned a value older than an acknowledged write"
+else
+    echo "❌ Stale reads:"
+    head -5 "$CLUSTER_DIR/violations"
+fi
+final=$(curl -s "${NODE_URLS[2]}/get?key=read_index" | jq -r '.value')
+if [[ "$final" == "$WRITES" ]]; then
+    echo "✅ A read through a follower returns the last write, $final"
+else
+    echo "❌ Expected $WRITES through a follower, got $final"
+fi
+echo ""
+
+echo "=== Read-Index Test Complete ==="
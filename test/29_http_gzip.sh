@@ -0,0 +1,60 @@
+#!/bin/bash
+
+echo "=== Response Compression ==="
+echo ""
+
+# The shared cluster runs without -http_gzip, so this test starts a node of
+# its own from a local build:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/29_http_gzip.sh
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+CLUSTER_SIZE=1 cluster_start --http_gzip || exit 1
+URL=${NODE_URLS[1]}
+echo ""
+
+echo "--- Storing 200 keys ---"
+keys=()
+for i in $(seq 1 200); do
+    curl -s -X POST "$URL/put?key=gzip_$i&val=value-$i-$(printf 'x%.0s' $(seq 1 60))" >/dev/null
+    keys+=("\"gzip_$i\"")
+done
+body="{\"keys\":[$(IFS=,; echo "${keys[*]}")]}"
+echo ""
+
+echo "--- A large /mget response is compressed ---"
+headers="$CLUSTER_DIR/headers"
+curl -s -D "$headers" -o "$CLUSTER_DIR/mget.gz" -H "Accept-Encoding: gzip" -H "Content-Type: application/json" -X POST "$URL/mget" -d "$body"
+curl -s -o "$CLUSTER_DIR/mget.json" -H "Content-Type: application/json" -X POST "$URL/mget" -d "$body"
+compressed=$(stat -c %s "$CLUSTER_DIR/mget.gz")
+plain=$(stat -c %s "$CLUSTER_DIR/mget.json")
+echo "Compressed: $compressed bytes, uncompressed: $plain bytes"
+if grep -qi "^Content-Encoding: gzip" "$headers" && [[ "$compressed" -lt "$plain" ]]; then
+    echo "✅ Response was gzipped and is smaller"
+else
+    echo "❌ Expected a smaller gzipped response"
+fi
+if [[ "$(gunzip -c "$CLUSTER_DIR/mget.gz" | jq -S .)" == "$(jq -S . "$CLUSTER_DIR/mget.json")" ]] &&
+    [[ "$(jq '.data.values | length' "$CLUSTER_DIR/mget.json")" == "200" ]]; then
+    echo "✅ Decompressed response matches the uncompressed one, with all 200 values"
+else
+    echo "❌ Decompressed response differs"
+fi
+echo ""
+
+echo "--- Small responses and clients without gzip are sent as is ---"
+curl -s -D "$headers" -o /dev/null -H "Accept-Encoding: gzip" "$URL/get?key=gzip_1"
+if grep -qi "^Content-Encoding:" "$headers"; then
+    echo "❌ Small GET response was compressed"
+else
+    echo "✅ Small GET response was not compressed"
+fi
+curl -s -D "$headers" -o /dev/null -H "Content-Type: application/json" -X POST "$URL/mget" -d "$body"
+if grep -qi "^Content-Encoding:" "$headers"; then
+    echo "❌ Response was compressed without Accept-Encoding: gzip"
+else
+    echo "✅ Response was not compressed without Accept-Encoding: gzip"
+fi
+echo ""
+
+echo "=== Response Compression Test Complete ==="
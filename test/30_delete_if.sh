@@ -0,0 +1,63 @@
+#!/bin/bash
+
+echo "=== Conditional DELETE (delete-if-equals) ==="
+echo ""
+
+# Find the current Raft leader so the writes are applied through consensus
+leader_url=""
+for shard in 1 2 3; do
+    port="80${shard}1"
+    if curl -s "http://shard${shard}:$port/raft/status" | jq -e '.data.state == "Leader"' >/dev/null 2>&1; then
+        leader_url="http://shard${shard}:$port"
+        break
+    fi
+done
+
+if [[ -z "$leader_url" ]]; then
+    echo "❌ Could not find a Raft leader"
+    exit 1
+fi
+
+# check_status LABEL EXPECTED_STATUS EXPECTED_CODE CURL_ARGS... runs curl and
+# compares the HTTP status and error code of the response
+check_status() {
+    local label=$1 want_status=$2 want_code=$3
+    shift 3
+    local body status
+    body=$(curl -s -w '\n%{http_code}' "$@")
+    status=$(tail -n1 <<<"$body")
+    body=$(sed '$d' <<<"$body")
+    code=$(jq -r '.code // empty' <<<"$body" 2>/dev/null)
+    if [[ "$status" == "$want_status" && "$code" == "$want_code" ]]; then
+        echo "✅ $label: $status ${code:-OK}"
+    else
+        echo "❌ $label: expected $want_status ${want_code:-OK}, got $status ${code:-OK} ($body)"
+    fi
+}
+
+curl -s -X POST "$leader_url/put?key=delif_owner&val=worker-1" >/dev/null
+echo "Stored delif_owner=worker-1"
+echo ""
+
+echo "--- Another client took the key over ---"
+curl -s -X POST "$leader_url/put?key=delif_owner&val=worker-2" >/dev/null
+check_status "Delete expecting the old value" 409 CAS_MISMATCH -X DELETE "$leader_url/deleteif?key=delif_owner&expected=worker-1"
+value=$(curl -s "$leader_url/get?key=delif_owner" | jq -r '.value')
+if [[ "$value" == "worker-2" ]]; then
+    echo "✅ The newer value survived"
+else
+    echo "❌ Expected worker-2 to survive, got $value"
+fi
+echo ""
+
+echo "--- The key still holds the expected value ---"
+check_status "Delete expecting the current value" 200 "" -X DELETE "$leader_url/deleteif?key=delif_owner&expected=worker-2"
+check_status "Read after the delete" 404 KEY_NOT_FOUND "$leader_url/get?key=delif_owner"
+echo ""
+
+echo "--- Absent keys and missing input ---"
+check_status "Delete of an absent key" 404 KEY_NOT_FOUND -X DELETE "$leader_url/deleteif?key=delif_owner&expected=worker-2"
+check_status "Delete without expected" 400 INVALID_REQUEST -X DELETE "$leader_url/deleteif?key=delif_owner"
+echo ""
+
+echo "=== Conditional DELETE Test Complete ==="
@@ -0,0 +1,63 @@
+#!/bin/bash
+
+echo "=== Stale Reads Without a Leader ==="
+echo ""
+
+# This test stops two of three nodes to leave the cluster without a leader, so
+# it runs its own cluster from a local build:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/31_stale_on_no_leader.sh
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+cluster_start --allow_stale_on_no_leader || exit 1
+echo ""
+
+# Node 3 must have applied the write itself before the leader goes away
+index=$(curl -s -X POST "${NODE_URLS[1]}/put?key=stale_read&val=last-committed" | jq -r '.data.index')
+for _ in $(seq 1 20); do
+    applied=$(curl -s "${NODE_URLS[3]}/raft/status" | jq -r '.data.applied_index')
+    [[ "$applied" -ge "$index" ]] && break
+    sleep 0.5
+done
+
+echo "--- With a leader, reads are not flagged ---"
+curl -s -D "$CLUSTER_DIR/headers" -o /dev/null "${NODE_URLS[3]}/get?key=stale_read"
+if grep -qi "^X-KV-Raft-Stale:" "$CLUSTER_DIR/headers"; then
+    echo "❌ Read through the leader was flagged stale"
+else
+    echo "✅ Read through the leader was not flagged stale"
+fi
+echo ""
+
+echo "--- Stopping nodes 1 and 2 leaves node 3 without a quorum ---"
+stop_node 1
+stop_node 2
+for _ in $(seq 1 20); do
+    [[ "$(node_state 3)" != "Follower" ]] && break
+    sleep 0.5
+done
+echo "Node 3 is $(node_state 3)"
+echo ""
+
+echo "--- Reads fall back to node 3's state ---"
+status=$(curl -s -D "$CLUSTER_DIR/headers" -o "$CLUSTER_DIR/get.json" -w "%{http_code}" "${NODE_URLS[3]}/get?key=stale_read")
+echo "HTTP $status $(cat "$CLUSTER_DIR/get.json")"
+if [[ "$status" == "200" ]] && jq -e '.value == "last-committed"' "$CLUSTER_DIR/get.json" >/dev/null 2>&1 &&
+    grep -qi "^X-KV-Raft-Stale: true" "$CLUSTER_DIR/headers"; then
+    echo "✅ Served the last committed value, flagged stale"
+else
+    echo "❌ Expected a 200 with X-KV-Raft-Stale: true"
+fi
+echo ""
+
+echo "--- Writes still fail ---"
+status=$(curl -s -o "$CLUSTER_DIR/put.json" -w "%{http_code}" -X POST "${NODE_URLS[3]}/put?key=stale_read&val=new")
+echo "HTTP $status $(cat "$CLUSTER_DIR/put.json")"
+if [[ "$status" != "200" ]]; then
+    echo "✅ Write was rejected"
+else
+    echo "❌ Write succeeded without a leader"
+fi
+echo ""
+
+echo "=== Stale Read Test Complete ==="
@@ -0,0 +1,56 @@
+#!/bin/bash
+
+echo "=== Write Quorum Check ==="
+echo ""
+
+# This test stops a node to leave the leader short of the configured write
+# quorum, so it runs its own cluster from a local build:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/32_write_quorum.sh
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+# Requiring all 3 voters keeps node 1 leader with a raft quorum while writes
+# are refused
+cluster_start --check_write_quorum --write_quorum=3 || exit 1
+echo ""
+
+echo "--- All voters reachable ---"
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST "${NODE_URLS[1]}/put?key=quorum&val=before")
+if [[ "$status" == "200" ]]; then
+    echo "✅ Write accepted with 3 of 3 voters reachable"
+else
+    echo "❌ Expected 200, got $status"
+fi
+echo ""
+
+echo "--- Stopping node 3 ---"
+stop_node 3
+for _ in $(seq 1 20); do
+    status=$(curl -s -o "$CLUSTER_DIR/put.json" -w "%{http_code}" -X POST "${NODE_URLS[1]}/put?key=quorum&val=during")
+    [[ "$status" == "503" ]] && break
+    sleep 0.5
+done
+echo "HTTP $status $(cat "$CLUSTER_DIR/put.json")"
+if [[ "$status" == "503" ]] && jq -e '.code == "NO_QUORUM"' "$CLUSTER_DIR/put.json" >/dev/null 2>&1; then
+    echo "✅ Write rejected with 503 NO_QUORUM"
+else
+    echo "❌ Expected 503 NO_QUORUM"
+fi
+echo "Node 1 is still $(node_state 1)"
+echo ""
+
+echo "--- Node 3 is back ---"
+start_node 3 --check_write_quorum --write_quorum=3
+for _ in $(seq 1 20); do
+    status=$(curl -s -o /dev/null -w "%{http_code}" -X POST "${NODE_URLS[1]}/put?key=quorum&val=after")
+    [[ "$status" == "200" ]] && break
+    sleep 0.5
+done
+if [[ "$status" == "200" ]]; then
+    echo "✅ Writes are accepted again"
+else
+    echo "❌ Expected 200 once node 3 answers heartbeats, got $status"
+fi
+echo ""
+
+echo "=== Write Quorum Test Complete ==="
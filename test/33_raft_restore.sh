@@ -0,0 +1,83 @@
+#!/bin/bash
+
+echo "=== Restore From a Snapshot ==="
+echo ""
+
+# This test replaces the whole cluster's state, so it runs its own cluster
+# from a local build:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/33_raft_restore.sh
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+# Local reads show what each node itself holds after the restore
+cluster_start --restore_token=let-me-restore --read_mode=local || exit 1
+echo ""
+
+# check_status LABEL EXPECTED_STATUS CURL_ARGS... runs curl and compares the HTTP status
+check_status() {
+    local label=$1 want_status=$2
+    shift 2
+    local status
+    status=$(curl -s -o "$CLUSTER_DIR/response.json" -w "%{http_code}" "$@")
+    if [[ "$status" == "$want_status" ]]; then
+        echo "✅ $label: $status"
+    else
+        echo "❌ $label: expected $want_status, got $status ($(cat "$CLUSTER_DIR/response.json"))"
+    fi
+}
+
+echo "--- Taking a snapshot of the backup state ---"
+curl -s -X POST "${NODE_URLS[1]}/put?key=restore_a&val=backup" >/dev/null
+curl -s -X POST "${NODE_URLS[1]}/put?key=restore_b&val=backup" >/dev/null
+curl -s -X POST "${NODE_URLS[1]}/raft/snapshot" >/dev/null
+snapshot=$(ls -td "$CLUSTER_DIR"/node1/snapshots/*/ | head -1)state.bin
+cp "$snapshot" "$CLUSTER_DIR/backup.json"
+echo "Saved $(wc -c <"$CLUSTER_DIR/backup.json") bytes from $snapshot"
+echo ""
+
+echo "--- Changing the state after the backup ---"
+curl -s -X POST "${NODE_URLS[1]}/put?key=restore_a&val=changed" >/dev/null
+curl -s -X DELETE "${NODE_URLS[1]}/delete?key=restore_b" >/dev/null
+curl -s -X POST "${NODE_URLS[1]}/put?key=restore_c&val=new" >/dev/null
+# A membership change moves the configuration index past the backup's
+curl -s -X POST "${NODE_URLS[1]}/raft/leave" -d "nodeid=node3" >/dev/null
+curl -s -X POST "${NODE_URLS[1]}/raft/join" -d "nodeid=node3&addr=localhost:18131" >/dev/null
+echo ""
+
+echo "--- Guards ---"
+check_status "Without the token" 403 -X POST "${NODE_URLS[1]}/raft/restore" --data-binary @"$CLUSTER_DIR/backup.json"
+check_status "On a follower" 421 -X POST -H "X-KV-Raft-Restore-Token: let-me-restore" "${NODE_URLS[2]}/raft/restore" --data-binary @"$CLUSTER_DIR/backup.json"
+check_status "With a body that is not a snapshot" 400 -X POST -H "X-KV-Raft-Restore-Token: let-me-restore" "${NODE_URLS[1]}/raft/restore" --data-binary "not a snapshot"
+echo ""
+
+echo "--- Restoring the backup ---"
+check_status "Restore on the leader" 200 -X POST -H "X-KV-Raft-Restore-Token: let-me-restore" "${NODE_URLS[1]}/raft/restore" --data-binary @"$CLUSTER_DIR/backup.json"
+cat "$CLUSTER_DIR/response.json"
+echo ""
+
+for n in 1 2 3; do
+    for _ in $(seq 1 20); do
+        a=$(curl -s "${NODE_URLS[$n]}/get?key=restore_a" | jq -r '.value')
+        [[ "$a" == "backup" ]] && break
+        sleep 0.5
+    done
+    b=$(curl -s "${NODE_URLS[$n]}/get?key=restore_b" | jq -r '.value')
+    c=$(curl -s -o /dev/null -w "%{http_code}" "${NODE_URLS[$n]}/get?key=restore_c")
+    if [[ "$a" == "backup" && "$b" == "backup" && "$c" == "404" ]]; then
+        echo "✅ Node $n holds the backup state"
+    else
+        echo "❌ Node $n: restore_a=$a restore_b=$b restore_c status $c"
+    fi
+done
+echo ""
+
+echo "--- Writes continue after the restore ---"
+check_status "Write after the restore" 200 -X POST "${NODE_URLS[1]}/put?key=restore_c&val=after"
+echo ""
+
+echo "--- Membership changes use this cluster's configuration index, not the backup's ---"
+check_status "Leave after the restore" 200 -X POST "${NODE_URLS[1]}/raft/leave" -d "nodeid=node3"
+check_status "Join after the restore" 200 -X POST "${NODE_URLS[1]}/raft/join" -d "nodeid=node3&addr=localhost:18131"
+echo ""
+
+echo "=== Restore Test Complete ==="
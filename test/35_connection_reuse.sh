@@ -0,0 +1,77 @@
+#!/bin/bash
+
+echo "=== Connection Reuse and HTTP/2 ==="
+echo ""
+
+# This test restarts the cluster with different flags, so it runs its own
+# cluster from a local build:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/35_connection_reuse.sh
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+REQUESTS=${REQUESTS:-1000}
+PARALLEL=${PARALLEL:-32}
+
+# peer_connections N prints how many connections node N has dialed to other nodes
+peer_connections() {
+    curl -s "${NODE_URLS[$1]}/metrics" | awk '/^kvraft_peer_connections_opened_total/ {print $2}'
+}
+
+# forward_benchmark LABEL N runs REQUESTS GETs, PARALLEL at a time, against
+# follower node N, which forwards each to the leader, and reports how many
+# connections node N dialed for them
+forward_benchmark() {
+    local label=$1 n=$2 before after start elapsed_ms
+    before=$(peer_connections "$n")
+    start=$(date +%s%N)
+    seq 1 "$REQUESTS" | xargs -P "$PARALLEL" -I{} curl -s -o /dev/null "${NODE_URLS[$n]}/get?key=reuse"
+    elapsed_ms=$((($(date +%s%N) - start) / 1000000))
+    after=$(peer_connections "$n")
+    local dialed=$((${after:-0} - ${before:-0}))
+    echo "$label: $REQUESTS forwarded GETs in ${elapsed_ms}ms over $dialed new connections to the leader"
+    if ((dialed <= PARALLEL)); then
+        echo "✅ Connections to the leader were reused"
+    else
+        echo "❌ Node $n dialed more connections than requests in flight"
+    fi
+}
+
+cluster_start || exit 1
+curl -s -X POST "${NODE_URLS[1]}/put?key=reuse&val=pooled" >/dev/null
+echo ""
+
+echo "--- HTTP/1.1 with a pooled transport ---"
+forward_benchmark "HTTP/1.1" 2
+echo ""
+
+echo "--- Restarting every node with -h2c ---"
+for n in 1 2 3; do
+    stop_node "$n"
+done
+for n in 1 2 3; do
+    start_node "$n" --h2c || exit 1
+done
+leader=$(cluster_leader)
+follower=$((leader % 3 + 1))
+echo "Node $leader leads; node $follower forwards"
+echo ""
+
+version=$(curl -s --http2-prior-knowledge -o /dev/null -w "%{http_version}" "${NODE_URLS[$follower]}/raft/status")
+if [[ "$version" == "2" ]]; then
+    echo "✅ Plaintext HTTP/2 is accepted"
+else
+    echo "❌ Expected HTTP/2, got HTTP/$version"
+fi
+version=$(curl -s --http1.1 -o /dev/null -w "%{http_version}" "${NODE_URLS[$follower]}/raft/status")
+if [[ "$version" == "1.1" ]]; then
+    echo "✅ HTTP/1.1 still works"
+else
+    echo "❌ Expected HTTP/1.1, got HTTP/$version"
+fi
+echo ""
+
+echo "--- HTTP/2 between nodes ---"
+forward_benchmark "h2c" "$follower"
+echo ""
+
+echo "=== Connection Reuse Test Complete ==="
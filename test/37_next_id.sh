@@ -0,0 +1,114 @@
+#!/bin/bash
+
+echo "=== Unique ID Allocation ==="
+echo ""
+
+# This test stops the leader in the middle of allocating, so it runs its own
+# cluster from a local build:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/37_next_id.sh
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+cluster_start || exit 1
+echo ""
+
+# allocate URL COUNT asks URL for COUNT IDs from the sequence "orders",
+# retrying while no leader is elected, and prints every ID it received
+allocate() {
+    local url=$1 count=$2 response
+    for _ in $(seq 1 40); do
+        response=$(curl -s -X POST "$url/nextid?name=orders&count=$count")
+        if jq -e '.success' <<<"$response" >/dev/null 2>&1; then
+            seq "$(jq -r '.data.first' <<<"$response")" "$(jq -r '.data.last' <<<"$response")"
+            return 0
+        fi
+        sleep 0.25
+    done
+    echo "allocation failed: $response" >&2
+    return 1
+}
+export -f allocate
+
+# run_callers IDS_FILE URL... starts 60 single-ID and 20 five-ID callers at
+# once, spread over the given nodes, appending the IDs they received to IDS_FILE
+run_callers() {
+    local ids=$1
+    shift
+    local urls=("$@") i
+    for i in $(seq 0 79); do
+        if (( i < 60 )); then
+            echo "${urls[i % ${#urls[@]}]} 1"
+        else
+            echo "${urls[i % ${#urls[@]}]} 5"
+        fi
+    done | xargs -P 20 -L 1 bash -c 'allocate "$0" "$1"' >>"$ids"
+}
+
+# check_unique IDS_FILE EXPECTED reports whether IDS_FILE holds EXPECTED IDs
+# and none of them twice
+check_unique() {
+    local ids=$1 want=$2
+    local total duplicates
+    total=$(wc -l <"$ids")
+    duplicates=$(sort -n "$ids" | uniq -d | wc -l)
+    if [[ "$total" == "$want" && "$duplicates" == "0" ]]; then
+        echo "✅ $total IDs allocated, no duplicates"
+    else
+        echo "❌ Expected $want distinct IDs, got $total with $duplicates duplicated"
+    fi
+}
+
+ids="$CLUSTER_DIR/ids.txt"
+: >"$ids"
+
+echo "--- Concurrent callers on every node ---"
+run_callers "$ids" "${NODE_URLS[1]}" "${NODE_URLS[2]}" "${NODE_URLS[3]}"
+check_unique "$ids" 160
+echo ""
+
+echo "--- Concurrent callers across a leader failover ---"
+before=$(sort -n "$ids" | tail -n1)
+leader=$(cluster_leader)
+survivors=()
+for n in 1 2 3; do
+    [[ "$n" != "$leader" ]] && survivors+=("${NODE_URLS[$n]}")
+done
+run_callers "$ids" "${survivors[@]}" &
+callers=$!
+sleep 0.2
+echo "Stopping leader node $leader"
+stop_node "$leader"
+wait "$callers"
+echo "New leader is node $(cluster_leader)"
+check_unique "$ids" 320
+after=$(sort -n "$ids" | tail -n1)
+echo "Highest ID before the failover $before, after $after"
+echo ""
+
+echo "--- Input validation ---"
+status=$(curl -s -o "$CLUSTER_DIR/nextid.json" -w "%{http_code}" -X POST "${survivors[0]}/nextid?count=1")
+if [[ "$status" == "400" ]]; then
+    echo "✅ Missing name rejected with 400"
+else
+    echo "❌ Expected 400 without a name, got $status"
+fi
+status=$(curl -s -o "$CLUSTER_DIR/nextid.json" -w "%{http_code}" -X POST "${survivors[0]}/nextid?name=orders&count=1000001")
+if [[ "$status" == "400" ]]; then
+    echo "✅ Oversized block rejected with 400"
+else
+    echo "❌ Expected 400 for a block above 1000000, got $status"
+fi
+echo ""
+
+echo "--- Sequences cannot be written through other endpoints ---"
+for request in "/put?namespace=_seq&key=orders&val=1" "/delete?namespace=_seq&key=orders" "/deleteprefix?prefix=_s" "/namespace/delete?namespace=_seq"; do
+    status=$(curl -s -o "$CLUSTER_DIR/nextid.json" -w "%{http_code}" -X POST "${survivors[0]}$request")
+    if [[ "$status" == "400" ]]; then
+        echo "✅ $request rejected with 400"
+    else
+        echo "❌ Expected 400 for $request, got $status"
+    fi
+done
+echo ""
+
+echo "=== Unique ID Allocation Test Complete ==="
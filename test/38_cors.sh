@@ -0,0 +1,61 @@
+#!/bin/bash
+
+echo "=== CORS for Browser Clients ==="
+echo ""
+
+# The shared cluster runs without -cors_origins, so this test starts a node of
+# its own from a local build:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/38_cors.sh
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+ORIGIN="http://dashboard.example.com"
+CLUSTER_SIZE=1 cluster_start --cors_origins="$ORIGIN" || exit 1
+URL=${NODE_URLS[1]}
+headers="$CLUSTER_DIR/headers"
+echo ""
+
+# allow_origin prints the Access-Control-Allow-Origin of the last response
+allow_origin() {
+    grep -i "^Access-Control-Allow-Origin:" "$headers" | cut -d' ' -f2 | tr -d '\r'
+}
+
+echo "--- Preflight from the allowed origin ---"
+status=$(curl -s -D "$headers" -o /dev/null -w "%{http_code}" -X OPTIONS "$URL/raft/status" \
+    -H "Origin: $ORIGIN" -H "Access-Control-Request-Method: GET")
+if [[ "$status" == "204" && "$(allow_origin)" == "$ORIGIN" ]] && grep -qi "^Access-Control-Allow-Methods:.*GET" "$headers"; then
+    echo "✅ Preflight answered with 204 and the allowed origin and methods"
+else
+    echo "❌ Expected 204 allowing $ORIGIN, got $status"
+    cat "$headers"
+fi
+echo ""
+
+echo "--- Reads and status from the allowed origin ---"
+curl -s -X POST "$URL/put?key=cors&val=visible" >/dev/null
+for path in "/get?key=cors" "/raft/status" "/config"; do
+    curl -s -D "$headers" -o /dev/null -H "Origin: $ORIGIN" "$URL$path"
+    if [[ "$(allow_origin)" == "$ORIGIN" ]]; then
+        echo "✅ $path allows $ORIGIN"
+    else
+        echo "❌ $path is missing Access-Control-Allow-Origin"
+    fi
+done
+echo ""
+
+echo "--- Other origins and endpoints get no CORS headers ---"
+curl -s -D "$headers" -o /dev/null -H "Origin: http://evil.example.com" "$URL/raft/status"
+if [[ -z "$(allow_origin)" ]]; then
+    echo "✅ Unlisted origin is not allowed"
+else
+    echo "❌ Unlisted origin was allowed: $(allow_origin)"
+fi
+curl -s -D "$headers" -o /dev/null -H "Origin: $ORIGIN" -X POST "$URL/put?key=cors&val=changed"
+if [[ -z "$(allow_origin)" ]]; then
+    echo "✅ /put is not exposed to browsers"
+else
+    echo "❌ /put allowed $(allow_origin)"
+fi
+echo ""
+
+echo "=== CORS Test Complete ==="
@@ -0,0 +1,81 @@
+#!/bin/bash
+
+echo "=== Value Compression ==="
+echo ""
+
+# This test compares the heap of a node storing JSON documents with and
+# without -value_compression, restarting one node between the two, from a
+# local build:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/39_value_compression.sh
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+DOCS=${DOCS:-20000}
+PPROF=localhost:6391
+
+CLUSTER_SIZE=1
+cluster_start --value_compression --pprof="$PPROF" || exit 1
+URL=${NODE_URLS[1]}
+echo ""
+
+# heap_inuse prints the node's in-use heap bytes right after a GC
+heap_inuse() {
+    curl -s "http://$PPROF/debug/pprof/heap?gc=1&debug=1" | awk '/^# HeapInuse = / {print $4}'
+}
+
+# restart_node FLAGS... restarts node 1 from its snapshot and waits for it to lead
+restart_node() {
+    stop_node 1
+    start_node 1 "$@" || exit 1
+    for _ in $(seq 1 20); do
+        [[ "$(node_state 1)" == "Leader" ]] && break
+        sleep 0.5
+    done
+}
+
+echo "--- Loading $DOCS JSON documents ---"
+awk -v n="$DOCS" 'BEGIN {
+    for (i = 0; i < n; i++) {
+        doc = sprintf("{\\\"id\\\":%d,\\\"type\\\":\\\"order\\\",\\\"status\\\":\\\"shipped\\\",\\\"items\\\":[", i)
+        for (j = 0; j < 12; j++) {
+            doc = doc sprintf("%s{\\\"sku\\\":\\\"SKU-%05d\\\",\\\"name\\\":\\\"Widget model %d\\\",\\\"quantity\\\":%d,\\\"price\\\":\\\"%d.99\\\",\\\"warehouse\\\":\\\"eu-west-1\\\"}", j ? "," : "", (i * 7 + j) % 50000, j, j + 1, 10 + j)
+        }
+        printf "{\"key\":\"doc-%06d\",\"val\":\"%s]}\"}\n", i, doc
+    }
+}' >"$CLUSTER_DIR/docs.ndjson"
+curl -s -X POST "$URL/import" -T "$CLUSTER_DIR/docs.ndjson" | tail -n1
+expected=$(sed -n '1p' "$CLUSTER_DIR/docs.ndjson" | jq -r '.val')
+echo "Each document is about ${#expected} bytes"
+echo ""
+
+echo "--- Compressed store ---"
+curl -s -X POST "$URL/raft/snapshot" >/dev/null
+restart_node --value_compression --pprof="$PPROF"
+compressed=$(heap_inuse)
+echo "Heap in use: $compressed bytes"
+if [[ "$(curl -s "$URL/get?key=doc-000000" | jq -r '.value')" == "$expected" ]]; then
+    echo "✅ A document reads back unchanged"
+else
+    echo "❌ The document did not read back unchanged"
+fi
+echo ""
+
+echo "--- The same store uncompressed, restored from the compressed snapshot ---"
+restart_node --pprof="$PPROF"
+plain=$(heap_inuse)
+echo "Heap in use: $plain bytes"
+if [[ "$(curl -s "$URL/get?key=doc-000000" | jq -r '.value')" == "$expected" ]]; then
+    echo "✅ A node without compression restores the compressed values"
+else
+    echo "❌ The compressed snapshot did not restore without compression"
+fi
+echo ""
+
+if [[ -n "$compressed" && -n "$plain" ]] && ((compressed < plain)); then
+    echo "✅ Compression saved $(((plain - compressed) / 1024)) KiB, $((100 - compressed * 100 / plain))% of the heap"
+else
+    echo "❌ Expected the compressed store to use less heap"
+fi
+echo ""
+
+echo "=== Value Compression Test Complete ==="
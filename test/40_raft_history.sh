@@ -0,0 +1,46 @@
+#!/bin/bash
+
+echo "=== Raft State History ==="
+echo ""
+
+# This test stops the leader to record a failover, so it runs its own cluster
+# from a local build:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/40_raft_history.sh
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+cluster_start --state_history=8 || exit 1
+echo ""
+
+echo "--- History of a follower before the failover ---"
+curl -s "${NODE_URLS[2]}/raft/history" | jq -c '.data.transitions[] | [.old_state, .new_state, .term, .leader]'
+echo ""
+
+echo "--- Stopping leader node 1 ---"
+stop_node 1
+leader=$(cluster_leader)
+echo "New leader is node $leader"
+echo ""
+
+echo "--- History of the new leader ---"
+curl -s "${NODE_URLS[$leader]}/raft/history" >"$CLUSTER_DIR/history.json"
+jq -c '.data.transitions[] | [.old_state, .new_state, .term, .leader]' "$CLUSTER_DIR/history.json"
+if jq -e '.data.transitions | last | .new_state == "Leader" and .leader == "node'"$leader"'"' "$CLUSTER_DIR/history.json" >/dev/null; then
+    echo "✅ The last transition is node $leader becoming leader"
+else
+    echo "❌ Expected the last transition to make node $leader leader"
+fi
+if jq -e '.data.transitions | any(.old_state == "Candidate" and .new_state == "Leader")' "$CLUSTER_DIR/history.json" >/dev/null &&
+    jq -e '.data.transitions | (last.term > first.term)' "$CLUSTER_DIR/history.json" >/dev/null; then
+    echo "✅ The election and the new term were recorded"
+else
+    echo "❌ Expected a Candidate to Leader transition in a later term"
+fi
+if jq -e '(.data.transitions | length) <= .data.capacity and .data.capacity == 8' "$CLUSTER_DIR/history.json" >/dev/null; then
+    echo "✅ The history stays within its capacity of 8"
+else
+    echo "❌ The history exceeds -state_history"
+fi
+echo ""
+
+echo "=== Raft State History Test Complete ==="
@@ -0,0 +1,65 @@
+#!/bin/bash
+
+echo "=== Transactional Reads ==="
+echo ""
+
+# Runs its own cluster from a local build:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/42_txget.sh
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+cluster_start || exit 1
+echo ""
+
+echo "--- Keys written by one batch are read together ---"
+curl -s -X POST "${NODE_URLS[1]}/batch" -H "Content-Type: application/json" \
+    -d '{"ops": [{"op": "put", "key": "tx_a", "val": "0"}, {"op": "put", "key": "tx_b", "val": "0"}]}' > /dev/null
+
+# A writer moves both keys forward in single batches while readers on every node read them
+(
+    for i in $(seq 1 300); do
+        curl -s -X POST "${NODE_URLS[1]}/batch" -H "Content-Type: application/json" \
+            -d "{\"ops\": [{\"op\": \"put\", \"key\": \"tx_a\", \"val\": \"$i\"}, {\"op\": \"put\", \"key\": \"tx_b\", \"val\": \"$i\"}]}" > /dev/null
+    done
+) &
+writer=$!
+
+torn=0
+reads=0
+while kill -0 "$writer" 2>/dev/null; do
+    for node in 1 2 3; do
+        response=$(curl -s -X POST "${NODE_URLS[$node]}/txget" -H "Content-Type: application/json" \
+            -d '{"keys": ["tx_a", "tx_b"]}')
+        a=$(echo "$response" | jq -r '.data.values["tx_a"]')
+        b=$(echo "$response" | jq -r '.data.values["tx_b"]')
+        reads=$((reads + 1))
+        [[ "$a" != "$b" || "$a" == "null" ]] && torn=$((torn + 1))
+    done
+done
+wait "$writer"
+
+if [[ "$torn" == "0" ]]; then
+    echo "✅ All $reads reads saw tx_a and tx_b from the same batch"
+else
+    echo "❌ $torn of $reads reads saw tx_a and tx_b from different batches"
+fi
+echo ""
+
+echo "--- Missing keys and validation ---"
+response=$(curl -s -X POST "${NODE_URLS[2]}/txget" -H "Content-Type: application/json" \
+    -d '{"keys": ["tx_a", "tx_none"]}')
+if [[ "$(echo "$response" | jq -r '.data.values["tx_a"]')" == "300" && "$(echo "$response" | jq -c '.data.missing')" == '["tx_none"]' ]]; then
+    echo "✅ A follower returns the latest value and lists the missing key"
+else
+    echo "❌ Unexpected response: $response"
+fi
+
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST "${NODE_URLS[1]}/txget" -H "Content-Type: application/json" -d '{"keys": []}')
+if [[ "$status" == "400" ]]; then
+    echo "✅ An empty key list is rejected with 400"
+else
+    echo "❌ Expected 400 for an empty key list, got $status"
+fi
+echo ""
+
+echo "=== Transactional Read Test Complete ==="
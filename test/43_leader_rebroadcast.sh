@@ -0,0 +1,90 @@
+#!/bin/bash
+
+echo "=== Leader Re-broadcast ==="
+echo ""
+
+# Runs its own cluster from a local build:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/43_leader_rebroadcast.sh
+#
+# The peer is a separate single-node shard 9 on port 8091, which is down
+# when node 1 first broadcasts and comes up a moment later
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+PEER_URL="http://localhost:8091"
+PEER_PID=""
+
+start_peer() {
+    mkdir -p "$CLUSTER_DIR/peer"
+    "$KV_RAFT_BIN" --node_id=peer9 --shard_id=9 --port=8091 --raft_addr=localhost:18091 \
+        --store_dir="$CLUSTER_DIR/peer" >>"$CLUSTER_DIR/peer/node.log" 2>&1 &
+    PEER_PID=$!
+}
+
+stop_peer() {
+    if [[ -n "$PEER_PID" ]]; then
+        kill "$PEER_PID" 2>/dev/null
+        wait "$PEER_PID" 2>/dev/null
+        PEER_PID=""
+    fi
+}
+
+# peer_knows SHARD prints the address the peer has for SHARD, empty if none.
+# An empty /syncshards merges nothing and answers with the peer's map.
+peer_knows() {
+    curl -s --max-time 1 -X POST "$PEER_URL/syncshards" -H "Content-Type: application/json" \
+        -d '{"shards": {}}' | jq -r ".data.map.shards[\"$1\"].address // empty" 2>/dev/null
+}
+
+# wait_peer_knows SHARD SECONDS waits for the peer to learn SHARD, printing how long it took
+wait_peer_knows() {
+    local start=$SECONDS
+    while (( SECONDS - start < $2 )); do
+        if [[ -n "$(peer_knows "$1")" ]]; then
+            echo $((SECONDS - start))
+            return 0
+        fi
+        sleep 0.2
+    done
+    return 1
+}
+
+CLUSTER_SIZE=1 cluster_start --peer_shards=localhost:8091 || exit 1
+trap 'stop_peer; cluster_stop' EXIT
+echo ""
+
+echo "--- A peer that missed the election broadcast still learns the leader ---"
+start_peer
+# The periodic sync runs every 30s, so only the re-broadcasts arrive this soon
+if took=$(wait_peer_knows 1 20); then
+    echo "✅ The peer learned shard 1 ${took}s after coming up"
+else
+    echo "❌ The peer did not learn shard 1 within 20s"
+fi
+echo ""
+
+echo "--- /addshard pushes the map even when nothing changed ---"
+curl -s -X POST "${NODE_URLS[1]}/addshard" -H "Content-Type: application/json" \
+    -d '{"shardID": "5", "shardAddress": "shard5:8051"}' >/dev/null
+wait_peer_knows 5 5 >/dev/null
+
+# The peer restarts without its state and misses every earlier push
+stop_peer
+rm -rf "$CLUSTER_DIR/peer"
+start_peer
+for _ in $(seq 1 20); do
+    curl -s --max-time 1 "$PEER_URL/raft/status" >/dev/null 2>&1 && break
+    sleep 0.5
+done
+
+# Shard 5 registers again at the same address
+curl -s -X POST "${NODE_URLS[1]}/addshard" -H "Content-Type: application/json" \
+    -d '{"shardID": "5", "shardAddress": "shard5:8051"}' >/dev/null
+if wait_peer_knows 5 3 >/dev/null && [[ -n "$(peer_knows 1)" ]]; then
+    echo "✅ The restarted peer got the map right after /addshard"
+else
+    echo "❌ The restarted peer did not get the map after /addshard"
+fi
+echo ""
+
+echo "=== Leader Re-broadcast Test Complete ==="
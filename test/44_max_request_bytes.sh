@@ -0,0 +1,64 @@
+#!/bin/bash
+
+echo "=== Request Body Limit ==="
+echo ""
+
+# Runs its own cluster from a local build:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/44_max_request_bytes.sh
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+CLUSTER_SIZE=1 cluster_start --max_request_bytes=4096 || exit 1
+echo ""
+
+big=$(head -c 8000 /dev/zero | tr '\0' 'x')
+body="$CLUSTER_DIR/body.json"
+echo "{\"key\": \"big\", \"val\": \"$big\"}" > "$body"
+
+# expect_413 NAME CURL_ARGS... checks that a request is rejected as too large
+expect_413() {
+    local name=$1
+    shift
+    response=$(curl -s -w "\n%{http_code}" "$@")
+    status=$(tail -n1 <<<"$response")
+    code=$(head -n -1 <<<"$response" | jq -r '.code' 2>/dev/null)
+    if [[ "$status" == "413" && "$code" == "TOO_LARGE" ]]; then
+        echo "✅ $name is rejected with 413 TOO_LARGE"
+    else
+        echo "❌ Expected 413 TOO_LARGE for $name, got $status $code"
+    fi
+}
+
+echo "--- Bodies over the limit ---"
+expect_413 "A JSON body with a Content-Length over the limit" \
+    -X POST "${NODE_URLS[1]}/put" -H "Content-Type: application/json" --data-binary "@$body"
+expect_413 "A chunked JSON body over the limit" \
+    -X POST "${NODE_URLS[1]}/put" -H "Content-Type: application/json" -H "Transfer-Encoding: chunked" --data-binary "@$body"
+expect_413 "A chunked form body over the limit" \
+    -X POST "${NODE_URLS[1]}/put" -H "Transfer-Encoding: chunked" --data-binary "key=big&val=$big"
+echo ""
+
+echo "--- Bodies within the limit ---"
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST "${NODE_URLS[1]}/put" -H "Content-Type: application/json" \
+    -d '{"key": "small", "val": "ok"}')
+if [[ "$status" == "200" ]]; then
+    echo "✅ A small body is accepted"
+else
+    echo "❌ Expected 200 for a small body, got $status"
+fi
+
+# /import streams its body in batches, so the limit does not apply to it
+import="$CLUSTER_DIR/import.ndjson"
+for i in $(seq 1 200); do
+    echo "{\"key\": \"imported-$i\", \"val\": \"value-$i\"}"
+done > "$import"
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST "${NODE_URLS[1]}/import" --data-binary "@$import")
+value=$(curl -s "${NODE_URLS[1]}/get?key=imported-200" | jq -r '.value')
+if [[ "$status" == "200" && "$value" == "value-200" ]]; then
+    echo "✅ /import accepts a $(wc -c <"$import")-byte body"
+else
+    echo "❌ /import of a $(wc -c <"$import")-byte body returned $status, imported-200 is $value"
+fi
+echo ""
+
+echo "=== Request Body Limit Test Complete ==="
@@ -0,0 +1,113 @@
+#!/bin/bash
+
+echo "=== Shard Rebalance ==="
+echo ""
+
+# Runs one process hosting groups 1-3 from a local build:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/45_rebalance.sh
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+CLUSTER_DIR=$(mktemp -d)
+trap cluster_stop EXIT
+start_node 1 --shards=1,2,3 --rebalance_rate=1000 || exit 1
+BASE="${NODE_URLS[1]}"
+
+for group in 1 2 3; do
+    for _ in $(seq 1 30); do
+        state=$(curl -s "$BASE/shard/$group/raft/status" | jq -r '.data.state // empty')
+        [[ "$state" == "Leader" ]] && break
+        sleep 0.5
+    done
+    if [[ "$state" != "Leader" ]]; then
+        echo "❌ Group $group has no leader"
+        exit 1
+    fi
+done
+echo "Groups 1-3 running in $CLUSTER_DIR"
+echo ""
+
+owner() {
+    curl -s "$BASE/locate?key=$1" | jq -r '.data.shard'
+}
+
+# wait_rebalance waits for group 1's rebalance to leave the running state
+wait_rebalance() {
+    for _ in $(seq 1 60); do
+        status=$(curl -s "$BASE/shard/1/rebalance/status")
+        [[ "$(jq -r '.data.state' <<<"$status")" != "running" ]] && return 0
+        sleep 0.5
+    done
+    return 1
+}
+
+# Every key is written to group 1, as if the ring had been only [1] when they were
+echo "--- Keys move to their owners ---"
+for i in $(seq 1 60); do
+    curl -s -X POST "$BASE/shard/1/put?key=key-$i&val=value-$i" >/dev/null
+done
+
+# An owner that already has a key keeps its own, newer value
+for i in $(seq 1 60); do
+    target=$(owner "key-$i")
+    if [[ "$target" != "1" ]]; then
+        newer="key-$i"
+        curl -s -X POST "$BASE/shard/$target/put?key=$newer&val=newer" >/dev/null
+        break
+    fi
+done
+
+curl -s -X POST "$BASE/shard/1/rebalance" -d "rate=20" >/dev/null
+sleep 1
+curl -s -X POST "$BASE/shard/1/rebalance/stop" >/dev/null
+wait_rebalance
+state=$(jq -r '.data.state' <<<"$status")
+moved=$(jq -r '.data.moved + .data.superseded' <<<"$status")
+if [[ "$state" == "stopped" && "$moved" -gt 0 ]]; then
+    echo "✅ The rebalance stopped after moving $moved keys at 20 keys/s"
+else
+    echo "❌ Expected a stopped rebalance with some keys moved: $status"
+fi
+
+status=$(curl -s -X POST "$BASE/shard/1/rebalance")
+if [[ "$(jq -r '.data.state' <<<"$status")" == "running" ]]; then
+    echo "✅ Starting it again resumes the move"
+else
+    echo "❌ The rebalance did not start again: $status"
+fi
+wait_rebalance
+if [[ "$(jq -r '.data.state' <<<"$status")" == "done" && "$(jq -r '.data.found' <<<"$status")" -lt 60 ]]; then
+    echo "✅ The second run finished the $(jq -r '.data.found' <<<"$status") keys still to move"
+else
+    echo "❌ Expected the second run to finish the remaining keys: $status"
+fi
+
+wrong=0
+for i in $(seq 1 60); do
+    target=$(owner "key-$i")
+    value=$(curl -s "$BASE/shard/$target/get?key=key-$i" | jq -r '.value')
+    expected="value-$i"
+    [[ "key-$i" == "$newer" ]] && expected="newer"
+    [[ "$value" != "$expected" ]] && wrong=$((wrong + 1))
+    if [[ "$target" != "1" ]]; then
+        status=$(curl -s -o /dev/null -w "%{http_code}" "$BASE/shard/1/get?key=key-$i")
+        [[ "$status" != "404" ]] && wrong=$((wrong + 1))
+    fi
+done
+if [[ "$wrong" == "0" ]]; then
+    echo "✅ Every key is on its owner only, and $newer kept the owner's newer value"
+else
+    echo "❌ $wrong keys are missing from their owner or still on shard 1"
+fi
+echo ""
+
+echo "--- Validation ---"
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST "$BASE/shard/1/rebalance" -d "ring=1,2,7")
+if [[ "$status" == "400" ]]; then
+    echo "✅ A ring with a shard this process does not host is rejected with 400"
+else
+    echo "❌ Expected 400 for an unknown shard in the ring, got $status"
+fi
+echo ""
+
+echo "=== Shard Rebalance Test Complete ==="
@@ -0,0 +1,62 @@
+#!/bin/bash
+
+echo "=== Scanning Keys ==="
+echo ""
+
+# Runs its own cluster from a local build:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/46_scan.sh
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+cluster_start || exit 1
+echo ""
+
+curl -s -X POST "${NODE_URLS[1]}/batch" -H "Content-Type: application/json" \
+    -d '{"ops": [{"op": "put", "namespace": "user", "key": "3", "val": "c"}, {"op": "put", "namespace": "user", "key": "1", "val": "a"},
+                 {"op": "put", "namespace": "user", "key": "2", "val": "b"}, {"op": "put", "key": "other", "val": "x"}]}' > /dev/null
+curl -s -X POST "${NODE_URLS[1]}/put" -H "Content-Type: application/json" \
+    -d '{"namespace": "ns", "key": "user/9", "val": "z"}' > /dev/null
+
+echo "--- Pages follow key order ---"
+first=$(curl -s -X POST "${NODE_URLS[2]}/scan" -H "Content-Type: application/json" -d '{"prefix": "user/", "limit": 2}')
+second=$(curl -s -X POST "${NODE_URLS[2]}/scan" -H "Content-Type: application/json" -d '{"prefix": "user/", "after": "user/2", "limit": 2}')
+if [[ "$(echo "$first" | jq -c '[.data.items[].key, .data.more]')" == '["user/1","user/2",true]' &&
+      "$(echo "$second" | jq -c '[(.data.items[] | .key + "=" + .value), .data.more]')" == '["user/3=c",false]' ]]; then
+    echo "✅ A follower lists user/1 and user/2, then user/3 after user/2"
+else
+    echo "❌ Unexpected pages: $first / $second"
+fi
+
+response=$(curl -s "${NODE_URLS[3]}/scan?namespace=ns")
+if [[ "$(echo "$response" | jq -c '[.data.items[].key]')" == '["user/9"]' ]]; then
+    echo "✅ A namespaced scan lists only that namespace, without its prefix"
+else
+    echo "❌ Unexpected namespaced scan: $response"
+fi
+echo ""
+
+echo "--- Glob patterns ---"
+response=$(curl -s -G "${NODE_URLS[2]}/scan" --data-urlencode "glob=user/[13]")
+if [[ "$(echo "$response" | jq -c '[.data.items[].key]')" == '["user/1","user/3"]' ]]; then
+    echo "✅ A glob with a character class lists user/1 and user/3"
+else
+    echo "❌ Unexpected glob scan: $response"
+fi
+echo ""
+
+echo "--- Validation ---"
+status=$(curl -s -o /dev/null -w "%{http_code}" -G "${NODE_URLS[1]}/scan" --data-urlencode "glob=user/[1")
+if [[ "$status" == "400" ]]; then
+    echo "✅ A malformed glob is rejected with 400"
+else
+    echo "❌ Expected 400 for a malformed glob, got $status"
+fi
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST "${NODE_URLS[1]}/scan" -H "Content-Type: application/json" -d '{"limit": 1001}')
+if [[ "$status" == "400" ]]; then
+    echo "✅ A limit over 1000 is rejected with 400"
+else
+    echo "❌ Expected 400 for limit 1001, got $status"
+fi
+echo ""
+
+echo "=== Scan Test Complete ==="
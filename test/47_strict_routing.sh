@@ -0,0 +1,62 @@
+#!/bin/bash
+
+echo "=== Strict Key Routing ==="
+echo ""
+
+# Runs one process hosting groups 1-3 from a local build:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/47_strict_routing.sh
+source "$(dirname "${BASH_SOURCE[0]}")/cluster_harness.sh"
+cluster_require_bin
+
+CLUSTER_DIR=$(mktemp -d)
+trap cluster_stop EXIT
+start_node 1 --shards=1,2,3 --strict_routing || exit 1
+BASE="${NODE_URLS[1]}"
+
+for group in 1 2 3; do
+    for _ in $(seq 1 30); do
+        state=$(curl -s "$BASE/shard/$group/raft/status" | jq -r '.data.state // empty')
+        [[ "$state" == "Leader" ]] && break
+        sleep 0.5
+    done
+    if [[ "$state" != "Leader" ]]; then
+        echo "❌ Group $group has no leader"
+        exit 1
+    fi
+done
+
+owner=$(curl -s "$BASE/locate?key=routed" | jq -r '.data.shard')
+other=$(( owner % 3 + 1 ))
+echo "routed belongs to group $owner"
+echo ""
+
+echo "--- The owner serves the key ---"
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST "$BASE/shard/$owner/put?key=routed&val=v")
+if [[ "$status" == "200" ]] && curl -s "$BASE/shard/$owner/get?key=routed" | jq -e '.value == "v"' >/dev/null; then
+    echo "✅ Group $owner stores and reads routed"
+else
+    echo "❌ Group $owner answered $status"
+fi
+echo ""
+
+echo "--- Another group refuses it ---"
+for request in "put?key=routed&val=w" "get?key=routed"; do
+    response=$(curl -s -w "\n%{http_code}" -X POST "$BASE/shard/$other/$request")
+    status=$(tail -n1 <<<"$response")
+    body=$(head -n -1 <<<"$response")
+    if [[ "$status" == "421" && "$(jq -r '.code' <<<"$body")" == "MISDIRECTED_KEY" &&
+          "$(jq -r '.data.shard' <<<"$body")" == "$owner" && "$(jq -r '.data.leader' <<<"$body")" == *"/shard/$owner" ]]; then
+        echo "✅ /$request on group $other is answered 421 with group $owner's leader"
+    else
+        echo "❌ /$request on group $other: $status $body"
+    fi
+done
+
+if curl -s "$BASE/shard/$owner/get?key=routed" | jq -e '.value == "v"' >/dev/null; then
+    echo "✅ The refused put left the owner's value alone"
+else
+    echo "❌ The owner's value changed"
+fi
+echo ""
+
+echo "=== Strict Routing Test Complete ==="
@@ -0,0 +1,115 @@
+#!/bin/bash
+
+# Local 3-node cluster for tests that need to stop, restart or wipe nodes,
+# which the shared docker-compose cluster does not allow. Source it from a
+# test script and point KV_RAFT_BIN at a shard-server binary:
+#   (cd shard && go build -o shard-server .) && KV_RAFT_BIN=shard/shard-server test/26_leave_leader.sh
+#
+# Node N (1-3) serves HTTP on port 81N1 and raft on 181N1, keeps its state in
+# $CLUSTER_DIR/nodeN and logs to $CLUSTER_DIR/nodeN/node.log. Its base URL is
+# ${NODE_URLS[N]}. cluster_start registers cluster_stop to run on exit.
+# Set CLUSTER_SIZE to 1 or 2 before cluster_start for a smaller cluster.
+
+NODE_URLS=([1]="http://localhost:8111" [2]="http://localhost:8121" [3]="http://localhost:8131")
+NODE_PIDS=()
+CLUSTER_DIR=""
+
+# cluster_require_bin exits the calling test early when there is no binary to run
+cluster_require_bin() {
+    if [[ -z "$KV_RAFT_BIN" || ! -x "$KV_RAFT_BIN" ]]; then
+        echo "⚠️  Set KV_RAFT_BIN to a shard-server binary to run this test"
+        exit 0
+    fi
+}
+
+# node_state N prints the raft state of node N, empty if it does not answer
+node_state() {
+    curl -s --max-time 2 "${NODE_URLS[$1]}/raft/status" | jq -r '.data.state // empty' 2>/dev/null
+}
+
+# start_node N [EXTRA_FLAGS...] launches node N on its existing state
+start_node() {
+    local n=$1
+    shift
+    mkdir -p "$CLUSTER_DIR/node$n"
+    "$KV_RAFT_BIN" --node_id="node$n" --shard_id="$n" --port="81${n}1" --raft_addr="localhost:181${n}1" \
+        --store_dir="$CLUSTER_DIR/node$n" "$@" >>"$CLUSTER_DIR/node$n/node.log" 2>&1 &
+    NODE_PIDS[$n]=$!
+    for _ in $(seq 1 20); do
+        if curl -s --max-time 1 "${NODE_URLS[$n]}/raft/status" >/dev/null 2>&1; then
+            return 0
+        fi
+        sleep 0.5
+    done
+    echo "❌ Node $n did not start, see $CLUSTER_DIR/node$n/node.log"
+    return 1
+}
+
+# stop_node N kills node N and waits for it to exit
+stop_node() {
+    local pid=${NODE_PIDS[$1]}
+    if [[ -n "$pid" ]]; then
+        kill "$pid" 2>/dev/null
+        wait "$pid" 2>/dev/null
+        NODE_PIDS[$1]=""
+    fi
+}
+
+# cluster_leader prints the number of the node that is leader, waiting up to
+# 15s for an election; it prints nothing if none of the running nodes leads
+cluster_leader() {
+    for _ in $(seq 1 30); do
+        for n in 1 2 3; do
+            if [[ -n "${NODE_PIDS[$n]}" && "$(node_state "$n")" == "Leader" ]]; then
+                echo "$n"
+                return 0
+            fi
+        done
+        sleep 0.5
+    done
+    return 1
+}
+
+# cluster_start launches CLUSTER_SIZE (default 3) nodes in a fresh directory
+# and forms a cluster with node 1 as its first leader. Extra flags are passed
+# to every node.
+cluster_start() {
+    CLUSTER_DIR=$(mktemp -d)
+    trap cluster_stop EXIT
+
+    start_node 1 "$@" || return 1
+    if [[ "$(cluster_leader)" != "1" ]]; then
+        echo "❌ Node 1 did not become leader"
+        return 1
+    fi
+
+    local size=${CLUSTER_SIZE:-3}
+    for n in $(seq 2 "$size"); do
+        start_node "$n" "$@" || return 1
+        response=$(curl -s -X POST "${NODE_URLS[1]}/raft/join" -d "nodeid=node$n&addr=localhost:181${n}1")
+        if ! jq -e '.success' <<<"$response" >/dev/null 2>&1; then
+            echo "❌ Node $n could not join: $response"
+            return 1
+        fi
+    done
+
+    # Joined nodes report Follower once they have heard from the leader
+    for n in $(seq 2 "$size"); do
+        for _ in $(seq 1 20); do
+            [[ "$(node_state "$n")" == "Follower" ]] && break
+            sleep 0.5
+        done
+    done
+    echo "Cluster of $size nodes running in $CLUSTER_DIR"
+}
+
+# cluster_stop kills every node and removes the cluster's state
+cluster_stop() {
+    for n in 1 2 3; do
+        stop_node "$n"
+    done
+    if [[ -n "$CLUSTER_DIR" ]]; then
+        rm -rf "$CLUSTER_DIR"
+        CLUSTER_DIR=""
+    fi
+}
@@ -20,6 +20,42 @@ TESTS=(
     "08_raft_status.sh"
     "09_direct_shard_put.sh"
     "10_direct_shard_get.sh"
+    "11_delete_nonexistent.sh"
+    "12_join_duplicate_node_id.sh"
+    "13_follower_get.sh"
+    "14_input_styles.sh"
+    "15_idempotent_delete.sh"
+    "16_concurrent_joins.sh"
+    "17_binary_roundtrip.sh"
+    "18_delete_prefix.sh"
+    "19_batch_limits.sh"
+    "20_eviction_order.sh"
+    "21_put_value_types.sh"
+    "22_snapshot_restore.sh"
+    "23_openapi.sh"
+    "24_handler_errors.sh"
+    "26_leave_leader.sh"
+    "27_leave_single_node.sh"
+    "28_read_index.sh"
+    "29_http_gzip.sh"
+    "30_delete_if.sh"
+    "31_stale_on_no_leader.sh"
+    "32_write_quorum.sh"
+    "33_raft_restore.sh"
+    "34_recover_corrupt.sh"
+    "35_connection_reuse.sh"
+    "36_snapshot_memory.sh"
+    "37_next_id.sh"
+    "38_cors.sh"
+    "39_value_compression.sh"
+    "40_raft_history.sh"
+    "41_session_token.sh"
+    "42_txget.sh"
+    "43_leader_rebroadcast.sh"
+    "44_max_request_bytes.sh"
+    "45_rebalance.sh"
+    "46_scan.sh"
+    "47_strict_routing.sh"
 )
 
 # Function to run a test with error handling